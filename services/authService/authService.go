@@ -10,6 +10,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -22,6 +23,7 @@ func (*AuthService) Login(ctx context.Context, req *pb.LoginReq) (*pb.LoginRsp,
 	account := req.GetAccount()
 	password := req.GetPassword()
 	jwt := req.GetJwt()
+	sessionID := ""
 	result := pb.AuthResult_OK
 
 	logger.Sugar().Debugf("RPC-LoginReq { account:%s, has_jwt:%t }", account, jwt != "")
@@ -63,11 +65,19 @@ func (*AuthService) Login(ctx context.Context, req *pb.LoginReq) (*pb.LoginRsp,
 			}
 		}
 
-		// 生成jwt
-		jwt, err = utils.GenerateJWT(user)
+		// 生成jwt，每次账号密码登录开启新的会话
+		sessionID, err = utils.NewSessionID()
+		if err != nil {
+			logger.Sugar().Errorln(userBriefStr(user), "failed to generate jwt session id:", err)
+			sessionID = ""
+			result = pb.AuthResult_SERVICE_ERROR
+			goto RETURN
+		}
+		jwt, err = utils.GenerateJWT(user, sessionID)
 		if err != nil {
 			logger.Sugar().Errorln(userBriefStr(user), "failed to generate jwt with key:", err)
 			jwt = ""
+			sessionID = ""
 			result = pb.AuthResult_SERVICE_ERROR
 			goto RETURN
 		}
@@ -87,11 +97,25 @@ func (*AuthService) Login(ctx context.Context, req *pb.LoginReq) (*pb.LoginRsp,
 			logger.Sugar().Warnln(userBriefStr(user), "failed to validate jwt:", validateErr)
 			goto RETURN
 		}
-		newJwt, err := utils.GenerateJWT(user)
+		if result = checkJwtSession(user, claim); result != pb.AuthResult_OK {
+			jwt = ""
+			goto RETURN
+		}
+		// 续签沿用原会话ID，旧版本签发的令牌没有会话ID，续签时补发一个
+		sessionID, err = sessionIDFor(claim)
+		if err != nil {
+			logger.Sugar().Errorln(userBriefStr(user), "failed to generate jwt session id:", err)
+			result = pb.AuthResult_SERVICE_ERROR
+			jwt = ""
+			sessionID = ""
+			goto RETURN
+		}
+		newJwt, err := utils.GenerateJWT(user, sessionID)
 		if err != nil {
 			logger.Sugar().Errorln(userBriefStr(user), "failed to generate jwt key:", err)
 			result = pb.AuthResult_SERVICE_ERROR
 			jwt = ""
+			sessionID = ""
 		} else {
 			jwt = newJwt
 			logger.Sugar().Infoln(userBriefStr(user), "login success with jwt")
@@ -100,10 +124,11 @@ func (*AuthService) Login(ctx context.Context, req *pb.LoginReq) (*pb.LoginRsp,
 
 RETURN:
 	return &pb.LoginRsp{
-		Result:  result,
-		UserId:  user.ID,
-		Account: account,
-		Jwt:     jwt,
+		Result:    result,
+		UserId:    user.ID,
+		Account:   account,
+		Jwt:       jwt,
+		SessionId: sessionID,
 	}, nil
 }
 
@@ -205,6 +230,7 @@ func (*AuthService) CheckJwt(ctx context.Context, rsp *pb.CheckJwtReq) (*pb.Chec
 		result = pb.AuthResult_JWT_ERROR
 		goto RETURN
 	}
+	result = checkJwtSession(user, claim)
 
 RETURN:
 	return &pb.CheckJwtRsp{
@@ -216,7 +242,7 @@ RETURN:
 }
 
 func (*AuthService) ChangePassword(_ context.Context, req *pb.ChangePasswordReq) (*pb.ChangePasswordRsp, error) {
-	user, result := authenticateUser(req.GetUserId(), req.GetJwt())
+	user, claims, result := authenticateUser(req.GetUserId(), req.GetJwt())
 	if result != pb.AuthResult_OK {
 		return &pb.ChangePasswordRsp{Result: result}, nil
 	}
@@ -243,6 +269,12 @@ func (*AuthService) ChangePassword(_ context.Context, req *pb.ChangePasswordReq)
 		logger.Sugar().Errorw("修改密码时生成签名密钥失败", "user_id", user.ID, "error", err)
 		return &pb.ChangePasswordRsp{Result: pb.AuthResult_SERVICE_ERROR}, nil
 	}
+	// 新令牌沿用发起设备的会话ID，该设备之后仍可被单独吊销
+	sessionID, err := sessionIDFor(claims)
+	if err != nil {
+		logger.Sugar().Errorw("修改密码时生成会话ID失败", "user_id", user.ID, "error", err)
+		return &pb.ChangePasswordRsp{Result: pb.AuthResult_SERVICE_ERROR}, nil
+	}
 
 	updated, err := db.UpdateUserCredentialsCAS(user.ID, user.PasswordHash, user.JwtKey, string(passwordHash), jwtKey)
 	if err != nil {
@@ -253,7 +285,7 @@ func (*AuthService) ChangePassword(_ context.Context, req *pb.ChangePasswordReq)
 		return &pb.ChangePasswordRsp{Result: pb.AuthResult_JWT_ERROR}, nil
 	}
 
-	newJWT, err := utils.GenerateJWT(&db.User{ID: user.ID, Account: user.Account, JwtKey: jwtKey})
+	newJWT, err := utils.GenerateJWT(&db.User{ID: user.ID, Account: user.Account, JwtKey: jwtKey}, sessionID)
 	if err != nil {
 		logger.Sugar().Errorw("修改密码后签发JWT失败", "user_id", user.ID, "error", err)
 		return &pb.ChangePasswordRsp{Result: pb.AuthResult_SERVICE_ERROR}, nil
@@ -263,7 +295,7 @@ func (*AuthService) ChangePassword(_ context.Context, req *pb.ChangePasswordReq)
 }
 
 func (*AuthService) RevokeSessions(_ context.Context, req *pb.RevokeSessionsReq) (*pb.RevokeSessionsRsp, error) {
-	user, result := authenticateUser(req.GetUserId(), req.GetJwt())
+	user, _, result := authenticateUser(req.GetUserId(), req.GetJwt())
 	if result != pb.AuthResult_OK {
 		return &pb.RevokeSessionsRsp{Result: result}, nil
 	}
@@ -285,23 +317,68 @@ func (*AuthService) RevokeSessions(_ context.Context, req *pb.RevokeSessionsReq)
 	return &pb.RevokeSessionsRsp{Result: pb.AuthResult_OK}, nil
 }
 
-func authenticateUser(userID int64, jwt string) (*db.User, pb.AuthResult) {
+// RevokeJwtSession 吊销同一用户的单个JWT会话，该会话续签得到的令牌一并失效；签名密钥不变，其他会话保持登录。
+func (*AuthService) RevokeJwtSession(_ context.Context, req *pb.RevokeJwtSessionReq) (*pb.RevokeJwtSessionRsp, error) {
+	user, _, result := authenticateUser(req.GetUserId(), req.GetJwt())
+	if result != pb.AuthResult_OK {
+		return &pb.RevokeJwtSessionRsp{Result: result}, nil
+	}
+	sessionID := req.GetSessionId()
+	if sessionID == "" || len(sessionID) > 64 {
+		return &pb.RevokeJwtSessionRsp{Result: pb.AuthResult_JWT_ERROR}, nil
+	}
+	if err := db.RevokeJwtSession(user.ID, sessionID, utils.RevokedSessionExpiry(time.Now())); err != nil {
+		logger.Sugar().Errorw("吊销JWT会话失败", "user_id", user.ID, "session_id", sessionID, "error", err)
+		return &pb.RevokeJwtSessionRsp{Result: pb.AuthResult_SERVICE_ERROR}, nil
+	}
+	logger.Sugar().Infow("用户JWT会话已吊销", "user_id", user.ID, "session_id", sessionID)
+	return &pb.RevokeJwtSessionRsp{Result: pb.AuthResult_OK}, nil
+}
+
+func authenticateUser(userID int64, jwt string) (*db.User, *utils.BetterflyClaims, pb.AuthResult) {
 	if userID <= 0 || jwt == "" {
-		return nil, pb.AuthResult_JWT_ERROR
+		return nil, nil, pb.AuthResult_JWT_ERROR
 	}
 	user, err := db.GetUserById(userID)
 	if err != nil {
 		logger.Sugar().Errorw("账号安全操作读取用户失败", "user_id", userID, "error", err)
-		return nil, pb.AuthResult_SERVICE_ERROR
+		return nil, nil, pb.AuthResult_SERVICE_ERROR
 	}
 	if user == nil || len(user.JwtKey) == 0 {
-		return nil, pb.AuthResult_JWT_ERROR
+		return nil, nil, pb.AuthResult_JWT_ERROR
 	}
 	claims, err := utils.ValidateJWT(jwt, user.JwtKey)
 	if err != nil || claims.ID != user.ID || claims.Account != user.Account {
-		return nil, pb.AuthResult_JWT_ERROR
+		return nil, nil, pb.AuthResult_JWT_ERROR
+	}
+	if result := checkJwtSession(user, claims); result != pb.AuthResult_OK {
+		return nil, nil, result
+	}
+	return user, claims, pb.AuthResult_OK
+}
+
+// checkJwtSession 拒绝已吊销会话签发的令牌。旧版本令牌没有会话ID，只能通过轮换签名密钥失效。
+func checkJwtSession(user *db.User, claims *utils.BetterflyClaims) pb.AuthResult {
+	if claims.SessionID == "" {
+		return pb.AuthResult_OK
+	}
+	revoked, err := db.IsJwtSessionRevoked(user.ID, claims.SessionID, time.Now())
+	if err != nil {
+		logger.Sugar().Errorw("读取JWT会话吊销状态失败", "user_id", user.ID, "error", err)
+		return pb.AuthResult_SERVICE_ERROR
+	}
+	if revoked {
+		logger.Sugar().Warnw("拒绝已吊销会话的JWT", "user_id", user.ID, "session_id", claims.SessionID)
+		return pb.AuthResult_JWT_ERROR
+	}
+	return pb.AuthResult_OK
+}
+
+func sessionIDFor(claims *utils.BetterflyClaims) (string, error) {
+	if claims.SessionID != "" {
+		return claims.SessionID, nil
 	}
-	return user, pb.AuthResult_OK
+	return utils.NewSessionID()
 }
 
 func newJWTKey() ([]byte, error) {
@@ -49,7 +49,7 @@ func TestUserBriefString(t *testing.T) {
 func TestLoginRejectsInvalidJWTWithoutIssuingReplacement(t *testing.T) {
 	key := []byte("0123456789abcdef0123456789abcdef")
 	wrongKey := []byte("abcdef0123456789abcdef0123456789")
-	token, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: wrongKey})
+	token, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: wrongKey}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -69,7 +69,7 @@ func TestLoginRejectsInvalidJWTWithoutIssuingReplacement(t *testing.T) {
 }
 
 func TestLoginRejectsJWTWhenUserSigningKeyIsMissing(t *testing.T) {
-	token, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: nil})
+	token, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: nil}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,7 +115,7 @@ func TestPasswordLoginRejectsWrongPasswordAndIssuesJWTOnSuccess(t *testing.T) {
 			t.Fatal(err)
 		}
 		claims, validateErr := utils.ValidateJWT(resp.GetJwt(), key)
-		if resp.GetResult() != pb.AuthResult_OK || validateErr != nil || claims.ID != 9 || claims.Account != "alice" {
+		if resp.GetResult() != pb.AuthResult_OK || validateErr != nil || claims.ID != 9 || claims.Account != "alice" || claims.SessionID == "" || claims.SessionID != resp.GetSessionId() {
 			t.Fatalf("successful login did not issue a valid JWT: response=%+v claims=%+v err=%v", resp, claims, validateErr)
 		}
 	})
@@ -151,7 +151,7 @@ func TestChangePasswordRotatesCredentialsAndReturnsUsableJWT(t *testing.T) {
 		t.Fatal(err)
 	}
 	oldKey := []byte("0123456789abcdef0123456789abcdef")
-	oldJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: oldKey})
+	oldJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: oldKey}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -173,7 +173,7 @@ func TestChangePasswordRotatesCredentialsAndReturnsUsableJWT(t *testing.T) {
 		t.Fatalf("change password failed: response=%+v err=%v", response, err)
 	}
 	claims, err := utils.ValidateJWT(response.GetJwt(), newKey.value)
-	if err != nil || claims.ID != 9 || claims.Account != "alice" {
+	if err != nil || claims.ID != 9 || claims.Account != "alice" || claims.SessionID == "" {
 		t.Fatalf("new JWT is invalid: claims=%+v err=%v", claims, err)
 	}
 	if _, err := utils.ValidateJWT(oldJWT, newKey.value); err == nil {
@@ -186,6 +186,7 @@ func TestChangePasswordRotatesCredentialsAndReturnsUsableJWT(t *testing.T) {
 		t.Fatal("old password still matches replacement hash")
 	}
 	expectUserByID(mock, []byte(newHash.value), newKey.value)
+	expectJwtSessionRevoked(mock, claims.SessionID, false)
 	checkNew, err := (&AuthService{}).CheckJwt(context.Background(), &pb.CheckJwtReq{UserId: 9, Jwt: response.GetJwt()})
 	if err != nil || checkNew.GetResult() != pb.AuthResult_OK {
 		t.Fatalf("new JWT was rejected by Auth: response=%+v err=%v", checkNew, err)
@@ -214,7 +215,7 @@ func TestChangePasswordFailuresDoNotUpdateCredentials(t *testing.T) {
 		t.Fatal(err)
 	}
 	key := []byte("0123456789abcdef0123456789abcdef")
-	validJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: key})
+	validJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: key}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -267,7 +268,7 @@ func TestChangePasswordFailuresDoNotUpdateCredentials(t *testing.T) {
 func TestRevokeSessionsRotatesJWTKey(t *testing.T) {
 	passwordHash := []byte("password-hash")
 	oldKey := []byte("0123456789abcdef0123456789abcdef")
-	oldJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: oldKey})
+	oldJWT, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: oldKey}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -291,7 +292,7 @@ func TestRevokeSessionsRotatesJWTKey(t *testing.T) {
 
 func TestRevokeSessionsDatabaseFailureDoesNotReportSuccess(t *testing.T) {
 	key := []byte("0123456789abcdef0123456789abcdef")
-	jwt, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: key})
+	jwt, err := utils.GenerateJWT(&db.User{ID: 9, Account: "alice", JwtKey: key}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -308,6 +309,67 @@ func TestRevokeSessionsDatabaseFailureDoesNotReportSuccess(t *testing.T) {
 	}
 }
 
+func TestRevokedJwtSessionIsRejectedWhileOtherSessionsStayValid(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	user := &db.User{ID: 9, Account: "alice", JwtKey: key}
+	desktopJWT, err := utils.GenerateJWT(user, "desktop-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phoneJWT, err := utils.GenerateJWT(user, "phone-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := useAuthMockDB(t)
+
+	expectUserByID(mock, []byte("password-hash"), key)
+	expectJwtSessionRevoked(mock, "desktop-session", false)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "revoked_jwt_sessions" \("user_id","session_id","expires_at"\) VALUES \(\$1,\$2,\$3\) ON CONFLICT \("user_id","session_id"\) DO UPDATE SET "expires_at"="excluded"."expires_at"`).
+		WithArgs(int64(9), "phone-session", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	revoked, err := (&AuthService{}).RevokeJwtSession(context.Background(), &pb.RevokeJwtSessionReq{UserId: 9, Jwt: desktopJWT, SessionId: "phone-session"})
+	if err != nil || revoked.GetResult() != pb.AuthResult_OK {
+		t.Fatalf("revoke JWT session failed: response=%+v err=%v", revoked, err)
+	}
+
+	// 登录请求不携带设备ID，被吊销的令牌换任何设备都会在这里被拒绝
+	expectUserByAccount(mock, []byte("password-hash"), key)
+	expectJwtSessionRevoked(mock, "phone-session", true)
+	login, err := (&AuthService{}).Login(context.Background(), &pb.LoginReq{Account: "alice", Jwt: phoneJWT})
+	if err != nil || login.GetResult() != pb.AuthResult_JWT_ERROR || login.GetJwt() != "" || login.GetSessionId() != "" {
+		t.Fatalf("revoked JWT logged in: response=%+v err=%v", login, err)
+	}
+	expectUserByID(mock, []byte("password-hash"), key)
+	expectJwtSessionRevoked(mock, "phone-session", true)
+	check, err := (&AuthService{}).CheckJwt(context.Background(), &pb.CheckJwtReq{UserId: 9, Jwt: phoneJWT})
+	if err != nil || check.GetResult() != pb.AuthResult_JWT_ERROR {
+		t.Fatalf("revoked JWT passed CheckJwt: response=%+v err=%v", check, err)
+	}
+
+	expectUserByAccount(mock, []byte("password-hash"), key)
+	expectJwtSessionRevoked(mock, "desktop-session", false)
+	renewed, err := (&AuthService{}).Login(context.Background(), &pb.LoginReq{Account: "alice", Jwt: desktopJWT})
+	if err != nil || renewed.GetResult() != pb.AuthResult_OK || renewed.GetSessionId() != "desktop-session" {
+		t.Fatalf("other session lost its JWT login: response=%+v err=%v", renewed, err)
+	}
+	claims, err := utils.ValidateJWT(renewed.GetJwt(), key)
+	if err != nil || claims.SessionID != "desktop-session" {
+		t.Fatalf("renewed JWT left its session: claims=%+v err=%v", claims, err)
+	}
+}
+
+func expectJwtSessionRevoked(mock sqlmock.Sqlmock, sessionID string, revoked bool) {
+	count := 0
+	if revoked {
+		count = 1
+	}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "revoked_jwt_sessions" WHERE user_id = \$1 AND session_id = \$2 AND expires_at > \$3`).
+		WithArgs(int64(9), sessionID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
 func expectUserByAccount(mock sqlmock.Sqlmock, passwordHash []byte, key []byte) {
 	mock.ExpectQuery(`SELECT \* FROM "users" WHERE account = \$1`).
 		WithArgs("alice", 1).
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"Betterfly2/shared/db"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	goJwt "github.com/golang-jwt/jwt/v5"
@@ -16,14 +18,31 @@ const (
 type BetterflyClaims struct {
 	ID      int64  `json:"id"`
 	Account string `json:"account"`
+	// SessionID 标识一次账号密码登录，JWT续签和修改密码沿用同一会话ID，吊销时按它拒绝整条令牌链。
+	SessionID string `json:"sid,omitempty"`
 	goJwt.RegisteredClaims
 }
 
-func GenerateJWT(user *db.User) (string, error) {
+// NewSessionID 生成账号密码登录时绑定到JWT的会话ID。
+func NewSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// RevokedSessionExpiry 返回吊销记录的过期时间，此时该会话签发的最后一个令牌也已过期。
+func RevokedSessionExpiry(now time.Time) time.Time {
+	return now.Add(jwtExp + jwtClockSkew)
+}
+
+func GenerateJWT(user *db.User, sessionID string) (string, error) {
 	now := time.Now().UTC()
 	claims := BetterflyClaims{
-		ID:      user.ID,
-		Account: user.Account,
+		ID:        user.ID,
+		Account:   user.Account,
+		SessionID: sessionID,
 		RegisteredClaims: goJwt.RegisteredClaims{
 			ExpiresAt: goJwt.NewNumericDate(now.Add(jwtExp)),
 			IssuedAt:  goJwt.NewNumericDate(now),
@@ -11,7 +11,7 @@ import (
 
 func TestGenerateAndValidateJWTRoundTrip(t *testing.T) {
 	user := &db.User{ID: 42, Account: "alice", JwtKey: []byte("test-secret-with-enough-entropy")}
-	token, err := GenerateJWT(user)
+	token, err := GenerateJWT(user, "session-1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -20,7 +20,7 @@ func TestGenerateAndValidateJWTRoundTrip(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if claims.ID != user.ID || claims.Account != user.Account {
+	if claims.ID != user.ID || claims.Account != user.Account || claims.SessionID != "session-1" {
 		t.Fatalf("unexpected claims: %+v", claims)
 	}
 	if claims.ExpiresAt == nil || claims.IssuedAt == nil || claims.NotBefore == nil {
@@ -42,7 +42,7 @@ func TestValidateJWTRejectsWrongKeyExpiredAndMissingExpiration(t *testing.T) {
 		{
 			name: "wrong key",
 			token: func() string {
-				token, _ := GenerateJWT(&db.User{ID: 1, Account: "alice", JwtKey: key})
+				token, _ := GenerateJWT(&db.User{ID: 1, Account: "alice", JwtKey: key}, "")
 				return token
 			},
 			key: []byte("wrong-secret"),
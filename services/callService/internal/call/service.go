@@ -80,7 +80,7 @@ func (s *Service) Handle(ctx context.Context, request *callpb.InternalRequest) e
 	}
 
 	callID := requestCallID(request.GetRequest())
-	if publishErr := s.publishToTopic(ctx, request.GetFromKafkaTopic(), request.GetUserId(), s.errorEvent(callID, err)); publishErr != nil {
+	if publishErr := s.replyToRequester(ctx, request, s.errorEvent(callID, err)); publishErr != nil {
 		return fmt.Errorf("handle call request: %v; publish error response: %w", err, publishErr)
 	}
 	return nil
@@ -127,7 +127,7 @@ func (s *Service) getConfig(ctx context.Context, request *callpb.InternalRequest
 		IceServers: s.ice.Servers(request.GetUserId(), s.now().UTC()),
 		Timestamp:  timestamp(s.now()),
 	}
-	return s.publishToTopic(ctx, request.GetFromKafkaTopic(), request.GetUserId(), event)
+	return s.replyToRequester(ctx, request, event)
 }
 
 func (s *Service) initiate(ctx context.Context, request *callpb.InternalRequest, payload *callpb.InitiateCall) error {
@@ -204,7 +204,7 @@ func (s *Service) resumeCall(ctx context.Context, request *callpb.InternalReques
 	if session.State != StateRinging || !s.now().Before(session.RingDeadline) {
 		return ErrInvalidState
 	}
-	return s.publishToTopic(ctx, request.GetFromKafkaTopic(), session.CalleeUserID, s.incomingEvent(session, s.now().UTC()))
+	return s.replyToRequester(ctx, request, s.incomingEvent(session, s.now().UTC()))
 }
 
 func (s *Service) HandlePushResult(ctx context.Context, result *pushpb.VoIPPushResult) error {
@@ -292,6 +292,14 @@ func (s *Service) publishToTopic(ctx context.Context, topic string, userID int64
 	return asDeliveryError(s.publisher.Publish(ctx, topic, &callpb.Delivery{TargetUserId: userID, Event: event}))
 }
 
+// replyToRequester sends an event answering the request back to the requesting user,
+// echoing the client's request_id so it can be correlated.
+func (s *Service) replyToRequester(ctx context.Context, request *callpb.InternalRequest, event *callpb.CallEvent) error {
+	return asDeliveryError(s.publisher.Publish(ctx, request.GetFromKafkaTopic(), &callpb.Delivery{
+		TargetUserId: request.GetUserId(), Event: event, RequestId: request.GetRequestId(),
+	}))
+}
+
 func operationKey(ctx context.Context) string {
 	value, _ := kafkaconsumer.OperationKeyFromContext(ctx)
 	return value
@@ -308,7 +316,7 @@ func (s *Service) initiatePendingEvents(
 ) ([]PendingEvent, error) {
 	operation := operationKey(ctx)
 	events := make([]PendingEvent, 0, 3)
-	caller, err := pendingCallDelivery(operation, "outgoing", request.GetFromKafkaTopic(), session.CallerUserID, callerEvent)
+	caller, err := pendingReplyDelivery(operation, "outgoing", request, callerEvent)
 	if err != nil {
 		return nil, err
 	}
@@ -359,14 +367,14 @@ func (s *Service) acceptAtomic(ctx context.Context, request *callpb.InternalRequ
 		updated.EndMessage = "caller unavailable after accept"
 		updated.EndedAt = &now
 		ended := s.sessionEvent(callpb.CallEventType_CALL_ENDED, updated, expected.CallerUserID)
-		pending, err := pendingCallDelivery(operation, "callee-ended", request.GetFromKafkaTopic(), expected.CalleeUserID, ended)
+		pending, err := pendingReplyDelivery(operation, "callee-ended", request, ended)
 		if err != nil {
 			return err
 		}
 		events = append(events, pending)
 	} else {
 		calleeEvent := s.sessionEvent(callpb.CallEventType_CALL_ACCEPTED, updated, expected.CallerUserID)
-		calleePending, err := pendingCallDelivery(operation, "callee-accepted", request.GetFromKafkaTopic(), expected.CalleeUserID, calleeEvent)
+		calleePending, err := pendingReplyDelivery(operation, "callee-accepted", request, calleeEvent)
 		if err != nil {
 			return err
 		}
@@ -403,7 +411,7 @@ func (s *Service) rejectAtomic(ctx context.Context, request *callpb.InternalRequ
 	updated.EndMessage = payload.GetMessage()
 	updated.EndedAt = &now
 	operation := operationKey(ctx)
-	events, err := s.terminalPendingEvents(ctx, operation, request.GetFromKafkaTopic(), request.GetUserId(), updated, callpb.CallEventType_CALL_REJECTED)
+	events, err := s.terminalPendingEvents(ctx, operation, request, updated, callpb.CallEventType_CALL_REJECTED)
 	if err != nil {
 		return err
 	}
@@ -432,7 +440,7 @@ func (s *Service) hangupAtomic(ctx context.Context, request *callpb.InternalRequ
 	updated.EndMessage = ""
 	updated.EndedAt = &now
 	operation := operationKey(ctx)
-	events, err := s.terminalPendingEvents(ctx, operation, request.GetFromKafkaTopic(), request.GetUserId(), updated, callpb.CallEventType_CALL_ENDED)
+	events, err := s.terminalPendingEvents(ctx, operation, request, updated, callpb.CallEventType_CALL_ENDED)
 	if err != nil {
 		return err
 	}
@@ -440,13 +448,14 @@ func (s *Service) hangupAtomic(ctx context.Context, request *callpb.InternalRequ
 	return err
 }
 
-func (s *Service) terminalPendingEvents(ctx context.Context, operation, requesterTopic string, requesterID int64, session Session, eventType callpb.CallEventType) ([]PendingEvent, error) {
+func (s *Service) terminalPendingEvents(ctx context.Context, operation string, request *callpb.InternalRequest, session Session, eventType callpb.CallEventType) ([]PendingEvent, error) {
+	requesterID := request.GetUserId()
 	peerID, err := session.Peer(requesterID)
 	if err != nil {
 		return nil, err
 	}
 	requesterEvent := s.sessionEvent(eventType, session, peerID)
-	requesterPending, err := pendingCallDelivery(operation, "requester-terminal", requesterTopic, requesterID, requesterEvent)
+	requesterPending, err := pendingReplyDelivery(operation, "requester-terminal", request, requesterEvent)
 	if err != nil {
 		return nil, err
 	}
@@ -505,7 +514,18 @@ func (s *Service) handlePushResultAtomic(ctx context.Context, result *pushpb.VoI
 }
 
 func pendingCallDelivery(operation, suffix, topic string, userID int64, event *callpb.CallEvent) (PendingEvent, error) {
-	payload, err := mq.MarshalEnvelope(envelope.MessageType_CALL_RESPONSE, &callpb.Delivery{TargetUserId: userID, Event: event})
+	return pendingDelivery(operation, suffix, topic, &callpb.Delivery{TargetUserId: userID, Event: event})
+}
+
+// pendingReplyDelivery is the outbox form of replyToRequester.
+func pendingReplyDelivery(operation, suffix string, request *callpb.InternalRequest, event *callpb.CallEvent) (PendingEvent, error) {
+	return pendingDelivery(operation, suffix, request.GetFromKafkaTopic(), &callpb.Delivery{
+		TargetUserId: request.GetUserId(), Event: event, RequestId: request.GetRequestId(),
+	})
+}
+
+func pendingDelivery(operation, suffix, topic string, delivery *callpb.Delivery) (PendingEvent, error) {
+	payload, err := mq.MarshalEnvelope(envelope.MessageType_CALL_RESPONSE, delivery)
 	if err != nil {
 		return PendingEvent{}, err
 	}
@@ -205,7 +205,7 @@ func TestCallLifecycle(t *testing.T) {
 		t.Fatalf("ICE routed incorrectly: %+v", last)
 	}
 
-	hangup := &callpb.InternalRequest{FromKafkaTopic: "df-b", UserId: 2, Request: &callpb.ClientRequest{Payload: &callpb.ClientRequest_Hangup{Hangup: &callpb.HangupCall{CallId: callID}}}}
+	hangup := &callpb.InternalRequest{FromKafkaTopic: "df-b", UserId: 2, RequestId: "req-hangup", Request: &callpb.ClientRequest{Payload: &callpb.ClientRequest_Hangup{Hangup: &callpb.HangupCall{CallId: callID}}}}
 	if err := service.Handle(testCallContext("lifecycle-hangup"), hangup); err != nil {
 		t.Fatalf("hangup call: %v", err)
 	}
@@ -214,6 +214,9 @@ func TestCallLifecycle(t *testing.T) {
 	if requester.topic != "df-b" || requester.delivery.GetTargetUserId() != 2 || peer.topic != "df-a" || peer.delivery.GetTargetUserId() != 1 {
 		t.Fatalf("hangup events routed incorrectly: requester=%+v peer=%+v", requester, peer)
 	}
+	if requester.delivery.GetRequestId() != "req-hangup" || peer.delivery.GetRequestId() != "" {
+		t.Fatalf("request_id should only be echoed to the requester: requester=%q peer=%q", requester.delivery.GetRequestId(), peer.delivery.GetRequestId())
+	}
 }
 
 func TestOfflineCallUsesVoIPPushAndUnauthorizedAcceptIsRejected(t *testing.T) {
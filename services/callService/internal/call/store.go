@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -194,29 +195,35 @@ func (s *RedisStore) Ping(ctx context.Context) error {
 	return s.client.Ping(ctx).Err()
 }
 
+// UserTopic 返回用户任一在线设备所在的 DF 容器；DF 收到后会扇出到该用户的所有设备。
 func (s *RedisStore) UserTopic(ctx context.Context, userID int64) (string, error) {
 	userIDString := strconv.FormatInt(userID, 10)
-	topic, err := s.client.HGet(ctx, "ws_connection_mapping", userIDString).Result()
-	if errors.Is(err, redis.Nil) {
-		return "", ErrUserOffline
-	}
+	routes, err := s.client.HGetAll(ctx, "ws_user_routes:"+userIDString).Result()
 	if err != nil {
 		return "", err
 	}
-	if topic == "" {
-		return "", ErrUserOffline
-	}
-	lease, err := s.client.Get(ctx, "ws_route_lease:"+userIDString).Result()
-	if errors.Is(err, redis.Nil) {
-		return "", ErrUserOffline
-	}
-	if err != nil {
-		return "", err
+	deviceIDs := make([]string, 0, len(routes))
+	for deviceID := range routes {
+		deviceIDs = append(deviceIDs, deviceID)
 	}
-	if lease == "" || !strings.HasPrefix(lease, topic+"|") {
-		return "", ErrUserOffline
+	sort.Strings(deviceIDs)
+	for _, deviceID := range deviceIDs {
+		topic := routes[deviceID]
+		if topic == "" {
+			continue
+		}
+		lease, err := s.client.Get(ctx, "ws_route_lease:"+userIDString+":"+deviceID).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(lease, topic+"|") {
+			return topic, nil
+		}
 	}
-	return topic, nil
+	return "", ErrUserOffline
 }
 
 func (s *RedisStore) GetSession(ctx context.Context, callID string) (Session, error) {
@@ -97,13 +97,13 @@ func TestUserTopicRequiresLiveRouteLease(t *testing.T) {
 	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
 	store := NewRedisStore(client, time.Minute, time.Hour)
 	ctx := context.Background()
-	if err := client.HSet(ctx, "ws_connection_mapping", "42", "df-a").Err(); err != nil {
+	if err := client.HSet(ctx, "ws_user_routes:42", "phone", "df-a").Err(); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := store.UserTopic(ctx, 42); !errors.Is(err, ErrUserOffline) {
 		t.Fatalf("stale route without lease must be offline, got %v", err)
 	}
-	if err := client.Set(ctx, "ws_route_lease:42", "df-a|owner-token", time.Minute).Err(); err != nil {
+	if err := client.Set(ctx, "ws_route_lease:42:phone", "df-a|owner-token", time.Minute).Err(); err != nil {
 		t.Fatal(err)
 	}
 	if topic, err := store.UserTopic(ctx, 42); err != nil || topic != "df-a" {
@@ -2,13 +2,17 @@ package main
 
 import (
 	"Betterfly2/shared/logger"
+	"context"
 	"data_forwarding_service/internal/grpcClient"
 	"data_forwarding_service/internal/handlers"
 	"data_forwarding_service/internal/publisher"
 	redisClient "data_forwarding_service/internal/redis"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -63,10 +67,23 @@ func main() {
 
 	sugar.Infoln("Betterfly2服务器启动完成")
 
+	// 收到退出信号后先排空连接，客户端据 ServerDraining 重连到其他实例
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		sigterm := make(chan os.Signal, 1)
+		signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+		<-sigterm
+		sugar.Infoln("收到退出信号，开始排空WebSocket连接")
+		webSocketHandler.Drain(context.Background())
+	}()
+
 	err = webSocketHandler.StartWebSocketServer()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		sugar.Fatalln("启动 WebSocket 服务器失败: ", err)
 	}
+	<-drained
+	sugar.Infoln("Betterfly2服务器已退出")
 }
 
 func envBool(key string, fallback bool) bool {
@@ -0,0 +1,283 @@
+package connection
+
+import (
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/metrics"
+	"context"
+	redisClient "data_forwarding_service/internal/redis"
+	"fmt"
+	"time"
+)
+
+const (
+	// spillTimeout 限制单次读写 Redis 待发送队列的耗时
+	spillTimeout = time.Second
+	// spillBatchSize 是 spill 协程单次写入 Redis 的最大帧数
+	spillBatchSize = 256
+)
+
+// OverflowPolicy 决定发送通道已满时如何处理新帧：
+//   - CoalesceKey 返回非空键的帧是可替换帧（在线状态、输入状态等），同一键只保留最新一帧，由写协程稍后发送；
+//   - 其余帧按顺序暂存到 Redis 中本连接的待发送队列，写协程发完通道中的帧后再取回；
+//   - 暂存帧达到 SpillLimit 或 Redis 不可用时缓冲区耗尽，写协程发送 SyncRequired 生成的帧后断开连接。
+//
+// Redis 读写由每个连接在首次暂存时启动的 spill 协程完成，入队和 TakePending 只在内存中交接帧，
+// 因此一个写得慢的客户端不会拖住向其他连接扇出的发送方。
+//
+// 没有设置 OverflowPolicy 的连接在通道已满时直接返回错误。
+type OverflowPolicy struct {
+	CoalesceKey  func(message []byte) string
+	SyncRequired func(since time.Time, sinceSeq int64) []byte
+	SpillLimit   int
+	SpillTTL     time.Duration
+}
+
+// SetOverflowPolicy 设置所有连接共用的发送溢出策略，需要在开始接受连接前调用。
+func (cm *ConnectionManager) SetOverflowPolicy(policy *OverflowPolicy) {
+	cm.overflowPolicy = policy
+}
+
+// SendQueueStats 返回本容器内连接发送队列的最大长度，以及正在使用 Redis 待发送队列的连接数。
+func (cm *ConnectionManager) SendQueueStats() (maxDepth, spilling int) {
+	cm.connections.Range(func(_, value any) bool {
+		connection := value.(*Connection)
+		maxDepth = max(maxDepth, len(connection.SendChan))
+		if connection.spilled.Load() > 0 {
+			spilling++
+		}
+		return true
+	})
+	return maxDepth, spilling
+}
+
+// Pending 在有合并帧或暂存帧等待发送时可读，写协程收到后应调用 TakePending。
+func (c *Connection) Pending() <-chan struct{} { return c.pending }
+
+// Exhausted 在发送缓冲区耗尽后关闭，写协程应发送 SyncRequiredFrame 后断开连接。
+func (c *Connection) Exhausted() <-chan struct{} { return c.exhausted }
+
+// SyncRequiredFrame 返回缓冲区耗尽时发给客户端的帧，只能在 Exhausted 关闭后调用。
+func (c *Connection) SyncRequiredFrame() []byte { return c.syncRequired }
+
+// TakePending 取回等待发送的帧：先是暂存帧中最早的最多 max 帧，再是合并后的可替换帧。
+// 调用方需要先发完 SendChan 中已有的帧，暂存帧才能保持顺序；暂存帧还在 Redis 中时由 spill 协程取回，
+// 取回后或队列未取完时会再次触发 Pending。
+func (c *Connection) TakePending(max int) [][]byte {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	var frames [][]byte
+	if c.spilled.Load() > 0 {
+		frames = c.takeSpilled(max)
+		if remaining := c.spilled.Add(-int64(len(frames))); remaining <= 0 {
+			// 暂存帧已经取空，之后的帧重新直接进入发送通道
+			c.spilled.Store(0)
+			c.overflowSince, c.overflowSeq = time.Time{}, 0
+		}
+	}
+	for _, key := range c.coalesceOrder {
+		frames = append(frames, c.coalesced[key])
+	}
+	c.coalesced, c.coalesceOrder = nil, nil
+	return frames
+}
+
+// takeSpilled 按顺序取出已经从 Redis 取回的帧；Redis 中没有更早的帧时直接取走尚未写入的帧。
+// 还有帧留在 Redis 时请求 spill 协程取回下一批。调用方持有 sequenceMu。
+func (c *Connection) takeSpilled(max int) [][]byte {
+	c.spillMu.Lock()
+	defer c.spillMu.Unlock()
+	count := min(max, len(c.spillInbox))
+	frames := c.spillInbox[:count:count]
+	c.spillInbox = c.spillInbox[count:]
+	if count < max && c.spillStored == 0 && !c.spillBusy {
+		direct := min(max-count, len(c.spillOutbox))
+		frames = append(frames, c.spillOutbox[:direct]...)
+		c.spillOutbox = c.spillOutbox[direct:]
+	}
+	switch {
+	case len(c.spillInbox) > 0:
+		c.signalPending()
+	case c.spillStored > 0 || c.spillBusy || len(c.spillOutbox) > 0:
+		c.spillWant = max
+		c.wakeSpiller()
+	}
+	return frames
+}
+
+// overflowEnqueue 处理发送通道已满或已有暂存帧时的入队，调用方持有 sequenceMu 与 sendMu 读锁。
+// 持久帧只放入内存中的待写队列，由 spill 协程写入 Redis，这里不做网络 I/O。
+func (c *Connection) overflowEnqueue(message []byte, seq int64, coalesceKey string) error {
+	if coalesceKey != "" {
+		if _, exists := c.coalesced[coalesceKey]; !exists {
+			if c.coalesced == nil {
+				c.coalesced = make(map[string][]byte)
+			}
+			c.coalesceOrder = append(c.coalesceOrder, coalesceKey)
+		}
+		c.coalesced[coalesceKey] = message
+		metrics.RecordWebSocketSendOverflow("coalesced")
+		c.signalPending()
+		return nil
+	}
+
+	if c.spilled.Load() == 0 {
+		c.overflowSince = time.Now()
+	}
+	if c.overflowSeq == 0 {
+		c.overflowSeq = seq
+	}
+	if !c.IsAuthenticated() || c.UserID == "" {
+		c.exhaust()
+		return fmt.Errorf("发送通道已满: %s", c.ID)
+	}
+	if c.spilled.Load() >= int64(c.overflow.SpillLimit) {
+		logger.Sugar().Warnw("发送缓冲区耗尽，要求客户端重新同步", "connection_id", c.ID, "user_id", c.UserID, "device_id", c.DeviceID, "spilled", c.spilled.Load())
+		c.exhaust()
+		return fmt.Errorf("发送缓冲区已耗尽: %s: %w", c.ID, redisClient.ErrSpillQueueFull)
+	}
+	c.spillMu.Lock()
+	c.spillOutbox = append(c.spillOutbox, message)
+	c.spillMu.Unlock()
+	c.spilled.Add(1)
+	metrics.RecordWebSocketSendOverflow("spilled")
+	c.wakeSpiller()
+	c.signalPending()
+	return nil
+}
+
+// wakeSpiller 在首次调用时启动本连接的 spill 协程，之后只唤醒它。
+func (c *Connection) wakeSpiller() {
+	c.spillOnce.Do(func() {
+		c.spillWake = make(chan struct{}, 1)
+		go c.runSpiller()
+	})
+	select {
+	case c.spillWake <- struct{}{}:
+	default:
+	}
+}
+
+// runSpiller 依次把待写队列写入 Redis、按写协程的请求取回暂存帧，连接关闭后删除未取回的帧。
+func (c *Connection) runSpiller() {
+	for {
+		select {
+		case <-c.done:
+			c.discardSpilled()
+			return
+		case <-c.spillWake:
+			for c.spillStep() {
+			}
+		}
+	}
+}
+
+// spillStep 完成一次 Redis 读写，没有需要处理的帧或连接已关闭时返回 false。
+func (c *Connection) spillStep() bool {
+	if c.closed.Load() || c.isExhausted() {
+		return false
+	}
+	c.spillMu.Lock()
+	batch := c.spillOutbox[:min(len(c.spillOutbox), spillBatchSize)]
+	fetch := 0
+	if len(batch) == 0 && len(c.spillInbox) == 0 && c.spillWant > 0 {
+		fetch = min(c.spillWant, c.spillStored)
+	}
+	if len(batch) == 0 && fetch == 0 {
+		c.spillMu.Unlock()
+		return false
+	}
+	c.spillOutbox = c.spillOutbox[len(batch):]
+	c.spillBusy = true
+	c.spillMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), spillTimeout)
+	defer cancel()
+	if len(batch) > 0 {
+		err := redisClient.PushSpilledFrames(ctx, c.UserID, c.DeviceID, c.OwnerToken, batch, c.overflow.SpillLimit, c.overflow.SpillTTL)
+		c.spillMu.Lock()
+		c.spillBusy = false
+		if err == nil {
+			c.spillStored += len(batch)
+		}
+		c.spillMu.Unlock()
+		if err != nil {
+			c.failSpill("写入待发送队列失败", err)
+			return false
+		}
+		return true
+	}
+
+	frames, err := redisClient.PopSpilledFrames(ctx, c.UserID, c.DeviceID, c.OwnerToken, fetch)
+	if err == nil && len(frames) < fetch {
+		err = fmt.Errorf("待发送队列缺少 %d 帧", fetch-len(frames))
+	}
+	c.spillMu.Lock()
+	c.spillBusy = false
+	c.spillWant = 0
+	c.spillStored -= len(frames)
+	c.spillInbox = append(c.spillInbox, frames...)
+	c.spillMu.Unlock()
+	if err != nil {
+		c.failSpill("读取待发送队列失败", err)
+		return false
+	}
+	c.signalPending()
+	return true
+}
+
+// failSpill 在 Redis 读写失败时让连接进入耗尽状态，客户端按 SyncRequired 重新同步。
+func (c *Connection) failSpill(message string, err error) {
+	logger.Sugar().Warnw(message, "connection_id", c.ID, "user_id", c.UserID, "device_id", c.DeviceID, "error", err)
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	c.exhaust()
+}
+
+func (c *Connection) coalesceKey(message []byte) string {
+	if c.overflow == nil || c.overflow.CoalesceKey == nil {
+		return ""
+	}
+	return c.overflow.CoalesceKey(message)
+}
+
+func (c *Connection) isExhausted() bool {
+	select {
+	case <-c.exhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Connection) signalPending() {
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+}
+
+// exhaust 只生效一次，之后写协程发送 SyncRequired 帧并断开连接。调用方持有 sequenceMu。
+func (c *Connection) exhaust() {
+	c.exhaustOnce.Do(func() {
+		if c.overflow.SyncRequired != nil {
+			c.syncRequired = c.overflow.SyncRequired(c.overflowSince, c.overflowSeq)
+		}
+		metrics.RecordWebSocketSendOverflow("sync_required")
+		close(c.exhausted)
+	})
+}
+
+// discardSpilled 在连接关闭后由 spill 协程删除未取回的暂存帧
+func (c *Connection) discardSpilled() {
+	c.spillMu.Lock()
+	stored := c.spillStored > 0
+	c.spillMu.Unlock()
+	if !stored {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), spillTimeout)
+	defer cancel()
+	if err := redisClient.DeleteSpilledFrames(ctx, c.UserID, c.DeviceID, c.OwnerToken); err != nil {
+		logger.Sugar().Warnw("删除待发送队列失败", "connection_id", c.ID, "user_id", c.UserID, "error", err)
+	}
+}
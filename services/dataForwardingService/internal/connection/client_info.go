@@ -0,0 +1,56 @@
+package connection
+
+// ClientInfo 是客户端在 Hello 握手中声明的协议版本与能力，未握手的连接为 nil，按旧客户端处理。
+type ClientInfo struct {
+	ProtocolVersion int32
+	AppVersion      string
+	Platform        string
+	capabilities    map[string]struct{}
+}
+
+// FrameAdapter 在帧入队前按客户端能力改写帧，deliver 为 false 时该帧不发送给此连接。
+// client 为 nil 表示客户端没有握手。
+type FrameAdapter func(client *ClientInfo, message []byte) (adapted []byte, deliver bool)
+
+func NewClientInfo(protocolVersion int32, appVersion, platform string, capabilities []string) *ClientInfo {
+	info := &ClientInfo{
+		ProtocolVersion: protocolVersion,
+		AppVersion:      appVersion,
+		Platform:        platform,
+		capabilities:    make(map[string]struct{}, len(capabilities)),
+	}
+	for _, capability := range capabilities {
+		info.capabilities[capability] = struct{}{}
+	}
+	return info
+}
+
+// Supports 判断客户端是否声明了某项能力，nil 不支持任何能力。
+func (i *ClientInfo) Supports(capability string) bool {
+	if i == nil {
+		return false
+	}
+	_, ok := i.capabilities[capability]
+	return ok
+}
+
+// SetFrameAdapter 设置所有连接共用的帧适配函数，需要在开始接受连接前调用。
+func (cm *ConnectionManager) SetFrameAdapter(adapter FrameAdapter) {
+	cm.frameAdapter = adapter
+}
+
+// SetClientInfo 记录客户端握手结果，之后入队的帧按新能力适配。
+func (c *Connection) SetClientInfo(info *ClientInfo) {
+	c.clientInfo.Store(info)
+}
+
+func (c *Connection) ClientInfo() *ClientInfo {
+	return c.clientInfo.Load()
+}
+
+func (c *Connection) adapt(message []byte) ([]byte, bool) {
+	if c.frameAdapter == nil {
+		return message, true
+	}
+	return c.frameAdapter(c.clientInfo.Load(), message)
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,24 +20,70 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// FrameSequencer 为即将入队的帧分配会话序列号，返回序列号和实际发送的字节。
+// 调用方持有连接的入队锁，实现不应在其中等待网络 I/O。
+type FrameSequencer func(message []byte) (int64, []byte, error)
+
+// Transport 标识连接使用的传输方式。
+type Transport string
+
+const (
+	TransportWebSocket Transport = "websocket"
+	// TransportHTTP 是 HTTP 请求加 SSE/长轮询的回退传输，Conn 为空，由 HTTP 处理器代替读写协程。
+	TransportHTTP Transport = "http"
+)
+
+// PresenceListener 在设备会话登录成功（online=true）或断开（online=false）后同步调用，不能阻塞。
+type PresenceListener func(userID string, online bool)
+
 type Connection struct {
 	ID            string
 	UserID        string
 	OwnerToken    string
+	DeviceID      string
+	Platform      string
 	Conn          *websocket.Conn
+	Transport     Transport
+	Subprotocol   string // 协商的帧格式，为空时使用二进制 protobuf 帧
 	SendChan      chan []byte
 	ShouldStop    bool
 	LoggedIn      bool
 	sendMu        sync.RWMutex
+	sequenceMu    sync.Mutex // 保证序列号分配顺序与入队顺序一致
+	sequencer     FrameSequencer
+	firstSeq      int64 // 本连接发出的第一个序列号，由 sequenceMu 保护
+	lastSeq       int64 // 本连接发出的最后一个序列号，由 sequenceMu 保护
 	closeOnce     sync.Once
 	closed        atomic.Bool
 	authenticated atomic.Bool
 	done          chan struct{}
+	clientInfo    atomic.Pointer[ClientInfo]
+	frameAdapter  FrameAdapter
+	overflow      *OverflowPolicy
+	pending       chan struct{} // 有合并帧或暂存帧等待写协程取回，容量为 1
+	exhausted     chan struct{}
+	exhaustOnce   sync.Once
+	syncRequired  []byte
+	// 以下字段由 sequenceMu 保护；spilled 为尚未交给写协程的暂存帧数，另外供统计无锁读取
+	coalesced     map[string][]byte
+	coalesceOrder []string
+	spilled       atomic.Int64
+	overflowSince time.Time
+	overflowSeq   int64
+	// 以下字段由 spillMu 保护，暂存帧依次经过 spillOutbox、Redis 与 spillInbox，见 runSpiller
+	spillMu     sync.Mutex
+	spillOutbox [][]byte // 等待 spill 协程写入 Redis 的帧
+	spillInbox  [][]byte // spill 协程从 Redis 取回、等待写协程取走的帧
+	spillStored int      // Redis 待发送队列中的帧数
+	spillWant   int      // 写协程请求取回的帧数，取回后清零
+	spillBusy   bool     // spill 协程正在读写 Redis
+	spillOnce   sync.Once
+	spillWake   chan struct{}
 }
 
 type ConnectionManager struct {
 	connections         *sync.Map
-	userConnections     *sync.Map
+	userConnections     map[string]map[string]string // userID -> deviceID -> connectionID，由 mutex 保护
 	mutex               sync.RWMutex
 	instanceID          string
 	connectionCount     int64
@@ -45,13 +92,16 @@ type ConnectionManager struct {
 	beforeExternalLogin func(context.Context, string) error
 	sessionLeaseTTL     time.Duration
 	routeLeaseTTL       time.Duration
+	presenceListener    PresenceListener
+	frameAdapter        FrameAdapter
+	overflowPolicy      *OverflowPolicy
 }
 
 func NewConnectionManager() *ConnectionManager {
 	instanceID := fmt.Sprintf("cm-%d", time.Now().UnixNano())
 	return &ConnectionManager{
 		connections:     &sync.Map{},
-		userConnections: &sync.Map{},
+		userConnections: make(map[string]map[string]string),
 		instanceID:      instanceID,
 		userLocks:       newKeyedLocker(),
 		sessionLeaseTTL: 2 * time.Minute,
@@ -68,26 +118,90 @@ func (cm *ConnectionManager) ConfigureSessionLeases(sessionTTL, routeTTL time.Du
 	}
 }
 
+// SetPresenceListener 设置设备会话上下线的回调，需要在开始接受连接前调用。
+func (cm *ConnectionManager) SetPresenceListener(listener PresenceListener) {
+	cm.presenceListener = listener
+}
+
 func (cm *ConnectionManager) AddConnection(conn *websocket.Conn) *Connection {
-	connection := &Connection{
-		ID:       conn.RemoteAddr().String(),
-		Conn:     conn,
-		SendChan: make(chan []byte, 256),
-		done:     make(chan struct{}),
+	connection := cm.newConnection(conn.RemoteAddr().String(), TransportWebSocket, conn.Subprotocol())
+	connection.Conn = conn
+	cm.storeConnection(connection)
+	return connection
+}
+
+// AddHTTPConnection 登记一个 HTTP 回退传输的连接，登录、路由与踢出流程与 WebSocket 连接相同。
+func (cm *ConnectionManager) AddHTTPConnection(connectionID, subprotocol string) *Connection {
+	connection := cm.newConnection(connectionID, TransportHTTP, subprotocol)
+	cm.storeConnection(connection)
+	return connection
+}
+
+func (cm *ConnectionManager) newConnection(connectionID string, transport Transport, subprotocol string) *Connection {
+	return &Connection{
+		ID:           connectionID,
+		Transport:    transport,
+		Subprotocol:  subprotocol,
+		SendChan:     make(chan []byte, 256),
+		done:         make(chan struct{}),
+		frameAdapter: cm.frameAdapter,
+		overflow:     cm.overflowPolicy,
+		pending:      make(chan struct{}, 1),
+		exhausted:    make(chan struct{}),
 	}
+}
+
+func (cm *ConnectionManager) storeConnection(connection *Connection) {
 	cm.connections.Store(connection.ID, connection)
 	atomic.AddInt64(&cm.connectionCount, 1)
 	metrics.RecordWebSocketConnectionOpened()
-	return connection
 }
 
-// Login first claims distributed ownership and only then commits local state.
+const (
+	maxDeviceIDLength = 64
+	maxPlatformLength = 32
+)
+
+// NormalizeDeviceID 校验客户端上报的设备ID，空值回落到默认设备。
+// 设备ID会出现在Redis键中，因此只允许字母、数字与 "._-"。
+func NormalizeDeviceID(rawDeviceID string) (string, error) {
+	deviceID := strings.TrimSpace(rawDeviceID)
+	if deviceID == "" {
+		return redisClient.DefaultDeviceID, nil
+	}
+	if len(deviceID) > maxDeviceIDLength {
+		return "", fmt.Errorf("设备ID过长: %d", len(deviceID))
+	}
+	for _, r := range deviceID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return "", fmt.Errorf("无效设备ID: %q", rawDeviceID)
+		}
+	}
+	return deviceID, nil
+}
+
+// Login 以默认设备登录，未上报设备信息的旧客户端仍保持单会话语义。
 func (cm *ConnectionManager) Login(ctx context.Context, connectionID, rawUserID string) error {
+	return cm.LoginDevice(ctx, connectionID, rawUserID, "", "", "")
+}
+
+// LoginDevice first claims distributed ownership of the (user, device) session
+// and only then commits local state. Sessions of other devices stay online.
+// jwtSessionID is recorded with the session so the device's token can be revoked.
+func (cm *ConnectionManager) LoginDevice(ctx context.Context, connectionID, rawUserID, rawDeviceID, platform, jwtSessionID string) error {
 	parsedUserID, err := strconv.ParseInt(strings.TrimSpace(rawUserID), 10, 64)
 	if err != nil || parsedUserID <= 0 {
 		return fmt.Errorf("无效用户ID: %q", rawUserID)
 	}
 	userID := strconv.FormatInt(parsedUserID, 10)
+	deviceID, err := NormalizeDeviceID(rawDeviceID)
+	if err != nil {
+		return err
+	}
+	platform = strings.TrimSpace(platform)
+	if len(platform) > maxPlatformLength {
+		platform = platform[:maxPlatformLength]
+	}
 	unlock, err := cm.userLocks.Lock(ctx, userID)
 	if err != nil {
 		return err
@@ -114,21 +228,24 @@ func (cm *ConnectionManager) Login(ctx context.Context, connectionID, rawUserID
 	}
 	defer releaseDistributedUserLock(dsm, userID, ownerToken)
 
-	previous, exists, err := dsm.GetUserSession(ctx, userID)
+	previous, exists, err := dsm.GetUserSession(ctx, userID, deviceID)
 	if err != nil {
 		return fmt.Errorf("读取现有会话失败: %w", err)
 	}
-	if exists && previous.ContainerID != "" && previous.OwnerToken != "" {
-		if err := dsm.PublishOwnedKickNotification(ctx, userID, previous.ContainerID, previous.OwnerToken); err != nil {
-			logger.Sugar().Warnw("发送旧会话踢出通知失败", "user_id", userID, "container_id", previous.ContainerID, "error", err)
-			kick := fmt.Sprintf("DELETE USER %s TARGET %s OWNER %s", userID, previous.ContainerID, previous.OwnerToken)
-			if kafkaErr := publisher.PublishRawMessageContext(ctx, []byte(kick), "user-kick-topic", nil); kafkaErr != nil {
-				return fmt.Errorf("发布旧会话踢出通知失败: redis=%v kafka=%w", err, kafkaErr)
-			}
+	if exists {
+		if err := publishSessionKick(ctx, dsm, userID, previous); err != nil {
+			return err
 		}
 	}
 
-	claimed := redisClient.SessionData{ConnectionID: connectionID, ContainerID: containerID, OwnerToken: ownerToken}
+	claimed := redisClient.SessionData{
+		ConnectionID: connectionID,
+		ContainerID:  containerID,
+		OwnerToken:   ownerToken,
+		DeviceID:     deviceID,
+		Platform:     platform,
+		JwtSessionID: jwtSessionID,
+	}
 	if err := dsm.ClaimSessionAndRoute(ctx, userID, claimed, cm.sessionLeaseTTL, cm.routeLeaseTTL); err != nil {
 		return fmt.Errorf("声明会话所有权失败: %w", err)
 	}
@@ -136,7 +253,7 @@ func (cm *ConnectionManager) Login(ctx context.Context, connectionID, rawUserID
 		cleanupOwnedSession(dsm, userID, claimed)
 		return err
 	}
-	oldConnection, err := cm.commitLocalLogin(connectionID, userID, ownerToken)
+	oldConnection, err := cm.commitLocalLogin(connectionID, userID, deviceID, platform, ownerToken)
 	if err != nil {
 		cleanupOwnedSession(dsm, userID, claimed)
 		return err
@@ -145,11 +262,49 @@ func (cm *ConnectionManager) Login(ctx context.Context, connectionID, rawUserID
 		oldConnection.Close()
 	}
 	metrics.UpdateOnlineUsers(cm.GetLoggedInUserCount())
-	logger.Sugar().Infof("用户登录成功: %s (容器: %s)", userID, containerID)
+	logger.Sugar().Infof("用户登录成功: %s (设备: %s, 容器: %s)", userID, deviceID, containerID)
+	if cm.presenceListener != nil {
+		cm.presenceListener(userID, true)
+	}
+	return nil
+}
+
+// KickUserSessions 通知所有持有该用户设备会话的容器关闭对应连接，用于全端登出。
+func (cm *ConnectionManager) KickUserSessions(ctx context.Context, userID string) error {
+	dsm := &redisClient.DistributedSessionManager{}
+	sessions, err := dsm.ListUserSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("读取用户设备会话失败: %w", err)
+	}
+	for _, session := range sessions {
+		if err := publishSessionKick(ctx, dsm, userID, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KickSession 通知持有指定设备会话的容器关闭该连接。
+func (cm *ConnectionManager) KickSession(ctx context.Context, userID string, session redisClient.SessionData) error {
+	return publishSessionKick(ctx, &redisClient.DistributedSessionManager{}, userID, session)
+}
+
+// publishSessionKick 优先通过 Redis 实时通知旧会话所在容器，失败时回落到 Kafka。
+func publishSessionKick(ctx context.Context, dsm *redisClient.DistributedSessionManager, userID string, session redisClient.SessionData) error {
+	if session.ContainerID == "" || session.OwnerToken == "" {
+		return nil
+	}
+	if err := dsm.PublishOwnedKickNotification(ctx, userID, session.ContainerID, session.OwnerToken); err != nil {
+		logger.Sugar().Warnw("发送旧会话踢出通知失败", "user_id", userID, "device_id", session.DeviceID, "container_id", session.ContainerID, "error", err)
+		kick := fmt.Sprintf("DELETE USER %s TARGET %s OWNER %s", userID, session.ContainerID, session.OwnerToken)
+		if kafkaErr := publisher.PublishRawMessageContext(ctx, []byte(kick), "user-kick-topic", nil); kafkaErr != nil {
+			return fmt.Errorf("发布旧会话踢出通知失败: redis=%v kafka=%w", err, kafkaErr)
+		}
+	}
 	return nil
 }
 
-func (cm *ConnectionManager) commitLocalLogin(connectionID, userID, ownerToken string) (*Connection, error) {
+func (cm *ConnectionManager) commitLocalLogin(connectionID, userID, deviceID, platform, ownerToken string) (*Connection, error) {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 	value, ok := cm.connections.Load(connectionID)
@@ -157,22 +312,43 @@ func (cm *ConnectionManager) commitLocalLogin(connectionID, userID, ownerToken s
 		return nil, fmt.Errorf("连接不存在或状态已变化: %s", connectionID)
 	}
 	var old *Connection
-	if oldID, exists := cm.userConnections.Load(userID); exists && oldID.(string) != connectionID {
-		if oldValue, found := cm.connections.LoadAndDelete(oldID.(string)); found {
+	if oldID, exists := cm.userConnections[userID][deviceID]; exists && oldID != connectionID {
+		if oldValue, found := cm.connections.LoadAndDelete(oldID); found {
 			old = oldValue.(*Connection)
 			atomic.AddInt64(&cm.connectionCount, -1)
-			if old.IsAuthenticated() {
-				atomic.AddInt64(&cm.loggedInCount, -1)
-			}
 		}
+		cm.unbindUserConnectionLocked(userID, deviceID)
 	}
 	connection := value.(*Connection)
+	connection.DeviceID = deviceID
+	connection.Platform = platform
 	connection.MarkAuthenticated(userID, ownerToken)
-	cm.userConnections.Store(userID, connectionID)
-	atomic.AddInt64(&cm.loggedInCount, 1)
+	cm.bindUserConnectionLocked(userID, deviceID, connectionID)
 	return old, nil
 }
 
+func (cm *ConnectionManager) bindUserConnectionLocked(userID, deviceID, connectionID string) {
+	devices := cm.userConnections[userID]
+	if devices == nil {
+		devices = make(map[string]string)
+		cm.userConnections[userID] = devices
+	}
+	devices[deviceID] = connectionID
+	atomic.AddInt64(&cm.loggedInCount, 1)
+}
+
+func (cm *ConnectionManager) unbindUserConnectionLocked(userID, deviceID string) {
+	devices := cm.userConnections[userID]
+	if _, ok := devices[deviceID]; !ok {
+		return
+	}
+	delete(devices, deviceID)
+	if len(devices) == 0 {
+		delete(cm.userConnections, userID)
+	}
+	atomic.AddInt64(&cm.loggedInCount, -1)
+}
+
 func acquireDistributedUserLock(ctx context.Context, dsm *redisClient.DistributedSessionManager, userID, ownerToken string) error {
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
@@ -231,11 +407,24 @@ func (cm *ConnectionManager) RemoveConnection(connectionID string) {
 			ConnectionID: connection.ID,
 			ContainerID:  currentContainerID(),
 			OwnerToken:   connection.OwnerToken,
+			DeviceID:     connection.DeviceID,
 		})
+		recordLastSeen(connection.UserID)
+		if cm.presenceListener != nil {
+			cm.presenceListener(connection.UserID, false)
+		}
 	}
 	metrics.UpdateOnlineUsers(cm.GetLoggedInUserCount())
 }
 
+func recordLastSeen(userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := redisClient.RecordLastSeen(ctx, userID, time.Now()); err != nil {
+		logger.Sugar().Warnf("记录最后在线时间失败: user_id=%s err=%v", userID, err)
+	}
+}
+
 func (cm *ConnectionManager) detachConnection(connectionID string) (*Connection, bool) {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
@@ -247,24 +436,31 @@ func (cm *ConnectionManager) detachConnection(connectionID string) (*Connection,
 	atomic.AddInt64(&cm.connectionCount, -1)
 	removeOwnership := false
 	if connection.IsAuthenticated() && connection.UserID != "" {
-		if mapped, exists := cm.userConnections.Load(connection.UserID); exists && mapped.(string) == connectionID {
-			cm.userConnections.Delete(connection.UserID)
-			atomic.AddInt64(&cm.loggedInCount, -1)
+		if mapped, exists := cm.userConnections[connection.UserID][connection.DeviceID]; exists && mapped == connectionID {
+			cm.unbindUserConnectionLocked(connection.UserID, connection.DeviceID)
 			removeOwnership = true
 		}
 	}
 	return connection, removeOwnership
 }
 
-func (cm *ConnectionManager) GetConnectionByUserID(userID string) (*Connection, bool) {
+// GetConnectionsByUserID 返回用户在本容器内所有已登录设备的连接，按设备ID排序。
+func (cm *ConnectionManager) GetConnectionsByUserID(userID string) []*Connection {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
-	if connectionID, ok := cm.userConnections.Load(userID); ok {
-		if value, exists := cm.connections.Load(connectionID); exists {
-			return value.(*Connection), true
+	devices := cm.userConnections[userID]
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	connections := make([]*Connection, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		if value, exists := cm.connections.Load(devices[deviceID]); exists {
+			connections = append(connections, value.(*Connection))
 		}
 	}
-	return nil, false
+	return connections
 }
 
 func (cm *ConnectionManager) GetConnectionByID(connectionID string) (*Connection, bool) {
@@ -277,43 +473,176 @@ func (cm *ConnectionManager) GetConnectionByID(connectionID string) (*Connection
 	return value.(*Connection), true
 }
 
+// Connections 返回本容器内所有连接的快照，包括尚未登录的连接。
+func (cm *ConnectionManager) Connections() []*Connection {
+	connections := make([]*Connection, 0, cm.GetConnectionCount())
+	cm.connections.Range(func(_, value any) bool {
+		connections = append(connections, value.(*Connection))
+		return true
+	})
+	return connections
+}
+
 func (cm *ConnectionManager) GetInstanceID() string { return cm.instanceID }
 
+// SendMessageToUser 将消息投递到用户在本容器内的每个设备连接，任一设备失败都会返回错误。
 func (cm *ConnectionManager) SendMessageToUser(userID string, message []byte) error {
-	connection, ok := cm.GetConnectionByUserID(userID)
-	if !ok {
+	connections := cm.GetConnectionsByUserID(userID)
+	if len(connections) == 0 {
+		return fmt.Errorf("用户未连接: %s", userID)
+	}
+	var firstErr error
+	for _, connection := range connections {
+		if err := connection.EnqueueMessage(message); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SendEphemeralToUser 与 SendMessageToUser 相同，但帧不分配会话序列号，断线后也不会补发。
+func (cm *ConnectionManager) SendEphemeralToUser(userID string, message []byte) error {
+	connections := cm.GetConnectionsByUserID(userID)
+	if len(connections) == 0 {
 		return fmt.Errorf("用户未连接: %s", userID)
 	}
-	return connection.EnqueueMessage(message)
+	var firstErr error
+	for _, connection := range connections {
+		if err := connection.EnqueueUnsequenced(message); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (cm *ConnectionManager) GetConnectionCount() int {
 	return int(atomic.LoadInt64(&cm.connectionCount))
 }
+
+// GetLoggedInUserCount 返回本容器内至少有一个已登录设备的用户数。
 func (cm *ConnectionManager) GetLoggedInUserCount() int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return len(cm.userConnections)
+}
+
+// GetLoggedInSessionCount 返回本容器内已登录的设备会话数。
+func (cm *ConnectionManager) GetLoggedInSessionCount() int {
 	return int(atomic.LoadInt64(&cm.loggedInCount))
 }
 
+// StopUserIfOwner 关闭持有 ownerToken 的设备连接；ownerToken 为 "*" 时关闭该用户在本容器的所有设备。
 func (cm *ConnectionManager) StopUserIfOwner(userID, ownerToken string) bool {
-	connection, ok := cm.GetConnectionByUserID(userID)
-	if !ok || ownerToken != "*" && connection.OwnerToken != ownerToken {
-		return false
+	stopped := false
+	for _, connection := range cm.GetConnectionsByUserID(userID) {
+		if ownerToken != "*" && connection.OwnerToken != ownerToken {
+			continue
+		}
+		cm.RemoveConnection(connection.ID)
+		stopped = true
 	}
-	cm.RemoveConnection(connection.ID)
-	return true
+	return stopped
+}
+
+// EnableReplay 之后入队的帧都会先经过 sequencer 编号并写入重放缓冲区。
+func (c *Connection) EnableReplay(sequencer FrameSequencer) {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	c.sequencer = sequencer
 }
 
+// EnqueueMessage 入队一帧；启用重放后编号失败时仍按无序号帧发送，只是该帧无法在断线后补发。
+// 发送通道已满时帧已经进入重放缓冲区，客户端可以重连后续传取回。
+// 帧先按客户端能力适配，被跳过的帧不分配序列号；重放缓冲区保存的是适配后的帧。
 func (c *Connection) EnqueueMessage(message []byte) error {
+	message, deliver := c.adapt(message)
+	if !deliver {
+		return nil
+	}
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	var seq int64
+	if c.sequencer != nil && !c.closed.Load() {
+		assigned, sequenced, err := c.sequencer(message)
+		if err != nil {
+			logger.Sugar().Warnf("分配会话序列号失败，按无序号帧发送: connection_id=%s error=%v", c.ID, err)
+		} else {
+			if c.firstSeq == 0 {
+				c.firstSeq = assigned
+			}
+			c.lastSeq = assigned
+			seq, message = assigned, sequenced
+		}
+	}
+	return c.enqueue(message, seq)
+}
+
+// EnqueueUnsequenced 按顺序入队不参与编号的会话控制帧。
+func (c *Connection) EnqueueUnsequenced(messages ...[]byte) error {
+	adapted := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		if message, deliver := c.adapt(message); deliver {
+			adapted = append(adapted, message)
+		}
+	}
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	return c.enqueueAll(adapted)
+}
+
+// Replay 暂停编号后调用 load 生成补发帧并按顺序入队，期间不会有新的推送帧插入。
+// load 收到本连接发出的第一个和最后一个序列号（尚未发出时均为 0），不应补发不小于 firstLiveSeq 的帧，因为客户端已直接收到。
+func (c *Connection) Replay(load func(firstLiveSeq, lastLiveSeq int64) ([][]byte, error)) error {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	messages, err := load(c.firstSeq, c.lastSeq)
+	if err != nil {
+		return err
+	}
+	return c.enqueueAll(messages)
+}
+
+func (c *Connection) enqueueAll(messages [][]byte) error {
+	for _, message := range messages {
+		if err := c.enqueue(message, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueue 由持有 sequenceMu 的调用方调用，seq 为帧的会话序列号，无序号帧为 0。
+// 设置了 OverflowPolicy 时，通道已满或已有帧暂存在 Redis 的情况交给 overflowEnqueue 处理。
+func (c *Connection) enqueue(message []byte, seq int64) error {
 	c.sendMu.RLock()
 	defer c.sendMu.RUnlock()
 	if c.closed.Load() {
 		return fmt.Errorf("连接已关闭: %s", c.ID)
 	}
+	if c.overflow == nil {
+		select {
+		case c.SendChan <- message:
+			metrics.RecordWebSocketSendQueueDepth(len(c.SendChan))
+			return nil
+		default:
+			return fmt.Errorf("发送通道已满: %s", c.ID)
+		}
+	}
+	if c.isExhausted() {
+		return fmt.Errorf("发送缓冲区已耗尽: %s", c.ID)
+	}
+	coalesceKey := c.coalesceKey(message)
+	_, coalescing := c.coalesced[coalesceKey]
+	if (coalesceKey == "" && c.spilled.Load() > 0) || (coalesceKey != "" && coalescing) {
+		// 保持持久帧顺序，并且不让旧的可替换帧晚于新帧发出
+		return c.overflowEnqueue(message, seq, coalesceKey)
+	}
 	select {
 	case c.SendChan <- message:
+		metrics.RecordWebSocketSendQueueDepth(len(c.SendChan))
 		return nil
 	default:
-		return fmt.Errorf("发送通道已满: %s", c.ID)
+		return c.overflowEnqueue(message, seq, coalesceKey)
 	}
 }
 
@@ -4,6 +4,9 @@ import (
 	"context"
 	redisClient "data_forwarding_service/internal/redis"
 	"errors"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -67,8 +70,8 @@ func TestLoginExternalWorkDoesNotBlockOtherUsersOrLocalOperations(t *testing.T)
 	other := addTestConnection(manager, "other")
 	local := addTestConnection(manager, "local")
 	local.MarkAuthenticated("99", "local-owner")
-	manager.userConnections.Store("99", local.ID)
-	atomic.AddInt64(&manager.loggedInCount, 1)
+	local.DeviceID = redisClient.DefaultDeviceID
+	manager.bindUserConnectionLocked("99", local.DeviceID, local.ID)
 
 	entered := make(chan struct{})
 	release := make(chan struct{})
@@ -143,11 +146,11 @@ func TestConcurrentSameUserHasOneOwnerAndOldCleanupIsFenced(t *testing.T) {
 		t.Fatalf("ownership did not move cleanly: first=%q second=%q", firstOwner, second.OwnerToken)
 	}
 	manager.RemoveConnection(first.ID)
-	container, err := redisClient.GetContainerByConnection("7")
-	if err != nil || container != currentContainerID() {
-		t.Fatalf("old cleanup removed new route: container=%q err=%v", container, err)
+	containers, err := redisClient.GetContainersByConnection("7")
+	if err != nil || len(containers) != 1 || containers[0] != currentContainerID() {
+		t.Fatalf("old cleanup removed new route: containers=%q err=%v", containers, err)
 	}
-	session, exists, err := (&redisClient.DistributedSessionManager{}).GetUserSession(context.Background(), "7")
+	session, exists, err := (&redisClient.DistributedSessionManager{}).GetUserSession(context.Background(), "7", "")
 	if err != nil || !exists || session.OwnerToken != second.OwnerToken {
 		t.Fatalf("unexpected final owner: %+v exists=%v err=%v", session, exists, err)
 	}
@@ -156,6 +159,44 @@ func TestConcurrentSameUserHasOneOwnerAndOldCleanupIsFenced(t *testing.T) {
 	}
 }
 
+func TestDifferentDevicesStayOnlineAndReceiveUserMessages(t *testing.T) {
+	useLoginTestRedis(t)
+	manager := NewConnectionManager()
+	phone := addTestConnection(manager, "phone")
+	desktop := addTestConnection(manager, "desktop")
+	replacement := addTestConnection(manager, "phone-2")
+	if err := manager.LoginDevice(context.Background(), phone.ID, "7", "phone-1", "ios", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.LoginDevice(context.Background(), desktop.ID, "7", "desktop-1", "macos", ""); err != nil {
+		t.Fatal(err)
+	}
+	if phone.IsClosed() || manager.GetLoggedInUserCount() != 1 || manager.GetLoggedInSessionCount() != 2 {
+		t.Fatalf("second device replaced first: closed=%v users=%d sessions=%d", phone.IsClosed(), manager.GetLoggedInUserCount(), manager.GetLoggedInSessionCount())
+	}
+	if err := manager.SendMessageToUser("7", []byte("fan-out")); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*Connection{phone, desktop} {
+		if got := <-conn.SendChan; string(got) != "fan-out" {
+			t.Fatalf("device %s got %q", conn.DeviceID, got)
+		}
+	}
+
+	if err := manager.LoginDevice(context.Background(), replacement.ID, "7", "phone-1", "ios", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !phone.IsClosed() || desktop.IsClosed() {
+		t.Fatalf("same-device login must only replace that device: phone=%v desktop=%v", phone.IsClosed(), desktop.IsClosed())
+	}
+	if !manager.StopUserIfOwner("7", desktop.OwnerToken) || !desktop.IsClosed() || replacement.IsClosed() {
+		t.Fatal("owned kick did not target exactly one device")
+	}
+	if _, err := NormalizeDeviceID("bad:device"); err == nil {
+		t.Fatal("device IDs containing key separators must be rejected")
+	}
+}
+
 func TestConnectionEnqueueRejectsFullChannelAndCloseIsIdempotent(t *testing.T) {
 	conn := &Connection{ID: "conn-full", SendChan: make(chan []byte, 1), done: make(chan struct{})}
 	if err := conn.EnqueueMessage([]byte("first")); err != nil {
@@ -176,19 +217,100 @@ func TestConnectionEnqueueRejectsFullChannelAndCloseIsIdempotent(t *testing.T) {
 	}
 }
 
+func TestReplaySkipsFramesAlreadySentOnThisConnection(t *testing.T) {
+	conn := &Connection{ID: "conn-replay", SendChan: make(chan []byte, 8), done: make(chan struct{})}
+	if err := conn.EnqueueMessage([]byte("before-replay")); err != nil {
+		t.Fatal(err)
+	}
+	nextSeq := int64(6)
+	conn.EnableReplay(func(message []byte) (int64, []byte, error) {
+		nextSeq++
+		return nextSeq, append([]byte(strconv.FormatInt(nextSeq, 10)+":"), message...), nil
+	})
+	if err := conn.EnqueueMessage([]byte("live")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.EnqueueMessage([]byte("live-2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.EnqueueUnsequenced([]byte("control")); err != nil {
+		t.Fatal(err)
+	}
+	err := conn.Replay(func(firstLiveSeq, lastLiveSeq int64) ([][]byte, error) {
+		if firstLiveSeq != 7 || lastLiveSeq != 8 {
+			t.Fatalf("unexpected live seq range: %d-%d", firstLiveSeq, lastLiveSeq)
+		}
+		return [][]byte{[]byte("5:missed"), []byte("resumed")}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"before-replay", "7:live", "8:live-2", "control", "5:missed", "resumed"}
+	for _, expected := range want {
+		if got := string(<-conn.SendChan); got != expected {
+			t.Fatalf("unexpected frame order: got %q want %q", got, expected)
+		}
+	}
+}
+
+func TestSendEphemeralToUserBypassesReplaySequence(t *testing.T) {
+	useLoginTestRedis(t)
+	manager := NewConnectionManager()
+	conn := addTestConnection(manager, "phone")
+	if err := manager.LoginDevice(context.Background(), conn.ID, "7", "phone-1", "ios", ""); err != nil {
+		t.Fatal(err)
+	}
+	conn.EnableReplay(func(message []byte) (int64, []byte, error) {
+		t.Fatal("ephemeral frames must not be sequenced")
+		return 0, nil, nil
+	})
+	if err := manager.SendEphemeralToUser("7", []byte("typing")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(<-conn.SendChan); got != "typing" {
+		t.Fatalf("unexpected ephemeral frame: %q", got)
+	}
+	if err := manager.SendEphemeralToUser("8", []byte("typing")); err == nil {
+		t.Fatal("expected an error for a user without local connections")
+	}
+}
+
+func TestPresenceListenerAndLastSeenFollowDeviceSessions(t *testing.T) {
+	useLoginTestRedis(t)
+	manager := NewConnectionManager()
+	var events []string
+	manager.SetPresenceListener(func(userID string, online bool) {
+		events = append(events, userID+":"+strconv.FormatBool(online))
+	})
+	conn := addTestConnection(manager, "phone")
+	if err := manager.LoginDevice(context.Background(), conn.ID, "7", "phone-1", "ios", ""); err != nil {
+		t.Fatal(err)
+	}
+	manager.RemoveConnection(conn.ID)
+	manager.RemoveConnection(conn.ID)
+
+	if len(events) != 2 || events[0] != "7:true" || events[1] != "7:false" {
+		t.Fatalf("unexpected presence events: %v", events)
+	}
+	lastSeen, err := redisClient.GetLastSeen(context.Background(), []string{"7"})
+	if err != nil || lastSeen["7"] == "" {
+		t.Fatalf("last seen was not recorded: %+v err=%v", lastSeen, err)
+	}
+}
+
 func TestConnectionManagerLookupSendAndRemoveUnloggedConnection(t *testing.T) {
 	manager := NewConnectionManager()
 	conn := &Connection{ID: "conn-1", UserID: "user-1", SendChan: make(chan []byte, 1)}
 	manager.connections.Store(conn.ID, conn)
-	manager.userConnections.Store(conn.UserID, conn.ID)
+	manager.bindUserConnectionLocked(conn.UserID, redisClient.DefaultDeviceID, conn.ID)
 	atomic.StoreInt64(&manager.connectionCount, 1)
-	atomic.StoreInt64(&manager.loggedInCount, 1)
 
 	if got, ok := manager.GetConnectionByID(conn.ID); !ok || got != conn {
 		t.Fatalf("connection lookup failed: got=%p ok=%v", got, ok)
 	}
-	if got, ok := manager.GetConnectionByUserID(conn.UserID); !ok || got != conn {
-		t.Fatalf("user lookup failed: got=%p ok=%v", got, ok)
+	if got := manager.GetConnectionsByUserID(conn.UserID); len(got) != 1 || got[0] != conn {
+		t.Fatalf("user lookup failed: got=%v", got)
 	}
 	if manager.GetConnectionCount() != 1 || manager.GetLoggedInUserCount() != 1 {
 		t.Fatalf("unexpected manager counts: connections=%d users=%d", manager.GetConnectionCount(), manager.GetLoggedInUserCount())
@@ -204,8 +326,7 @@ func TestConnectionManagerLookupSendAndRemoveUnloggedConnection(t *testing.T) {
 	}
 
 	// Remove the artificial login mapping before exercising unauthenticated removal.
-	manager.userConnections.Delete(conn.UserID)
-	atomic.StoreInt64(&manager.loggedInCount, 0)
+	manager.unbindUserConnectionLocked(conn.UserID, redisClient.DefaultDeviceID)
 	manager.RemoveConnection(conn.ID)
 	if manager.GetConnectionCount() != 0 || !conn.IsClosed() {
 		t.Fatalf("connection was not removed: count=%d closed=%v", manager.GetConnectionCount(), conn.IsClosed())
@@ -240,3 +361,182 @@ func TestConnectionManagerRejectsInvalidUserIDBeforeSessionRegistration(t *testi
 		t.Fatalf("invalid login changed logged-in count: %d", manager.GetLoggedInUserCount())
 	}
 }
+
+func TestFrameAdapterSkipsFramesBeforeSequencing(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.SetFrameAdapter(func(client *ClientInfo, message []byte) ([]byte, bool) {
+		if string(message) != "new-event" {
+			return message, true
+		}
+		if client.Supports("new_event") {
+			return message, true
+		}
+		return nil, false
+	})
+	conn := &Connection{ID: "conn-adapter", SendChan: make(chan []byte, 8), done: make(chan struct{}), frameAdapter: manager.frameAdapter}
+	sequenced := 0
+	conn.EnableReplay(func(message []byte) (int64, []byte, error) {
+		sequenced++
+		return int64(sequenced), message, nil
+	})
+
+	if err := conn.EnqueueMessage([]byte("new-event")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.EnqueueUnsequenced([]byte("new-event"), []byte("control")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetClientInfo(NewClientInfo(1, "2.0.0", "ios", []string{"new_event"}))
+	if err := conn.EnqueueMessage([]byte("new-event")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"control", "new-event"} {
+		if got := string(<-conn.SendChan); got != expected {
+			t.Fatalf("unexpected frame: got %q want %q", got, expected)
+		}
+	}
+	if sequenced != 1 {
+		t.Fatalf("skipped frames must not consume a sequence number, sequenced=%d", sequenced)
+	}
+	if got := conn.ClientInfo(); got.ProtocolVersion != 1 || got.AppVersion != "2.0.0" || !got.Supports("new_event") || got.Supports("other") {
+		t.Fatalf("unexpected client info: %+v", got)
+	}
+}
+
+func TestOverflowPolicyCoalescesSpillsThenRequiresSync(t *testing.T) {
+	useLoginTestRedis(t)
+	policy := &OverflowPolicy{
+		CoalesceKey: func(message []byte) string {
+			if rest, ok := strings.CutPrefix(string(message), "typing:"); ok {
+				key, _, _ := strings.Cut(rest, ":")
+				return key
+			}
+			return ""
+		},
+		SyncRequired: func(since time.Time, sinceSeq int64) []byte {
+			if since.IsZero() {
+				t.Fatal("sync hint must carry the overflow start time")
+			}
+			return []byte("sync:" + strconv.FormatInt(sinceSeq, 10))
+		},
+		SpillLimit: 2,
+		SpillTTL:   time.Minute,
+	}
+	conn := &Connection{ID: "conn-overflow", DeviceID: "phone", SendChan: make(chan []byte, 1), done: make(chan struct{}),
+		overflow: policy, pending: make(chan struct{}, 1), exhausted: make(chan struct{})}
+	conn.MarkAuthenticated("7", "owner")
+	var seq int64
+	conn.EnableReplay(func(message []byte) (int64, []byte, error) {
+		seq++
+		return seq, message, nil
+	})
+	enqueue := func(frames ...string) {
+		t.Helper()
+		for _, frame := range frames {
+			if strings.HasPrefix(frame, "typing:") {
+				if err := conn.EnqueueUnsequenced([]byte(frame)); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := conn.EnqueueMessage([]byte(frame)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	enqueue("first", "typing:a:1", "typing:b:1", "typing:a:2", "second", "third")
+	<-conn.Pending()
+	if got := string(<-conn.SendChan); got != "first" {
+		t.Fatalf("unexpected queued frame: %q", got)
+	}
+	// 暂存帧可能已经写入 Redis，此时 TakePending 先返回合并帧，spill 协程取回后再次触发 Pending
+	var taken []string
+	for _, frame := range conn.TakePending(8) {
+		taken = append(taken, string(frame))
+	}
+	for conn.spilled.Load() > 0 {
+		select {
+		case <-conn.Pending():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("spilled frames were not handed back: %v", taken)
+		}
+		for _, frame := range conn.TakePending(8) {
+			taken = append(taken, string(frame))
+		}
+	}
+	if got := strings.Join(taken, ","); got != "second,third,typing:a:2,typing:b:1" && got != "typing:a:2,typing:b:1,second,third" {
+		t.Fatalf("unexpected pending frames: %v", taken)
+	}
+
+	// 暂存队列取空后恢复直接入队；再次积压超过上限时要求客户端重新同步
+	enqueue("fourth")
+	if got := string(<-conn.SendChan); got != "fourth" {
+		t.Fatalf("frames should bypass the spill queue once drained: %q", got)
+	}
+	enqueue("fifth", "sixth", "seventh")
+	if err := conn.EnqueueMessage([]byte("eighth")); err == nil {
+		t.Fatal("expected the exhausted spill queue to reject the frame")
+	}
+	select {
+	case <-conn.Exhausted():
+	default:
+		t.Fatal("connection was not marked exhausted")
+	}
+	if got := string(conn.SyncRequiredFrame()); got != "sync:6" {
+		t.Fatalf("unexpected sync hint: %q", got)
+	}
+	if err := conn.EnqueueMessage([]byte("ninth")); err == nil {
+		t.Fatal("exhausted connection should reject further frames")
+	}
+}
+
+func TestOverflowSpillDoesNotBlockEnqueueOnUnresponsiveRedis(t *testing.T) {
+	// 只接受连接、从不应答的 Redis，spill 协程写入时会等到 spillTimeout
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+	previous := redisClient.Rdb
+	redisClient.Rdb = redis.NewClient(&redis.Options{Addr: listener.Addr().String(), MaxRetries: -1, ContextTimeoutEnabled: true})
+	t.Cleanup(func() {
+		_ = redisClient.Rdb.Close()
+		redisClient.Rdb = previous
+	})
+
+	policy := &OverflowPolicy{
+		SyncRequired: func(time.Time, int64) []byte { return []byte("sync") },
+		SpillLimit:   16,
+		SpillTTL:     time.Minute,
+	}
+	conn := &Connection{ID: "conn-slow-redis", DeviceID: "phone", SendChan: make(chan []byte, 1), done: make(chan struct{}),
+		overflow: policy, pending: make(chan struct{}, 1), exhausted: make(chan struct{})}
+	conn.MarkAuthenticated("7", "owner")
+
+	start := time.Now()
+	for _, frame := range []string{"first", "second", "third", "fourth"} {
+		if err := conn.EnqueueMessage([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > spillTimeout/2 {
+		t.Fatalf("enqueue waited on Redis for %s", elapsed)
+	}
+	select {
+	case <-conn.Exhausted():
+	case <-time.After(3 * spillTimeout):
+		t.Fatal("failed spill should exhaust the connection")
+	}
+	if got := string(conn.SyncRequiredFrame()); got != "sync" {
+		t.Fatalf("unexpected sync hint: %q", got)
+	}
+}
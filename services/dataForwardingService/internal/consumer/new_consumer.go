@@ -9,6 +9,7 @@ import (
 	"Betterfly2/shared/logger"
 	"context"
 	"data_forwarding_service/internal/handlers"
+	"data_forwarding_service/internal/router"
 	"errors"
 	"fmt"
 	"regexp"
@@ -66,7 +67,8 @@ func (h *NewKafkaConsumerGroupHandler) handleCallDelivery(delivery *callpb.Deliv
 		return fmt.Errorf("通话投递报文不完整")
 	}
 	response := &pb.ResponseMessage{
-		Payload: &pb.ResponseMessage_CallEvent{CallEvent: delivery.GetEvent()},
+		RequestId: delivery.GetRequestId(),
+		Payload:   &pb.ResponseMessage_CallEvent{CallEvent: delivery.GetEvent()},
 	}
 	payload, err := proto.Marshal(response)
 	if err != nil {
@@ -84,7 +86,8 @@ func (h *NewKafkaConsumerGroupHandler) handlePushResponse(response *pushpb.Respo
 		return fmt.Errorf("推送响应不是有效的客户端投递报文")
 	}
 	dfResponse := &pb.ResponseMessage{
-		Payload: &pb.ResponseMessage_PushEvent{PushEvent: delivery.GetEvent()},
+		RequestId: delivery.GetRequestId(),
+		Payload:   &pb.ResponseMessage_PushEvent{PushEvent: delivery.GetEvent()},
 	}
 	payload, err := proto.Marshal(dfResponse)
 	if err != nil {
@@ -100,6 +103,12 @@ func (h *NewKafkaConsumerGroupHandler) handleFriendResponse(friendResp *friend.R
 
 	var dfResp *pb.ResponseMessage
 	switch payload := friendResp.Payload.(type) {
+	case *friend.ResponseMessage_GroupChanged:
+		// 群资料变更事件推送给全部群成员，不作为请求的应答
+		if err := handlers.DeliverGroupChanged(payload.GroupChanged); err != nil {
+			return fmt.Errorf("投递群资料变更事件失败: %v", err)
+		}
+		return nil
 	case *friend.ResponseMessage_RelationshipRequestListRsp:
 		dfResp = buildRelationshipRequestListResponse(payload.RelationshipRequestListRsp)
 	case *friend.ResponseMessage_RelationshipOperationRsp:
@@ -235,6 +244,7 @@ func (h *NewKafkaConsumerGroupHandler) handleFriendResponse(friendResp *friend.R
 	}
 
 marshalFriendResponse:
+	dfResp.RequestId = friendResp.GetRequestId()
 	respBytes, err := proto.Marshal(dfResp)
 	if err != nil {
 		return fmt.Errorf("序列化好友响应消息失败: %v", err)
@@ -522,6 +532,40 @@ func (h *NewKafkaConsumerGroupHandler) handleDFResponse(payload []byte) error {
 			return permanentError("MessageRecallDelivery内容不完整")
 		}
 		return h.deliverMessageRecallToUsers(recallDelivery.GetEvent(), []int64{recallDelivery.GetTargetUserId()})
+	case *pb.DFInternalDelivery_MessageEditBatchDelivery:
+		editDelivery := delivery.MessageEditBatchDelivery
+		if editDelivery.GetEvent() == nil || len(editDelivery.GetTargetUserIds()) == 0 {
+			return permanentError("MessageEditBatchDelivery内容不完整")
+		}
+		return h.deliverMessageEditToUsers(editDelivery.GetEvent(), editDelivery.GetTargetUserIds())
+	case *pb.DFInternalDelivery_MessageEditDelivery:
+		editDelivery := delivery.MessageEditDelivery
+		if editDelivery.GetEvent() == nil || editDelivery.GetTargetUserId() <= 0 {
+			return permanentError("MessageEditDelivery内容不完整")
+		}
+		return h.deliverMessageEditToUsers(editDelivery.GetEvent(), []int64{editDelivery.GetTargetUserId()})
+	case *pb.DFInternalDelivery_MessagePinBatchDelivery:
+		pinDelivery := delivery.MessagePinBatchDelivery
+		if pinDelivery.GetEvent() == nil || len(pinDelivery.GetTargetUserIds()) == 0 {
+			return permanentError("MessagePinBatchDelivery内容不完整")
+		}
+		return h.deliverMessagePinToUsers(pinDelivery.GetEvent(), pinDelivery.GetTargetUserIds())
+	case *pb.DFInternalDelivery_MessagePinDelivery:
+		pinDelivery := delivery.MessagePinDelivery
+		if pinDelivery.GetEvent() == nil || pinDelivery.GetTargetUserId() <= 0 {
+			return permanentError("MessagePinDelivery内容不完整")
+		}
+		return h.deliverMessagePinToUsers(pinDelivery.GetEvent(), []int64{pinDelivery.GetTargetUserId()})
+	case *pb.DFInternalDelivery_ClientResponseDelivery:
+		responseDelivery := delivery.ClientResponseDelivery
+		if len(responseDelivery.GetResponseMessage()) == 0 || len(responseDelivery.GetTargetUserIds()) == 0 {
+			return permanentError("ClientResponseDelivery内容不完整")
+		}
+		if responseDelivery.GetEphemeral() {
+			h.deliverEphemeralToLocalUsers(responseDelivery.GetResponseMessage(), responseDelivery.GetTargetUserIds())
+			return nil
+		}
+		return h.deliverResponseToLocalUsers(responseDelivery.GetResponseMessage(), responseDelivery.GetTargetUserIds())
 	default:
 		return permanentError("DFInternalDelivery类型不受支持")
 	}
@@ -534,9 +578,40 @@ func (h *NewKafkaConsumerGroupHandler) deliverMessageRecallToUsers(event *pb.Mes
 	if err != nil {
 		return fmt.Errorf("序列化消息撤回事件失败: %v", err)
 	}
+	return h.deliverResponseToLocalUsers(responseBytes, targetUserIDs)
+}
+
+func (h *NewKafkaConsumerGroupHandler) deliverMessageEditToUsers(event *pb.MessageEditEvent, targetUserIDs []int64) error {
+	responseBytes, err := proto.Marshal(&pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_MessageEditEvent{MessageEditEvent: event},
+	})
+	if err != nil {
+		return fmt.Errorf("序列化消息编辑事件失败: %v", err)
+	}
+	return h.deliverResponseToLocalUsers(responseBytes, targetUserIDs)
+}
+
+func (h *NewKafkaConsumerGroupHandler) deliverMessagePinToUsers(event *pb.MessagePinEvent, targetUserIDs []int64) error {
+	responseBytes, err := proto.Marshal(&pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_MessagePinEvent{MessagePinEvent: event},
+	})
+	if err != nil {
+		return fmt.Errorf("序列化消息置顶事件失败: %v", err)
+	}
+	return h.deliverResponseToLocalUsers(responseBytes, targetUserIDs)
+}
+
+// deliverResponseToLocalUsers 只投递到目标用户在本容器内的设备：发送方容器已按设备所在容器拆分投递，
+// 这里再次扇出会导致重复；设备在转发途中断开属于正常情况，由消息同步兜底。
+func (h *NewKafkaConsumerGroupHandler) deliverResponseToLocalUsers(responseBytes []byte, targetUserIDs []int64) error {
 	for _, targetUserID := range targetUserIDs {
-		if err := h.wsHandler.SendMessage(strconv.FormatInt(targetUserID, 10), responseBytes); err != nil {
-			return fmt.Errorf("转发消息撤回事件给用户 %d 失败: %v", targetUserID, err)
+		err := h.wsHandler.DeliverLocally(strconv.FormatInt(targetUserID, 10), responseBytes)
+		if errors.Is(err, router.ErrUserOffline) {
+			logger.Sugar().Debugf("跨容器投递目标已不在本容器: user_id=%d", targetUserID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("转发消息给用户 %d 失败: %v", targetUserID, err)
 		}
 	}
 	return nil
@@ -552,14 +627,7 @@ func (h *NewKafkaConsumerGroupHandler) deliverGroupPostToUsers(post *pb.Post, ta
 	if err != nil {
 		return fmt.Errorf("序列化群消息响应失败: %v", err)
 	}
-
-	for _, targetUserID := range targetUserIDs {
-		targetUserIDStr := strconv.FormatInt(targetUserID, 10)
-		if err := h.wsHandler.SendMessage(targetUserIDStr, respBytes); err != nil {
-			return fmt.Errorf("转发群消息给用户 %s 失败: %v", targetUserIDStr, err)
-		}
-	}
-	return nil
+	return h.deliverResponseToLocalUsers(respBytes, targetUserIDs)
 }
 
 // handleStorageResponse 处理storage服务的响应并转发给客户端
@@ -600,6 +668,12 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 					Warn: &pb.Warn{WarningMessage: "无权访问该资源"},
 				},
 			}
+		case storage.StorageResult_INVALID_SEARCH:
+			dfResp = &pb.ResponseMessage{
+				Payload: &pb.ResponseMessage_Warn{
+					Warn: &pb.Warn{WarningMessage: "搜索条件无效"},
+				},
+			}
 		default:
 			dfResp = &pb.ResponseMessage{
 				Payload: &pb.ResponseMessage_Warn{
@@ -611,6 +685,7 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 		}
 		// 发送响应并返回
 		if dfResp != nil {
+			dfResp.RequestId = storageResp.GetRequestId()
 			// 序列化响应消息
 			respBytes, err := proto.Marshal(dfResp)
 			if err != nil {
@@ -633,21 +708,20 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 	case *storage.ResponseMessage_StoreMsgRsp:
 		storeRsp := payload.StoreMsgRsp
 		sugar.Debugf("收到消息存储响应: message_id=%d client_message_id=%s created=%t", storeRsp.GetMessageId(), storeRsp.GetClientMessageId(), storeRsp.GetCreated())
+		if warning, rejected := rejectedPostWarnings[storageResp.GetResult()]; rejected {
+			handlers.ReleasePostIdempotency(context.Background(), storeRsp.GetFromUserId(), storeRsp.GetClientMessageId())
+			dfResp = &pb.ResponseMessage{
+				Payload: &pb.ResponseMessage_Warn{
+					Warn: &pb.Warn{WarningMessage: warning},
+				},
+			}
+			break
+		}
 		if err := handlers.CompletePostIdempotency(context.Background(), storeRsp.GetFromUserId(), storeRsp.GetClientMessageId(), storeRsp.GetMessageId()); err != nil {
 			sugar.Errorf("更新消息幂等ACK缓存失败: message_id=%d err=%v", storeRsp.GetMessageId(), err)
 		}
 		if storeRsp.GetCreated() {
-			post := &pb.Post{
-				FromId:          storeRsp.GetFromUserId(),
-				ToId:            storeRsp.GetToUserId(),
-				Msg:             storeRsp.GetContent(),
-				MsgType:         storeRsp.GetMessageType(),
-				IsGroup:         storeRsp.GetIsGroup(),
-				RealFileName:    storeRsp.GetRealFileName(),
-				Timestamp:       storeRsp.GetClientTimestamp(),
-				ClientMessageId: storeRsp.GetClientMessageId(),
-			}
-			if err := handlers.DeliverStoredPost(storeRsp.GetMessageId(), post); err != nil {
+			if err := handlers.DeliverStoredPost(storeRsp.GetMessageId(), buildStoredPost(storeRsp)); err != nil {
 				sugar.Errorf("存储成功后的消息投递失败: message_id=%d err=%v", storeRsp.GetMessageId(), err)
 			}
 		}
@@ -665,25 +739,112 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 			Payload: &pb.ResponseMessage_MessageRecallEvent{MessageRecallEvent: event},
 		}
 
+	case *storage.ResponseMessage_EditMessageRsp:
+		event := buildMessageEditEvent(storageResp.GetResult(), payload.EditMessageRsp)
+		if storageResp.GetResult() == storage.StorageResult_OK {
+			if err := handlers.DeliverMessageEdit(event); err != nil {
+				return fmt.Errorf("投递消息编辑事件失败: %v", err)
+			}
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_MessageEditEvent{MessageEditEvent: event},
+		}
+
+	case *storage.ResponseMessage_PinMessageRsp:
+		event := buildMessagePinEvent(storageResp.GetResult(), payload.PinMessageRsp)
+		if storageResp.GetResult() == storage.StorageResult_OK {
+			if err := handlers.DeliverMessagePin(event); err != nil {
+				return fmt.Errorf("投递消息置顶事件失败: %v", err)
+			}
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_MessagePinEvent{MessagePinEvent: event},
+		}
+
+	case *storage.ResponseMessage_ReactToMessageRsp:
+		event := buildReactionEvent(storageResp.GetResult(), payload.ReactToMessageRsp)
+		if storageResp.GetResult() == storage.StorageResult_OK {
+			if err := handlers.DeliverReactionEvent(event, payload.ReactToMessageRsp.GetReaderUserIds()); err != nil {
+				return fmt.Errorf("投递消息回应事件失败: %v", err)
+			}
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_ReactionEvent{ReactionEvent: event},
+		}
+
+	case *storage.ResponseMessage_ForwardMessagesRsp:
+		forward := payload.ForwardMessagesRsp
+		if storageResp.GetResult() == storage.StorageResult_OK {
+			// 幂等重放返回的已有消息在首次转发时已经投递
+			for _, stored := range forward.GetMessages() {
+				if !stored.GetCreated() {
+					continue
+				}
+				if err := handlers.DeliverStoredPost(stored.GetMessageId(), buildStoredPost(stored)); err != nil {
+					sugar.Errorf("转发成功后的消息投递失败: message_id=%d err=%v", stored.GetMessageId(), err)
+				}
+			}
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_ForwardMessagesRsp{ForwardMessagesRsp: buildForwardMessagesRsp(storageResp.GetResult(), forward)},
+		}
+
+	case *storage.ResponseMessage_MessageReceiptsRsp:
+		// 回执只推送给消息发送者，不回复回执人
+		if err := handlers.DeliverMessageReceipts(payload.MessageReceiptsRsp); err != nil {
+			return fmt.Errorf("投递消息回执事件失败: %v", err)
+		}
+		return nil
+
+	case *storage.ResponseMessage_UserProfileChanged:
+		// 资料变更事件推送给好友和本人的设备，不作为请求的应答
+		if err := handlers.DeliverProfileChanged(payload.UserProfileChanged); err != nil {
+			return fmt.Errorf("投递资料变更事件失败: %v", err)
+		}
+		return nil
+
+	case *storage.ResponseMessage_PresenceSettingsRsp:
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_PresenceSettingsRsp{
+				PresenceSettingsRsp: &pb.PresenceSettingsRsp{HideLastSeen: payload.PresenceSettingsRsp.GetHideLastSeen()},
+			},
+		}
+
 	case *storage.ResponseMessage_MsgRsp:
 		// 单条消息查询响应
 		msg := payload.MsgRsp
 		sugar.Debugf("收到单条消息查询响应: from=%d to=%d", msg.GetFromUserId(), msg.GetToUserId())
 
 		dfResp = &pb.ResponseMessage{
-			Payload: &pb.ResponseMessage_MessageRsp{
-				MessageRsp: &pb.MessageRsp{
-					MessageId:    msg.GetMessageId(),
-					FromUserId:   msg.GetFromUserId(),
-					ToUserId:     msg.GetToUserId(),
-					Content:      msg.GetContent(),
-					Timestamp:    msg.GetTimestamp(),
-					MsgType:      msg.GetMsgType(),
-					IsGroup:      msg.GetIsGroup(),
-					RealFileName: msg.GetRealFileName(),
-					IsRecalled:   msg.GetIsRecalled(),
-					RecalledAt:   msg.GetRecalledAt(),
-					RecalledBy:   msg.GetRecalledBy(),
+			Payload: &pb.ResponseMessage_MessageRsp{MessageRsp: buildMessageRsp(msg)},
+		}
+
+	case *storage.ResponseMessage_ConversationsRsp:
+		conversations := payload.ConversationsRsp
+		sugar.Debugf("收到会话列表响应: 会话数量=%d", len(conversations.GetConversations()))
+
+		dfConversations := make([]*pb.ConversationSummary, 0, len(conversations.GetConversations()))
+		for _, conversation := range conversations.GetConversations() {
+			dfConversations = append(dfConversations, &pb.ConversationSummary{
+				ConversationId:      conversation.GetConversationId(),
+				IsGroup:             conversation.GetIsGroup(),
+				LastMessageId:       conversation.GetLastMessageId(),
+				LastSenderId:        conversation.GetLastSenderId(),
+				LastMessageType:     conversation.GetLastMessageType(),
+				Snippet:             conversation.GetSnippet(),
+				LastMessageAt:       conversation.GetLastMessageAt(),
+				LastMessageRecalled: conversation.GetLastMessageRecalled(),
+				UnreadCount:         conversation.GetUnreadCount(),
+			})
+		}
+
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_ConversationsRsp{
+				ConversationsRsp: &pb.ConversationsRsp{
+					Conversations:       dfConversations,
+					HasMore:             conversations.GetHasMore(),
+					NextCursorTimestamp: conversations.GetNextCursorTimestamp(),
+					NextCursorMessageId: conversations.GetNextCursorMessageId(),
 				},
 			},
 		}
@@ -694,30 +855,105 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 		sugar.Debugf("收到同步消息查询响应: 消息数量=%d", len(syncMsgs.GetMsgs()))
 
 		// 转换为data_forwarding的MessageRsp列表
-		var dfMsgs []*pb.MessageRsp
+		var dfMsgs, reactionUpdates []*pb.MessageRsp
 		for _, msg := range syncMsgs.GetMsgs() {
-			dfMsgs = append(dfMsgs, &pb.MessageRsp{
-				MessageId:    msg.GetMessageId(),
-				FromUserId:   msg.GetFromUserId(),
-				ToUserId:     msg.GetToUserId(),
-				Content:      msg.GetContent(),
-				Timestamp:    msg.GetTimestamp(),
-				MsgType:      msg.GetMsgType(),
-				IsGroup:      msg.GetIsGroup(),
-				RealFileName: msg.GetRealFileName(),
-				IsRecalled:   msg.GetIsRecalled(),
-				RecalledAt:   msg.GetRecalledAt(),
-				RecalledBy:   msg.GetRecalledBy(),
-			})
+			dfMsgs = append(dfMsgs, buildMessageRsp(msg))
+		}
+		for _, msg := range syncMsgs.GetReactionUpdates() {
+			reactionUpdates = append(reactionUpdates, buildMessageRsp(msg))
 		}
 
 		dfResp = &pb.ResponseMessage{
 			Payload: &pb.ResponseMessage_SyncMsgsRsp{
 				SyncMsgsRsp: &pb.SyncMessagesRsp{
+					Msgs:                   dfMsgs,
+					HasMore:                syncMsgs.GetHasMore(),
+					NextCursorTimestamp:    syncMsgs.GetNextCursorTimestamp(),
+					NextCursorMessageId:    syncMsgs.GetNextCursorMessageId(),
+					NextAfterSeq:           syncMsgs.GetNextAfterSeq(),
+					LatestSeq:              syncMsgs.GetLatestSeq(),
+					ReactionUpdates:        reactionUpdates,
+					ReactionUpdatesHasMore: syncMsgs.GetReactionUpdatesHasMore(),
+					NextAfterReactionSeq:   syncMsgs.GetNextAfterReactionSeq(),
+					LatestReactionSeq:      syncMsgs.GetLatestReactionSeq(),
+				},
+			},
+		}
+
+	case *storage.ResponseMessage_ConversationHistoryRsp:
+		history := payload.ConversationHistoryRsp
+		sugar.Debugf("收到会话历史响应: peer_or_group_id=%d is_group=%t 消息数量=%d", history.GetPeerOrGroupId(), history.GetIsGroup(), len(history.GetMsgs()))
+
+		dfMsgs := make([]*pb.MessageRsp, 0, len(history.GetMsgs()))
+		for _, msg := range history.GetMsgs() {
+			dfMsgs = append(dfMsgs, buildMessageRsp(msg))
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_ConversationHistoryRsp{
+				ConversationHistoryRsp: &pb.ConversationHistoryRsp{
+					PeerOrGroupId:       history.GetPeerOrGroupId(),
+					IsGroup:             history.GetIsGroup(),
+					Msgs:                dfMsgs,
+					HasMore:             history.GetHasMore(),
+					NextBeforeMessageId: history.GetNextBeforeMessageId(),
+				},
+			},
+		}
+
+	case *storage.ResponseMessage_MentionedMessagesRsp:
+		mentioned := payload.MentionedMessagesRsp
+		sugar.Debugf("收到@我的消息响应: 消息数量=%d has_more=%t", len(mentioned.GetMsgs()), mentioned.GetHasMore())
+
+		dfMsgs := make([]*pb.MessageRsp, 0, len(mentioned.GetMsgs()))
+		for _, msg := range mentioned.GetMsgs() {
+			dfMsgs = append(dfMsgs, buildMessageRsp(msg))
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_MentionedMessagesRsp{
+				MentionedMessagesRsp: &pb.MentionedMessagesRsp{
 					Msgs:                dfMsgs,
-					HasMore:             syncMsgs.GetHasMore(),
-					NextCursorTimestamp: syncMsgs.GetNextCursorTimestamp(),
-					NextCursorMessageId: syncMsgs.GetNextCursorMessageId(),
+					HasMore:             mentioned.GetHasMore(),
+					NextBeforeMessageId: mentioned.GetNextBeforeMessageId(),
+				},
+			},
+		}
+
+	case *storage.ResponseMessage_SearchMessagesRsp:
+		search := payload.SearchMessagesRsp
+		sugar.Debugf("收到消息搜索响应: 消息数量=%d has_more=%t", len(search.GetMsgs()), search.GetHasMore())
+
+		dfMsgs := make([]*pb.MessageRsp, 0, len(search.GetMsgs()))
+		for _, msg := range search.GetMsgs() {
+			dfMsgs = append(dfMsgs, buildMessageRsp(msg))
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_SearchMessagesRsp{
+				SearchMessagesRsp: &pb.SearchMessagesRsp{
+					Msgs:                dfMsgs,
+					HasMore:             search.GetHasMore(),
+					NextBeforeMessageId: search.GetNextBeforeMessageId(),
+				},
+			},
+		}
+
+	case *storage.ResponseMessage_PinnedMessagesRsp:
+		pinned := payload.PinnedMessagesRsp
+		sugar.Debugf("收到置顶消息响应: peer_or_group_id=%d 消息数量=%d", pinned.GetPeerOrGroupId(), len(pinned.GetPins()))
+
+		dfPins := make([]*pb.PinnedMessage, 0, len(pinned.GetPins()))
+		for _, pin := range pinned.GetPins() {
+			dfPins = append(dfPins, &pb.PinnedMessage{
+				Msg:      buildMessageRsp(pin.GetMsg()),
+				PinnedBy: pin.GetPinnedBy(),
+				PinnedAt: pin.GetPinnedAt(),
+			})
+		}
+		dfResp = &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_PinnedMessagesRsp{
+				PinnedMessagesRsp: &pb.PinnedMessagesRsp{
+					PeerOrGroupId: pinned.GetPeerOrGroupId(),
+					IsGroup:       pinned.GetIsGroup(),
+					Pins:          dfPins,
 				},
 			},
 		}
@@ -747,6 +983,7 @@ func (h *NewKafkaConsumerGroupHandler) handleStorageResponse(storageResp *storag
 		return nil
 	}
 
+	dfResp.RequestId = storageResp.GetRequestId()
 	// 序列化响应消息
 	respBytes, err := proto.Marshal(dfResp)
 	if err != nil {
@@ -796,6 +1033,238 @@ func buildMessageRecallEvent(result storage.StorageResult, recall *storage.Recal
 	}
 }
 
+func mapStorageEditResult(result storage.StorageResult) pb.MessageEditResult {
+	switch result {
+	case storage.StorageResult_OK:
+		return pb.MessageEditResult_MESSAGE_EDIT_OK
+	case storage.StorageResult_RECORD_NOT_EXIST:
+		return pb.MessageEditResult_MESSAGE_EDIT_NOT_FOUND
+	case storage.StorageResult_FORBIDDEN:
+		return pb.MessageEditResult_MESSAGE_EDIT_FORBIDDEN
+	case storage.StorageResult_ALREADY_RECALLED:
+		return pb.MessageEditResult_MESSAGE_EDIT_RECALLED
+	case storage.StorageResult_EDIT_EXPIRED:
+		return pb.MessageEditResult_MESSAGE_EDIT_EXPIRED
+	case storage.StorageResult_NOT_EDITABLE:
+		return pb.MessageEditResult_MESSAGE_EDIT_NOT_EDITABLE
+	default:
+		return pb.MessageEditResult_MESSAGE_EDIT_SERVICE_ERROR
+	}
+}
+
+func buildMessageEditEvent(result storage.StorageResult, edit *storage.EditMessageRsp) *pb.MessageEditEvent {
+	if edit == nil {
+		edit = &storage.EditMessageRsp{}
+	}
+	return &pb.MessageEditEvent{
+		Result:         mapStorageEditResult(result),
+		MessageId:      edit.GetMessageId(),
+		FromUserId:     edit.GetFromUserId(),
+		ToUserId:       edit.GetToUserId(),
+		IsGroup:        edit.GetIsGroup(),
+		OperatorUserId: edit.GetOperatorUserId(),
+		EditedAt:       edit.GetEditedAt(),
+		Content:        edit.GetContent(),
+		MessageType:    edit.GetMessageType(),
+	}
+}
+
+func mapStoragePinResult(result storage.StorageResult) pb.MessagePinResult {
+	switch result {
+	case storage.StorageResult_OK:
+		return pb.MessagePinResult_MESSAGE_PIN_OK
+	case storage.StorageResult_RECORD_NOT_EXIST:
+		return pb.MessagePinResult_MESSAGE_PIN_NOT_FOUND
+	case storage.StorageResult_FORBIDDEN:
+		return pb.MessagePinResult_MESSAGE_PIN_FORBIDDEN
+	case storage.StorageResult_ALREADY_RECALLED:
+		return pb.MessagePinResult_MESSAGE_PIN_RECALLED
+	case storage.StorageResult_PIN_LIMIT_REACHED:
+		return pb.MessagePinResult_MESSAGE_PIN_LIMIT_REACHED
+	default:
+		return pb.MessagePinResult_MESSAGE_PIN_SERVICE_ERROR
+	}
+}
+
+func buildMessagePinEvent(result storage.StorageResult, pin *storage.PinMessageRsp) *pb.MessagePinEvent {
+	if pin == nil {
+		pin = &storage.PinMessageRsp{}
+	}
+	return &pb.MessagePinEvent{
+		Result:         mapStoragePinResult(result),
+		MessageId:      pin.GetMessageId(),
+		FromUserId:     pin.GetFromUserId(),
+		ToUserId:       pin.GetToUserId(),
+		IsGroup:        pin.GetIsGroup(),
+		OperatorUserId: pin.GetOperatorUserId(),
+		Unpinned:       pin.GetUnpinned(),
+		Changed:        pin.GetChanged(),
+		PinnedAt:       pin.GetPinnedAt(),
+	}
+}
+
+func mapStorageReactionResult(result storage.StorageResult) pb.MessageReactionResult {
+	switch result {
+	case storage.StorageResult_OK:
+		return pb.MessageReactionResult_MESSAGE_REACTION_OK
+	case storage.StorageResult_RECORD_NOT_EXIST:
+		return pb.MessageReactionResult_MESSAGE_REACTION_NOT_FOUND
+	case storage.StorageResult_ALREADY_RECALLED:
+		return pb.MessageReactionResult_MESSAGE_REACTION_RECALLED
+	case storage.StorageResult_INVALID_REACTION:
+		return pb.MessageReactionResult_MESSAGE_REACTION_INVALID
+	default:
+		return pb.MessageReactionResult_MESSAGE_REACTION_SERVICE_ERROR
+	}
+}
+
+func buildReactionEvent(result storage.StorageResult, reaction *storage.ReactToMessageRsp) *pb.ReactionEvent {
+	if reaction == nil {
+		reaction = &storage.ReactToMessageRsp{}
+	}
+	return &pb.ReactionEvent{
+		Result:         mapStorageReactionResult(result),
+		MessageId:      reaction.GetMessageId(),
+		FromUserId:     reaction.GetFromUserId(),
+		ToUserId:       reaction.GetToUserId(),
+		IsGroup:        reaction.GetIsGroup(),
+		OperatorUserId: reaction.GetOperatorUserId(),
+		Emoji:          reaction.GetEmoji(),
+		Removed:        reaction.GetRemoved(),
+		Changed:        reaction.GetChanged(),
+		ReactedAt:      reaction.GetReactedAt(),
+		Reactions:      buildMessageReactions(reaction.GetReactions()),
+	}
+}
+
+func buildMessageReactions(reactions []*storage.MessageReaction) []*pb.MessageReaction {
+	if len(reactions) == 0 {
+		return nil
+	}
+	converted := make([]*pb.MessageReaction, 0, len(reactions))
+	for _, reaction := range reactions {
+		converted = append(converted, &pb.MessageReaction{
+			Emoji:       reaction.GetEmoji(),
+			Count:       reaction.GetCount(),
+			ReactedByMe: reaction.GetReactedByMe(),
+		})
+	}
+	return converted
+}
+
+func buildMessageRsp(msg *storage.MessageRsp) *pb.MessageRsp {
+	return &pb.MessageRsp{
+		MessageId:        msg.GetMessageId(),
+		FromUserId:       msg.GetFromUserId(),
+		ToUserId:         msg.GetToUserId(),
+		Content:          msg.GetContent(),
+		Timestamp:        msg.GetTimestamp(),
+		MsgType:          msg.GetMsgType(),
+		IsGroup:          msg.GetIsGroup(),
+		RealFileName:     msg.GetRealFileName(),
+		IsRecalled:       msg.GetIsRecalled(),
+		RecalledAt:       msg.GetRecalledAt(),
+		RecalledBy:       msg.GetRecalledBy(),
+		InboxSeq:         msg.GetInboxSeq(),
+		EditedAt:         msg.GetEditedAt(),
+		Reactions:        buildMessageReactions(msg.GetReactions()),
+		ReplyToMessageId: msg.GetReplyToMessageId(),
+		ReplyTo:          buildQuotedMessage(msg.GetReplyTo()),
+		MergedItems:      buildMergedForwardItems(msg.GetMergedItems()),
+		MentionedUserIds: msg.GetMentionedUserIds(),
+		MentionAll:       msg.GetMentionAll(),
+	}
+}
+
+func buildQuotedMessage(quoted *storage.QuotedMessage) *pb.QuotedMessage {
+	if quoted == nil {
+		return nil
+	}
+	return &pb.QuotedMessage{
+		MessageId:  quoted.GetMessageId(),
+		FromUserId: quoted.GetFromUserId(),
+		MsgType:    quoted.GetMsgType(),
+		Snippet:    quoted.GetSnippet(),
+		IsRecalled: quoted.GetIsRecalled(),
+	}
+}
+
+// rejectedPostWarnings 是存储前被拒绝的 Post 返回给发送者的提示，拒绝后释放幂等占位以便客户端修改后重发
+var rejectedPostWarnings = map[storage.StorageResult]string{
+	storage.StorageResult_INVALID_REPLY:   "引用的消息不存在或不在当前会话",
+	storage.StorageResult_INVALID_MENTION: "只有群消息可以@成员，且被@的人数不能超过上限",
+	storage.StorageResult_FORBIDDEN:       "只有群主和管理员可以@所有人",
+}
+
+// buildStoredPost 把存储成功的消息还原为投递给会话成员的 Post
+func buildStoredPost(storeRsp *storage.StoreMsgRsp) *pb.Post {
+	return &pb.Post{
+		FromId:           storeRsp.GetFromUserId(),
+		ToId:             storeRsp.GetToUserId(),
+		Msg:              storeRsp.GetContent(),
+		MsgType:          storeRsp.GetMessageType(),
+		IsGroup:          storeRsp.GetIsGroup(),
+		RealFileName:     storeRsp.GetRealFileName(),
+		Timestamp:        storeRsp.GetClientTimestamp(),
+		ClientMessageId:  storeRsp.GetClientMessageId(),
+		ReplyToMessageId: storeRsp.GetReplyToMessageId(),
+		MergedItems:      buildMergedForwardItems(storeRsp.GetMergedItems()),
+		MentionedUserIds: storeRsp.GetMentionedUserIds(),
+		MentionAll:       storeRsp.GetMentionAll(),
+	}
+}
+
+func mapStorageForwardResult(result storage.StorageResult) pb.MessageForwardResult {
+	switch result {
+	case storage.StorageResult_OK:
+		return pb.MessageForwardResult_MESSAGE_FORWARD_OK
+	case storage.StorageResult_RECORD_NOT_EXIST:
+		return pb.MessageForwardResult_MESSAGE_FORWARD_NOT_FOUND
+	case storage.StorageResult_ALREADY_RECALLED:
+		return pb.MessageForwardResult_MESSAGE_FORWARD_RECALLED
+	case storage.StorageResult_INVALID_FORWARD:
+		return pb.MessageForwardResult_MESSAGE_FORWARD_INVALID
+	default:
+		return pb.MessageForwardResult_MESSAGE_FORWARD_SERVICE_ERROR
+	}
+}
+
+func buildForwardMessagesRsp(result storage.StorageResult, forward *storage.ForwardMessagesRsp) *pb.ForwardMessagesRsp {
+	if forward == nil {
+		forward = &storage.ForwardMessagesRsp{}
+	}
+	messageIDs := make([]int64, 0, len(forward.GetMessages()))
+	for _, stored := range forward.GetMessages() {
+		messageIDs = append(messageIDs, stored.GetMessageId())
+	}
+	return &pb.ForwardMessagesRsp{
+		Result:          mapStorageForwardResult(result),
+		ClientMessageId: forward.GetClientMessageId(),
+		ToUserId:        forward.GetToUserId(),
+		IsGroup:         forward.GetIsGroup(),
+		Merged:          forward.GetMerged(),
+		MessageIds:      messageIDs,
+	}
+}
+
+func buildMergedForwardItems(items []*storage.MergedForwardItem) []*pb.MergedForwardItem {
+	if len(items) == 0 {
+		return nil
+	}
+	converted := make([]*pb.MergedForwardItem, 0, len(items))
+	for _, item := range items {
+		converted = append(converted, &pb.MergedForwardItem{
+			SourceMessageId: item.GetSourceMessageId(),
+			FromUserId:      item.GetFromUserId(),
+			Content:         item.GetContent(),
+			Timestamp:       item.GetTimestamp(),
+			MsgType:         item.GetMsgType(),
+			RealFileName:    item.GetRealFileName(),
+		})
+	}
+	return converted
+}
+
 func buildPostAckResponse(storeMsgRsp *storage.StoreMsgRsp) *pb.ResponseMessage {
 	return &pb.ResponseMessage{
 		Payload: &pb.ResponseMessage_PostAckRsp{
@@ -806,3 +1275,13 @@ func buildPostAckResponse(storeMsgRsp *storage.StoreMsgRsp) *pb.ResponseMessage
 		},
 	}
 }
+
+// deliverEphemeralToLocalUsers 投递临时信号。信号过期很快，投递失败只记录日志，不让Kafka重试。
+func (h *NewKafkaConsumerGroupHandler) deliverEphemeralToLocalUsers(responseBytes []byte, targetUserIDs []int64) {
+	for _, targetUserID := range targetUserIDs {
+		err := h.wsHandler.DeliverEphemeralLocally(strconv.FormatInt(targetUserID, 10), responseBytes)
+		if err != nil && !errors.Is(err, router.ErrUserOffline) {
+			logger.Sugar().Debugf("跨容器投递临时信号失败: user_id=%d err=%v", targetUserID, err)
+		}
+	}
+}
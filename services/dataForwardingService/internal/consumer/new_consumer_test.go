@@ -241,6 +241,51 @@ func TestBuildPostAckResponse(t *testing.T) {
 	}
 }
 
+func TestBuildMessageRspCopiesQuotedPreview(t *testing.T) {
+	msg := buildMessageRsp(&storage.MessageRsp{
+		MessageId:        79,
+		ReplyToMessageId: 77,
+		ReplyTo:          &storage.QuotedMessage{MessageId: 77, FromUserId: 1001, MsgType: "text", Snippet: "lunch?"},
+	})
+	if msg.GetReplyToMessageId() != 77 || msg.GetReplyTo().GetSnippet() != "lunch?" || msg.GetReplyTo().GetFromUserId() != 1001 {
+		t.Fatalf("quoted preview mapping mismatch: %+v", msg)
+	}
+	if buildMessageRsp(&storage.MessageRsp{MessageId: 80}).GetReplyTo() != nil {
+		t.Fatal("non-reply message should not carry a quoted preview")
+	}
+}
+
+func TestBuildStoredPostAndMessageRspCopyMentions(t *testing.T) {
+	post := buildStoredPost(&storage.StoreMsgRsp{MessageId: 91, FromUserId: 1001, ToUserId: 7, IsGroup: true, MentionedUserIds: []int64{1002, 1003}})
+	if len(post.GetMentionedUserIds()) != 2 || post.GetMentionAll() {
+		t.Fatalf("mentions were not copied to the post: %+v", post)
+	}
+	msg := buildMessageRsp(&storage.MessageRsp{MessageId: 92, IsGroup: true, MentionAll: true})
+	if !msg.GetMentionAll() || len(msg.GetMentionedUserIds()) != 0 {
+		t.Fatalf("mention all was not copied to the message: %+v", msg)
+	}
+}
+
+func TestBuildForwardMessagesRspAndMergedPost(t *testing.T) {
+	stored := &storage.StoreMsgRsp{
+		MessageId: 90, Created: true, FromUserId: 1001, ToUserId: 7, IsGroup: true, MessageType: "merged",
+		MergedItems: []*storage.MergedForwardItem{{SourceMessageId: 61, FromUserId: 1002, Content: "sha512-report", MsgType: "file", RealFileName: "report.pdf"}},
+	}
+	rsp := buildForwardMessagesRsp(storage.StorageResult_OK, &storage.ForwardMessagesRsp{
+		Messages: []*storage.StoreMsgRsp{stored}, ToUserId: 7, IsGroup: true, Merged: true, ClientMessageId: "bundle-1",
+	})
+	if rsp.GetResult() != pb.MessageForwardResult_MESSAGE_FORWARD_OK || len(rsp.GetMessageIds()) != 1 || rsp.GetMessageIds()[0] != 90 || rsp.GetClientMessageId() != "bundle-1" {
+		t.Fatalf("unexpected forward response: %+v", rsp)
+	}
+	post := buildStoredPost(stored)
+	if post.GetMsgType() != "merged" || len(post.GetMergedItems()) != 1 || post.GetMergedItems()[0].GetRealFileName() != "report.pdf" {
+		t.Fatalf("merged items were not copied to the post: %+v", post)
+	}
+	if got := buildForwardMessagesRsp(storage.StorageResult_INVALID_FORWARD, nil).GetResult(); got != pb.MessageForwardResult_MESSAGE_FORWARD_INVALID {
+		t.Fatalf("unexpected invalid forward result: %v", got)
+	}
+}
+
 func TestBuildFriendResponsesPreserveClientFields(t *testing.T) {
 	t.Run("group info", func(t *testing.T) {
 		resp := buildGroupInfoResponse(&friend.GroupInfoRsp{GroupId: 10, GroupName: "Team", Avatar: "group-avatar", ClientNeedSave: true})
@@ -359,3 +404,79 @@ func TestBuildMessageRecallEventMapsResultsAndFields(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildMessageEditEventMapsResultsAndFields(t *testing.T) {
+	edit := &storage.EditMessageRsp{
+		MessageId: 77, FromUserId: 1001, ToUserId: 9001, IsGroup: true, OperatorUserId: 1001,
+		EditedAt: "2026-07-21T04:03:00Z", Content: "fixed", MessageType: "text",
+	}
+	event := buildMessageEditEvent(storage.StorageResult_OK, edit)
+	if event.GetResult() != pb.MessageEditResult_MESSAGE_EDIT_OK || event.GetMessageId() != 77 || event.GetToUserId() != 9001 || !event.GetIsGroup() ||
+		event.GetEditedAt() != edit.GetEditedAt() || event.GetContent() != "fixed" || event.GetMessageType() != "text" {
+		t.Fatalf("unexpected edit event: %+v", event)
+	}
+
+	tests := map[storage.StorageResult]pb.MessageEditResult{
+		storage.StorageResult_RECORD_NOT_EXIST: pb.MessageEditResult_MESSAGE_EDIT_NOT_FOUND,
+		storage.StorageResult_FORBIDDEN:        pb.MessageEditResult_MESSAGE_EDIT_FORBIDDEN,
+		storage.StorageResult_ALREADY_RECALLED: pb.MessageEditResult_MESSAGE_EDIT_RECALLED,
+		storage.StorageResult_EDIT_EXPIRED:     pb.MessageEditResult_MESSAGE_EDIT_EXPIRED,
+		storage.StorageResult_NOT_EDITABLE:     pb.MessageEditResult_MESSAGE_EDIT_NOT_EDITABLE,
+		storage.StorageResult_SERVICE_ERROR:    pb.MessageEditResult_MESSAGE_EDIT_SERVICE_ERROR,
+	}
+	for input, want := range tests {
+		if got := buildMessageEditEvent(input, nil).GetResult(); got != want {
+			t.Fatalf("result %s mapped to %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestBuildMessagePinEventMapsResultsAndFields(t *testing.T) {
+	pin := &storage.PinMessageRsp{
+		MessageId: 77, FromUserId: 1001, ToUserId: 1002, OperatorUserId: 1002,
+		Unpinned: true, Changed: true, PinnedAt: "2026-08-01T09:00:00Z",
+	}
+	event := buildMessagePinEvent(storage.StorageResult_OK, pin)
+	if event.GetResult() != pb.MessagePinResult_MESSAGE_PIN_OK || event.GetMessageId() != 77 || event.GetFromUserId() != 1001 || event.GetIsGroup() ||
+		event.GetOperatorUserId() != 1002 || !event.GetUnpinned() || !event.GetChanged() || event.GetPinnedAt() != pin.GetPinnedAt() {
+		t.Fatalf("unexpected pin event: %+v", event)
+	}
+
+	tests := map[storage.StorageResult]pb.MessagePinResult{
+		storage.StorageResult_RECORD_NOT_EXIST:  pb.MessagePinResult_MESSAGE_PIN_NOT_FOUND,
+		storage.StorageResult_FORBIDDEN:         pb.MessagePinResult_MESSAGE_PIN_FORBIDDEN,
+		storage.StorageResult_ALREADY_RECALLED:  pb.MessagePinResult_MESSAGE_PIN_RECALLED,
+		storage.StorageResult_PIN_LIMIT_REACHED: pb.MessagePinResult_MESSAGE_PIN_LIMIT_REACHED,
+		storage.StorageResult_SERVICE_ERROR:     pb.MessagePinResult_MESSAGE_PIN_SERVICE_ERROR,
+	}
+	for input, want := range tests {
+		if got := buildMessagePinEvent(input, nil).GetResult(); got != want {
+			t.Fatalf("result %s mapped to %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestBuildReactionEventMapsResultsAndReactions(t *testing.T) {
+	reaction := &storage.ReactToMessageRsp{
+		MessageId: 77, FromUserId: 1001, ToUserId: 9001, IsGroup: true, OperatorUserId: 1002, Emoji: "👍",
+		Changed: true, ReactedAt: "2026-07-22T09:00:00Z",
+		Reactions: []*storage.MessageReaction{{Emoji: "👍", Count: 3, ReactedByMe: true}},
+	}
+	event := buildReactionEvent(storage.StorageResult_OK, reaction)
+	if event.GetResult() != pb.MessageReactionResult_MESSAGE_REACTION_OK || event.GetMessageId() != 77 || !event.GetIsGroup() || !event.GetChanged() ||
+		event.GetOperatorUserId() != 1002 || len(event.GetReactions()) != 1 || event.GetReactions()[0].GetCount() != 3 || !event.GetReactions()[0].GetReactedByMe() {
+		t.Fatalf("unexpected reaction event: %+v", event)
+	}
+
+	tests := map[storage.StorageResult]pb.MessageReactionResult{
+		storage.StorageResult_RECORD_NOT_EXIST: pb.MessageReactionResult_MESSAGE_REACTION_NOT_FOUND,
+		storage.StorageResult_ALREADY_RECALLED: pb.MessageReactionResult_MESSAGE_REACTION_RECALLED,
+		storage.StorageResult_INVALID_REACTION: pb.MessageReactionResult_MESSAGE_REACTION_INVALID,
+		storage.StorageResult_SERVICE_ERROR:    pb.MessageReactionResult_MESSAGE_REACTION_SERVICE_ERROR,
+	}
+	for input, want := range tests {
+		if got := buildReactionEvent(input, nil).GetResult(); got != want {
+			t.Fatalf("result %s mapped to %s, want %s", input, got, want)
+		}
+	}
+}
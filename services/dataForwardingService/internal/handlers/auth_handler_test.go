@@ -11,16 +11,19 @@ import (
 )
 
 type authClientStub struct {
-	loginResponse          *auth.LoginRsp
-	loginError             error
-	signupResponse         *auth.SignupRsp
-	signupError            error
-	changePasswordResponse *auth.ChangePasswordRsp
-	changePasswordError    error
-	revokeSessionsResponse *auth.RevokeSessionsRsp
-	revokeSessionsError    error
-	changePasswordRequest  **auth.ChangePasswordReq
-	revokeSessionsRequest  **auth.RevokeSessionsReq
+	loginResponse            *auth.LoginRsp
+	loginError               error
+	signupResponse           *auth.SignupRsp
+	signupError              error
+	changePasswordResponse   *auth.ChangePasswordRsp
+	changePasswordError      error
+	revokeSessionsResponse   *auth.RevokeSessionsRsp
+	revokeSessionsError      error
+	changePasswordRequest    **auth.ChangePasswordReq
+	revokeSessionsRequest    **auth.RevokeSessionsReq
+	checkJwtResponse         *auth.CheckJwtRsp
+	revokeJwtSessionResponse *auth.RevokeJwtSessionRsp
+	revokeJwtSessionRequest  **auth.RevokeJwtSessionReq
 }
 
 func (s authClientStub) Login(context.Context, *auth.LoginReq, ...grpc.CallOption) (*auth.LoginRsp, error) {
@@ -32,7 +35,10 @@ func (s authClientStub) Signup(context.Context, *auth.SignupReq, ...grpc.CallOpt
 }
 
 func (s authClientStub) CheckJwt(context.Context, *auth.CheckJwtReq, ...grpc.CallOption) (*auth.CheckJwtRsp, error) {
-	return nil, errors.New("not implemented")
+	if s.checkJwtResponse == nil {
+		return nil, errors.New("not implemented")
+	}
+	return s.checkJwtResponse, nil
 }
 
 func (s authClientStub) ChangePassword(_ context.Context, req *auth.ChangePasswordReq, _ ...grpc.CallOption) (*auth.ChangePasswordRsp, error) {
@@ -49,11 +55,21 @@ func (s authClientStub) RevokeSessions(_ context.Context, req *auth.RevokeSessio
 	return s.revokeSessionsResponse, s.revokeSessionsError
 }
 
+func (s authClientStub) RevokeJwtSession(_ context.Context, req *auth.RevokeJwtSessionReq, _ ...grpc.CallOption) (*auth.RevokeJwtSessionRsp, error) {
+	if s.revokeJwtSessionRequest != nil {
+		*s.revokeJwtSessionRequest = req
+	}
+	if s.revokeJwtSessionResponse == nil {
+		return nil, errors.New("not implemented")
+	}
+	return s.revokeJwtSessionResponse, nil
+}
+
 func TestAuthHandlersPreserveRPCFailuresWithoutPanicking(t *testing.T) {
 	rpcErr := errors.New("auth unavailable")
 	withAuthClient(t, authClientStub{loginError: rpcErr, signupError: rpcErr})
 
-	loginResponse, userID, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
+	loginResponse, userID, _, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
 	if !errors.Is(err, rpcErr) || userID != -1 || loginResponse.GetLogin().GetResult() != pb.LoginResult_LOGIN_SVR_ERROR {
 		t.Fatalf("unexpected login failure mapping: response=%+v user_id=%d err=%v", loginResponse, userID, err)
 	}
@@ -67,7 +83,7 @@ func TestAuthHandlersPreserveRPCFailuresWithoutPanicking(t *testing.T) {
 func TestAuthHandlersRejectEmptySuccessfulRPCResponses(t *testing.T) {
 	withAuthClient(t, authClientStub{})
 
-	if _, _, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}}); err == nil {
+	if _, _, _, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}}); err == nil {
 		t.Fatal("empty login response must be rejected")
 	}
 	if _, err := HandleSignupMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Signup{Signup: &pb.SignupReq{Account: "alice"}}}); err == nil {
@@ -76,14 +92,14 @@ func TestAuthHandlersRejectEmptySuccessfulRPCResponses(t *testing.T) {
 }
 
 func TestLoginHandlerMapsSuccessfulAuthResponse(t *testing.T) {
-	withAuthClient(t, authClientStub{loginResponse: &auth.LoginRsp{Result: auth.AuthResult_OK, UserId: 42, Jwt: "renewed"}})
+	withAuthClient(t, authClientStub{loginResponse: &auth.LoginRsp{Result: auth.AuthResult_OK, UserId: 42, Jwt: "renewed", SessionId: "session-1"}})
 
-	response, userID, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
+	response, userID, sessionID, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if userID != 42 || response.GetLogin().GetResult() != pb.LoginResult_LOGIN_OK || response.GetLogin().GetJwt() != "renewed" {
-		t.Fatalf("unexpected successful login mapping: response=%+v user_id=%d", response, userID)
+	if userID != 42 || sessionID != "session-1" || response.GetLogin().GetResult() != pb.LoginResult_LOGIN_OK || response.GetLogin().GetJwt() != "renewed" {
+		t.Fatalf("unexpected successful login mapping: response=%+v user_id=%d session_id=%q", response, userID, sessionID)
 	}
 }
 
@@ -100,7 +116,7 @@ func TestFailedAuthenticationNeverAllowsSessionBinding(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			withAuthClient(t, authClientStub{loginResponse: &auth.LoginRsp{Result: test.authResult, UserId: 304}})
-			response, userID, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
+			response, userID, _, err := HandleLoginMessage(&pb.RequestMessage{Payload: &pb.RequestMessage_Login{Login: &pb.LoginReq{Account: "alice"}}})
 			if err != nil || response.GetLogin().GetResult() != test.wantResult || userID != -1 {
 				t.Fatalf("unexpected auth failure: response=%+v user_id=%d err=%v", response, userID, err)
 			}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/shared/logger"
+	"data_forwarding_service/internal/connection"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// pendingBatchSize 是写协程每次从 Redis 待发送队列取回的帧数
+const pendingBatchSize = 64
+
+var (
+	presenceEventField           = responsePayloadFields.ByName("presence_event").Number()
+	conversationSignalEventField = responsePayloadFields.ByName("conversation_signal_event").Number()
+)
+
+// newOverflowPolicy 返回连接发送通道已满时的处理策略，见 connection.OverflowPolicy。
+func (h *WebSocketHandler) newOverflowPolicy() *connection.OverflowPolicy {
+	return &connection.OverflowPolicy{
+		CoalesceKey:  coalesceKeyForFrame,
+		SyncRequired: syncRequiredFrame,
+		SpillLimit:   h.config.sendSpillLimit,
+		SpillTTL:     h.config.sendSpillTTL,
+	}
+}
+
+// coalesceKeyForFrame 为只需保留最新状态的推送返回合并键：同一用户的在线状态、同一会话同一发送者的会话信号。
+// 其他帧返回空字符串，按顺序暂存。
+func coalesceKeyForFrame(message []byte) string {
+	field := responsePayloadField(message)
+	if field != presenceEventField && field != conversationSignalEventField {
+		return ""
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(message, response); err != nil {
+		return ""
+	}
+	if event := response.GetPresenceEvent(); event != nil {
+		return fmt.Sprintf("presence:%d", event.GetUserId())
+	}
+	if event := response.GetConversationSignalEvent(); event != nil {
+		return fmt.Sprintf("signal:%t:%d:%d", event.GetIsGroup(), event.GetConversationId(), event.GetFromUserId())
+	}
+	return ""
+}
+
+func syncRequiredFrame(since time.Time, sinceSeq int64) []byte {
+	syncRequired := &pb.SyncRequired{SinceSeq: sinceSeq}
+	if !since.IsZero() {
+		syncRequired.Since = since.UTC().Format(time.RFC3339)
+	}
+	frame, err := proto.Marshal(&pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_SyncRequired{SyncRequired: syncRequired},
+	})
+	if err != nil {
+		logger.Sugar().Errorf("序列化 SyncRequired 失败: %v", err)
+		return nil
+	}
+	return frame
+}
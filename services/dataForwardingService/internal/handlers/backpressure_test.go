@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCoalesceKeyForFrameOnlyMatchesReplaceableEvents(t *testing.T) {
+	marshal := func(response *pb.ResponseMessage) []byte {
+		frame, err := proto.Marshal(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+	online := marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_PresenceEvent{PresenceEvent: &pb.PresenceInfo{UserId: 7, Online: true}}})
+	offline := marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_PresenceEvent{PresenceEvent: &pb.PresenceInfo{UserId: 7}}})
+	if key := coalesceKeyForFrame(online); key == "" || key != coalesceKeyForFrame(offline) {
+		t.Fatalf("presence events of one user should share a key: %q", key)
+	}
+	typing := marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_ConversationSignalEvent{ConversationSignalEvent: &pb.ConversationSignalEvent{
+		FromUserId: 7, ConversationId: 9, IsGroup: true, Kind: pb.ConversationSignalKind_TYPING_STARTED,
+	}}})
+	otherSender := marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_ConversationSignalEvent{ConversationSignalEvent: &pb.ConversationSignalEvent{
+		FromUserId: 8, ConversationId: 9, IsGroup: true, Kind: pb.ConversationSignalKind_TYPING_STARTED,
+	}}})
+	if key := coalesceKeyForFrame(typing); key == "" || key == coalesceKeyForFrame(otherSender) || key == coalesceKeyForFrame(online) {
+		t.Fatalf("unexpected signal key: %q", key)
+	}
+	post := marshal(&pb.ResponseMessage{Seq: 3, Payload: &pb.ResponseMessage_Post{Post: &pb.Post{}}})
+	if key := coalesceKeyForFrame(post); key != "" {
+		t.Fatalf("durable frames must not be coalesced: %q", key)
+	}
+}
+
+func TestSyncRequiredFrameCarriesOverflowStart(t *testing.T) {
+	since := time.Date(2026, 10, 18, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(syncRequiredFrame(since, 42), response); err != nil {
+		t.Fatal(err)
+	}
+	if got := response.GetSyncRequired(); got.GetSince() != "2026-10-18T00:00:00Z" || got.GetSinceSeq() != 42 {
+		t.Fatalf("unexpected sync hint: %+v", got)
+	}
+}
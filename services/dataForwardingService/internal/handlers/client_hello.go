@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	sharedDB "Betterfly2/shared/db"
+	"Betterfly2/shared/logger"
+	"bytes"
+	"data_forwarding_service/internal/connection"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// serverProtocolVersion 是服务端实现的协议版本，新增需要客户端声明能力的推送时递增
+const serverProtocolVersion int32 = 1
+
+// 客户端可以在 Hello 中声明的能力，未声明的能力对应的推送不会发给该连接
+const (
+	capabilityMessageRecall       = "message_recall"
+	capabilityReceipts            = "receipts"
+	capabilityConversationSignals = "conversation_signals"
+	capabilityPresence            = "presence"
+	capabilityDeliveryAck         = "delivery_ack"
+	capabilityContactEvents       = "contact_events"
+	capabilityMessageEdit         = "message_edit"
+	capabilityReactions           = "reactions"
+	capabilityMessageForward      = "message_forward"
+	capabilityMessagePins         = "message_pins"
+)
+
+// serverCapabilities 按 HelloRsp.capabilities 返回的顺序排列，只包含确实会改变推送内容的能力
+var serverCapabilities = []string{
+	capabilityMessageRecall,
+	capabilityReceipts,
+	capabilityConversationSignals,
+	capabilityPresence,
+	capabilityDeliveryAck,
+	capabilityContactEvents,
+	capabilityMessageEdit,
+	capabilityReactions,
+	capabilityMessageForward,
+	capabilityMessagePins,
+}
+
+// gatedResponsePayloads 记录需要客户端声明能力才能接收的服务端推送事件。
+// 对客户端自身请求的应答（*_rsp）不在此列，无论是否声明能力都会下发，否则旧客户端的请求会得不到任何回复。
+var gatedResponsePayloads = map[protoreflect.Name]string{
+	"message_recall_event":      capabilityMessageRecall,
+	"receipt_event":             capabilityReceipts,
+	"conversation_signal_event": capabilityConversationSignals,
+	"presence_event":            capabilityPresence,
+	"profile_changed_event":     capabilityContactEvents,
+	"group_changed_event":       capabilityContactEvents,
+	"message_edit_event":        capabilityMessageEdit,
+	"reaction_event":            capabilityReactions,
+	"message_pin_event":         capabilityMessagePins,
+}
+
+// responseDowngrades 为部分能力提供旧客户端可以显示的替代帧，没有替代帧或替代函数返回 nil 的推送直接跳过。
+var responseDowngrades = map[protoreflect.Name]func(*pb.ResponseMessage) *pb.ResponseMessage{
+	"message_recall_event": downgradeMessageRecallEvent,
+	"message_edit_event":   downgradeMessageEditEvent,
+	"reaction_event":       downgradeReactionEvent,
+	"message_pin_event":    downgradeMessagePinEvent,
+}
+
+// mergedForwardPlaceholder 是未声明 message_forward 的客户端看到的合并转发消息正文，与会话摘要一致
+const mergedForwardPlaceholder = "[聊天记录]"
+
+// mergedForwardMarker 是 msg_type 取值的原始字节，帧中不含它时无需解析
+var mergedForwardMarker = []byte(sharedDB.MergedForwardMessageType)
+
+var (
+	responsePayloadFields = (&pb.ResponseMessage{}).ProtoReflect().Descriptor().Oneofs().ByName("payload").Fields()
+	// responseCapabilityByField 是 gatedResponsePayloads 按字段号的索引，适配时无需反序列化整帧
+	responseCapabilityByField = indexResponsePayloads(gatedResponsePayloads)
+)
+
+func indexResponsePayloads(byName map[protoreflect.Name]string) map[protowire.Number]protoreflect.Name {
+	index := make(map[protowire.Number]protoreflect.Name, len(byName))
+	for name := range byName {
+		field := responsePayloadFields.ByName(name)
+		if field == nil {
+			panic(fmt.Sprintf("ResponseMessage 中不存在 payload 字段 %s", name))
+		}
+		index[field.Number()] = name
+	}
+	return index
+}
+
+// handleHello 记录客户端声明的协议版本与能力，返回本连接实际启用的能力。
+func (h *WebSocketHandler) handleHello(conn *connection.Connection, requestMsg *pb.RequestMessage) {
+	hello := requestMsg.GetHello()
+	drained := conn.ClientInfo().Supports(capabilityDeliveryAck)
+	enabled := negotiateCapabilities(hello.GetCapabilities())
+	version := min(hello.GetProtocolVersion(), serverProtocolVersion)
+	conn.SetClientInfo(connection.NewClientInfo(version, hello.GetAppVersion(), hello.GetPlatform(), enabled))
+	logger.Sugar().Infow("客户端握手", "connection_id", conn.ID, "protocol_version", hello.GetProtocolVersion(),
+		"app_version", hello.GetAppVersion(), "platform", hello.GetPlatform(), "capabilities", enabled)
+
+	h.sendControlResponse(conn, replyTo(requestMsg, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_Hello{Hello: &pb.HelloRsp{
+			ProtocolVersion:       version,
+			Capabilities:          enabled,
+			ServerProtocolVersion: serverProtocolVersion,
+		}},
+	}))
+	// 登录后才声明 delivery_ack 的连接在这里补发，登录时已经补发过的不再重复
+	if conn.IsAuthenticated() && !drained {
+		h.drainPendingDeliveries(conn)
+	}
+}
+
+// negotiateCapabilities 返回客户端声明且服务端支持的能力，顺序与 serverCapabilities 一致。
+func negotiateCapabilities(requested []string) []string {
+	declared := make(map[string]bool, len(requested))
+	for _, capability := range requested {
+		declared[capability] = true
+	}
+	enabled := make([]string, 0, len(serverCapabilities))
+	for _, capability := range serverCapabilities {
+		if declared[capability] {
+			enabled = append(enabled, capability)
+		}
+	}
+	return enabled
+}
+
+// adaptFrameForClient 是连接的 FrameAdapter：客户端未声明某项能力时，
+// 对应的帧降级为替代帧，没有替代帧时跳过。
+func adaptFrameForClient(client *connection.ClientInfo, message []byte) ([]byte, bool) {
+	name, gated := responseCapabilityByField[responsePayloadField(message)]
+	if !gated {
+		return downgradeMergedForwardsForClient(client, message), true
+	}
+	if client.Supports(gatedResponsePayloads[name]) {
+		return message, true
+	}
+	downgrade, exists := responseDowngrades[name]
+	if !exists {
+		return nil, false
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(message, response); err != nil {
+		logger.Sugar().Warnf("解析待降级的响应帧失败: %v", err)
+		return nil, false
+	}
+	downgraded := downgrade(response)
+	if downgraded == nil {
+		return nil, false
+	}
+	downgraded.RequestId = response.GetRequestId()
+	adapted, err := proto.Marshal(downgraded)
+	if err != nil {
+		logger.Sugar().Warnf("序列化降级响应帧失败: %v", err)
+		return nil, false
+	}
+	return adapted, true
+}
+
+// downgradeMergedForwardsForClient 把帧中的合并转发消息替换为文本占位，供未声明 message_forward 的客户端显示。
+// 实时 post 与同步、历史、搜索等应答中的 MessageRsp 都会替换，其他帧原样返回。
+func downgradeMergedForwardsForClient(client *connection.ClientInfo, message []byte) []byte {
+	if client.Supports(capabilityMessageForward) || !bytes.Contains(message, mergedForwardMarker) {
+		return message
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(message, response); err != nil {
+		logger.Sugar().Warnf("解析待降级的合并转发帧失败: %v", err)
+		return message
+	}
+	if !replaceMergedForwards(response) {
+		return message
+	}
+	adapted, err := proto.Marshal(response)
+	if err != nil {
+		logger.Sugar().Warnf("序列化降级的合并转发帧失败: %v", err)
+		return message
+	}
+	return adapted
+}
+
+func replaceMergedForwards(response *pb.ResponseMessage) bool {
+	changed := false
+	replaceMessage := func(msg *pb.MessageRsp) {
+		if msg.GetMsgType() != sharedDB.MergedForwardMessageType {
+			return
+		}
+		msg.MsgType = "text"
+		if !msg.GetIsRecalled() {
+			msg.Content = mergedForwardPlaceholder
+		}
+		msg.MergedItems = nil
+		changed = true
+	}
+	replaceMessages := func(msgs []*pb.MessageRsp) {
+		for _, msg := range msgs {
+			replaceMessage(msg)
+		}
+	}
+
+	switch payload := response.Payload.(type) {
+	case *pb.ResponseMessage_Post:
+		if payload.Post.GetMsgType() == sharedDB.MergedForwardMessageType {
+			payload.Post.MsgType = "text"
+			payload.Post.Msg = mergedForwardPlaceholder
+			payload.Post.MergedItems = nil
+			changed = true
+		}
+	case *pb.ResponseMessage_MessageRsp:
+		replaceMessage(payload.MessageRsp)
+	case *pb.ResponseMessage_SyncMsgsRsp:
+		replaceMessages(payload.SyncMsgsRsp.GetMsgs())
+		replaceMessages(payload.SyncMsgsRsp.GetReactionUpdates())
+	case *pb.ResponseMessage_ConversationHistoryRsp:
+		replaceMessages(payload.ConversationHistoryRsp.GetMsgs())
+	case *pb.ResponseMessage_MentionedMessagesRsp:
+		replaceMessages(payload.MentionedMessagesRsp.GetMsgs())
+	case *pb.ResponseMessage_SearchMessagesRsp:
+		replaceMessages(payload.SearchMessagesRsp.GetMsgs())
+	case *pb.ResponseMessage_PinnedMessagesRsp:
+		for _, pin := range payload.PinnedMessagesRsp.GetPins() {
+			replaceMessage(pin.GetMsg())
+		}
+	}
+	return changed
+}
+
+// responsePayloadField 扫描已序列化 ResponseMessage 的顶层字段，返回 payload 的字段号，没有 payload 时返回 0。
+func responsePayloadField(message []byte) protowire.Number {
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return 0
+		}
+		message = message[n:]
+		if responsePayloadFields.ByNumber(number) != nil {
+			return number
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, message)
+		if n < 0 {
+			return 0
+		}
+		message = message[n:]
+	}
+	return 0
+}
+
+func downgradeMessageRecallEvent(response *pb.ResponseMessage) *pb.ResponseMessage {
+	event := response.GetMessageRecallEvent()
+	if event.GetResult() != pb.MessageRecallResult_MESSAGE_RECALL_OK {
+		return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{
+			WarningMessage: fmt.Sprintf("消息撤回失败: %s", event.GetResult()),
+		}}}
+	}
+	return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Server{Server: &pb.Server{
+		ServerMsg: fmt.Sprintf("消息 %d 已被撤回", event.GetMessageId()),
+	}}}
+}
+
+func downgradeMessageEditEvent(response *pb.ResponseMessage) *pb.ResponseMessage {
+	event := response.GetMessageEditEvent()
+	if event.GetResult() != pb.MessageEditResult_MESSAGE_EDIT_OK {
+		return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{
+			WarningMessage: fmt.Sprintf("消息编辑失败: %s", event.GetResult()),
+		}}}
+	}
+	return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Server{Server: &pb.Server{
+		ServerMsg: fmt.Sprintf("消息 %d 已被编辑", event.GetMessageId()),
+	}}}
+}
+
+// downgradeReactionEvent 只提示操作者自己的失败结果，其他人的回应对旧客户端没有可显示的替代帧
+func downgradeReactionEvent(response *pb.ResponseMessage) *pb.ResponseMessage {
+	event := response.GetReactionEvent()
+	if event.GetResult() == pb.MessageReactionResult_MESSAGE_REACTION_OK {
+		return nil
+	}
+	return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{
+		WarningMessage: fmt.Sprintf("消息回应失败: %s", event.GetResult()),
+	}}}
+}
+
+// downgradeMessagePinEvent 只提示操作者自己的失败结果，置顶状态对旧客户端没有可显示的替代帧
+func downgradeMessagePinEvent(response *pb.ResponseMessage) *pb.ResponseMessage {
+	event := response.GetMessagePinEvent()
+	if event.GetResult() == pb.MessagePinResult_MESSAGE_PIN_OK {
+		return nil
+	}
+	return &pb.ResponseMessage{Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{
+		WarningMessage: fmt.Sprintf("消息置顶失败: %s", event.GetResult()),
+	}}}
+}
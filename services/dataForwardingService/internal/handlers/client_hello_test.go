@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"data_forwarding_service/internal/connection"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNegotiateCapabilitiesKeepsKnownCapabilitiesInServerOrder(t *testing.T) {
+	got := negotiateCapabilities([]string{capabilityPresence, "unknown", capabilityMessageRecall, capabilityPresence})
+	if len(got) != 2 || got[0] != capabilityMessageRecall || got[1] != capabilityPresence {
+		t.Fatalf("unexpected capabilities: %v", got)
+	}
+	// 这些能力不限制任何推送，服务端不再声明支持，客户端发送时按未知能力忽略
+	if got := negotiateCapabilities([]string{"relationship_requests", "conversation_list", "message_search"}); len(got) != 0 {
+		t.Fatalf("capabilities without gated events should be ignored: %v", got)
+	}
+}
+
+func TestAdaptFrameForClientSkipsOrDowngradesGatedPayloads(t *testing.T) {
+	marshal := func(response *pb.ResponseMessage) []byte {
+		t.Helper()
+		data, err := proto.Marshal(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	receipt := marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_ReceiptEvent{ReceiptEvent: &pb.ReceiptEvent{}}})
+	recall := marshal(&pb.ResponseMessage{RequestId: "req-1", Payload: &pb.ResponseMessage_MessageRecallEvent{
+		MessageRecallEvent: &pb.MessageRecallEvent{MessageId: 42},
+	}})
+	post := marshal(&pb.ResponseMessage{RequestId: "req-2", Payload: &pb.ResponseMessage_Post{Post: &pb.Post{Msg: "hi"}}})
+	modern := connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityReceipts, capabilityMessageRecall})
+
+	if _, deliver := adaptFrameForClient(nil, receipt); deliver {
+		t.Fatal("legacy client should not receive receipt events")
+	}
+	if adapted, deliver := adaptFrameForClient(modern, receipt); !deliver || string(adapted) != string(receipt) {
+		t.Fatal("client declaring receipts should receive the original frame")
+	}
+	if adapted, deliver := adaptFrameForClient(nil, post); !deliver || string(adapted) != string(post) {
+		t.Fatal("ungated payloads should pass through unchanged")
+	}
+
+	adapted, deliver := adaptFrameForClient(nil, recall)
+	if !deliver {
+		t.Fatal("recall events should be downgraded for legacy clients")
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(adapted, response); err != nil {
+		t.Fatal(err)
+	}
+	if response.GetServer().GetServerMsg() == "" || response.GetRequestId() != "req-1" {
+		t.Fatalf("unexpected downgraded frame: %+v", response)
+	}
+}
+
+func TestContactEventsRequireCapability(t *testing.T) {
+	for _, response := range []*pb.ResponseMessage{
+		{Payload: &pb.ResponseMessage_ProfileChangedEvent{ProfileChangedEvent: &pb.ProfileChangedEvent{UserId: 1001, Name: "新昵称"}}},
+		{Payload: &pb.ResponseMessage_GroupChangedEvent{GroupChangedEvent: &pb.GroupChangedEvent{GroupId: 3001, Name: "新群名称"}}},
+	} {
+		frame, err := proto.Marshal(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, deliver := adaptFrameForClient(nil, frame); deliver {
+			t.Fatalf("legacy client should not receive %T", response.GetPayload())
+		}
+		client := connection.NewClientInfo(serverProtocolVersion, "2.0.0", "android", []string{capabilityContactEvents})
+		if adapted, deliver := adaptFrameForClient(client, frame); !deliver || string(adapted) != string(frame) {
+			t.Fatalf("client declaring contact events should receive %T", response.GetPayload())
+		}
+	}
+}
+
+func TestReactionEventsDowngradeOnlyOperatorFailures(t *testing.T) {
+	marshal := func(result pb.MessageReactionResult) []byte {
+		t.Helper()
+		data, err := proto.Marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_ReactionEvent{ReactionEvent: &pb.ReactionEvent{Result: result, MessageId: 42}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if _, deliver := adaptFrameForClient(nil, marshal(pb.MessageReactionResult_MESSAGE_REACTION_OK)); deliver {
+		t.Fatal("legacy client should not receive reaction updates")
+	}
+	adapted, deliver := adaptFrameForClient(nil, marshal(pb.MessageReactionResult_MESSAGE_REACTION_INVALID))
+	if !deliver {
+		t.Fatal("legacy client should be warned about its failed reaction")
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(adapted, response); err != nil || response.GetWarn() == nil {
+		t.Fatalf("unexpected downgraded reaction frame: %+v err=%v", response, err)
+	}
+	client := connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityReactions})
+	if _, deliver := adaptFrameForClient(client, marshal(pb.MessageReactionResult_MESSAGE_REACTION_OK)); !deliver {
+		t.Fatal("client declaring reactions should receive reaction events")
+	}
+}
+
+func TestMessagePinEventsDowngradeOnlyOperatorFailures(t *testing.T) {
+	marshal := func(result pb.MessagePinResult) []byte {
+		t.Helper()
+		data, err := proto.Marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_MessagePinEvent{MessagePinEvent: &pb.MessagePinEvent{Result: result, MessageId: 42, Changed: true}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if _, deliver := adaptFrameForClient(nil, marshal(pb.MessagePinResult_MESSAGE_PIN_OK)); deliver {
+		t.Fatal("legacy client should not receive pin updates")
+	}
+	adapted, deliver := adaptFrameForClient(nil, marshal(pb.MessagePinResult_MESSAGE_PIN_LIMIT_REACHED))
+	response := &pb.ResponseMessage{}
+	if !deliver || proto.Unmarshal(adapted, response) != nil || response.GetWarn() == nil {
+		t.Fatalf("legacy client should be warned about its failed pin: %+v", response)
+	}
+	client := connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityMessagePins})
+	if _, deliver := adaptFrameForClient(client, marshal(pb.MessagePinResult_MESSAGE_PIN_OK)); !deliver {
+		t.Fatal("client declaring message pins should receive pin events")
+	}
+}
+
+func TestResponsePayloadFieldFindsPayloadAfterOtherFields(t *testing.T) {
+	frame := withResponseSeq(nil, 3)
+	payload, err := proto.Marshal(&pb.ResponseMessage{RequestId: "req", Payload: &pb.ResponseMessage_PresenceEvent{PresenceEvent: &pb.PresenceInfo{UserId: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame = append(frame, payload...)
+	if got := responsePayloadField(frame); got != 28 {
+		t.Fatalf("unexpected payload field: %d", got)
+	}
+	if got := responsePayloadField(withResponseSeq(nil, 1)); got != 0 {
+		t.Fatalf("frame without payload should return 0, got %d", got)
+	}
+}
+
+func TestAdaptFrameForClientAlwaysDeliversRequestReplies(t *testing.T) {
+	for _, response := range []*pb.ResponseMessage{
+		{Payload: &pb.ResponseMessage_RelationshipRequestListRsp{RelationshipRequestListRsp: &pb.RelationshipRequestListRsp{}}},
+		{Payload: &pb.ResponseMessage_RelationshipOperationRsp{RelationshipOperationRsp: &pb.RelationshipOperationRsp{}}},
+		{Payload: &pb.ResponseMessage_GroupMemberOperationRsp{GroupMemberOperationRsp: &pb.GroupMemberOperationRsp{}}},
+		{Payload: &pb.ResponseMessage_PresenceRsp{PresenceRsp: &pb.PresenceRsp{}}},
+		{Payload: &pb.ResponseMessage_PresenceSettingsRsp{PresenceSettingsRsp: &pb.PresenceSettingsRsp{HideLastSeen: true}}},
+		{Payload: &pb.ResponseMessage_ConversationsRsp{ConversationsRsp: &pb.ConversationsRsp{}}},
+		{Payload: &pb.ResponseMessage_ConversationHistoryRsp{ConversationHistoryRsp: &pb.ConversationHistoryRsp{}}},
+		{Payload: &pb.ResponseMessage_ForwardMessagesRsp{ForwardMessagesRsp: &pb.ForwardMessagesRsp{Result: pb.MessageForwardResult_MESSAGE_FORWARD_NOT_FOUND}}},
+		{Payload: &pb.ResponseMessage_MentionedMessagesRsp{MentionedMessagesRsp: &pb.MentionedMessagesRsp{}}},
+		{Payload: &pb.ResponseMessage_PinnedMessagesRsp{PinnedMessagesRsp: &pb.PinnedMessagesRsp{}}},
+		{Payload: &pb.ResponseMessage_SearchMessagesRsp{SearchMessagesRsp: &pb.SearchMessagesRsp{}}},
+	} {
+		response.RequestId = "req-legacy"
+		frame, err := proto.Marshal(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adapted, deliver := adaptFrameForClient(nil, frame); !deliver || string(adapted) != string(frame) {
+			t.Fatalf("legacy client should receive its own reply %T", response.GetPayload())
+		}
+	}
+}
+
+func TestMergedForwardsDowngradeToPlaceholderWithoutCapability(t *testing.T) {
+	merged := []*pb.MergedForwardItem{{SourceMessageId: 61, Content: "see attached", MsgType: "text"}}
+	for _, response := range []*pb.ResponseMessage{
+		{Payload: &pb.ResponseMessage_Post{Post: &pb.Post{FromId: 1001, ToId: 7, MsgType: "merged", MergedItems: merged}}},
+		{Payload: &pb.ResponseMessage_SyncMsgsRsp{SyncMsgsRsp: &pb.SyncMessagesRsp{Msgs: []*pb.MessageRsp{
+			{MessageId: 90, MsgType: "text", Content: "hello"},
+			{MessageId: 91, MsgType: "merged", MergedItems: merged},
+		}}}},
+	} {
+		frame, err := proto.Marshal(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modern := connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityMessageForward})
+		if adapted, deliver := adaptFrameForClient(modern, frame); !deliver || string(adapted) != string(frame) {
+			t.Fatalf("client declaring message_forward should receive %T unchanged", response.GetPayload())
+		}
+
+		adapted, deliver := adaptFrameForClient(nil, frame)
+		legacy := &pb.ResponseMessage{}
+		if !deliver || proto.Unmarshal(adapted, legacy) != nil {
+			t.Fatalf("legacy client should receive downgraded %T", response.GetPayload())
+		}
+		if post := legacy.GetPost(); post != nil {
+			if post.GetMsgType() != "text" || post.GetMsg() != mergedForwardPlaceholder || len(post.GetMergedItems()) != 0 {
+				t.Fatalf("unexpected downgraded post: %+v", post)
+			}
+			continue
+		}
+		msgs := legacy.GetSyncMsgsRsp().GetMsgs()
+		if len(msgs) != 2 || msgs[0].GetContent() != "hello" || msgs[1].GetMsgType() != "text" ||
+			msgs[1].GetContent() != mergedForwardPlaceholder || len(msgs[1].GetMergedItems()) != 0 {
+			t.Fatalf("unexpected downgraded sync messages: %+v", msgs)
+		}
+	}
+}
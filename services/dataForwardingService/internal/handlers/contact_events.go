@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	friend "Betterfly2/proto/friend"
+	"Betterfly2/proto/storage"
+	sharedDB "Betterfly2/shared/db"
+	"fmt"
+)
+
+// DeliverProfileChanged 把用户资料变更推送给其在线好友和本人的所有设备。
+// 资料变更不进入待投递日志，离线客户端仍通过 QueryContacts 对齐。
+func DeliverProfileChanged(changed *storage.UserProfileChanged) error {
+	if changed == nil || changed.GetUserId() <= 0 {
+		return fmt.Errorf("待投递的资料变更事件无效")
+	}
+	friends, err := sharedDB.GetFriendList(changed.GetUserId())
+	if err != nil {
+		return err
+	}
+	targetIDs := make([]int64, 0, len(friends)+1)
+	targetIDs = append(targetIDs, changed.GetUserId())
+	for _, contact := range friends {
+		targetIDs = append(targetIDs, contact.UserID)
+	}
+	return deliverEphemeralResponse(targetIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_ProfileChangedEvent{ProfileChangedEvent: &pb.ProfileChangedEvent{
+			UserId:     changed.GetUserId(),
+			Name:       changed.GetName(),
+			Avatar:     changed.GetAvatar(),
+			UpdateTime: changed.GetUpdateTime(),
+		}},
+	})
+}
+
+// DeliverGroupChanged 把群名称、群头像变更推送给所有在线群成员，包括操作人的其他设备。
+func DeliverGroupChanged(changed *friend.GroupChanged) error {
+	if changed == nil || changed.GetGroupId() <= 0 {
+		return fmt.Errorf("待投递的群资料变更事件无效")
+	}
+	memberIDs, err := sharedDB.GetActiveGroupMemberIDs(changed.GetGroupId())
+	if err != nil {
+		return err
+	}
+	return deliverEphemeralResponse(memberIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_GroupChangedEvent{GroupChangedEvent: &pb.GroupChangedEvent{
+			GroupId:        changed.GetGroupId(),
+			Name:           changed.GetGroupName(),
+			Avatar:         changed.GetAvatar(),
+			OperatorUserId: changed.GetOperatorUserId(),
+			UpdateTime:     changed.GetUpdateTime(),
+		}},
+	})
+}
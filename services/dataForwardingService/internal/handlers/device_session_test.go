@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	auth "Betterfly2/proto/server_rpc/auth"
+	"context"
+	"data_forwarding_service/internal/connection"
+	redisClient "data_forwarding_service/internal/redis"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func useHandlerTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	previous := redisClient.Rdb
+	redisClient.Rdb = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = redisClient.Rdb.Close()
+		redisClient.Rdb = previous
+	})
+	return server
+}
+
+func claimDeviceSession(t *testing.T, userID, deviceID, platform, containerID string) redisClient.SessionData {
+	t.Helper()
+	data := redisClient.SessionData{
+		ConnectionID: "connection-" + deviceID,
+		ContainerID:  containerID,
+		OwnerToken:   "owner-" + deviceID,
+		DeviceID:     deviceID,
+		Platform:     platform,
+		JwtSessionID: "session-" + deviceID,
+	}
+	if err := (&redisClient.DistributedSessionManager{}).ClaimSessionAndRoute(context.Background(), userID, data, time.Minute, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestListDeviceSessionsReportsLiveDevices(t *testing.T) {
+	server := useHandlerTestRedis(t)
+	claimDeviceSession(t, "42", "desktop", "macos", "df-a")
+	claimDeviceSession(t, "42", "phone", "ios", "df-b")
+	claimDeviceSession(t, "42", "tablet", "ios", "df-b")
+	server.Del("ws_route_lease:42:tablet")
+
+	sessions, err := listDeviceSessions(context.Background(), 42, "desktop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected only live device sessions, got %+v", sessions)
+	}
+	desktop, phone := sessions[0], sessions[1]
+	if desktop.GetDeviceId() != "desktop" || !desktop.GetCurrent() || desktop.GetPod() != "df-a" || desktop.GetPlatform() != "macos" {
+		t.Fatalf("unexpected current device session: %+v", desktop)
+	}
+	if phone.GetDeviceId() != "phone" || phone.GetCurrent() || phone.GetLoggedInAt() == "" || phone.GetLastRefreshedAt() == "" {
+		t.Fatalf("unexpected other device session: %+v", phone)
+	}
+}
+
+func TestRevokeDeviceSessionKeepsOtherDevicesAuthenticated(t *testing.T) {
+	server := useHandlerTestRedis(t)
+	claimDeviceSession(t, "42", "desktop", "macos", "df-a")
+	claimDeviceSession(t, "42", "phone", "ios", "df-b")
+	claimDeviceSession(t, "42", "tablet", "ios", "df-b")
+	var revokeRequest *auth.RevokeSessionsReq
+	var revokeJwtRequest *auth.RevokeJwtSessionReq
+	withAuthClient(t, authClientStub{
+		checkJwtResponse:         &auth.CheckJwtRsp{Result: auth.AuthResult_OK},
+		revokeSessionsRequest:    &revokeRequest,
+		revokeJwtSessionResponse: &auth.RevokeJwtSessionRsp{Result: auth.AuthResult_OK},
+		revokeJwtSessionRequest:  &revokeJwtRequest,
+	})
+
+	request := &pb.RequestMessage{Jwt: "desktop-jwt", Payload: &pb.RequestMessage_RevokeDeviceSession{
+		RevokeDeviceSession: &pb.RevokeDeviceSession{DeviceId: "phone"},
+	}}
+	result, err := deviceRequestMessageHandler(42, "desktop", request)
+	if err != nil || result.code != 0 {
+		t.Fatalf("revoke must keep the requesting connection open: result=%+v err=%v", result, err)
+	}
+	response := result.response.GetDeviceSessionsRsp()
+	if response.GetResult() != pb.DeviceSessionResult_DEVICE_SESSION_OK {
+		t.Fatalf("unexpected revoke response: %+v", response)
+	}
+	if revokeRequest != nil {
+		t.Fatalf("revoking one device must not rotate the user's JWT key: %+v", revokeRequest)
+	}
+	if revokeJwtRequest.GetUserId() != 42 || revokeJwtRequest.GetJwt() != "desktop-jwt" || revokeJwtRequest.GetSessionId() != "session-phone" {
+		t.Fatalf("revoke must ask Auth to revoke the target device's JWT session: %+v", revokeJwtRequest)
+	}
+	if len(response.GetSessions()) != 2 || response.GetSessions()[0].GetDeviceId() != "desktop" || response.GetSessions()[1].GetDeviceId() != "tablet" {
+		t.Fatalf("revoke must only remove the target device: %+v", response.GetSessions())
+	}
+	if server.Exists("user_session:42:phone") || server.Exists("ws_route_lease:42:phone") {
+		t.Fatal("revoked device kept its session or route lease")
+	}
+	if _, exists, err := (&redisClient.DistributedSessionManager{}).GetUserSession(context.Background(), "42", "tablet"); err != nil || !exists {
+		t.Fatalf("second device session was removed: exists=%v err=%v", exists, err)
+	}
+}
+
+func TestRevokeDeviceSessionKeepsAuthFailuresFromRemovingTheSession(t *testing.T) {
+	server := useHandlerTestRedis(t)
+	claimDeviceSession(t, "42", "desktop", "macos", "df-a")
+	claimDeviceSession(t, "42", "phone", "ios", "df-b")
+	withAuthClient(t, authClientStub{
+		checkJwtResponse:         &auth.CheckJwtRsp{Result: auth.AuthResult_OK},
+		revokeJwtSessionResponse: &auth.RevokeJwtSessionRsp{Result: auth.AuthResult_SERVICE_ERROR},
+	})
+
+	request := &pb.RequestMessage{Jwt: "desktop-jwt", Payload: &pb.RequestMessage_RevokeDeviceSession{
+		RevokeDeviceSession: &pb.RevokeDeviceSession{DeviceId: "phone"},
+	}}
+	result, err := deviceRequestMessageHandler(42, "desktop", request)
+	if err != nil || result.response.GetDeviceSessionsRsp().GetResult() != pb.DeviceSessionResult_DEVICE_SESSION_SERVICE_ERROR {
+		t.Fatalf("unexpected revoke result: result=%+v err=%v", result.response, err)
+	}
+	if !server.Exists("user_session:42:phone") {
+		t.Fatal("device session was removed although its JWT session is still valid")
+	}
+}
+
+// revokingAuthStub 按令牌的会话ID模拟 Auth 的吊销判断，登录请求不携带设备ID。
+type revokingAuthStub struct {
+	authClientStub
+	sessions map[string]string
+	revoked  map[string]bool
+}
+
+func (s *revokingAuthStub) Login(_ context.Context, req *auth.LoginReq, _ ...grpc.CallOption) (*auth.LoginRsp, error) {
+	sessionID, ok := s.sessions[req.GetJwt()]
+	if !ok || s.revoked[sessionID] {
+		return &auth.LoginRsp{Result: auth.AuthResult_JWT_ERROR}, nil
+	}
+	return &auth.LoginRsp{Result: auth.AuthResult_OK, UserId: 42, Jwt: req.GetJwt(), SessionId: sessionID}, nil
+}
+
+func (s *revokingAuthStub) CheckJwt(_ context.Context, req *auth.CheckJwtReq, _ ...grpc.CallOption) (*auth.CheckJwtRsp, error) {
+	if sessionID, ok := s.sessions[req.GetJwt()]; !ok || s.revoked[sessionID] {
+		return &auth.CheckJwtRsp{Result: auth.AuthResult_JWT_ERROR}, nil
+	}
+	return &auth.CheckJwtRsp{Result: auth.AuthResult_OK, UserId: req.GetUserId()}, nil
+}
+
+func (s *revokingAuthStub) RevokeJwtSession(_ context.Context, req *auth.RevokeJwtSessionReq, _ ...grpc.CallOption) (*auth.RevokeJwtSessionRsp, error) {
+	s.revoked[req.GetSessionId()] = true
+	return &auth.RevokeJwtSessionRsp{Result: auth.AuthResult_OK}, nil
+}
+
+func TestRevokedDeviceJWTCannotLogInWithNewDeviceID(t *testing.T) {
+	server := useHandlerTestRedis(t)
+	claimDeviceSession(t, "42", "desktop", "macos", "df-a")
+	claimDeviceSession(t, "42", "phone", "ios", "df-b")
+	withAuthClient(t, &revokingAuthStub{
+		sessions: map[string]string{"desktop-jwt": "session-desktop", "phone-jwt": "session-phone"},
+		revoked:  map[string]bool{},
+	})
+
+	request := &pb.RequestMessage{Jwt: "desktop-jwt", Payload: &pb.RequestMessage_RevokeDeviceSession{
+		RevokeDeviceSession: &pb.RevokeDeviceSession{DeviceId: "phone"},
+	}}
+	if result, err := deviceRequestMessageHandler(42, "desktop", request); err != nil || result.response.GetDeviceSessionsRsp().GetResult() != pb.DeviceSessionResult_DEVICE_SESSION_OK {
+		t.Fatalf("revoke failed: result=%+v err=%v", result.response, err)
+	}
+
+	handler := testWebSocketHandler(testWebSocketConfig())
+	for _, deviceID := range []string{"phone-reinstalled", ""} {
+		conn := &connection.Connection{ID: "relogin-" + deviceID, SendChan: make(chan []byte, 1)}
+		handler.handleLogin(conn, &pb.RequestMessage{Jwt: "phone-jwt", Payload: &pb.RequestMessage_Login{
+			Login: &pb.LoginReq{Account: "alice", DeviceId: deviceID, Platform: "ios"},
+		}})
+		response := &pb.ResponseMessage{}
+		if err := proto.Unmarshal(<-conn.SendChan, response); err != nil {
+			t.Fatal(err)
+		}
+		if response.GetLogin().GetResult() != pb.LoginResult_JWT_ERROR || conn.IsAuthenticated() {
+			t.Fatalf("revoked JWT logged in as device %q: %+v", deviceID, response)
+		}
+	}
+	if keys := server.Keys(); containsKeyWithPrefix(keys, "user_session:42:phone") || containsKeyWithPrefix(keys, "user_session:42:default") {
+		t.Fatalf("revoked JWT created a device session: %v", keys)
+	}
+}
+
+func containsKeyWithPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRevokeDeviceSessionRejectsCurrentAndUnknownDevices(t *testing.T) {
+	useHandlerTestRedis(t)
+	claimDeviceSession(t, "42", "desktop", "macos", "df-a")
+	var revokeRequest *auth.RevokeSessionsReq
+	var revokeJwtRequest *auth.RevokeJwtSessionReq
+	withAuthClient(t, authClientStub{
+		checkJwtResponse:        &auth.CheckJwtRsp{Result: auth.AuthResult_OK},
+		revokeSessionsRequest:   &revokeRequest,
+		revokeJwtSessionRequest: &revokeJwtRequest,
+	})
+
+	tests := []struct {
+		deviceID string
+		want     pb.DeviceSessionResult
+	}{
+		{deviceID: "desktop", want: pb.DeviceSessionResult_DEVICE_SESSION_CURRENT_DEVICE},
+		{deviceID: "lost-phone", want: pb.DeviceSessionResult_DEVICE_SESSION_NOT_FOUND},
+		{deviceID: "bad:device", want: pb.DeviceSessionResult_DEVICE_SESSION_INVALID_DEVICE_ID},
+	}
+	for _, test := range tests {
+		request := &pb.RequestMessage{Jwt: "jwt", Payload: &pb.RequestMessage_RevokeDeviceSession{
+			RevokeDeviceSession: &pb.RevokeDeviceSession{DeviceId: test.deviceID},
+		}}
+		result, err := deviceRequestMessageHandler(42, "desktop", request)
+		if err != nil || result.response.GetDeviceSessionsRsp().GetResult() != test.want {
+			t.Fatalf("device %q: result=%+v err=%v", test.deviceID, result.response, err)
+		}
+	}
+	if revokeRequest != nil || revokeJwtRequest != nil {
+		t.Fatalf("rejected device revoke reached Auth: revoke_sessions=%+v revoke_jwt_session=%+v", revokeRequest, revokeJwtRequest)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/shared/logger"
+	"context"
+	"data_forwarding_service/internal/connection"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// Drain 在实例下线前排空连接：拒绝新的升级请求，向所有连接发送 ServerDraining，
+// 等待 retry_after 让客户端自行重连到其他实例，再分批关闭剩余连接并释放会话路由租约，最后关闭 HTTP 服务。
+// 超过 WS_DRAIN_TIMEOUT 或 ctx 结束时不再等待，剩余连接立即关闭。
+func (h *WebSocketHandler) Drain(ctx context.Context) {
+	if !h.draining.CompareAndSwap(false, true) {
+		return
+	}
+	sugar := logger.Sugar()
+	ctx, cancel := context.WithTimeout(ctx, h.config.drainTimeout)
+	defer cancel()
+
+	connections := h.connManager.Connections()
+	sugar.Infow("开始排空WebSocket连接", "connections", len(connections), "retry_after", h.config.drainRetryAfter)
+	notice, err := proto.Marshal(&pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_ServerDraining{ServerDraining: &pb.ServerDraining{
+			RetryAfterMs: h.config.drainRetryAfter.Milliseconds(),
+		}},
+	})
+	if err != nil {
+		sugar.Errorf("序列化下线通知失败: %v", err)
+	}
+	for _, conn := range connections {
+		if notice == nil {
+			break
+		}
+		if err := conn.EnqueueUnsequenced(notice); err != nil {
+			sugar.Debugf("发送下线通知失败: connection_id=%s error=%v", conn.ID, err)
+		}
+	}
+	waitDrainInterval(ctx, h.config.drainRetryAfter)
+
+	batchSize := max(h.config.drainBatchSize, 1)
+	closed := 0
+	for start := 0; start < len(connections); start += batchSize {
+		if start > 0 {
+			waitDrainInterval(ctx, h.config.drainBatchInterval)
+		}
+		for _, conn := range connections[start:min(start+batchSize, len(connections))] {
+			if h.closeDrainedConnection(conn) {
+				closed++
+			}
+		}
+	}
+	sugar.Infow("WebSocket连接排空完成", "notified", len(connections), "closed_by_server", closed)
+
+	if server := h.server.Load(); server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			sugar.Warnf("关闭WebSocket服务器失败: %v", err)
+		}
+	}
+}
+
+// closeDrainedConnection 以 1012 (Service Restart) 关闭仍未断开的连接，
+// RemoveConnection 会通过 RemoveOwnedSessionAndRoute 释放该设备的会话与路由租约。
+func (h *WebSocketHandler) closeDrainedConnection(conn *connection.Connection) bool {
+	if current, exists := h.connManager.GetConnectionByID(conn.ID); !exists || current != conn {
+		return false
+	}
+	if conn.Conn != nil {
+		_ = conn.Conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server draining"),
+			time.Now().Add(h.config.writeTimeout),
+		)
+	}
+	h.connManager.RemoveConnection(conn.ID)
+	return true
+}
+
+func waitDrainInterval(ctx context.Context, interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
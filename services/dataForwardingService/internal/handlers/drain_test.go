@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDrainNotifiesThenClosesConnectionsInBatches(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = time.Second
+	config.drainTimeout = time.Second
+	config.drainRetryAfter = 30 * time.Millisecond
+	config.drainBatchSize = 1
+	config.drainBatchInterval = 40 * time.Millisecond
+	handler := testWebSocketHandler(config)
+	url, closeServer := startWebSocketTestServer(t, handler)
+	defer closeServer()
+
+	clients := make([]*websocket.Conn, 2)
+	for i := range clients {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		waitForConnection(t, handler, client)
+		clients[i] = client
+	}
+
+	started := time.Now()
+	drained := make(chan struct{})
+	go func() {
+		handler.Drain(context.Background())
+		close(drained)
+	}()
+
+	closedAt := make([]time.Duration, len(clients))
+	errs := make(chan error, len(clients))
+	for i, client := range clients {
+		go func() {
+			errs <- readDrainSequence(client)
+			closedAt[i] = time.Since(started)
+			errs <- nil
+		}()
+	}
+	for range 2 * len(clients) {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("drain did not finish")
+	}
+	waitForConnectionCount(t, handler, 0)
+
+	// 两个连接分属两批，关闭时间应相差至少一个批次间隔
+	first, second := min(closedAt[0], closedAt[1]), max(closedAt[0], closedAt[1])
+	if first < config.drainRetryAfter || second-first < config.drainBatchInterval/2 {
+		t.Fatalf("connections were not closed in staggered batches: %v", closedAt)
+	}
+
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusServiceUnavailable || response.Header.Get("Retry-After") != "1" {
+		t.Fatalf("draining handler accepted a new upgrade: %v %+v", err, response)
+	}
+}
+
+// readDrainSequence 期望先收到 server_draining，再收到 1012 关闭帧。
+func readDrainSequence(client *websocket.Conn) error {
+	_, frame, err := client.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("did not receive drain notice: %w", err)
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(frame, response); err != nil {
+		return err
+	}
+	if response.GetServerDraining().GetRetryAfterMs() != 30 || response.GetSeq() != 0 {
+		return fmt.Errorf("unexpected drain notice: %+v", response)
+	}
+	_, _, err = client.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != websocket.CloseServiceRestart {
+		return fmt.Errorf("closed with unexpected error: %v", err)
+	}
+	return nil
+}
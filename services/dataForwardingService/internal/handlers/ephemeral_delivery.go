@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/proto/envelope"
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/mq"
+	"data_forwarding_service/internal/publisher"
+	redisClient "data_forwarding_service/internal/redis"
+	routerpkg "data_forwarding_service/internal/router"
+	"errors"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// deliverEphemeralResponse 把会话信号、在线状态等临时事件按设备所在容器拆分投递给在线用户。
+// 临时事件不分配会话序列号，投递是尽力而为的：单个接收方失败只记录日志，不影响其他接收方。
+func deliverEphemeralResponse(targetIDs []int64, response *pb.ResponseMessage) error {
+	if len(targetIDs) == 0 {
+		return nil
+	}
+	userIDs := make([]string, 0, len(targetIDs))
+	userIDValues := make(map[string]int64, len(targetIDs))
+	for _, targetID := range targetIDs {
+		userID := strconv.FormatInt(targetID, 10)
+		userIDs = append(userIDs, userID)
+		userIDValues[userID] = targetID
+	}
+	routes, err := redisClient.GetContainersByConnections(userIDs)
+	if err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+
+	responseBytes, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+	wsHandler := GetWebSocketHandler()
+	if wsHandler == nil {
+		return errors.New("WebSocket处理器未初始化")
+	}
+	currentTopic := currentContainerTopic()
+	crossContainerTargets := make(map[string][]int64)
+	for _, userID := range userIDs {
+		for _, topic := range routes[userID] {
+			if topic != currentTopic {
+				crossContainerTargets[topic] = append(crossContainerTargets[topic], userIDValues[userID])
+				continue
+			}
+			if err := wsHandler.DeliverEphemeralLocally(userID, responseBytes); err != nil && !errors.Is(err, routerpkg.ErrUserOffline) {
+				logger.Sugar().Debugf("本地投递临时事件失败: user_id=%s err=%v", userID, err)
+			}
+		}
+	}
+
+	for topic, topicTargets := range crossContainerTargets {
+		publishEphemeralDelivery(topic, topicTargets, responseBytes)
+	}
+	return nil
+}
+
+func publishEphemeralDelivery(topic string, targetUserIDs []int64, responseBytes []byte) {
+	envelopeBytes, err := mq.MarshalEnvelope(envelope.MessageType_DF_RESPONSE, &pb.DFInternalDelivery{
+		Payload: &pb.DFInternalDelivery_ClientResponseDelivery{ClientResponseDelivery: &pb.ClientResponseDelivery{
+			TargetUserIds:   targetUserIDs,
+			ResponseMessage: responseBytes,
+			Ephemeral:       true,
+		}},
+	})
+	if err != nil {
+		logger.Sugar().Errorf("序列化临时事件投递失败: %v", err)
+		return
+	}
+	if err := publisher.PublishMessage(string(envelopeBytes), topic); err != nil {
+		logger.Sugar().Warnf("跨容器发布临时事件失败: topic=%s targets=%d err=%v", topic, len(targetUserIDs), err)
+	}
+}
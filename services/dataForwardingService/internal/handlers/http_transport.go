@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"Betterfly2/shared/logger"
+	"context"
+	"crypto/rand"
+	"data_forwarding_service/internal/connection"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// HTTP 回退传输供无法建立 WebSocket 的网络使用：POST 发送请求，GET 以 SSE 或长轮询接收响应。
+// 每个 HTTP 会话对应 ConnectionManager 中的一个 TransportHTTP 连接，登录、路由、租约与踢出流程不变。
+const (
+	httpSessionHeader = "X-Betterfly-Session"
+	httpSessionQuery  = "session"
+	// httpMaxFramesPerPoll 限制单次长轮询响应的帧数
+	httpMaxFramesPerPoll = pendingBatchSize
+)
+
+type httpSession struct {
+	token string
+	conn  *connection.Connection
+	// requestMu 保证同一会话的请求按到达顺序逐个处理，与 WebSocket 读协程一致
+	requestMu  sync.Mutex
+	receiving  atomic.Bool
+	lastActive atomic.Int64
+	createdAt  time.Time
+}
+
+func (s *httpSession) touch() { s.lastActive.Store(time.Now().UnixNano()) }
+
+func (h *WebSocketHandler) registerHTTPTransport(mux *http.ServeMux) {
+	mux.HandleFunc("/http/session", h.handleHTTPSession)
+	mux.HandleFunc("/http/send", h.handleHTTPSend)
+	mux.HandleFunc("/http/events", h.handleHTTPEvents)
+}
+
+// handleHTTPSession 创建 HTTP 会话，protocol=betterfly.json 时使用 protojson 帧，否则使用二进制 protobuf 帧。
+func (h *WebSocketHandler) handleHTTPSession(w http.ResponseWriter, r *http.Request) {
+	if !h.allowHTTPRequest(w, r, http.MethodPost) {
+		return
+	}
+	if h.draining.Load() {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(h.config.drainRetryAfter/time.Second))))
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+	subprotocol := r.URL.Query().Get("protocol")
+	if subprotocol != "" && subprotocol != jsonSubprotocol {
+		http.Error(w, "unsupported protocol", http.StatusBadRequest)
+		return
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "create session failed", http.StatusInternalServerError)
+		return
+	}
+	connectionID, err := randomHex(8)
+	if err != nil {
+		http.Error(w, "create session failed", http.StatusInternalServerError)
+		return
+	}
+	session := &httpSession{
+		token:     token,
+		conn:      h.connManager.AddHTTPConnection("http-"+connectionID, subprotocol),
+		createdAt: time.Now(),
+	}
+	session.touch()
+	h.httpSessions.Store(token, session)
+	go h.superviseHTTPSession(session)
+	logger.Sugar().Debugf("已与 %v 建立HTTP会话: connection_id=%s", r.RemoteAddr, session.conn.ID)
+
+	w.Header().Set(httpSessionHeader, token)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]string{"session": token})
+}
+
+// handleHTTPSend 处理一条 RequestMessage，响应通过 /http/events 下发。
+func (h *WebSocketHandler) handleHTTPSend(w http.ResponseWriter, r *http.Request) {
+	if !h.allowHTTPRequest(w, r, http.MethodPost) {
+		return
+	}
+	session, ok := h.lookupHTTPSession(w, r)
+	if !ok {
+		return
+	}
+	session.touch()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.maxMessageBytes))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	requestMsg, err := decodeRequestFrame(session.conn, body)
+	if err != nil {
+		logger.Sugar().Warnf("收到非标准化数据: %v", err)
+		http.Error(w, "invalid request message", http.StatusBadRequest)
+		return
+	}
+
+	session.requestMu.Lock()
+	if session.conn.IsClosed() {
+		session.requestMu.Unlock()
+		http.Error(w, "session closed", http.StatusNotFound)
+		return
+	}
+	h.handleClientMessage(session.conn, requestMsg)
+	session.requestMu.Unlock()
+	logger.Sugar().Debugf("收到HTTP消息: %d bytes", len(body))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleHTTPEvents 下发响应帧。Accept 包含 text/event-stream 时以 SSE 持续推送，否则按长轮询返回一批帧。
+// 同一会话同时只允许一个接收请求，避免帧被拆分到不同请求中乱序到达。
+func (h *WebSocketHandler) handleHTTPEvents(w http.ResponseWriter, r *http.Request) {
+	if !h.allowHTTPRequest(w, r, http.MethodGet) {
+		return
+	}
+	session, ok := h.lookupHTTPSession(w, r)
+	if !ok {
+		return
+	}
+	if !session.receiving.CompareAndSwap(false, true) {
+		http.Error(w, "another receive request is active", http.StatusConflict)
+		return
+	}
+	defer func() {
+		session.touch()
+		session.receiving.Store(false)
+	}()
+	session.touch()
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamHTTPEvents(w, r, session.conn)
+	} else {
+		h.pollHTTPEvents(w, r, session.conn)
+	}
+}
+
+func (h *WebSocketHandler) streamHTTPEvents(w http.ResponseWriter, r *http.Request, conn *connection.Connection) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	// 定期发送注释行，防止代理因长时间无数据断开连接
+	ticker := time.NewTicker(h.config.pingInterval)
+	defer ticker.Stop()
+	for {
+		frames, open := h.nextHTTPFrames(r.Context(), conn, ticker.C)
+		if len(frames) == 0 && r.Context().Err() != nil {
+			return
+		}
+		var event strings.Builder
+		if len(frames) == 0 && open {
+			event.WriteString(": ping\n\n")
+		}
+		for _, frame := range frames {
+			data, err := encodeHTTPEventData(conn, frame)
+			if err != nil {
+				logger.Sugar().Errorw("编码待发送帧失败，跳过该帧", "connection_id", conn.ID, "error", err)
+				continue
+			}
+			event.WriteString("data: ")
+			event.WriteString(data)
+			event.WriteString("\n\n")
+		}
+		if !h.writeHTTPFrames(controller, w, conn, []byte(event.String())) || !open {
+			return
+		}
+	}
+}
+
+func (h *WebSocketHandler) pollHTTPEvents(w http.ResponseWriter, r *http.Request, conn *connection.Connection) {
+	timer := time.NewTimer(h.config.httpPollTimeout)
+	defer timer.Stop()
+	frames, open := h.nextHTTPFrames(r.Context(), conn, timer.C)
+	if len(frames) == 0 && r.Context().Err() != nil {
+		return
+	}
+	if len(frames) == 0 {
+		if open {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			http.Error(w, "session closed", http.StatusGone)
+		}
+		return
+	}
+	body, contentType, err := encodeHTTPPollBody(conn, frames)
+	if err != nil {
+		logger.Sugar().Errorw("编码长轮询响应失败，关闭连接", "connection_id", conn.ID, "error", err)
+		conn.Close()
+		http.Error(w, "encode response failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	h.writeHTTPFrames(http.NewResponseController(w), w, conn, body)
+}
+
+// writeHTTPFrames 写出已经从发送队列取出的帧；写入失败时这些帧已经丢失，与 WebSocket 写失败一样关闭连接，
+// 客户端重新登录后通过断线续传补发。
+func (h *WebSocketHandler) writeHTTPFrames(controller *http.ResponseController, w http.ResponseWriter, conn *connection.Connection, data []byte) bool {
+	_ = controller.SetWriteDeadline(time.Now().Add(h.config.writeTimeout))
+	if _, err := w.Write(data); err != nil {
+		logger.Sugar().Errorln("发送消息错误: ", err)
+		conn.Close()
+		return false
+	}
+	if err := controller.Flush(); err != nil {
+		logger.Sugar().Errorln("发送消息错误: ", err)
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// nextHTTPFrames 等待发送队列中的帧，取出时与 writePending 相同，先取发送通道中已有的帧，再取合并帧和暂存帧。
+// wait 触发或请求结束时返回空结果；open 为 false 表示连接已经关闭，之后不会再有新帧。
+// 暂存帧还在 Redis 中时 Pending 可能先触发一次空的 TakePending，此时继续等待 spill 协程取回。
+func (h *WebSocketHandler) nextHTTPFrames(ctx context.Context, conn *connection.Connection, wait <-chan time.Time) ([][]byte, bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, true
+		case <-wait:
+			return nil, true
+		case msg, ok := <-conn.SendChan:
+			if !ok {
+				return nil, false
+			}
+			return drainHTTPFrames(conn, [][]byte{msg})
+		case <-conn.Pending():
+			frames, open := drainHTTPFrames(conn, nil)
+			if open && len(frames) < httpMaxFramesPerPoll {
+				frames = append(frames, conn.TakePending(httpMaxFramesPerPoll-len(frames))...)
+			}
+			if len(frames) == 0 && open {
+				continue
+			}
+			return frames, open
+		case <-conn.Exhausted():
+			// 与 closeExhaustedConnection 相同，只发送 SyncRequired，积压的帧不再发送
+			frame := conn.SyncRequiredFrame()
+			conn.Close()
+			if frame == nil {
+				return nil, false
+			}
+			return [][]byte{frame}, false
+		}
+	}
+}
+
+func drainHTTPFrames(conn *connection.Connection, frames [][]byte) ([][]byte, bool) {
+	for len(frames) < httpMaxFramesPerPoll {
+		select {
+		case msg, ok := <-conn.SendChan:
+			if !ok {
+				return frames, false
+			}
+			frames = append(frames, msg)
+		default:
+			return frames, true
+		}
+	}
+	return frames, true
+}
+
+// encodeHTTPEventData 返回 SSE data 行的内容：JSON 会话为单行 protojson，二进制会话为 base64 编码的 protobuf。
+func encodeHTTPEventData(conn *connection.Connection, frame []byte) (string, error) {
+	_, data, err := encodeResponseFrame(conn, frame)
+	if err != nil {
+		return "", err
+	}
+	if usesJSONFrames(conn) {
+		return string(data), nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// encodeHTTPPollBody 返回长轮询响应体：JSON 会话为 ResponseMessage 数组，二进制会话为按 varint 长度前缀分隔的帧。
+func encodeHTTPPollBody(conn *connection.Connection, frames [][]byte) ([]byte, string, error) {
+	if usesJSONFrames(conn) {
+		messages := make([]json.RawMessage, 0, len(frames))
+		for _, frame := range frames {
+			_, data, err := encodeResponseFrame(conn, frame)
+			if err != nil {
+				return nil, "", err
+			}
+			messages = append(messages, data)
+		}
+		body, err := json.Marshal(messages)
+		return body, "application/json", err
+	}
+	var body []byte
+	for _, frame := range frames {
+		body = protowire.AppendBytes(body, frame)
+	}
+	return body, "application/x-protobuf; delimited=true", nil
+}
+
+// superviseHTTPSession 代替 WebSocket 的读超时与心跳：未在 authTimeout 内登录，
+// 或超过 httpIdleTimeout 没有任何请求时关闭连接，连接关闭后释放会话。
+func (h *WebSocketHandler) superviseHTTPSession(session *httpSession) {
+	conn := session.conn
+	defer func() {
+		h.httpSessions.Delete(session.token)
+		h.connManager.RemoveConnection(conn.ID)
+		logger.Sugar().Debugf("HTTP会话已关闭: connection_id=%s", conn.ID)
+	}()
+	ticker := time.NewTicker(max(min(h.config.authTimeout, h.config.httpIdleTimeout)/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.Done():
+			return
+		case <-h.lifecycleDone():
+			conn.Close()
+			return
+		case now := <-ticker.C:
+			if !conn.IsAuthenticated() && now.Sub(session.createdAt) > h.config.authTimeout {
+				logger.Sugar().Infow("HTTP会话未在限定时间内登录，关闭连接", "connection_id", conn.ID)
+				return
+			}
+			if !session.receiving.Load() && now.Sub(time.Unix(0, session.lastActive.Load())) > h.config.httpIdleTimeout {
+				logger.Sugar().Debugw("HTTP会话空闲超时，关闭连接", "connection_id", conn.ID)
+				return
+			}
+		}
+	}
+}
+
+func (h *WebSocketHandler) lifecycleDone() <-chan struct{} {
+	if h.lifecycleCtx == nil {
+		return nil
+	}
+	return h.lifecycleCtx.Done()
+}
+
+func (h *WebSocketHandler) lookupHTTPSession(w http.ResponseWriter, r *http.Request) (*httpSession, bool) {
+	token := r.Header.Get(httpSessionHeader)
+	if token == "" {
+		// 浏览器 EventSource 不能设置请求头，允许通过查询参数传递
+		token = r.URL.Query().Get(httpSessionQuery)
+	}
+	if value, ok := h.httpSessions.Load(token); ok && token != "" {
+		session := value.(*httpSession)
+		if !session.conn.IsClosed() {
+			return session, true
+		}
+	}
+	http.Error(w, "unknown session", http.StatusNotFound)
+	return nil, false
+}
+
+// allowHTTPRequest 按 WebSocket 的 Origin 白名单校验跨域请求并处理预检请求。
+func (h *WebSocketHandler) allowHTTPRequest(w http.ResponseWriter, r *http.Request, method string) bool {
+	if !h.config.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		// 负载均衡依靠 Cookie 把同一会话的请求粘滞到同一实例
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", httpSessionHeader)
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", method)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+httpSessionHeader)
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func randomHex(size int) (string, error) {
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return "", fmt.Errorf("生成随机标识失败: %w", err)
+	}
+	return hex.EncodeToString(value), nil
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func startHTTPTransportTestServer(t *testing.T, handler *WebSocketHandler) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	handler.registerHTTPTransport(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func createHTTPTestSession(t *testing.T, baseURL, protocol string) string {
+	t.Helper()
+	url := baseURL + "/http/session"
+	if protocol != "" {
+		url += "?protocol=" + protocol
+	}
+	response, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var body struct{ Session string }
+	if response.StatusCode != http.StatusCreated || json.NewDecoder(response.Body).Decode(&body) != nil || body.Session == "" {
+		t.Fatalf("create session failed: %d", response.StatusCode)
+	}
+	return body.Session
+}
+
+func postHTTPTestRequest(t *testing.T, baseURL, session string, body []byte) int {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, baseURL+"/http/send", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(httpSessionHeader, session)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestHTTPLongPollDeliversProtojsonResponses(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = time.Second
+	config.httpPollTimeout = time.Second
+	config.httpIdleTimeout = time.Second
+	handler := testWebSocketHandler(config)
+	baseURL := startHTTPTransportTestServer(t, handler)
+	session := createHTTPTestSession(t, baseURL, jsonSubprotocol)
+	if handler.connManager.GetConnectionCount() != 1 {
+		t.Fatal("HTTP session should be counted as a connection")
+	}
+
+	hello := []byte(`{"requestId":"req-1","hello":{"protocolVersion":1,"capabilities":["receipts"]}}`)
+	if status := postHTTPTestRequest(t, baseURL, session, hello); status != http.StatusAccepted {
+		t.Fatalf("unexpected send status: %d", status)
+	}
+	response, err := http.Get(baseURL + "/http/events?session=" + session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var frames []json.RawMessage
+	if response.StatusCode != http.StatusOK || json.NewDecoder(response.Body).Decode(&frames) != nil || len(frames) != 1 {
+		t.Fatalf("unexpected poll response: %d", response.StatusCode)
+	}
+	message := &pb.ResponseMessage{}
+	if err := protojson.Unmarshal(frames[0], message); err != nil || message.GetRequestId() != "req-1" || message.GetHello() == nil {
+		t.Fatalf("unexpected frame: %s", frames[0])
+	}
+
+	if status := postHTTPTestRequest(t, baseURL, "unknown", hello); status != http.StatusNotFound {
+		t.Fatalf("unknown session should be rejected: %d", status)
+	}
+}
+
+func TestHTTPEventStreamDeliversBase64ProtobufFrames(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = time.Second
+	config.httpPollTimeout = time.Second
+	config.httpIdleTimeout = time.Second
+	handler := testWebSocketHandler(config)
+	baseURL := startHTTPTransportTestServer(t, handler)
+	session := createHTTPTestSession(t, baseURL, "")
+
+	request, err := http.NewRequest(http.MethodGet, baseURL+"/http/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(httpSessionHeader, session)
+	request.Header.Set("Accept", "text/event-stream")
+	stream, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if !strings.HasPrefix(stream.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("unexpected content type: %s", stream.Header.Get("Content-Type"))
+	}
+
+	second, err := http.DefaultClient.Do(request.Clone(request.Context()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, second.Body)
+	second.Body.Close()
+	if second.StatusCode != http.StatusConflict {
+		t.Fatalf("concurrent receive should be rejected: %d", second.StatusCode)
+	}
+
+	hello, err := proto.Marshal(&pb.RequestMessage{RequestId: "req-2", Payload: &pb.RequestMessage_Hello{Hello: &pb.Hello{ProtocolVersion: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := postHTTPTestRequest(t, baseURL, session, hello); status != http.StatusAccepted {
+		t.Fatalf("unexpected send status: %d", status)
+	}
+	reader := bufio.NewReader(stream.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		frame, err := base64.StdEncoding.DecodeString(data)
+		message := &pb.ResponseMessage{}
+		if err != nil || proto.Unmarshal(frame, message) != nil || message.GetRequestId() != "req-2" || message.GetHello() == nil {
+			t.Fatalf("unexpected event data: %s", data)
+		}
+		return
+	}
+}
+
+func TestHTTPSessionClosesWithoutLogin(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = 50 * time.Millisecond
+	config.httpPollTimeout = time.Second
+	config.httpIdleTimeout = time.Second
+	handler := testWebSocketHandler(config)
+	baseURL := startHTTPTransportTestServer(t, handler)
+	session := createHTTPTestSession(t, baseURL, "")
+
+	waitForConnectionCount(t, handler, 0)
+	if status := postHTTPTestRequest(t, baseURL, session, nil); status != http.StatusNotFound {
+		t.Fatalf("expired session should be removed: %d", status)
+	}
+}
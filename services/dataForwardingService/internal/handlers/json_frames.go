@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"data_forwarding_service/internal/connection"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// jsonSubprotocol 是以 protojson 文本帧收发 RequestMessage/ResponseMessage 的 WebSocket 子协议，
+// 供浏览器调试和没有 protobuf 运行时的 Web 客户端使用。未协商子协议的连接继续使用二进制 protobuf 帧。
+const jsonSubprotocol = "betterfly.json"
+
+var (
+	jsonFrameMarshal = protojson.MarshalOptions{}
+	// 与二进制 protobuf 一致，忽略新版本客户端携带的未知字段
+	jsonFrameUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+func usesJSONFrames(conn *connection.Connection) bool {
+	return conn.Subprotocol == jsonSubprotocol
+}
+
+// decodeRequestFrame 按连接协商的子协议解析客户端发来的帧
+func decodeRequestFrame(conn *connection.Connection, data []byte) (*pb.RequestMessage, error) {
+	if !usesJSONFrames(conn) {
+		return HandleRequestData(data)
+	}
+	req := &pb.RequestMessage{}
+	if err := jsonFrameUnmarshal.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("解析JSON请求失败: %w", err)
+	}
+	return req, nil
+}
+
+// encodeResponseFrame 返回写给客户端的 WebSocket 帧类型与内容。发送队列、重放缓冲区与跨实例路由
+// 始终保存 protobuf 帧，JSON 连接只在写出时转换。
+func encodeResponseFrame(conn *connection.Connection, frame []byte) (int, []byte, error) {
+	if !usesJSONFrames(conn) {
+		return websocket.BinaryMessage, frame, nil
+	}
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(frame, response); err != nil {
+		return 0, nil, fmt.Errorf("解析待发送响应失败: %w", err)
+	}
+	text, err := jsonFrameMarshal.Marshal(response)
+	if err != nil {
+		return 0, nil, fmt.Errorf("转换JSON响应失败: %w", err)
+	}
+	return websocket.TextMessage, text, nil
+}
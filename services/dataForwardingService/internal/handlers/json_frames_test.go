@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestJSONSubprotocolExchangesProtojsonTextFrames(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = time.Second
+	handler := testWebSocketHandler(config)
+	url, closeServer := startWebSocketTestServer(t, handler)
+	defer closeServer()
+
+	dialer := websocket.Dialer{Subprotocols: []string{jsonSubprotocol}}
+	client, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.Subprotocol() != jsonSubprotocol {
+		t.Fatalf("subprotocol was not negotiated: %q", client.Subprotocol())
+	}
+
+	hello := `{"requestId":"req-1","hello":{"protocolVersion":1,"capabilities":["presence"],"futureField":true}}`
+	if err := client.WriteMessage(websocket.TextMessage, []byte(hello)); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, frame, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &pb.ResponseMessage{}
+	if messageType != websocket.TextMessage || protojson.Unmarshal(frame, response) != nil {
+		t.Fatalf("expected a protojson text frame, got type %d: %s", messageType, frame)
+	}
+	if response.GetRequestId() != "req-1" || len(response.GetHello().GetCapabilities()) != 1 {
+		t.Fatalf("unexpected hello response: %s", frame)
+	}
+}
+
+func TestBinaryClientsKeepProtobufFrames(t *testing.T) {
+	config := testWebSocketConfig()
+	config.authTimeout = time.Second
+	handler := testWebSocketHandler(config)
+	url, closeServer := startWebSocketTestServer(t, handler)
+	defer closeServer()
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	request, err := proto.Marshal(&pb.RequestMessage{Payload: &pb.RequestMessage_Hello{Hello: &pb.Hello{ProtocolVersion: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMessage(websocket.BinaryMessage, request); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, frame, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &pb.ResponseMessage{}
+	if messageType != websocket.BinaryMessage || proto.Unmarshal(frame, response) != nil || response.GetHello() == nil {
+		t.Fatalf("binary client received an unexpected frame: type %d", messageType)
+	}
+}
@@ -19,6 +19,8 @@ var getAuthClient = grpcClient.GetAuthClient
 type dfRequestContext struct {
 	fromID  int64
 	message *pb.RequestMessage
+	// deviceID 是发起请求的设备会话，非 WebSocket 来源的请求为空。
+	deviceID string
 }
 
 type dfRequestResult struct {
@@ -50,6 +52,9 @@ func newDFRequestRouter() *dispatch.OneofRouter[dfRequestContext, dfRequestResul
 	for _, register := range dfRequestModules {
 		register(router)
 	}
+	if envBoolValue("DF_RATE_LIMIT_ENABLED", true) {
+		router.Use(newRequestRateLimiter(loadRequestRateLimits()).middleware)
+	}
 	return router
 }
 
@@ -66,10 +71,17 @@ func HandleRequestData(data []byte) (*pb.RequestMessage, error) {
 }
 
 func RequestMessageHandler(fromID int64, message *pb.RequestMessage) (dfRequestResult, error) {
-	result, err := getDFRequestRouter().Dispatch(dfRequestContext{
-		fromID:  fromID,
-		message: message,
-	}, message.Payload)
+	return dispatchDFRequest(dfRequestContext{fromID: fromID, message: message})
+}
+
+// deviceRequestMessageHandler 处理来自已登录设备连接的请求，携带设备上下文。
+func deviceRequestMessageHandler(fromID int64, deviceID string, message *pb.RequestMessage) (dfRequestResult, error) {
+	return dispatchDFRequest(dfRequestContext{fromID: fromID, message: message, deviceID: deviceID})
+}
+
+func dispatchDFRequest(ctx dfRequestContext) (dfRequestResult, error) {
+	message := ctx.message
+	result, err := getDFRequestRouter().Dispatch(ctx, message.Payload)
 	if err != nil {
 		if errors.Is(err, dispatch.ErrNilPayload) || errors.Is(err, dispatch.ErrUnregisteredPayload) {
 			logger.Sugar().Warnf("收到不可处理Payload: %+v", message.Payload)
@@ -80,7 +92,7 @@ func RequestMessageHandler(fromID int64, message *pb.RequestMessage) (dfRequestR
 	return result, nil
 }
 
-func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64, error) {
+func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64, string, error) {
 	jwt := message.GetJwt()
 	errRsp := &pb.ResponseMessage{
 		Payload: &pb.ResponseMessage_Login{
@@ -91,7 +103,7 @@ func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64,
 	}
 	rpcClient, err := getAuthClient()
 	if err != nil {
-		return errRsp, -1, err
+		return errRsp, -1, "", err
 	}
 	clientLoginReq := message.GetLogin()
 	authLoginReq := &auth.LoginReq{}
@@ -108,10 +120,10 @@ func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64,
 	defer cancel()
 	authServiceRsp, err := rpcClient.Login(ctx, authLoginReq)
 	if err != nil {
-		return errRsp, -1, err
+		return errRsp, -1, "", err
 	}
 	if authServiceRsp == nil {
-		return errRsp, -1, errors.New("auth service returned an empty login response")
+		return errRsp, -1, "", errors.New("auth service returned an empty login response")
 	}
 	logger.Sugar().Debugf(
 		"authService登录响应: result=%s user_id=%d account=%q",
@@ -119,12 +131,14 @@ func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64,
 	)
 	loginRsp := &pb.LoginRsp{}
 	var userID int64 = -1
+	sessionID := ""
 	switch authServiceRsp.Result {
 	case auth.AuthResult_OK:
 		loginRsp.Result = pb.LoginResult_LOGIN_OK
 		loginRsp.Jwt = authServiceRsp.GetJwt()
 		loginRsp.UserId = authServiceRsp.GetUserId()
 		userID = authServiceRsp.GetUserId()
+		sessionID = authServiceRsp.GetSessionId()
 	case auth.AuthResult_ACCOUNT_NOT_EXIST:
 		loginRsp.Result = pb.LoginResult_ACCOUNT_NOT_EXIST
 	case auth.AuthResult_PASSWORD_ERROR:
@@ -138,7 +152,7 @@ func HandleLoginMessage(message *pb.RequestMessage) (*pb.ResponseMessage, int64,
 		Payload: &pb.ResponseMessage_Login{
 			Login: loginRsp,
 		},
-	}, userID, nil
+	}, userID, sessionID, nil
 }
 
 func HandleSignupMessage(message *pb.RequestMessage) (*pb.ResponseMessage, error) {
@@ -5,7 +5,10 @@ import (
 	envelope "Betterfly2/proto/envelope"
 	friend "Betterfly2/proto/friend"
 	storage "Betterfly2/proto/storage"
+	sharedDB "Betterfly2/shared/db"
+	"strings"
 	"testing"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -35,6 +38,72 @@ func TestBuildSyncMessagesStorageRequestRoutesResponseToRequester(t *testing.T)
 	if queryPayload.QuerySyncMessages.GetTimestamp() != "2026-03-27T08:00:00Z" {
 		t.Fatalf("unexpected timestamp: %q", queryPayload.QuerySyncMessages.GetTimestamp())
 	}
+
+	seqReq := buildSyncMessagesStorageRequest(1001, &pb.QuerySyncMessages{UseInboxSeq: true, AfterSeq: 42, AfterReactionSeq: 7}, "df-pod-1")
+	if query := seqReq.GetQuerySyncMessages(); !query.GetUseInboxSeq() || query.GetAfterSeq() != 42 || query.GetAfterReactionSeq() != 7 || query.GetToUserId() != 1001 {
+		t.Fatalf("unexpected inbox seq sync query: %+v", query)
+	}
+}
+
+func TestBuildConversationsStorageRequestTargetsRequester(t *testing.T) {
+	storeReq := buildConversationsStorageRequest(1001, &pb.QueryConversations{
+		PageSize:        20,
+		CursorTimestamp: "2026-07-21T03:00:00Z",
+		CursorMessageId: 77,
+	}, "df-pod-1")
+
+	query := storeReq.GetQueryConversations()
+	if storeReq.GetFromKafkaTopic() != "df-pod-1" || storeReq.GetTargetUserId() != 1001 || query == nil {
+		t.Fatalf("unexpected conversations request: %+v", storeReq)
+	}
+	if query.GetPageSize() != 20 || query.GetCursorTimestamp() != "2026-07-21T03:00:00Z" || query.GetCursorMessageId() != 77 {
+		t.Fatalf("unexpected conversations cursor: %+v", query)
+	}
+}
+
+func TestBuildConversationHistoryStorageRequestTargetsRequester(t *testing.T) {
+	storeReq := buildConversationHistoryStorageRequest(1001, &pb.QueryConversationHistory{
+		PeerOrGroupId:   7,
+		IsGroup:         true,
+		BeforeMessageId: 90,
+		Limit:           30,
+	}, "df-pod-1")
+
+	query := storeReq.GetQueryConversationHistory()
+	if storeReq.GetFromKafkaTopic() != "df-pod-1" || storeReq.GetTargetUserId() != 1001 || query == nil {
+		t.Fatalf("unexpected history request: %+v", storeReq)
+	}
+	if query.GetPeerOrGroupId() != 7 || !query.GetIsGroup() || query.GetBeforeMessageId() != 90 || query.GetLimit() != 30 {
+		t.Fatalf("unexpected history query: %+v", query)
+	}
+}
+
+func TestBuildMentionedMessagesStorageRequestTargetsRequester(t *testing.T) {
+	storeReq := buildMentionedMessagesStorageRequest(1001, &pb.QueryMentionedMessages{BeforeMessageId: 90, Limit: 30}, "df-pod-1")
+	query := storeReq.GetQueryMentionedMessages()
+	if storeReq.GetFromKafkaTopic() != "df-pod-1" || storeReq.GetTargetUserId() != 1001 || query == nil {
+		t.Fatalf("unexpected mentioned messages request: %+v", storeReq)
+	}
+	if query.GetBeforeMessageId() != 90 || query.GetLimit() != 30 {
+		t.Fatalf("unexpected mentioned messages query: %+v", query)
+	}
+}
+
+func TestValidatePostMentionsRejectsDirectChatsAndOversizedLists(t *testing.T) {
+	tests := map[string]*pb.Post{
+		"direct chat":       {ToId: 1002, MentionedUserIds: []int64{1002}},
+		"direct chat all":   {ToId: 1002, MentionAll: true},
+		"too many mentions": {ToId: 7, IsGroup: true, MentionedUserIds: make([]int64, sharedDB.MaxMessageMentions+1)},
+		"invalid user":      {ToId: 7, IsGroup: true, MentionedUserIds: []int64{0}},
+	}
+	for name, post := range tests {
+		if err := validatePostMentions(post); err == nil {
+			t.Errorf("%s: invalid mentions were accepted", name)
+		}
+	}
+	if err := validatePostMentions(&pb.Post{ToId: 7, IsGroup: true, MentionedUserIds: []int64{1002}, MentionAll: true}); err != nil {
+		t.Fatalf("group mentions were rejected: %v", err)
+	}
 }
 
 func TestBuildDirectMessageRecallPushUsesSenderConversation(t *testing.T) {
@@ -47,14 +116,17 @@ func TestBuildDirectMessageRecallPushUsesSenderConversation(t *testing.T) {
 
 func TestBuildStoreNewMessageStorageRequestRoutesAckToSender(t *testing.T) {
 	post := &pb.Post{
-		FromId:          1001,
-		ToId:            2002,
-		Msg:             "hello",
-		MsgType:         "text",
-		IsGroup:         false,
-		RealFileName:    "",
-		Timestamp:       "2026-07-12T09:00:00Z",
-		ClientMessageId: "client-42",
+		FromId:           1001,
+		ToId:             2002,
+		Msg:              "hello",
+		MsgType:          "text",
+		IsGroup:          false,
+		RealFileName:     "",
+		Timestamp:        "2026-07-12T09:00:00Z",
+		ClientMessageId:  "client-42",
+		ReplyToMessageId: 77,
+		MentionedUserIds: []int64{2003},
+		MentionAll:       true,
 	}
 
 	storeReq := buildStoreNewMessageStorageRequest(post, "df-pod-1")
@@ -76,6 +148,42 @@ func TestBuildStoreNewMessageStorageRequestRoutesAckToSender(t *testing.T) {
 	if storePayload.StoreNewMessage.GetClientMessageId() != "client-42" || storePayload.StoreNewMessage.GetClientTimestamp() != post.GetTimestamp() {
 		t.Fatalf("message correlation fields were not forwarded: %+v", storePayload.StoreNewMessage)
 	}
+	if storePayload.StoreNewMessage.GetReplyToMessageId() != 77 {
+		t.Fatalf("expected reply target 77, got %d", storePayload.StoreNewMessage.GetReplyToMessageId())
+	}
+	if len(storePayload.StoreNewMessage.GetMentionedUserIds()) != 1 || !storePayload.StoreNewMessage.GetMentionAll() {
+		t.Fatalf("mentions were not forwarded: %+v", storePayload.StoreNewMessage)
+	}
+}
+
+func TestBuildForwardMessagesStorageRequestUsesAuthenticatedIdentity(t *testing.T) {
+	payload := &pb.ForwardMessages{SourceMessageIds: []int64{61, 62}, TargetId: 7, IsGroup: true, Merged: true, ClientMessageId: " bundle-1 "}
+	if err := validateForwardMessagesPayload(payload); err != nil {
+		t.Fatal(err)
+	}
+	request := buildForwardMessagesStorageRequest(1001, payload, "df-pod-1")
+	forward := request.GetForwardMessages()
+	if request.GetTargetUserId() != 1001 || request.GetFromKafkaTopic() != "df-pod-1" {
+		t.Fatalf("unexpected forward routing: %+v", request)
+	}
+	if len(forward.GetSourceMessageIds()) != 2 || forward.GetTargetId() != 7 || !forward.GetIsGroup() || !forward.GetMerged() || forward.GetClientMessageId() != "bundle-1" {
+		t.Fatalf("forward fields were not copied: %+v", forward)
+	}
+}
+
+func TestValidateForwardMessagesPayloadRejectsInvalidRequests(t *testing.T) {
+	tooMany := make([]int64, sharedDB.MaxForwardSourceMessages+1)
+	tests := map[string]*pb.ForwardMessages{
+		"no sources":       {TargetId: 1002},
+		"too many sources": {SourceMessageIds: tooMany, TargetId: 1002},
+		"missing target":   {SourceMessageIds: []int64{61}},
+		"long client id":   {SourceMessageIds: []int64{61}, TargetId: 1002, ClientMessageId: strings.Repeat("x", 121)},
+	}
+	for name, payload := range tests {
+		if err := validateForwardMessagesPayload(payload); err == nil {
+			t.Errorf("%s: invalid forward request was accepted", name)
+		}
+	}
 }
 
 func TestBuildRecallMessageStorageRequestUsesAuthenticatedIdentity(t *testing.T) {
@@ -113,6 +221,86 @@ func TestBuildMessageRecallPushRequestPreservesConversationMetadata(t *testing.T
 	}
 }
 
+func TestBuildEditMessageStorageRequestUsesAuthenticatedIdentity(t *testing.T) {
+	request := buildEditMessageStorageRequest(1001, &pb.EditMessage{MessageId: 77, NewContent: "fixed"}, "df-pod-1")
+	if request.GetFromKafkaTopic() != "df-pod-1" || request.GetTargetUserId() != 1001 {
+		t.Fatalf("unexpected edit routing: %+v", request)
+	}
+	payload := request.GetEditMessage()
+	if payload == nil || payload.GetMessageId() != 77 || payload.GetNewContent() != "fixed" {
+		t.Fatalf("unexpected edit payload: %+v", payload)
+	}
+}
+
+func TestBuildPinMessageStorageRequestSelectsPinOrUnpin(t *testing.T) {
+	pin := buildPinMessageStorageRequest(1001, 77, false, "df-pod-1")
+	if pin.GetFromKafkaTopic() != "df-pod-1" || pin.GetTargetUserId() != 1001 || pin.GetPinMessage().GetMessageId() != 77 || pin.GetUnpinMessage() != nil {
+		t.Fatalf("unexpected pin request: %+v", pin)
+	}
+	unpin := buildPinMessageStorageRequest(1001, 77, true, "df-pod-1")
+	if unpin.GetUnpinMessage().GetMessageId() != 77 || unpin.GetPinMessage() != nil {
+		t.Fatalf("unexpected unpin request: %+v", unpin)
+	}
+}
+
+func TestBuildPinnedMessagesStorageRequestTargetsRequester(t *testing.T) {
+	storeReq := buildPinnedMessagesStorageRequest(1001, &pb.QueryPinnedMessages{PeerOrGroupId: 7, IsGroup: true}, "df-pod-1")
+	query := storeReq.GetQueryPinnedMessages()
+	if storeReq.GetFromKafkaTopic() != "df-pod-1" || storeReq.GetTargetUserId() != 1001 || query.GetPeerOrGroupId() != 7 || !query.GetIsGroup() {
+		t.Fatalf("unexpected pinned messages request: %+v", storeReq)
+	}
+}
+
+func TestBuildSearchMessagesStorageRequestTargetsRequester(t *testing.T) {
+	storeReq := buildSearchMessagesStorageRequest(1001, &pb.SearchMessages{
+		Query: "  开会 ", PeerOrGroupId: 7, IsGroup: true, Since: "2026-08-01T00:00:00Z", MsgType: "text", BeforeMessageId: 90, Limit: 20,
+	}, "df-pod-1")
+	search := storeReq.GetSearchMessages()
+	if storeReq.GetFromKafkaTopic() != "df-pod-1" || storeReq.GetTargetUserId() != 1001 || search.GetQuery() != "开会" ||
+		search.GetPeerOrGroupId() != 7 || !search.GetIsGroup() || search.GetSince() != "2026-08-01T00:00:00Z" ||
+		search.GetMsgType() != "text" || search.GetBeforeMessageId() != 90 || search.GetLimit() != 20 {
+		t.Fatalf("unexpected search request: %+v", storeReq)
+	}
+}
+
+func TestBuildMessageEditPushRequestReplacesPreview(t *testing.T) {
+	event := &pb.MessageEditEvent{
+		Result: pb.MessageEditResult_MESSAGE_EDIT_OK, MessageId: 77, FromUserId: 1001, ToUserId: 1002,
+		OperatorUserId: 1001, EditedAt: "2026-07-21T05:03:00Z", Content: "fixed", MessageType: "text",
+	}
+	request := buildMessageEditPushRequest([]int64{1002}, event).GetMessageEdit()
+	message := request.GetMessage()
+	if message == nil || message.GetMessageId() != 77 || message.GetConversationId() != 1001 || message.GetIsGroup() ||
+		message.GetSenderUserId() != 1001 || message.GetPreview() != "fixed" || request.GetEditedAt() != event.GetEditedAt() {
+		t.Fatalf("unexpected edit push request: %+v", request)
+	}
+}
+
+func TestBuildReactToMessageStorageRequestUsesAuthenticatedIdentity(t *testing.T) {
+	request := buildReactToMessageStorageRequest(1001, &pb.ReactToMessage{MessageId: 77, Emoji: "👍", Remove: true}, "df-pod-1")
+	if request.GetFromKafkaTopic() != "df-pod-1" || request.GetTargetUserId() != 1001 {
+		t.Fatalf("unexpected reaction routing: %+v", request)
+	}
+	payload := request.GetReactToMessage()
+	if payload == nil || payload.GetMessageId() != 77 || payload.GetEmoji() != "👍" || !payload.GetRemove() {
+		t.Fatalf("unexpected reaction payload: %+v", payload)
+	}
+}
+
+func TestReactionEventForOtherReadersClearsOperatorState(t *testing.T) {
+	event := &pb.ReactionEvent{
+		Result: pb.MessageReactionResult_MESSAGE_REACTION_OK, MessageId: 77, OperatorUserId: 1002, Emoji: "👍", Changed: true,
+		Reactions: []*pb.MessageReaction{{Emoji: "👍", Count: 2, ReactedByMe: true}},
+	}
+	broadcast := reactionEventForOtherReaders(event)
+	if broadcast.GetReactions()[0].GetReactedByMe() || broadcast.GetReactions()[0].GetCount() != 2 {
+		t.Fatalf("unexpected broadcast reactions: %+v", broadcast.GetReactions())
+	}
+	if !event.GetReactions()[0].GetReactedByMe() {
+		t.Fatal("operator event must keep reacted_by_me")
+	}
+}
+
 func TestBuildGroupPostBatchDeliveryEnvelope(t *testing.T) {
 	post := &pb.Post{
 		FromId:  1001,
@@ -422,3 +610,95 @@ func TestBuildGroupManagementRequestsUseAuthenticatedActor(t *testing.T) {
 		t.Fatalf("owner transfer request bridge mismatch: %+v", transfer)
 	}
 }
+
+func TestBuildMarkReceiptsStorageRequestUsesAuthenticatedReader(t *testing.T) {
+	request, err := buildMarkReceiptsStorageRequest(1002, true, nil, &pb.ReceiptWatermark{ConversationId: 7, IsGroup: true, UpToMessageId: 50}, "df-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark := request.GetMarkMessageReceipts()
+	if request.GetTargetUserId() != 1002 || request.GetFromKafkaTopic() != "df-a" || !mark.GetRead() ||
+		mark.GetConversationId() != 7 || !mark.GetIsGroup() || mark.GetUpToMessageId() != 50 || len(mark.GetMessageIds()) != 0 {
+		t.Fatalf("unexpected receipt storage request: %+v", request)
+	}
+	if _, err := buildMarkReceiptsStorageRequest(1002, false, nil, nil, "df-a"); err == nil {
+		t.Fatal("receipt without message IDs or watermark was accepted")
+	}
+	if _, err := buildMarkReceiptsStorageRequest(1002, false, make([]int64, maxReceiptMessageIDs+1), nil, "df-a"); err == nil {
+		t.Fatal("oversized receipt batch was accepted")
+	}
+}
+
+func TestBuildReceiptEventsGroupsBySenderConversation(t *testing.T) {
+	events := buildReceiptEvents(&storage.MessageReceiptsRsp{
+		ReaderUserId: 1002,
+		Read:         true,
+		ReceiptedAt:  "2026-07-21T04:00:00Z",
+		Updates: []*storage.MessageReceiptUpdate{
+			{MessageId: 12, FromUserId: 1001, ToUserId: 1002, DeliveredCount: 1, ReadCount: 1},
+			{MessageId: 11, FromUserId: 1001, ToUserId: 1002, DeliveredCount: 1, ReadCount: 1},
+			{MessageId: 30, FromUserId: 1001, ToUserId: 7, IsGroup: true, DeliveredCount: 4, ReadCount: 2},
+			{MessageId: 31, FromUserId: 1003, ToUserId: 7, IsGroup: true, DeliveredCount: 3, ReadCount: 1},
+		},
+	})
+	if len(events) != 3 {
+		t.Fatalf("expected one event per sender conversation, got %d", len(events))
+	}
+	direct := events[receiptEventKey{userID: 1001, conversationID: 1002}]
+	if direct.GetType() != pb.ReceiptType_RECEIPT_READ || direct.GetReaderUserId() != 1002 || len(direct.GetMessages()) != 2 ||
+		direct.GetMessages()[0].GetMessageId() != 11 || direct.GetReceiptedAt() != "2026-07-21T04:00:00Z" {
+		t.Fatalf("unexpected direct receipt event: %+v", direct)
+	}
+	group := events[receiptEventKey{userID: 1001, conversationID: 7, isGroup: true}]
+	if !group.GetIsGroup() || len(group.GetMessages()) != 1 || group.GetMessages()[0].GetReadCount() != 2 {
+		t.Fatalf("unexpected group receipt event: %+v", group)
+	}
+}
+
+func TestSignalThrottleDropsRepeatsButLetsStopThrough(t *testing.T) {
+	throttle := newSignalThrottle()
+	key := signalThrottleKey{senderID: 1001, conversationID: 7, isGroup: true}
+	now := time.Unix(1_700_000_000, 0)
+
+	if !throttle.allow(key, pb.ConversationSignalKind_TYPING_STARTED, now) {
+		t.Fatal("first signal must be forwarded")
+	}
+	if throttle.allow(key, pb.ConversationSignalKind_UPLOADING_FILE, now.Add(100*time.Millisecond)) {
+		t.Fatal("rapid kind switch must be dropped")
+	}
+	if throttle.allow(key, pb.ConversationSignalKind_TYPING_STARTED, now.Add(time.Second)) {
+		t.Fatal("repeated signal inside the interval must be dropped")
+	}
+	if !throttle.allow(key, pb.ConversationSignalKind_TYPING_STOPPED, now.Add(time.Second+200*time.Millisecond)) {
+		t.Fatal("typing stopped must clear the indicator immediately")
+	}
+	if !throttle.allow(signalThrottleKey{senderID: 1001, conversationID: 8}, pb.ConversationSignalKind_TYPING_STARTED, now) {
+		t.Fatal("throttle must be scoped to a single conversation")
+	}
+	if !throttle.allow(key, pb.ConversationSignalKind_TYPING_STOPPED, now.Add(signalRepeatInterval+2*time.Second)) {
+		t.Fatal("repeated signal after the interval must be forwarded")
+	}
+}
+
+func TestBuildConversationSignalEventUsesRecipientConversation(t *testing.T) {
+	now := time.Date(2026, 7, 21, 4, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	direct := buildConversationSignalEvent(1001, &pb.ConversationSignal{ConversationId: 1002, Kind: pb.ConversationSignalKind_RECORDING_AUDIO}, now)
+	if direct.GetFromUserId() != 1001 || direct.GetConversationId() != 1001 || direct.GetIsGroup() ||
+		direct.GetKind() != pb.ConversationSignalKind_RECORDING_AUDIO || direct.GetSentAt() != "2026-07-20T20:00:00Z" {
+		t.Fatalf("unexpected direct signal event: %+v", direct)
+	}
+	group := buildConversationSignalEvent(1001, &pb.ConversationSignal{ConversationId: 7, IsGroup: true}, now)
+	if group.GetConversationId() != 7 || !group.GetIsGroup() {
+		t.Fatalf("unexpected group signal event: %+v", group)
+	}
+
+	for _, signal := range []*pb.ConversationSignal{
+		{},
+		{ConversationId: 1001},
+		{ConversationId: 1002, Kind: pb.ConversationSignalKind(99)},
+	} {
+		if err := validateConversationSignal(1001, signal); err == nil {
+			t.Fatalf("invalid signal was accepted: %+v", signal)
+		}
+	}
+}
@@ -21,6 +21,7 @@ func registerCallRequestModule(router *dispatch.OneofRouter[dfRequestContext, df
 			FromKafkaTopic: currentContainerTopic(),
 			UserId:         ctx.fromID,
 			Request:        payload,
+			RequestId:      ctx.message.GetRequestId(),
 		}
 		if err := publishCallRequest(request); err != nil {
 			return dfRequestResult{}, err
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	auth "Betterfly2/proto/server_rpc/auth"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	"context"
+	"data_forwarding_service/internal/connection"
+	redisClient "data_forwarding_service/internal/redis"
+	"errors"
+	"strconv"
+	"time"
+)
+
+func init() { registerDFRequestModule(registerDeviceSessionModule) }
+
+func registerDeviceSessionModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_QueryDeviceSessions) (dfRequestResult, error) {
+		return deviceSessionsResult(queryDeviceSessions(ctx)), nil
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_RevokeDeviceSession) (dfRequestResult, error) {
+		return deviceSessionsResult(revokeDeviceSession(ctx, payload.RevokeDeviceSession)), nil
+	})
+}
+
+func deviceSessionsResult(response *pb.DeviceSessionsRsp) dfRequestResult {
+	return dfRequestResult{response: &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_DeviceSessionsRsp{DeviceSessionsRsp: response},
+	}}
+}
+
+func queryDeviceSessions(ctx dfRequestContext) *pb.DeviceSessionsRsp {
+	response := &pb.DeviceSessionsRsp{
+		Operation: "query_device_sessions",
+		Result:    pb.DeviceSessionResult_DEVICE_SESSION_SERVICE_ERROR,
+	}
+	if _, err := authenticatedPayload(ctx.fromID, ctx.message, "查询设备会话", "QueryDeviceSessions", (*pb.RequestMessage).GetQueryDeviceSessions); err != nil {
+		logger.Sugar().Warnw("查询设备会话鉴权失败", "user_id", ctx.fromID, "error", err)
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_JWT_ERROR
+		return response
+	}
+	sessions, err := listDeviceSessions(context.Background(), ctx.fromID, ctx.deviceID)
+	if err != nil {
+		logger.Sugar().Warnw("读取设备会话失败", "user_id", ctx.fromID, "error", err)
+		return response
+	}
+	response.Result = pb.DeviceSessionResult_DEVICE_SESSION_OK
+	response.Sessions = sessions
+	return response
+}
+
+// revokeDeviceSession 只结束目标设备：由 Auth 吊销其登录所用的JWT会话，再移除会话与路由租约并断开连接。
+// 被吊销的令牌无论以哪个设备ID重新登录都会被拒绝；用户的JWT密钥不变，当前设备和其他设备保持登录。
+func revokeDeviceSession(ctx dfRequestContext, request *pb.RevokeDeviceSession) *pb.DeviceSessionsRsp {
+	response := &pb.DeviceSessionsRsp{
+		Operation: "revoke_device_session",
+		Result:    pb.DeviceSessionResult_DEVICE_SESSION_SERVICE_ERROR,
+	}
+	if request == nil || ctx.fromID <= 0 || ctx.message.GetJwt() == "" {
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_JWT_ERROR
+		return response
+	}
+	deviceID, err := connection.NormalizeDeviceID(request.GetDeviceId())
+	if err != nil {
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_INVALID_DEVICE_ID
+		return response
+	}
+	if deviceID == ctx.deviceID {
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_CURRENT_DEVICE
+		return response
+	}
+	if redisClient.Rdb == nil {
+		return response
+	}
+
+	userID := strconv.FormatInt(ctx.fromID, 10)
+	requestCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rpcClient, err := getAuthClient()
+	if err != nil {
+		return response
+	}
+	checkResponse, err := rpcClient.CheckJwt(requestCtx, &auth.CheckJwtReq{UserId: ctx.fromID, Jwt: ctx.message.GetJwt()})
+	if err != nil || checkResponse == nil {
+		logger.Sugar().Warnw("结束设备会话校验JWT失败", "user_id", ctx.fromID, "device_id", deviceID, "error", err)
+		return response
+	}
+	if checkResponse.GetResult() != auth.AuthResult_OK {
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_JWT_ERROR
+		return response
+	}
+
+	sessionManager := &redisClient.DistributedSessionManager{}
+	session, exists, err := sessionManager.GetUserSession(requestCtx, userID, deviceID)
+	if err != nil {
+		logger.Sugar().Warnw("读取待吊销设备会话失败", "user_id", ctx.fromID, "device_id", deviceID, "error", err)
+		return response
+	}
+	if !exists {
+		response.Result = pb.DeviceSessionResult_DEVICE_SESSION_NOT_FOUND
+		return response
+	}
+	if session.JwtSessionID == "" {
+		// 升级前建立的会话没有记录JWT会话ID，只能断开连接，令牌需通过退出全部设备失效
+		logger.Sugar().Warnw("设备会话缺少JWT会话ID，无法吊销其令牌", "user_id", ctx.fromID, "device_id", deviceID)
+	} else {
+		revokeResponse, err := rpcClient.RevokeJwtSession(requestCtx, &auth.RevokeJwtSessionReq{
+			UserId:    ctx.fromID,
+			Jwt:       ctx.message.GetJwt(),
+			SessionId: session.JwtSessionID,
+		})
+		if err != nil || revokeResponse == nil {
+			logger.Sugar().Warnw("吊销设备JWT会话失败", "user_id", ctx.fromID, "device_id", deviceID, "error", err)
+			return response
+		}
+		if revokeResponse.GetResult() == auth.AuthResult_JWT_ERROR {
+			response.Result = pb.DeviceSessionResult_DEVICE_SESSION_JWT_ERROR
+			return response
+		}
+		if revokeResponse.GetResult() != auth.AuthResult_OK {
+			return response
+		}
+	}
+	if err := sessionManager.RemoveOwnedSessionAndRoute(requestCtx, userID, session); err != nil {
+		logger.Sugar().Warnw("移除被吊销设备会话失败", "user_id", ctx.fromID, "device_id", deviceID, "error", err)
+		return response
+	}
+
+	// 会话已移除，踢出通知失败时该连接会在下次续租路由时发现所有权丢失并断开。
+	if handler := GetWebSocketHandler(); handler != nil {
+		if err := handler.connManager.KickSession(requestCtx, userID, session); err != nil {
+			logger.Sugar().Warnw("通知被吊销设备下线失败", "user_id", ctx.fromID, "device_id", deviceID, "error", err)
+		}
+	}
+	logger.Sugar().Infow("用户远程结束设备会话", "user_id", ctx.fromID, "device_id", deviceID, "platform", session.Platform)
+
+	response.Result = pb.DeviceSessionResult_DEVICE_SESSION_OK
+	sessions, err := listDeviceSessions(requestCtx, ctx.fromID, ctx.deviceID)
+	if err != nil {
+		logger.Sugar().Warnw("吊销后读取设备会话失败", "user_id", ctx.fromID, "error", err)
+		return response
+	}
+	response.Sessions = sessions
+	return response
+}
+
+// listDeviceSessions 返回仍持有有效路由租约的设备会话。
+func listDeviceSessions(ctx context.Context, fromID int64, currentDeviceID string) ([]*pb.DeviceSessionInfo, error) {
+	if redisClient.Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	userID := strconv.FormatInt(fromID, 10)
+	sessions, err := (&redisClient.DistributedSessionManager{}).ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := redisClient.GetDeviceRoutesByConnection(userID)
+	if err != nil && !errors.Is(err, redisClient.ErrRouteNotFound) {
+		return nil, err
+	}
+	result := make([]*pb.DeviceSessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		if routes[session.DeviceID] != session.ContainerID {
+			continue
+		}
+		result = append(result, &pb.DeviceSessionInfo{
+			DeviceId:        session.DeviceID,
+			Platform:        session.Platform,
+			Pod:             session.ContainerID,
+			LoggedInAt:      formatSessionTime(session.LoggedInAt),
+			LastRefreshedAt: formatSessionTime(session.RefreshedAt),
+			Current:         session.DeviceID == currentDeviceID,
+		})
+	}
+	return result, nil
+}
+
+func formatSessionTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339Nano)
+}
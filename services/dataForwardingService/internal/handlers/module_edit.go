@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/proto/envelope"
+	pushpb "Betterfly2/proto/push"
+	sharedDB "Betterfly2/shared/db"
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/mq"
+	"data_forwarding_service/internal/publisher"
+	"fmt"
+)
+
+// DeliverMessageEdit performs best-effort realtime delivery. Offline users
+// converge through the edited content and edited_at returned by message sync.
+func DeliverMessageEdit(event *pb.MessageEditEvent) error {
+	if event == nil || event.GetResult() != pb.MessageEditResult_MESSAGE_EDIT_OK || event.GetMessageId() <= 0 || event.GetEditedAt() == "" {
+		return fmt.Errorf("待投递的消息编辑事件无效")
+	}
+
+	var targetIDs []int64
+	if event.GetIsGroup() {
+		memberIDs, err := sharedDB.GetActiveGroupMemberIDs(event.GetToUserId())
+		if err != nil {
+			return err
+		}
+		targetIDs = memberIDs
+	} else {
+		targetIDs = []int64{event.GetToUserId()}
+	}
+	replacePendingDelivery(event, targetIDs)
+	targetIDs = recallTargetsWithoutOperator(targetIDs, event.GetOperatorUserId())
+	if len(targetIDs) == 0 {
+		return nil
+	}
+	if err := publishPushRequest(buildMessageEditPushRequest(targetIDs, event)); err != nil {
+		return fmt.Errorf("发布消息编辑APNs请求失败: %w", err)
+	}
+
+	return deliverConversationEvent(targetIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_MessageEditEvent{MessageEditEvent: event},
+	}, func(topic string, topicTargets []int64) error {
+		return publishEditDelivery(topic, topicTargets, event)
+	})
+}
+
+// buildMessageEditPushRequest 复用普通消息的预览规则，APNs 按 collapse ID 替换原通知。
+func buildMessageEditPushRequest(targetUserIDs []int64, event *pb.MessageEditEvent) *pushpb.RequestMessage {
+	if event == nil {
+		return &pushpb.RequestMessage{}
+	}
+	conversationID := event.GetFromUserId()
+	if event.GetIsGroup() {
+		conversationID = event.GetToUserId()
+	}
+	return &pushpb.RequestMessage{Payload: &pushpb.RequestMessage_MessageEdit{MessageEdit: &pushpb.MessageEditPushRequest{
+		Message: &pushpb.MessagePushRequest{
+			TargetUserIds:  targetUserIDs,
+			SenderUserId:   event.GetFromUserId(),
+			ConversationId: conversationID,
+			IsGroup:        event.GetIsGroup(),
+			MessageType:    event.GetMessageType(),
+			SentAt:         event.GetEditedAt(),
+			Preview:        messagePushPreview(&pb.Post{MsgType: event.GetMessageType(), Msg: event.GetContent()}),
+			MessageId:      event.GetMessageId(),
+		},
+		EditedAt: event.GetEditedAt(),
+	}}}
+}
+
+func publishEditDelivery(topic string, targetUserIDs []int64, event *pb.MessageEditEvent) error {
+	if topic == "" || len(targetUserIDs) == 0 {
+		return nil
+	}
+	delivery := &pb.DFInternalDelivery{}
+	if len(targetUserIDs) == 1 {
+		delivery.Payload = &pb.DFInternalDelivery_MessageEditDelivery{MessageEditDelivery: &pb.MessageEditDelivery{
+			TargetUserId: targetUserIDs[0],
+			Event:        event,
+		}}
+	} else {
+		delivery.Payload = &pb.DFInternalDelivery_MessageEditBatchDelivery{MessageEditBatchDelivery: &pb.MessageEditBatchDelivery{
+			TargetUserIds: targetUserIDs,
+			Event:         event,
+		}}
+	}
+	envelopeBytes, err := mq.MarshalEnvelope(envelope.MessageType_DF_RESPONSE, delivery)
+	if err != nil {
+		return err
+	}
+	if err := publisher.PublishMessage(string(envelopeBytes), topic); err != nil {
+		logger.Sugar().Errorf("跨容器发布消息编辑事件失败: topic=%s targets=%d err=%v", topic, len(targetUserIDs), err)
+		return err
+	}
+	return nil
+}
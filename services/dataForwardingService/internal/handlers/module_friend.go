@@ -82,7 +82,7 @@ func handleInsertContact(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildInsertContactFriendRequestWithMessage(fromID, payload.GetToInsertUserId(), payload.GetMessage(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildInsertContactFriendRequestWithMessage(fromID, payload.GetToInsertUserId(), payload.GetMessage(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布InsertContact请求到friend-service失败: %v", err)
 		return err
 	}
@@ -100,7 +100,7 @@ func handleQueryContacts(fromID int64, message *pb.RequestMessage) error {
 
 	friendReq := buildQueryContactsFriendRequest(fromID, currentContainerID)
 
-	if err := publishFriendRequest(friendReq); err != nil {
+	if err := publishFriendRequest(friendReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布QueryContacts请求到friend-service失败: %v", err)
 		return err
 	}
@@ -130,7 +130,7 @@ func handleQueryGroup(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildQueryGroupFriendRequest(fromID, payload.GetToQueryGroupId(), payload.GetClientNeedSave(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildQueryGroupFriendRequest(fromID, payload.GetToQueryGroupId(), payload.GetClientNeedSave(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布QueryGroup请求到friend-service失败: %v", err)
 		return err
 	}
@@ -162,7 +162,7 @@ func handleInsertGroup(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildInsertGroupFriendRequest(fromID, payload.GetToBeCreatedGroupId(), payload.GetToBeCreatedGroupName(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildInsertGroupFriendRequest(fromID, payload.GetToBeCreatedGroupId(), payload.GetToBeCreatedGroupName(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布InsertGroup请求到friend-service失败: %v", err)
 		return err
 	}
@@ -194,7 +194,7 @@ func handleInsertGroupUser(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildInsertGroupUserFriendRequestWithMessage(fromID, payload.GetTargetGroupId(), payload.GetMessage(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildInsertGroupUserFriendRequestWithMessage(fromID, payload.GetTargetGroupId(), payload.GetMessage(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布InsertGroupUser请求到friend-service失败: %v", err)
 		return err
 	}
@@ -226,7 +226,7 @@ func handleQueryGroupMembers(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildQueryGroupMembersFriendRequest(fromID, payload.GetTargetGroupId(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildQueryGroupMembersFriendRequest(fromID, payload.GetTargetGroupId(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布QueryGroupMembers请求到friend-service失败: %v", err)
 		return err
 	}
@@ -242,7 +242,7 @@ func handleQueryJoinedGroups(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildQueryJoinedGroupsFriendRequest(fromID, currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildQueryJoinedGroupsFriendRequest(fromID, currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布QueryJoinedGroups请求到friend-service失败: %v", err)
 		return err
 	}
@@ -283,7 +283,7 @@ func handleDeleteGroupUser(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildDeleteGroupUserFriendRequest(fromID, payload.GetTargetGroupId(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildDeleteGroupUserFriendRequest(fromID, payload.GetTargetGroupId(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布DeleteGroupUser请求到friend-service失败: %v", err)
 		return err
 	}
@@ -321,7 +321,7 @@ func handleUpdateAvatar(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildUpdateGroupAvatarFriendRequest(fromID, payload.GetTargetId(), payload.GetAvatarHash(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildUpdateGroupAvatarFriendRequest(fromID, payload.GetTargetId(), payload.GetAvatarHash(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布UpdateAvatar请求到friend-service失败: %v", err)
 		return err
 	}
@@ -368,7 +368,7 @@ func handleDeleteContact(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildDeleteContactFriendRequest(fromID, payload.GetToDeleteUserId(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildDeleteContactFriendRequest(fromID, payload.GetToDeleteUserId(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布DeleteContact请求到friend-service失败: %v", err)
 		return err
 	}
@@ -405,7 +405,7 @@ func handleUpdateContactAlias(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildUpdateContactAliasFriendRequest(fromID, payload.GetTargetUserId(), payload.GetNewAlias(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildUpdateContactAliasFriendRequest(fromID, payload.GetTargetUserId(), payload.GetNewAlias(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布UpdateContactAlias请求到friend-service失败: %v", err)
 		return err
 	}
@@ -443,7 +443,7 @@ func handleUpdateContactNotify(fromID int64, message *pb.RequestMessage) error {
 
 	currentContainerID := currentContainerTopic()
 
-	if err := publishFriendRequest(buildUpdateContactNotifyFriendRequest(fromID, payload.GetTargetUserId(), payload.GetIsNotify(), currentContainerID)); err != nil {
+	if err := publishFriendRequest(buildUpdateContactNotifyFriendRequest(fromID, payload.GetTargetUserId(), payload.GetIsNotify(), currentContainerID), message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布UpdateContactNotify请求到friend-service失败: %v", err)
 		return err
 	}
@@ -338,64 +338,68 @@ func monitorServiceStatus(ctx context.Context) (string, error) {
 }
 
 func monitorConnectionStatus(ctx context.Context) (string, error) {
-	localConnections, localUsers := 0, 0
+	localConnections, localUsers, maxQueueDepth, spilling := 0, 0, 0, 0
 	if handler := GetWebSocketHandler(); handler != nil {
 		localConnections, localUsers = handler.GetConnectionStats()
+		maxQueueDepth, spilling = handler.connManager.SendQueueStats()
 	}
 	if redisClient.Rdb == nil {
 		return "", errors.New("Redis未初始化")
 	}
-	globalUsers, err := redisClient.Rdb.HLen(ctx, "ws_connection_mapping").Result()
+	globalUsers, err := redisClient.CountUserRoutes(ctx)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("连接统计\n当前 Pod: connections=%d logged_in=%d\n全局路由记录: %d", localConnections, localUsers, globalUsers), nil
+	return fmt.Sprintf("连接统计\n当前 Pod: connections=%d logged_in=%d\n发送队列: max_depth=%d spilling=%d\n全局路由记录: %d",
+		localConnections, localUsers, maxQueueDepth, spilling, globalUsers), nil
 }
 
 func monitorUserRoute(ctx context.Context, userID int64) (string, error) {
 	if redisClient.Rdb == nil {
 		return "", errors.New("Redis未初始化")
 	}
-	container, err := redisClient.Rdb.HGet(ctx, "ws_connection_mapping", strconv.FormatInt(userID, 10)).Result()
-	if errors.Is(err, redis.Nil) {
-		return fmt.Sprintf("用户 %d 当前离线。", userID), nil
-	}
+	userIDStr := strconv.FormatInt(userID, 10)
+	sessions, err := (&redisClient.DistributedSessionManager{}).ListUserSessions(ctx, userIDStr)
 	if err != nil {
 		return "", err
 	}
-	leaseExists, err := redisClient.Rdb.Exists(ctx, "ws_route_lease:"+strconv.FormatInt(userID, 10)).Result()
-	if err != nil {
+	routes, err := redisClient.GetDeviceRoutesByConnection(userIDStr)
+	if err != nil && !errors.Is(err, redisClient.ErrRouteNotFound) {
 		return "", err
 	}
-	if leaseExists == 0 {
-		return fmt.Sprintf("用户 %d 存在过期路由记录：%s（租约已失效）", userID, container), nil
+	if len(sessions) == 0 && len(routes) == 0 {
+		return fmt.Sprintf("用户 %d 当前离线。", userID), nil
 	}
-	return fmt.Sprintf("用户 %d 当前路由到 DF Pod：%s", userID, container), nil
+	lines := []string{fmt.Sprintf("用户 %d 当前有 %d 个在线设备：", userID, len(routes))}
+	for _, session := range sessions {
+		if container, ok := routes[session.DeviceID]; ok {
+			lines = append(lines, fmt.Sprintf("- 设备 %s -> DF Pod：%s", session.DeviceID, container))
+		} else {
+			lines = append(lines, fmt.Sprintf("- 设备 %s 存在过期会话记录：%s（租约已失效）", session.DeviceID, session.ContainerID))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 func monitorKickUser(ctx context.Context, userID int64) (string, error) {
 	if redisClient.Rdb == nil {
 		return "", errors.New("Redis未初始化")
 	}
-	container, err := redisClient.Rdb.HGet(ctx, "ws_connection_mapping", strconv.FormatInt(userID, 10)).Result()
-	if errors.Is(err, redis.Nil) {
-		return fmt.Sprintf("用户 %d 当前离线，无需踢出。", userID), nil
+	userIDStr := strconv.FormatInt(userID, 10)
+	containers, err := redisClient.GetContainersByConnection(userIDStr)
+	if errors.Is(err, redisClient.ErrRouteNotFound) {
+		return fmt.Sprintf("用户 %d 当前离线或路由租约已失效，无需踢出。", userID), nil
 	}
 	if err != nil {
 		return "", err
 	}
-	leaseExists, err := redisClient.Rdb.Exists(ctx, "ws_route_lease:"+strconv.FormatInt(userID, 10)).Result()
-	if err != nil {
-		return "", err
-	}
-	if leaseExists == 0 {
-		return fmt.Sprintf("用户 %d 的路由租约已失效，未执行踢出。", userID), nil
-	}
 	dsm := &redisClient.DistributedSessionManager{}
-	if err := dsm.PublishKickNotification(ctx, strconv.FormatInt(userID, 10), container); err != nil {
-		return "", err
+	for _, container := range containers {
+		if err := dsm.PublishKickNotification(ctx, userIDStr, container); err != nil {
+			return "", err
+		}
 	}
-	return fmt.Sprintf("已向 DF Pod %s 发送用户 %d 的强制断线指令。", container, userID), nil
+	return fmt.Sprintf("已向 DF Pod %s 发送用户 %d 全部设备的强制断线指令。", strings.Join(containers, ", "), userID), nil
 }
 
 func monitorUserSummary(_ context.Context, userID int64) (string, error) {
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	"context"
+	"data_forwarding_service/internal/connection"
+	redisClient "data_forwarding_service/internal/redis"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// maxAckDeliveryMessageIDs 限制单次确认的消息数，批量确认应使用 up_to_message_id
+const maxAckDeliveryMessageIDs = 200
+
+// pendingDeliveryTimeout 限制写入或读取待投递日志的耗时
+const pendingDeliveryTimeout = 2 * time.Second
+
+func init() {
+	registerDFRequestModule(registerPendingDeliveryModule)
+}
+
+func registerPendingDeliveryModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_AckDelivery) (dfRequestResult, error) {
+		payload, err := authenticatedPayload(ctx.fromID, ctx.message, "确认消息投递", "ack_delivery", (*pb.RequestMessage).GetAckDelivery)
+		if err != nil {
+			return dfRequestResult{}, err
+		}
+		return dfRequestResult{}, handleAckDelivery(ctx.fromID, payload)
+	})
+}
+
+func handleAckDelivery(fromID int64, ack *pb.AckDelivery) error {
+	if len(ack.GetMessageIds()) > maxAckDeliveryMessageIDs {
+		return fmt.Errorf("单次确认的消息数不能超过%d", maxAckDeliveryMessageIDs)
+	}
+	if len(ack.GetMessageIds()) == 0 && ack.GetUpToMessageId() <= 0 {
+		return errors.New("确认投递需要指定message_ids或up_to_message_id")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pendingDeliveryTimeout)
+	defer cancel()
+	userID := strconv.FormatInt(fromID, 10)
+	if err := redisClient.AckPendingDeliveries(ctx, userID, ack.GetMessageIds(), ack.GetUpToMessageId()); err != nil {
+		return fmt.Errorf("删除已确认的待投递消息失败: %w", err)
+	}
+	// 长时间保持连接的客户端不会重新登录，靠确认续期投递确认能力的标记
+	if handler := GetWebSocketHandler(); handler != nil {
+		if err := redisClient.MarkDeliveryAckCapable(ctx, userID, handler.config.pendingDeliveryTTL); err != nil {
+			logger.Sugar().Warnw("续期投递确认能力失败", "user_id", fromID, "error", err)
+		}
+	}
+	logger.Sugar().Debugf("客户端确认消息投递: user_id=%d message_ids=%d up_to=%d", fromID, len(ack.GetMessageIds()), ack.GetUpToMessageId())
+	return nil
+}
+
+// appendPendingDelivery 在实时投递前把消息帧写入接收者的待投递日志，客户端确认前断线或所在 pod 故障时，
+// 重新登录后仍会收到。只有登录过声明 delivery_ack 客户端的接收者才会写入，返回实际写入的接收者数。
+// 写入失败只影响补发，不阻止实时投递。
+func appendPendingDelivery(messageID int64, targetUserIDs []int64, frame []byte) (int, error) {
+	handler := GetWebSocketHandler()
+	if handler == nil || redisClient.Rdb == nil {
+		return 0, errors.New("待投递日志不可用")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pendingDeliveryTimeout)
+	defer cancel()
+	queued, err := redisClient.AppendPendingDelivery(ctx, formatUserIDs(targetUserIDs), messageID, frame, handler.config.pendingDeliveryLimit, handler.config.pendingDeliveryTTL)
+	if err != nil {
+		logger.Sugar().Warnw("写入待投递日志失败", "message_id", messageID, "targets", len(targetUserIDs), "error", err)
+	}
+	return len(queued), err
+}
+
+// removePendingDelivery 在消息撤回后删除接收者尚未确认的投递帧，离线设备上线后不会再收到撤回前的内容。
+func removePendingDelivery(messageID int64, targetUserIDs []int64) {
+	if redisClient.Rdb == nil || len(targetUserIDs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pendingDeliveryTimeout)
+	defer cancel()
+	if err := redisClient.RemovePendingDelivery(ctx, formatUserIDs(targetUserIDs), messageID); err != nil {
+		logger.Sugar().Warnw("删除已撤回消息的待投递帧失败", "message_id", messageID, "targets", len(targetUserIDs), "error", err)
+	}
+}
+
+// replacePendingDelivery 在消息编辑后把接收者尚未确认的投递帧换成编辑后的内容，已确认的条目不会重新写入。
+func replacePendingDelivery(event *pb.MessageEditEvent, targetUserIDs []int64) {
+	if redisClient.Rdb == nil || len(targetUserIDs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pendingDeliveryTimeout)
+	defer cancel()
+	frames, err := redisClient.ReadPendingDelivery(ctx, formatUserIDs(targetUserIDs), event.GetMessageId())
+	if err != nil {
+		logger.Sugar().Warnw("读取待编辑消息的待投递帧失败", "message_id", event.GetMessageId(), "error", err)
+		return
+	}
+	// 同一消息写入所有接收者的是同一帧，按原帧缓存改写结果
+	edited := make(map[string][]byte, 1)
+	for userID, frame := range frames {
+		replacement, exists := edited[string(frame)]
+		if !exists {
+			if replacement, err = editPendingPostFrame(frame, event); err != nil {
+				logger.Sugar().Warnw("改写待投递帧失败", "message_id", event.GetMessageId(), "error", err)
+				return
+			}
+			edited[string(frame)] = replacement
+		}
+		if err := redisClient.ReplacePendingDelivery(ctx, userID, event.GetMessageId(), replacement); err != nil {
+			logger.Sugar().Warnw("替换已编辑消息的待投递帧失败", "message_id", event.GetMessageId(), "user_id", userID, "error", err)
+		}
+	}
+}
+
+func editPendingPostFrame(frame []byte, event *pb.MessageEditEvent) ([]byte, error) {
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(frame, response); err != nil {
+		return nil, err
+	}
+	post := response.GetPost()
+	if post == nil {
+		return nil, errors.New("待投递帧不是post")
+	}
+	post.Msg = event.GetContent()
+	if event.GetMessageType() != "" {
+		post.MsgType = event.GetMessageType()
+	}
+	return proto.Marshal(response)
+}
+
+func formatUserIDs(userIDs []int64) []string {
+	formatted := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		formatted[i] = strconv.FormatInt(userID, 10)
+	}
+	return formatted
+}
+
+// drainPendingDeliveries 登记用户有会确认投递的客户端，并按 message_id 顺序把未确认的消息发给刚登录的连接。
+// 只对声明了 delivery_ack 能力的客户端补发，旧客户端不会确认，补发只会在每次登录时重复。
+func (h *WebSocketHandler) drainPendingDeliveries(conn *connection.Connection) {
+	if redisClient.Rdb == nil || !conn.ClientInfo().Supports(capabilityDeliveryAck) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.lifecycleCtx, pendingDeliveryTimeout)
+	defer cancel()
+	if err := redisClient.MarkDeliveryAckCapable(ctx, conn.UserID, h.config.pendingDeliveryTTL); err != nil {
+		logger.Sugar().Warnw("登记投递确认能力失败", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+	}
+	frames, err := redisClient.ReadPendingDeliveries(ctx, conn.UserID, h.config.pendingDeliveryLimit)
+	if err != nil {
+		logger.Sugar().Warnw("读取待投递日志失败", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+		return
+	}
+	for _, frame := range frames {
+		if err := conn.EnqueueMessage(frame); err != nil {
+			logger.Sugar().Warnw("补发待投递消息失败", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+			return
+		}
+	}
+	if len(frames) > 0 {
+		logger.Sugar().Infow("已补发待投递消息", "user_id", conn.UserID, "device_id", conn.DeviceID, "count", len(frames))
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"context"
+	"data_forwarding_service/internal/connection"
+	"testing"
+	"time"
+)
+
+func appendPendingPosts(t *testing.T, messageIDs ...int64) {
+	t.Helper()
+	for _, messageID := range messageIDs {
+		frame, err := buildPostResponseBytes(&pb.Post{FromId: 7, ToId: 42, Msg: "hi", MsgType: "text", MessageId: messageID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := appendPendingDelivery(messageID, []int64{42}, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPendingDeliveriesDrainOnlyToAckCapableClientsUntilAcked(t *testing.T) {
+	useHandlerTestRedis(t)
+	handler := &WebSocketHandler{
+		config:       websocketConfig{pendingDeliveryLimit: 10, pendingDeliveryTTL: time.Hour},
+		lifecycleCtx: context.Background(),
+	}
+	SetGlobalWebSocketHandler(handler)
+	t.Cleanup(func() { SetGlobalWebSocketHandler(nil) })
+
+	legacy := newResumeTestConnection("42", "desktop")
+	handler.drainPendingDeliveries(legacy)
+	appendPendingPosts(t, 10)
+	handler.drainPendingDeliveries(legacy)
+	if len(legacy.SendChan) != 0 {
+		t.Fatal("clients without delivery_ack must not receive pending deliveries")
+	}
+
+	modern := newResumeTestConnection("42", "phone")
+	modern.SetClientInfo(connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityDeliveryAck}))
+	handler.drainPendingDeliveries(modern)
+	if len(modern.SendChan) != 0 {
+		t.Fatal("messages sent while the user only had legacy clients must not be queued")
+	}
+	appendPendingPosts(t, 12, 11)
+	handler.drainPendingDeliveries(modern)
+	for _, want := range []int64{11, 12} {
+		if got := receiveResponse(t, modern).GetPost().GetMessageId(); got != want {
+			t.Fatalf("pending deliveries out of order: got %d want %d", got, want)
+		}
+	}
+
+	if err := handleAckDelivery(42, &pb.AckDelivery{UpToMessageId: 11}); err != nil {
+		t.Fatal(err)
+	}
+	handler.drainPendingDeliveries(modern)
+	if got := receiveResponse(t, modern).GetPost().GetMessageId(); got != 12 || len(modern.SendChan) != 0 {
+		t.Fatalf("acked delivery was drained again: %d", got)
+	}
+	if err := handleAckDelivery(42, &pb.AckDelivery{MessageIds: []int64{12}}); err != nil {
+		t.Fatal(err)
+	}
+	handler.drainPendingDeliveries(modern)
+	if len(modern.SendChan) != 0 {
+		t.Fatal("all deliveries were acked")
+	}
+
+	if err := handleAckDelivery(42, &pb.AckDelivery{}); err == nil {
+		t.Fatal("empty ack should be rejected")
+	}
+	if err := handleAckDelivery(42, &pb.AckDelivery{MessageIds: make([]int64, maxAckDeliveryMessageIDs+1)}); err == nil {
+		t.Fatal("oversized ack should be rejected")
+	}
+}
+
+func TestPendingDeliveriesFollowRecallAndEdit(t *testing.T) {
+	useHandlerTestRedis(t)
+	handler := &WebSocketHandler{
+		config:       websocketConfig{pendingDeliveryLimit: 10, pendingDeliveryTTL: time.Hour},
+		lifecycleCtx: context.Background(),
+	}
+	SetGlobalWebSocketHandler(handler)
+	t.Cleanup(func() { SetGlobalWebSocketHandler(nil) })
+
+	conn := newResumeTestConnection("42", "phone")
+	conn.SetClientInfo(connection.NewClientInfo(serverProtocolVersion, "2.0.0", "ios", []string{capabilityDeliveryAck}))
+	handler.drainPendingDeliveries(conn)
+	appendPendingPosts(t, 21, 22)
+
+	removePendingDelivery(21, []int64{42})
+	replacePendingDelivery(&pb.MessageEditEvent{MessageId: 22, Content: "hi, edited", MessageType: "text"}, []int64{42})
+
+	handler.drainPendingDeliveries(conn)
+	post := receiveResponse(t, conn).GetPost()
+	if post.GetMessageId() != 22 || post.GetMsg() != "hi, edited" || len(conn.SendChan) != 0 {
+		t.Fatalf("pending log must drop recalled and rewrite edited messages: %+v", post)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/proto/envelope"
+	sharedDB "Betterfly2/shared/db"
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/mq"
+	"data_forwarding_service/internal/publisher"
+	"fmt"
+)
+
+// DeliverMessagePin performs best-effort realtime delivery to the other
+// conversation participants. Pins do not produce APNs notifications; offline
+// users converge through QueryPinnedMessages.
+func DeliverMessagePin(event *pb.MessagePinEvent) error {
+	if event == nil || event.GetResult() != pb.MessagePinResult_MESSAGE_PIN_OK || event.GetMessageId() <= 0 {
+		return fmt.Errorf("待投递的消息置顶事件无效")
+	}
+	if !event.GetChanged() {
+		return nil
+	}
+
+	var targetIDs []int64
+	if event.GetIsGroup() {
+		memberIDs, err := sharedDB.GetActiveGroupMemberIDs(event.GetToUserId())
+		if err != nil {
+			return err
+		}
+		targetIDs = memberIDs
+	} else {
+		// 单聊双方都可以置顶，操作者不一定是发送者
+		targetIDs = []int64{event.GetFromUserId(), event.GetToUserId()}
+	}
+	targetIDs = recallTargetsWithoutOperator(targetIDs, event.GetOperatorUserId())
+	if len(targetIDs) == 0 {
+		return nil
+	}
+
+	return deliverConversationEvent(targetIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_MessagePinEvent{MessagePinEvent: event},
+	}, func(topic string, topicTargets []int64) error {
+		return publishPinDelivery(topic, topicTargets, event)
+	})
+}
+
+func publishPinDelivery(topic string, targetUserIDs []int64, event *pb.MessagePinEvent) error {
+	if topic == "" || len(targetUserIDs) == 0 {
+		return nil
+	}
+	delivery := &pb.DFInternalDelivery{}
+	if len(targetUserIDs) == 1 {
+		delivery.Payload = &pb.DFInternalDelivery_MessagePinDelivery{MessagePinDelivery: &pb.MessagePinDelivery{
+			TargetUserId: targetUserIDs[0],
+			Event:        event,
+		}}
+	} else {
+		delivery.Payload = &pb.DFInternalDelivery_MessagePinBatchDelivery{MessagePinBatchDelivery: &pb.MessagePinBatchDelivery{
+			TargetUserIds: targetUserIDs,
+			Event:         event,
+		}}
+	}
+	envelopeBytes, err := mq.MarshalEnvelope(envelope.MessageType_DF_RESPONSE, delivery)
+	if err != nil {
+		return err
+	}
+	if err := publisher.PublishMessage(string(envelopeBytes), topic); err != nil {
+		logger.Sugar().Errorf("跨容器发布消息置顶事件失败: topic=%s targets=%d err=%v", topic, len(targetUserIDs), err)
+		return err
+	}
+	return nil
+}
@@ -33,10 +33,10 @@ func registerPostModule(router *dispatch.OneofRouter[dfRequestContext, dfRequest
 	})
 }
 
-// sendMessageToStorage 发送消息到storageService进行存储
-func sendMessageToStorage(payload *pb.Post, currentContainerID string) error {
+// sendMessageToStorage 发送消息到storageService进行存储，存储完成后的 PostAckRsp 携带 requestID
+func sendMessageToStorage(payload *pb.Post, currentContainerID, requestID string) error {
 	storeReq := buildStoreNewMessageStorageRequest(payload, currentContainerID)
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, requestID); err != nil {
 		logger.Sugar().Errorf("发布消息到storage-service失败: %v", err)
 		return err
 	}
@@ -49,14 +49,17 @@ func buildStoreNewMessageStorageRequest(payload *pb.Post, currentContainerID str
 	req := newStorageRequest(currentContainerID, payload.GetFromId())
 	req.Payload = &storage.RequestMessage_StoreNewMessage{
 		StoreNewMessage: &storage.StoreNewMessage{
-			FromUserId:      payload.GetFromId(),
-			ToUserId:        payload.GetToId(),
-			Content:         payload.GetMsg(),
-			MessageType:     payload.GetMsgType(),
-			IsGroup:         payload.GetIsGroup(),
-			RealFileName:    payload.GetRealFileName(),
-			ClientMessageId: payload.GetClientMessageId(),
-			ClientTimestamp: payload.GetTimestamp(),
+			FromUserId:       payload.GetFromId(),
+			ToUserId:         payload.GetToId(),
+			Content:          payload.GetMsg(),
+			MessageType:      payload.GetMsgType(),
+			IsGroup:          payload.GetIsGroup(),
+			RealFileName:     payload.GetRealFileName(),
+			ClientMessageId:  payload.GetClientMessageId(),
+			ClientTimestamp:  payload.GetTimestamp(),
+			ReplyToMessageId: payload.GetReplyToMessageId(),
+			MentionedUserIds: payload.GetMentionedUserIds(),
+			MentionAll:       payload.GetMentionAll(),
 		},
 	}
 	return req
@@ -71,6 +74,12 @@ func handlePostMessage(fromID int64, message *pb.RequestMessage) error {
 	if err := validatePostPayload(payload); err != nil {
 		return err
 	}
+	if payload.GetMsgType() == sharedDB.MergedForwardMessageType {
+		return errors.New("合并转发消息只能通过forward_messages发送")
+	}
+	if err := validatePostMentions(payload); err != nil {
+		return err
+	}
 	clientMessageID := ensurePostClientMessageID(payload)
 	if monitor.IsMonitorID(payload.GetToId()) {
 		return handleMonitorPost(fromID, payload)
@@ -92,13 +101,13 @@ func handlePostMessage(fromID int64, message *pb.RequestMessage) error {
 	}
 	if !claim.acquired {
 		if claim.messageID > 0 {
-			return sendPostAck(fromID, claim.messageID, clientMessageID)
+			return sendPostAck(fromID, claim.messageID, clientMessageID, message.GetRequestId())
 		}
 		logger.Sugar().Debugf("消息正在处理中，忽略重复请求: from=%d client_message_id=%s", fromID, clientMessageID)
 		return nil
 	}
 
-	if err := sendMessageToStorage(payload, currentContainerTopic()); err != nil {
+	if err := sendMessageToStorage(payload, currentContainerTopic(), message.GetRequestId()); err != nil {
 		releasePostClaim(context.Background(), fromID, clientMessageID)
 		return err
 	}
@@ -119,20 +128,21 @@ func DeliverStoredPost(messageID int64, payload *pb.Post) error {
 		return nil
 	}
 
-	message := &pb.RequestMessage{Payload: &pb.RequestMessage_Post{Post: payload}}
-	currentContainerID := currentContainerTopic()
+	payload.MessageId = messageID
 	if payload.GetIsGroup() {
-		err = routeGroupMessage(messageID, payload.GetFromId(), payload, message, currentContainerID)
+		err = routeGroupMessage(messageID, payload.GetFromId(), payload, currentContainerTopic())
 	} else {
-		targetUserID := strconv.FormatInt(payload.GetToId(), 10)
-		targetTopic, routeErr := redisClient.GetContainerByConnection(targetUserID)
 		publishMessagePushBestEffort([]int64{payload.GetToId()}, payload, messageID)
-		if routeErr == nil {
-			err = routePostToTarget(targetUserID, targetTopic, currentContainerID, payload, message)
-		} else if errors.Is(routeErr, redisClient.ErrRouteNotFound) {
-			err = routerpkg.ErrUserOffline
-		} else {
-			err = routeErr
+		queued := false
+		if frame, buildErr := buildPostResponseBytes(payload); buildErr == nil {
+			count, appendErr := appendPendingDelivery(messageID, []int64{payload.GetToId()}, frame)
+			queued = appendErr == nil && count == 1
+		}
+		err = routePostToTarget(strconv.FormatInt(payload.GetToId(), 10), payload)
+		if queued && errors.Is(err, routerpkg.ErrUserOffline) {
+			// 消息已进入待投递日志，接收者上线后补发
+			logger.Sugar().Debugf("接收者离线，消息等待上线后补发: message_id=%d to=%d", messageID, payload.GetToId())
+			err = nil
 		}
 	}
 	if err != nil {
@@ -141,6 +151,7 @@ func DeliverStoredPost(messageID int64, payload *pb.Post) error {
 	return err
 }
 
+// InplaceHandlePostMessage 处理旧版本容器以 DF_REQUEST 转发的单聊消息，只投递到本容器内的设备。
 func InplaceHandlePostMessage(message *pb.RequestMessage) error {
 	payload := message.GetPost()
 	logger.Sugar().Debugf("InplaceHandlePostMessage-payload: %s", payload.String())
@@ -170,7 +181,7 @@ func InplaceHandlePostMessage(message *pb.RequestMessage) error {
 	}
 
 	targetUserID := strconv.FormatInt(payload.GetToId(), 10)
-	err = wsHandler.router.RouteMessage(targetUserID, rspBytes)
+	err = wsHandler.router.DeliverLocally(targetUserID, rspBytes)
 	if err != nil {
 		logger.Sugar().Errorf("路由器发送消息失败: %v", err)
 		return err
@@ -209,12 +220,31 @@ func validatePostPayload(payload *pb.Post) error {
 	return nil
 }
 
+// validatePostMentions 只检查格式，@所有人的角色与被@用户的成员资格由storageService在事务内校验
+func validatePostMentions(payload *pb.Post) error {
+	if len(payload.GetMentionedUserIds()) == 0 && !payload.GetMentionAll() {
+		return nil
+	}
+	if !payload.GetIsGroup() {
+		return errors.New("只有群消息可以@成员")
+	}
+	if len(payload.GetMentionedUserIds()) > sharedDB.MaxMessageMentions {
+		return fmt.Errorf("单条消息最多@%d人", sharedDB.MaxMessageMentions)
+	}
+	for _, userID := range payload.GetMentionedUserIds() {
+		if userID <= 0 {
+			return errors.New("被@的用户ID无效")
+		}
+	}
+	return nil
+}
+
 // ValidatePostPayload validates an internal post before it enters the delivery path.
 func ValidatePostPayload(payload *pb.Post) error {
 	return validatePostPayload(payload)
 }
 
-func routeGroupMessage(messageID, fromID int64, payload *pb.Post, message *pb.RequestMessage, currentContainerID string) error {
+func routeGroupMessage(messageID, fromID int64, payload *pb.Post, currentContainerID string) error {
 	isMember, err := sharedDB.IsActiveGroupMember(payload.GetToId(), fromID)
 	if err != nil {
 		return err
@@ -239,30 +269,38 @@ func routeGroupMessage(messageID, fromID int64, payload *pb.Post, message *pb.Re
 		memberIDByUserID[targetUserID] = memberID
 	}
 	publishMessagePushBestEffort(membersWithoutSender(memberIDs, fromID), payload, messageID)
-	containerByUserID, err := redisClient.GetContainersByConnections(targetUserIDs)
+	containersByUserID, err := redisClient.GetContainersByConnections(targetUserIDs)
 	if err != nil {
 		return err
 	}
 
+	responseBytes, err := buildPostResponseBytes(payload)
+	if err != nil {
+		return err
+	}
+	_, _ = appendPendingDelivery(messageID, membersWithoutSender(memberIDs, fromID), responseBytes)
+	wsHandler := GetWebSocketHandler()
 	delivered := 0
 	crossContainerTargets := make(map[string][]int64)
 	for _, targetUserID := range targetUserIDs {
-		targetTopic := containerByUserID[targetUserID]
-		if targetTopic == "" {
-			continue
-		}
-
-		if targetTopic == currentContainerID {
-			if err := routePostToTarget(targetUserID, targetTopic, currentContainerID, payload, message); err != nil {
-				logger.Sugar().Errorf("群消息本地转发失败: group_id=%d, target_user=%s, err=%v", payload.GetToId(), targetUserID, err)
+		// 同一成员的多个设备可能分布在不同容器，每个容器各投递一次
+		for _, targetTopic := range containersByUserID[targetUserID] {
+			if targetTopic == currentContainerID {
+				if wsHandler == nil {
+					logger.Sugar().Errorf("WebSocket处理器未初始化，无法本地转发群消息: group_id=%d", payload.GetToId())
+					continue
+				}
+				if err := wsHandler.DeliverLocally(targetUserID, responseBytes); err != nil {
+					logger.Sugar().Errorf("群消息本地转发失败: group_id=%d, target_user=%s, err=%v", payload.GetToId(), targetUserID, err)
+					continue
+				}
+				delivered++
 				continue
 			}
-			delivered++
-			continue
-		}
 
-		memberID := memberIDByUserID[targetUserID]
-		crossContainerTargets[targetTopic] = append(crossContainerTargets[targetTopic], memberID)
+			memberID := memberIDByUserID[targetUserID]
+			crossContainerTargets[targetTopic] = append(crossContainerTargets[targetTopic], memberID)
+		}
 	}
 
 	for targetTopic, targetUserIDs := range crossContainerTargets {
@@ -292,17 +330,39 @@ func buildMessagePushRequest(targetUserIDs []int64, payload *pb.Post, messageID
 		conversationID = payload.GetToId()
 	}
 	return &pushpb.RequestMessage{Payload: &pushpb.RequestMessage_MessagePush{MessagePush: &pushpb.MessagePushRequest{
-		TargetUserIds:  targetUserIDs,
-		SenderUserId:   payload.GetFromId(),
-		ConversationId: conversationID,
-		IsGroup:        payload.GetIsGroup(),
-		MessageType:    payload.GetMsgType(),
-		SentAt:         payload.GetTimestamp(),
-		Preview:        messagePushPreview(payload),
-		MessageId:      messageID,
+		TargetUserIds:    targetUserIDs,
+		SenderUserId:     payload.GetFromId(),
+		ConversationId:   conversationID,
+		IsGroup:          payload.GetIsGroup(),
+		MessageType:      payload.GetMsgType(),
+		SentAt:           payload.GetTimestamp(),
+		Preview:          messagePushPreview(payload),
+		MessageId:        messageID,
+		MentionedUserIds: mentionedPushTargets(targetUserIDs, payload),
 	}}}
 }
 
+// mentionedPushTargets 返回推送目标中被@的用户，@所有人时为全部目标
+func mentionedPushTargets(targetUserIDs []int64, payload *pb.Post) []int64 {
+	if payload.GetMentionAll() {
+		return targetUserIDs
+	}
+	if len(payload.GetMentionedUserIds()) == 0 {
+		return nil
+	}
+	mentioned := make(map[int64]bool, len(payload.GetMentionedUserIds()))
+	for _, userID := range payload.GetMentionedUserIds() {
+		mentioned[userID] = true
+	}
+	targets := make([]int64, 0, len(payload.GetMentionedUserIds()))
+	for _, userID := range targetUserIDs {
+		if mentioned[userID] {
+			targets = append(targets, userID)
+		}
+	}
+	return targets
+}
+
 func messagePushPreview(payload *pb.Post) string {
 	if payload == nil {
 		return "发来一条消息"
@@ -401,19 +461,15 @@ func buildGroupPostDeliveryEnvelopeBytes(targetUserIDs []int64, payload *pb.Post
 	return envBytes, nil
 }
 
-func routePostToTarget(targetUserID, targetTopic, currentContainerID string, payload *pb.Post, message *pb.RequestMessage) error {
-	if targetTopic == "" {
-		logger.Sugar().Debugf("%s 用户不在线，消息已保存", targetUserID)
-		return nil
-	}
-
+// routePostToTarget 将单聊消息投递到目标用户的所有在线设备。
+func routePostToTarget(targetUserID string, payload *pb.Post) error {
 	wsHandler := GetWebSocketHandler()
 	if wsHandler == nil || wsHandler.router == nil {
 		logger.Sugar().Errorf("WebSocket处理器或路由器未初始化")
 		return fmt.Errorf("WebSocket处理器或路由器未初始化")
 	}
 
-	messageBytes, err := buildPostDeliveryMessageBytes(targetTopic, currentContainerID, payload, message)
+	messageBytes, err := buildPostResponseBytes(payload)
 	if err != nil {
 		return err
 	}
@@ -423,28 +479,19 @@ func routePostToTarget(targetUserID, targetTopic, currentContainerID string, pay
 		return err
 	}
 
-	logger.Sugar().Debugf("消息路由成功: %d -> %s (容器: %s)", payload.GetFromId(), targetUserID, targetTopic)
+	logger.Sugar().Debugf("消息路由成功: %d -> %s", payload.GetFromId(), targetUserID)
 	return nil
 }
 
-func buildPostDeliveryMessageBytes(targetTopic, currentContainerID string, payload *pb.Post, message *pb.RequestMessage) ([]byte, error) {
-	if targetTopic == currentContainerID {
-		rsp := &pb.ResponseMessage{
-			Payload: &pb.ResponseMessage_Post{
-				Post: payload,
-			},
-		}
-		messageBytes, err := proto.Marshal(rsp)
-		if err != nil {
-			logger.Sugar().Errorf("序列化响应消息失败: %v", err)
-			return nil, err
-		}
-		return messageBytes, nil
+func buildPostResponseBytes(payload *pb.Post) ([]byte, error) {
+	rsp := &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_Post{
+			Post: payload,
+		},
 	}
-
-	messageBytes, err := proto.Marshal(message)
+	messageBytes, err := proto.Marshal(rsp)
 	if err != nil {
-		logger.Sugar().Errorf("序列化RequestMessage失败: %v", err)
+		logger.Sugar().Errorf("序列化响应消息失败: %v", err)
 		return nil, err
 	}
 	return messageBytes, nil
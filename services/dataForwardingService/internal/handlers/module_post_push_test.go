@@ -50,3 +50,17 @@ func TestDirectMessagePushUsesSenderAsRecipientConversation(t *testing.T) {
 		t.Fatalf("unexpected direct conversation metadata: %+v", request)
 	}
 }
+
+func TestMessagePushMarksMentionedTargets(t *testing.T) {
+	post := &pb.Post{FromId: 1, ToId: 88, IsGroup: true, MsgType: "text", MentionedUserIds: []int64{3, 5}}
+	request := buildMessagePushRequest([]int64{2, 3, 4}, post, 125).GetMessagePush()
+	if got := request.GetMentionedUserIds(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("unexpected mentioned targets: %v", got)
+	}
+
+	post.MentionAll = true
+	request = buildMessagePushRequest([]int64{2, 3, 4}, post, 126).GetMessagePush()
+	if got := request.GetMentionedUserIds(); len(got) != 3 {
+		t.Fatalf("mention all should mark every target: %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	storage "Betterfly2/proto/storage"
+	sharedDB "Betterfly2/shared/db"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	"context"
+	redisClient "data_forwarding_service/internal/redis"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// maxPresenceQueryUsers 限制单次 QueryPresence 的用户数
+const maxPresenceQueryUsers = 200
+
+func init() { registerDFRequestModule(registerPresenceModule) }
+
+func registerPresenceModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_QueryPresence) (dfRequestResult, error) {
+		return dfRequestResult{response: &pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_PresenceRsp{PresenceRsp: queryPresence(ctx)},
+		}}, nil
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_UpdatePresenceSettings) (dfRequestResult, error) {
+		return dfRequestResult{}, handleUpdatePresenceSettings(ctx.fromID, ctx.message)
+	})
+}
+
+// queryPresence 返回请求中自己和好友的在线状态，其他用户被静默忽略，避免陌生人探测在线情况。
+func queryPresence(ctx dfRequestContext) *pb.PresenceRsp {
+	response := &pb.PresenceRsp{Result: pb.PresenceResult_PRESENCE_SERVICE_ERROR}
+	payload, err := authenticatedPayload(ctx.fromID, ctx.message, "查询在线状态", "query_presence", (*pb.RequestMessage).GetQueryPresence)
+	if err != nil {
+		logger.Sugar().Warnw("查询在线状态鉴权失败", "user_id", ctx.fromID, "error", err)
+		response.Result = pb.PresenceResult_PRESENCE_JWT_ERROR
+		return response
+	}
+	if len(payload.GetUserIds()) > maxPresenceQueryUsers {
+		response.Result = pb.PresenceResult_PRESENCE_TOO_MANY_USERS
+		return response
+	}
+	friends, err := sharedDB.GetFriendList(ctx.fromID)
+	if err != nil {
+		logger.Sugar().Warnw("读取好友列表失败", "user_id", ctx.fromID, "error", err)
+		return response
+	}
+	userIDs := visiblePresenceUserIDs(ctx.fromID, payload.GetUserIds(), friends)
+
+	requestCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	presences, hideLastSeen, err := loadPresence(requestCtx, ctx.fromID, userIDs)
+	if err != nil {
+		logger.Sugar().Warnw("读取在线状态失败", "user_id", ctx.fromID, "error", err)
+		return response
+	}
+	response.Result = pb.PresenceResult_PRESENCE_OK
+	response.Presences = presences
+	response.HideLastSeen = hideLastSeen
+	return response
+}
+
+// visiblePresenceUserIDs 按请求顺序去重，只保留自己和好友。
+func visiblePresenceUserIDs(viewerID int64, requested []int64, friends []sharedDB.FriendContact) []int64 {
+	allowed := make(map[int64]bool, len(friends)+1)
+	allowed[viewerID] = true
+	for _, friend := range friends {
+		allowed[friend.UserID] = true
+	}
+	result := make([]int64, 0, len(requested))
+	for _, userID := range requested {
+		if !allowed[userID] {
+			continue
+		}
+		allowed[userID] = false
+		result = append(result, userID)
+	}
+	return result
+}
+
+func loadPresence(ctx context.Context, viewerID int64, userIDs []int64) ([]*pb.PresenceInfo, bool, error) {
+	hidden, err := sharedDB.GetHiddenLastSeenUsers(append([]int64{viewerID}, userIDs...))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(userIDs) == 0 {
+		return nil, hidden[viewerID], nil
+	}
+	rawIDs := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		rawIDs[i] = strconv.FormatInt(userID, 10)
+	}
+	routes, err := redisClient.GetContainersByConnections(rawIDs)
+	if err != nil {
+		return nil, false, err
+	}
+	offline := make([]string, 0, len(rawIDs))
+	for _, rawID := range rawIDs {
+		if len(routes[rawID]) == 0 {
+			offline = append(offline, rawID)
+		}
+	}
+	lastSeen, err := redisClient.GetLastSeen(ctx, offline)
+	if err != nil {
+		return nil, false, err
+	}
+
+	presences := make([]*pb.PresenceInfo, 0, len(userIDs))
+	for i, userID := range userIDs {
+		presence := &pb.PresenceInfo{UserId: userID, Online: len(routes[rawIDs[i]]) > 0}
+		if !presence.Online && (userID == viewerID || !hidden[userID]) {
+			presence.LastSeenAt = lastSeen[rawIDs[i]]
+		}
+		presences = append(presences, presence)
+	}
+	return presences, hidden[viewerID], nil
+}
+
+func handleUpdatePresenceSettings(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "修改在线状态设置", "update_presence_settings", (*pb.RequestMessage).GetUpdatePresenceSettings)
+	if err != nil {
+		return err
+	}
+	storeReq := newStorageRequest(currentContainerTopic(), fromID)
+	storeReq.Payload = &storage.RequestMessage_UpdatePresenceSettings{
+		UpdatePresenceSettings: &storage.UpdatePresenceSettings{HideLastSeen: payload.GetHideLastSeen()},
+	}
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("在线状态设置请求已发送到storageService: user_id=%d hide_last_seen=%t", fromID, payload.GetHideLastSeen())
+	return nil
+}
+
+// onPresenceChange 是 ConnectionManager 的上下线回调，通知好友的查询在后台执行。
+func (h *WebSocketHandler) onPresenceChange(userID string, online bool) {
+	go func() {
+		if err := publishPresenceChange(userID, online, time.Now()); err != nil {
+			logger.Sugar().Warnw("推送在线状态变化失败", "user_id", userID, "online", online, "error", err)
+		}
+	}()
+}
+
+// publishPresenceChange 只在用户第一个设备上线或最后一个设备下线时通知在线好友，
+// 其他设备的登录、断开不改变用户整体的在线状态。
+func publishPresenceChange(rawUserID string, online bool, now time.Time) error {
+	userID, err := strconv.ParseInt(rawUserID, 10, 64)
+	if err != nil {
+		return err
+	}
+	routes, err := redisClient.GetDeviceRoutesByConnection(rawUserID)
+	if err != nil && !errors.Is(err, redisClient.ErrRouteNotFound) {
+		return err
+	}
+	if !presenceTransition(online, len(routes)) {
+		return nil
+	}
+
+	event := &pb.PresenceInfo{UserId: userID, Online: online}
+	if !online {
+		hidden, err := sharedDB.GetHiddenLastSeenUsers([]int64{userID})
+		if err != nil {
+			return err
+		}
+		if !hidden[userID] {
+			event.LastSeenAt = now.UTC().Format(time.RFC3339)
+		}
+	}
+	friends, err := sharedDB.GetFriendList(userID)
+	if err != nil {
+		return err
+	}
+	friendIDs := make([]int64, 0, len(friends))
+	for _, friend := range friends {
+		friendIDs = append(friendIDs, friend.UserID)
+	}
+	return deliverEphemeralResponse(friendIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_PresenceEvent{PresenceEvent: event},
+	})
+}
+
+// presenceTransition 判断设备上下线后用户整体状态是否发生变化，onlineDevices 为变化后的在线设备数。
+func presenceTransition(online bool, onlineDevices int) bool {
+	if online {
+		return onlineDevices == 1
+	}
+	return onlineDevices == 0
+}
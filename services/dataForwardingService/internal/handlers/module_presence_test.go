@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"testing"
+
+	sharedDB "Betterfly2/shared/db"
+)
+
+func TestVisiblePresenceUserIDsKeepsSelfAndFriendsInRequestOrder(t *testing.T) {
+	friends := []sharedDB.FriendContact{{UserID: 1002}, {UserID: 1003}}
+	got := visiblePresenceUserIDs(1001, []int64{1003, 9999, 1001, 1003, 0, 1002}, friends)
+	want := []int64{1003, 1001, 1002}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected visible users: %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected visible users: got %v want %v", got, want)
+		}
+	}
+}
+
+func TestPresenceTransitionOnlyOnFirstAndLastDevice(t *testing.T) {
+	tests := []struct {
+		online        bool
+		onlineDevices int
+		want          bool
+	}{
+		{online: true, onlineDevices: 1, want: true},
+		{online: true, onlineDevices: 2, want: false},
+		{online: false, onlineDevices: 0, want: true},
+		{online: false, onlineDevices: 1, want: false},
+	}
+	for _, test := range tests {
+		if got := presenceTransition(test.online, test.onlineDevices); got != test.want {
+			t.Fatalf("presenceTransition(%t, %d) = %t, want %t", test.online, test.onlineDevices, got, test.want)
+		}
+	}
+}
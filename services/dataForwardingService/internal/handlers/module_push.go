@@ -23,6 +23,7 @@ func registerPushRequestModule(router *dispatch.OneofRouter[dfRequestContext, df
 					FromKafkaTopic: currentContainerTopic(),
 					UserId:         ctx.fromID,
 					Request:        payload,
+					RequestId:      ctx.message.GetRequestId(),
 				},
 			},
 		}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/proto/envelope"
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/mq"
+	"data_forwarding_service/internal/publisher"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DeliverReactionEvent pushes a changed reaction to the message's other readers.
+// Offline readers converge through inbox sync, which storage re-queues on every change.
+func DeliverReactionEvent(event *pb.ReactionEvent, readerUserIDs []int64) error {
+	if event == nil || event.GetResult() != pb.MessageReactionResult_MESSAGE_REACTION_OK || event.GetMessageId() <= 0 {
+		return fmt.Errorf("待投递的消息回应事件无效")
+	}
+	if !event.GetChanged() {
+		return nil
+	}
+	targetIDs := recallTargetsWithoutOperator(readerUserIDs, event.GetOperatorUserId())
+	if len(targetIDs) == 0 {
+		return nil
+	}
+
+	response := &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_ReactionEvent{ReactionEvent: reactionEventForOtherReaders(event)},
+	}
+	return deliverConversationEvent(targetIDs, response, func(topic string, topicTargets []int64) error {
+		return publishReactionDelivery(topic, topicTargets, response)
+	})
+}
+
+// reactionEventForOtherReaders 清除相对于操作者计算的 reacted_by_me，避免接收方误以为自己也回应过
+func reactionEventForOtherReaders(event *pb.ReactionEvent) *pb.ReactionEvent {
+	broadcast := proto.Clone(event).(*pb.ReactionEvent)
+	for _, reaction := range broadcast.GetReactions() {
+		reaction.ReactedByMe = false
+	}
+	return broadcast
+}
+
+func publishReactionDelivery(topic string, targetUserIDs []int64, response *pb.ResponseMessage) error {
+	if topic == "" || len(targetUserIDs) == 0 {
+		return nil
+	}
+	responseBytes, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+	envelopeBytes, err := mq.MarshalEnvelope(envelope.MessageType_DF_RESPONSE, &pb.DFInternalDelivery{
+		Payload: &pb.DFInternalDelivery_ClientResponseDelivery{ClientResponseDelivery: &pb.ClientResponseDelivery{
+			TargetUserIds:   targetUserIDs,
+			ResponseMessage: responseBytes,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := publisher.PublishMessage(string(envelopeBytes), topic); err != nil {
+		logger.Sugar().Errorf("跨容器发布消息回应事件失败: topic=%s targets=%d err=%v", topic, len(targetUserIDs), err)
+		return err
+	}
+	return nil
+}
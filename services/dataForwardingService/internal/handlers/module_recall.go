@@ -9,6 +9,7 @@ import (
 	"Betterfly2/shared/mq"
 	"data_forwarding_service/internal/publisher"
 	redisClient "data_forwarding_service/internal/redis"
+	routerpkg "data_forwarding_service/internal/router"
 	"errors"
 	"fmt"
 	"strconv"
@@ -33,6 +34,8 @@ func DeliverMessageRecall(event *pb.MessageRecallEvent) error {
 	} else {
 		targetIDs = []int64{event.GetToUserId()}
 	}
+	// 群管理员撤回他人消息时自己也可能有未确认的条目，按撤回前的完整接收者清理
+	removePendingDelivery(event.GetMessageId(), targetIDs)
 	targetIDs = recallTargetsWithoutOperator(targetIDs, event.GetOperatorUserId())
 	if len(targetIDs) == 0 {
 		return nil
@@ -41,6 +44,15 @@ func DeliverMessageRecall(event *pb.MessageRecallEvent) error {
 		return fmt.Errorf("发布消息撤回APNs请求失败: %w", err)
 	}
 
+	return deliverConversationEvent(targetIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_MessageRecallEvent{MessageRecallEvent: event},
+	}, func(topic string, topicTargets []int64) error {
+		return publishRecallDelivery(topic, topicTargets, event)
+	})
+}
+
+// deliverConversationEvent 把会话事件直接投递给本容器内的在线设备，其他容器的设备交给 publishRemote 按容器转发。
+func deliverConversationEvent(targetIDs []int64, response *pb.ResponseMessage, publishRemote func(topic string, targetUserIDs []int64) error) error {
 	userIDs := make([]string, 0, len(targetIDs))
 	userIDValues := make(map[string]int64, len(targetIDs))
 	for _, targetID := range targetIDs {
@@ -53,9 +65,7 @@ func DeliverMessageRecall(event *pb.MessageRecallEvent) error {
 		return err
 	}
 
-	responseBytes, err := proto.Marshal(&pb.ResponseMessage{
-		Payload: &pb.ResponseMessage_MessageRecallEvent{MessageRecallEvent: event},
-	})
+	responseBytes, err := proto.Marshal(response)
 	if err != nil {
 		return err
 	}
@@ -66,21 +76,19 @@ func DeliverMessageRecall(event *pb.MessageRecallEvent) error {
 	}
 	crossContainerTargets := make(map[string][]int64)
 	for _, userID := range userIDs {
-		topic := routes[userID]
-		if topic == "" {
-			continue
-		}
-		if topic == currentTopic {
-			if err := wsHandler.SendMessage(userID, responseBytes); err != nil {
-				return err
+		for _, topic := range routes[userID] {
+			if topic == currentTopic {
+				if err := wsHandler.DeliverLocally(userID, responseBytes); err != nil && !errors.Is(err, routerpkg.ErrUserOffline) {
+					return err
+				}
+				continue
 			}
-			continue
+			crossContainerTargets[topic] = append(crossContainerTargets[topic], userIDValues[userID])
 		}
-		crossContainerTargets[topic] = append(crossContainerTargets[topic], userIDValues[userID])
 	}
 
 	for topic, topicTargets := range crossContainerTargets {
-		if err := publishRecallDelivery(topic, topicTargets, event); err != nil {
+		if err := publishRemote(topic, topicTargets); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	storage "Betterfly2/proto/storage"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	routerpkg "data_forwarding_service/internal/router"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// maxReceiptMessageIDs 与存储侧单次回执上限一致，超出部分直接拒绝而不是静默截断
+const maxReceiptMessageIDs = 200
+
+func init() {
+	registerDFRequestModule(registerReceiptRequestModule)
+}
+
+func registerReceiptRequestModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_MarkDelivered) (dfRequestResult, error) {
+		payload, err := authenticatedPayload(ctx.fromID, ctx.message, "回执消息送达", "mark_delivered", (*pb.RequestMessage).GetMarkDelivered)
+		if err != nil {
+			return dfRequestResult{}, err
+		}
+		return dfRequestResult{}, handleMarkReceipts(ctx.fromID, false, payload.GetMessageIds(), payload.GetWatermark())
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_MarkRead) (dfRequestResult, error) {
+		payload, err := authenticatedPayload(ctx.fromID, ctx.message, "回执消息已读", "mark_read", (*pb.RequestMessage).GetMarkRead)
+		if err != nil {
+			return dfRequestResult{}, err
+		}
+		return dfRequestResult{}, handleMarkReceipts(ctx.fromID, true, payload.GetMessageIds(), payload.GetWatermark())
+	})
+}
+
+func handleMarkReceipts(fromID int64, read bool, messageIDs []int64, watermark *pb.ReceiptWatermark) error {
+	storeReq, err := buildMarkReceiptsStorageRequest(fromID, read, messageIDs, watermark, currentContainerTopic())
+	if err != nil {
+		return err
+	}
+	// 回执处理结果只推送给消息发送者，请求方不会收到应答，无需携带 request_id
+	if err := publishStorageRequest(storeReq, ""); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("消息回执请求已发送到storageService: user_id=%d read=%t message_ids=%d", fromID, read, len(messageIDs))
+	return nil
+}
+
+func buildMarkReceiptsStorageRequest(fromID int64, read bool, messageIDs []int64, watermark *pb.ReceiptWatermark, responseTopic string) (*storage.RequestMessage, error) {
+	mark := &storage.MarkMessageReceipts{Read: read}
+	switch {
+	case len(messageIDs) > maxReceiptMessageIDs:
+		return nil, fmt.Errorf("单次回执的消息数不能超过%d", maxReceiptMessageIDs)
+	case len(messageIDs) > 0:
+		mark.MessageIds = messageIDs
+	case watermark.GetConversationId() > 0 && watermark.GetUpToMessageId() > 0:
+		mark.ConversationId = watermark.GetConversationId()
+		mark.IsGroup = watermark.GetIsGroup()
+		mark.UpToMessageId = watermark.GetUpToMessageId()
+	default:
+		return nil, fmt.Errorf("回执需要指定message_ids或会话水位线")
+	}
+	request := newStorageRequest(responseTopic, fromID)
+	request.Payload = &storage.RequestMessage_MarkMessageReceipts{MarkMessageReceipts: mark}
+	return request, nil
+}
+
+// DeliverMessageReceipts 把存储层确认的回执按发送者和会话聚合为 ReceiptEvent，
+// 通过路由推送到发送者的所有在线设备。回执只做实时投递，发送者离线时不补发。
+func DeliverMessageReceipts(receipts *storage.MessageReceiptsRsp) error {
+	handler := GetWebSocketHandler()
+	if handler == nil {
+		return errors.New("WebSocket处理器未初始化")
+	}
+	var firstErr error
+	for key, event := range buildReceiptEvents(receipts) {
+		responseBytes, err := proto.Marshal(&pb.ResponseMessage{
+			Payload: &pb.ResponseMessage_ReceiptEvent{ReceiptEvent: event},
+		})
+		if err != nil {
+			return err
+		}
+		err = handler.SendMessage(strconv.FormatInt(key.userID, 10), responseBytes)
+		if err != nil && !errors.Is(err, routerpkg.ErrUserOffline) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type receiptEventKey struct {
+	userID         int64
+	conversationID int64
+	isGroup        bool
+}
+
+func buildReceiptEvents(receipts *storage.MessageReceiptsRsp) map[receiptEventKey]*pb.ReceiptEvent {
+	events := make(map[receiptEventKey]*pb.ReceiptEvent)
+	receiptType := pb.ReceiptType_RECEIPT_DELIVERED
+	if receipts.GetRead() {
+		receiptType = pb.ReceiptType_RECEIPT_READ
+	}
+	for _, update := range receipts.GetUpdates() {
+		if update.GetFromUserId() <= 0 || update.GetFromUserId() == receipts.GetReaderUserId() {
+			continue
+		}
+		key := receiptEventKey{userID: update.GetFromUserId(), conversationID: receipts.GetReaderUserId()}
+		if update.GetIsGroup() {
+			key.conversationID = update.GetToUserId()
+			key.isGroup = true
+		}
+		event, exists := events[key]
+		if !exists {
+			event = &pb.ReceiptEvent{
+				Type:           receiptType,
+				ReaderUserId:   receipts.GetReaderUserId(),
+				ConversationId: key.conversationID,
+				IsGroup:        key.isGroup,
+				ReceiptedAt:    receipts.GetReceiptedAt(),
+			}
+			events[key] = event
+		}
+		event.Messages = append(event.Messages, &pb.MessageReceiptState{
+			MessageId:      update.GetMessageId(),
+			DeliveredCount: update.GetDeliveredCount(),
+			ReadCount:      update.GetReadCount(),
+		})
+	}
+	for _, event := range events {
+		sort.Slice(event.Messages, func(i, j int) bool { return event.Messages[i].GetMessageId() < event.Messages[j].GetMessageId() })
+	}
+	return events
+}
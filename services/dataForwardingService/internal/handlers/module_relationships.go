@@ -52,7 +52,7 @@ func handleQueryFriendRequests(fromID int64, message *pb.RequestMessage) error {
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_QueryFriendRequests{QueryFriendRequests: &friend.QueryFriendRequests{UserId: fromID, IncludeOutgoing: payload.GetIncludeOutgoing()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleResolveFriendRequest(fromID int64, message *pb.RequestMessage) error {
@@ -66,7 +66,7 @@ func handleResolveFriendRequest(fromID int64, message *pb.RequestMessage) error
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_ResolveFriendRequest{ResolveFriendRequest: &friend.ResolveFriendRequest{UserId: fromID, RequestId: payload.GetRequestId(), Decision: decision}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleQueryGroupJoinRequests(fromID int64, message *pb.RequestMessage) error {
@@ -79,7 +79,7 @@ func handleQueryGroupJoinRequests(fromID int64, message *pb.RequestMessage) erro
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_QueryGroupJoinRequests{QueryGroupJoinRequests: &friend.QueryGroupJoinRequests{RequestUserId: fromID, GroupId: payload.GetTargetGroupId()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleResolveGroupJoinRequest(fromID int64, message *pb.RequestMessage) error {
@@ -93,7 +93,7 @@ func handleResolveGroupJoinRequest(fromID int64, message *pb.RequestMessage) err
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_ResolveGroupJoinRequest{ResolveGroupJoinRequest: &friend.ResolveGroupJoinRequest{RequestUserId: fromID, RequestId: payload.GetRequestId(), Decision: decision}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleInviteGroupMember(fromID int64, message *pb.RequestMessage) error {
@@ -106,7 +106,7 @@ func handleInviteGroupMember(fromID int64, message *pb.RequestMessage) error {
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_InviteGroupMember{InviteGroupMember: &friend.InviteGroupMember{RequestUserId: fromID, GroupId: payload.GetTargetGroupId(), UserId: payload.GetTargetUserId(), Message: payload.GetMessage()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleQueryGroupInvitations(fromID int64, message *pb.RequestMessage) error {
@@ -116,7 +116,7 @@ func handleQueryGroupInvitations(fromID int64, message *pb.RequestMessage) error
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_QueryGroupInvitations{QueryGroupInvitations: &friend.QueryGroupInvitations{UserId: fromID, IncludeOutgoing: payload.GetIncludeOutgoing()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleResolveGroupInvitation(fromID int64, message *pb.RequestMessage) error {
@@ -130,7 +130,7 @@ func handleResolveGroupInvitation(fromID int64, message *pb.RequestMessage) erro
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_ResolveGroupInvitation{ResolveGroupInvitation: &friend.ResolveGroupInvitation{UserId: fromID, InvitationId: payload.GetInvitationId(), Decision: decision}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleKickGroupMember(fromID int64, message *pb.RequestMessage) error {
@@ -143,7 +143,7 @@ func handleKickGroupMember(fromID int64, message *pb.RequestMessage) error {
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_KickGroupMember{KickGroupMember: &friend.KickGroupMember{RequestUserId: fromID, GroupId: payload.GetTargetGroupId(), UserId: payload.GetTargetUserId()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleUpdateGroupMemberRole(fromID int64, message *pb.RequestMessage) error {
@@ -156,7 +156,7 @@ func handleUpdateGroupMemberRole(fromID int64, message *pb.RequestMessage) error
 	}
 	req := newFriendRequest(currentContainerTopic(), fromID)
 	req.Payload = &friend.RequestMessage_UpdateGroupMemberRole{UpdateGroupMemberRole: &friend.UpdateGroupMemberRole{RequestUserId: fromID, GroupId: payload.GetTargetGroupId(), UserId: payload.GetTargetUserId(), Role: payload.GetRole()}}
-	return publishFriendRequest(req)
+	return publishFriendRequest(req, message.GetRequestId())
 }
 
 func handleUpdateGroupName(fromID int64, message *pb.RequestMessage) error {
@@ -167,7 +167,7 @@ func handleUpdateGroupName(fromID int64, message *pb.RequestMessage) error {
 	if payload.GetTargetGroupId() <= 0 {
 		return errors.New("群名称修改参数非法")
 	}
-	return publishFriendRequest(buildUpdateGroupNameFriendRequest(fromID, payload.GetTargetGroupId(), payload.GetNewGroupName(), currentContainerTopic()))
+	return publishFriendRequest(buildUpdateGroupNameFriendRequest(fromID, payload.GetTargetGroupId(), payload.GetNewGroupName(), currentContainerTopic()), message.GetRequestId())
 }
 
 func buildUpdateGroupNameFriendRequest(fromID, groupID int64, groupName, topic string) *friend.RequestMessage {
@@ -188,7 +188,7 @@ func handleTransferGroupOwner(fromID int64, message *pb.RequestMessage) error {
 	if payload.GetTargetGroupId() <= 0 || payload.GetTargetUserId() <= 0 || payload.GetTargetUserId() == fromID {
 		return errors.New("群主转让参数非法")
 	}
-	return publishFriendRequest(buildTransferGroupOwnerFriendRequest(fromID, payload.GetTargetGroupId(), payload.GetTargetUserId(), currentContainerTopic()))
+	return publishFriendRequest(buildTransferGroupOwnerFriendRequest(fromID, payload.GetTargetGroupId(), payload.GetTargetUserId(), currentContainerTopic()), message.GetRequestId())
 }
 
 func buildTransferGroupOwnerFriendRequest(fromID, groupID, targetUserID int64, topic string) *friend.RequestMessage {
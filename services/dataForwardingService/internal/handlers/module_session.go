@@ -6,6 +6,7 @@ import (
 	"Betterfly2/shared/dispatch"
 	"Betterfly2/shared/logger"
 	"context"
+	"strconv"
 	"time"
 )
 
@@ -16,7 +17,7 @@ func init() {
 func registerSessionRequestModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
 	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_Logout) (dfRequestResult, error) {
 		if payload.Logout.GetScope() == pb.LogoutScope_CURRENT_CONNECTION {
-			logger.Sugar().Infof("用户登出当前连接: user_id=%d", ctx.fromID)
+			logger.Sugar().Infof("用户登出当前设备: user_id=%d", ctx.fromID)
 			return dfRequestResult{code: 1}, nil
 		}
 		if payload.Logout.GetScope() != pb.LogoutScope_ALL_SESSIONS {
@@ -44,7 +45,8 @@ func registerSessionRequestModule(router *dispatch.OneofRouter[dfRequestContext,
 			}
 			return logoutWarning(message), nil
 		}
-		logger.Sugar().Infof("用户撤销全部会话并登出当前连接: user_id=%d", ctx.fromID)
+		kickAllDeviceSessions(ctx.fromID)
+		logger.Sugar().Infof("用户撤销全部会话并登出所有设备: user_id=%d", ctx.fromID)
 		return dfRequestResult{code: 1}, nil
 	})
 	dispatch.Register(router, func(_ dfRequestContext, payload *pb.RequestMessage_Login) (dfRequestResult, error) {
@@ -55,6 +57,25 @@ func registerSessionRequestModule(router *dispatch.OneofRouter[dfRequestContext,
 		logger.Sugar().Warnf("收到认证服务请求，不处理：%+v", payload)
 		return dfRequestResult{}, nil
 	})
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_ResumeSession) (dfRequestResult, error) {
+		// 续传由 WebSocketHandler 在连接上直接处理，其他入口无法补发帧
+		logger.Sugar().Warnf("收到非WebSocket连接的续传请求，不处理: user_id=%d", ctx.fromID)
+		return dfRequestResult{}, nil
+	})
+}
+
+// kickAllDeviceSessions 令牌已在认证服务吊销，这里只是让各设备连接立即断开；
+// 通知失败时这些设备会在下次认证时被拒绝，因此不影响登出结果。
+func kickAllDeviceSessions(userID int64) {
+	handler := GetWebSocketHandler()
+	if handler == nil {
+		return
+	}
+	kickCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := handler.connManager.KickUserSessions(kickCtx, strconv.FormatInt(userID, 10)); err != nil {
+		logger.Sugar().Warnw("通知设备会话下线失败", "user_id", userID, "error", err)
+	}
 }
 
 func logoutWarning(message string) dfRequestResult {
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	sharedDB "Betterfly2/shared/db"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// signalRepeatInterval 内同一发送者在同一会话重复发送相同信号只转发一次
+	signalRepeatInterval = 3 * time.Second
+	// signalSwitchInterval 是切换信号类型的最小间隔，TYPING_STOPPED 不受限制以便及时清除对端状态
+	signalSwitchInterval = 300 * time.Millisecond
+	// signalThrottleSweepSize 节流表超过该大小时清理已过期的条目
+	signalThrottleSweepSize = 4096
+)
+
+type signalThrottleKey struct {
+	senderID       int64
+	conversationID int64
+	isGroup        bool
+}
+
+type signalThrottleState struct {
+	kind pb.ConversationSignalKind
+	at   time.Time
+}
+
+// signalThrottle 按 (发送者, 会话) 记录最近一次转发的信号。节流状态只保存在本容器内，
+// 同一用户的设备分布在多个容器时上限按容器数放大，对临时信号可以接受。
+type signalThrottle struct {
+	mu   sync.Mutex
+	last map[signalThrottleKey]signalThrottleState
+}
+
+var conversationSignalThrottle = newSignalThrottle()
+
+func newSignalThrottle() *signalThrottle {
+	return &signalThrottle{last: make(map[signalThrottleKey]signalThrottleState)}
+}
+
+func (t *signalThrottle) allow(key signalThrottleKey, kind pb.ConversationSignalKind, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, exists := t.last[key]; exists {
+		elapsed := now.Sub(state.at)
+		if state.kind == kind && elapsed < signalRepeatInterval {
+			return false
+		}
+		if state.kind != kind && kind != pb.ConversationSignalKind_TYPING_STOPPED && elapsed < signalSwitchInterval {
+			return false
+		}
+	}
+	if len(t.last) >= signalThrottleSweepSize {
+		for staleKey, state := range t.last {
+			if now.Sub(state.at) >= signalRepeatInterval {
+				delete(t.last, staleKey)
+			}
+		}
+	}
+	t.last[key] = signalThrottleState{kind: kind, at: now}
+	return true
+}
+
+func init() {
+	registerDFRequestModule(registerSignalRequestModule)
+}
+
+func registerSignalRequestModule(router *dispatch.OneofRouter[dfRequestContext, dfRequestResult]) {
+	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_ConversationSignal) (dfRequestResult, error) {
+		payload, err := authenticatedPayload(ctx.fromID, ctx.message, "发送会话信号", "conversation_signal", (*pb.RequestMessage).GetConversationSignal)
+		if err != nil {
+			return dfRequestResult{}, err
+		}
+		return dfRequestResult{}, handleConversationSignal(ctx.fromID, payload, time.Now())
+	})
+}
+
+// handleConversationSignal 把输入状态等临时信号实时转发给会话内其他在线成员。
+// 信号不经过 storageService，也不分配会话序列号，接收方离线或断线期间的信号直接丢弃。
+func handleConversationSignal(fromID int64, signal *pb.ConversationSignal, now time.Time) error {
+	if err := validateConversationSignal(fromID, signal); err != nil {
+		return err
+	}
+	key := signalThrottleKey{senderID: fromID, conversationID: signal.GetConversationId(), isGroup: signal.GetIsGroup()}
+	if !conversationSignalThrottle.allow(key, signal.GetKind(), now) {
+		logger.Sugar().Debugf("会话信号被节流: from=%d conversation=%d is_group=%t kind=%s", fromID, key.conversationID, key.isGroup, signal.GetKind())
+		return nil
+	}
+
+	var targetIDs []int64
+	if signal.GetIsGroup() {
+		isMember, err := sharedDB.IsActiveGroupMember(signal.GetConversationId(), fromID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errors.New("当前用户不在该群中，无法发送会话信号")
+		}
+		memberIDs, err := sharedDB.GetActiveGroupMemberIDs(signal.GetConversationId())
+		if err != nil {
+			return err
+		}
+		targetIDs = membersWithoutSender(memberIDs, fromID)
+	} else {
+		// 对方删除好友后不再接收输入状态，避免向陌生人暴露在线与输入动态
+		isFriend, err := sharedDB.IsActiveFriend(signal.GetConversationId(), fromID)
+		if err != nil {
+			return err
+		}
+		if !isFriend {
+			return errors.New("对方不是好友，无法发送会话信号")
+		}
+		targetIDs = []int64{signal.GetConversationId()}
+	}
+	return deliverEphemeralResponse(targetIDs, &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_ConversationSignalEvent{ConversationSignalEvent: buildConversationSignalEvent(fromID, signal, now)},
+	})
+}
+
+func validateConversationSignal(fromID int64, signal *pb.ConversationSignal) error {
+	if signal.GetConversationId() <= 0 {
+		return errors.New("会话信号缺少会话ID")
+	}
+	if !signal.GetIsGroup() && signal.GetConversationId() == fromID {
+		return errors.New("不能向自己发送会话信号")
+	}
+	if _, known := pb.ConversationSignalKind_name[int32(signal.GetKind())]; !known {
+		return fmt.Errorf("未知的会话信号类型: %d", signal.GetKind())
+	}
+	return nil
+}
+
+func buildConversationSignalEvent(fromID int64, signal *pb.ConversationSignal, now time.Time) *pb.ConversationSignalEvent {
+	event := &pb.ConversationSignalEvent{
+		FromUserId:     fromID,
+		ConversationId: fromID,
+		IsGroup:        signal.GetIsGroup(),
+		Kind:           signal.GetKind(),
+		SentAt:         now.UTC().Format(time.RFC3339),
+	}
+	if signal.GetIsGroup() {
+		event.ConversationId = signal.GetConversationId()
+	}
+	return event
+}
@@ -3,10 +3,14 @@ package handlers
 import (
 	pb "Betterfly2/proto/data_forwarding"
 	storage "Betterfly2/proto/storage"
+	sharedDB "Betterfly2/shared/db"
 	"Betterfly2/shared/dispatch"
 	"Betterfly2/shared/logger"
 	"data_forwarding_service/internal/monitor"
+	"errors"
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
 func init() {
@@ -22,10 +26,54 @@ func registerStorageRequestModules(router *dispatch.OneofRouter[dfRequestContext
 		logger.Sugar().Debugf("收到 QuerySyncMessages 消息: to_user_id=%d", payload.QuerySyncMessages.GetToUserId())
 		return dfRequestResult{}, handleQuerySyncMessages(ctx.fromID, ctx.message)
 	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_QueryConversations) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 QueryConversations 消息: page_size=%d", payload.QueryConversations.GetPageSize())
+		return dfRequestResult{}, handleQueryConversations(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_QueryConversationHistory) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 QueryConversationHistory 消息: peer_or_group_id=%d is_group=%t before_message_id=%d",
+			payload.QueryConversationHistory.GetPeerOrGroupId(), payload.QueryConversationHistory.GetIsGroup(), payload.QueryConversationHistory.GetBeforeMessageId())
+		return dfRequestResult{}, handleQueryConversationHistory(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_QueryMentionedMessages) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 QueryMentionedMessages 消息: before_message_id=%d", payload.QueryMentionedMessages.GetBeforeMessageId())
+		return dfRequestResult{}, handleQueryMentionedMessages(ctx.fromID, ctx.message)
+	})
 	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_RecallMessage) (dfRequestResult, error) {
 		logger.Sugar().Debugf("收到 RecallMessage 消息: message_id=%d", payload.RecallMessage.GetMessageId())
 		return dfRequestResult{}, handleRecallMessage(ctx.fromID, ctx.message)
 	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_EditMessage) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 EditMessage 消息: message_id=%d", payload.EditMessage.GetMessageId())
+		return dfRequestResult{}, handleEditMessage(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_ReactToMessage) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 ReactToMessage 消息: message_id=%d remove=%t", payload.ReactToMessage.GetMessageId(), payload.ReactToMessage.GetRemove())
+		return dfRequestResult{}, handleReactToMessage(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_PinMessage) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 PinMessage 消息: message_id=%d", payload.PinMessage.GetMessageId())
+		return dfRequestResult{}, handlePinMessage(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_UnpinMessage) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 UnpinMessage 消息: message_id=%d", payload.UnpinMessage.GetMessageId())
+		return dfRequestResult{}, handleUnpinMessage(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_QueryPinnedMessages) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 QueryPinnedMessages 消息: peer_or_group_id=%d is_group=%t",
+			payload.QueryPinnedMessages.GetPeerOrGroupId(), payload.QueryPinnedMessages.GetIsGroup())
+		return dfRequestResult{}, handleQueryPinnedMessages(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_SearchMessages) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 SearchMessages 消息: peer_or_group_id=%d is_group=%t before_message_id=%d",
+			payload.SearchMessages.GetPeerOrGroupId(), payload.SearchMessages.GetIsGroup(), payload.SearchMessages.GetBeforeMessageId())
+		return dfRequestResult{}, handleSearchMessages(ctx.fromID, ctx.message)
+	})
+	dispatch.Register(router, func(ctx dfRequestContext, payload *pb.RequestMessage_ForwardMessages) (dfRequestResult, error) {
+		logger.Sugar().Debugf("收到 ForwardMessages 消息: sources=%d target_id=%d is_group=%t merged=%t",
+			len(payload.ForwardMessages.GetSourceMessageIds()), payload.ForwardMessages.GetTargetId(), payload.ForwardMessages.GetIsGroup(), payload.ForwardMessages.GetMerged())
+		return dfRequestResult{}, handleForwardMessages(ctx.fromID, ctx.message)
+	})
 	dispatch.Register(router, func(ctx dfRequestContext, _ *pb.RequestMessage_QueryUser) (dfRequestResult, error) {
 		logger.Sugar().Debugf("收到 QueryUser 消息")
 		return dfRequestResult{}, handleQueryUser(ctx.fromID, ctx.message)
@@ -50,7 +98,7 @@ func handleRecallMessage(fromID int64, message *pb.RequestMessage) error {
 	}
 
 	storeReq := buildRecallMessageStorageRequest(fromID, payload.GetMessageId(), currentContainerTopic())
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		return err
 	}
 	logger.Sugar().Debugf("消息撤回请求已发送到storageService: operator_user_id=%d message_id=%d", fromID, payload.GetMessageId())
@@ -63,6 +111,158 @@ func buildRecallMessageStorageRequest(fromID, messageID int64, responseTopic str
 	return request
 }
 
+func handleEditMessage(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "编辑消息", "edit_message", (*pb.RequestMessage).GetEditMessage)
+	if err != nil {
+		return err
+	}
+	if payload.GetMessageId() <= 0 {
+		return fmt.Errorf("待编辑的message_id无效")
+	}
+
+	storeReq := buildEditMessageStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("消息编辑请求已发送到storageService: operator_user_id=%d message_id=%d", fromID, payload.GetMessageId())
+	return nil
+}
+
+func buildEditMessageStorageRequest(fromID int64, payload *pb.EditMessage, responseTopic string) *storage.RequestMessage {
+	request := newStorageRequest(responseTopic, fromID)
+	request.Payload = &storage.RequestMessage_EditMessage{EditMessage: &storage.EditMessage{
+		MessageId:  payload.GetMessageId(),
+		NewContent: payload.GetNewContent(),
+	}}
+	return request
+}
+
+func handleReactToMessage(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "回应消息", "react_to_message", (*pb.RequestMessage).GetReactToMessage)
+	if err != nil {
+		return err
+	}
+	if payload.GetMessageId() <= 0 {
+		return fmt.Errorf("待回应的message_id无效")
+	}
+
+	storeReq := buildReactToMessageStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("消息回应请求已发送到storageService: operator_user_id=%d message_id=%d remove=%t", fromID, payload.GetMessageId(), payload.GetRemove())
+	return nil
+}
+
+func buildReactToMessageStorageRequest(fromID int64, payload *pb.ReactToMessage, responseTopic string) *storage.RequestMessage {
+	request := newStorageRequest(responseTopic, fromID)
+	request.Payload = &storage.RequestMessage_ReactToMessage{ReactToMessage: &storage.ReactToMessage{
+		MessageId: payload.GetMessageId(),
+		Emoji:     payload.GetEmoji(),
+		Remove:    payload.GetRemove(),
+	}}
+	return request
+}
+
+// handlePinMessage 把置顶请求交给storageService，群管理员身份和置顶上限由storage在事务内校验
+func handlePinMessage(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "置顶消息", "pin_message", (*pb.RequestMessage).GetPinMessage)
+	if err != nil {
+		return err
+	}
+	if payload.GetMessageId() <= 0 {
+		return fmt.Errorf("待置顶的message_id无效")
+	}
+
+	storeReq := buildPinMessageStorageRequest(fromID, payload.GetMessageId(), false, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("消息置顶请求已发送到storageService: operator_user_id=%d message_id=%d", fromID, payload.GetMessageId())
+	return nil
+}
+
+func handleUnpinMessage(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "取消置顶消息", "unpin_message", (*pb.RequestMessage).GetUnpinMessage)
+	if err != nil {
+		return err
+	}
+	if payload.GetMessageId() <= 0 {
+		return fmt.Errorf("待取消置顶的message_id无效")
+	}
+
+	storeReq := buildPinMessageStorageRequest(fromID, payload.GetMessageId(), true, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("取消置顶请求已发送到storageService: operator_user_id=%d message_id=%d", fromID, payload.GetMessageId())
+	return nil
+}
+
+func buildPinMessageStorageRequest(fromID, messageID int64, unpin bool, responseTopic string) *storage.RequestMessage {
+	request := newStorageRequest(responseTopic, fromID)
+	if unpin {
+		request.Payload = &storage.RequestMessage_UnpinMessage{UnpinMessage: &storage.UnpinMessage{MessageId: messageID}}
+	} else {
+		request.Payload = &storage.RequestMessage_PinMessage{PinMessage: &storage.PinMessage{MessageId: messageID}}
+	}
+	return request
+}
+
+// handleForwardMessages 把转发请求交给storageService，源消息的读权限由storage逐条校验
+func handleForwardMessages(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "转发消息", "forward_messages", (*pb.RequestMessage).GetForwardMessages)
+	if err != nil {
+		return err
+	}
+	if err := validateForwardMessagesPayload(payload); err != nil {
+		return err
+	}
+	if payload.GetIsGroup() {
+		isMember, err := sharedDB.IsActiveGroupMember(payload.GetTargetId(), fromID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errors.New("当前用户不在该群中，无法转发到该群")
+		}
+	}
+
+	storeReq := buildForwardMessagesStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		return err
+	}
+	logger.Sugar().Debugf("消息转发请求已发送到storageService: from=%d target_id=%d sources=%d merged=%t", fromID, payload.GetTargetId(), len(payload.GetSourceMessageIds()), payload.GetMerged())
+	return nil
+}
+
+func validateForwardMessagesPayload(payload *pb.ForwardMessages) error {
+	sourceCount := len(payload.GetSourceMessageIds())
+	if sourceCount == 0 || sourceCount > sharedDB.MaxForwardSourceMessages {
+		return fmt.Errorf("转发的消息数量必须在1到%d之间", sharedDB.MaxForwardSourceMessages)
+	}
+	if payload.GetTargetId() <= 0 || monitor.IsMonitorID(payload.GetTargetId()) {
+		return errors.New("转发目标无效")
+	}
+	// 逐条转发的幂等ID会追加 "#<序号>"，预留后缀长度
+	if len(strings.TrimSpace(payload.GetClientMessageId())) > 120 {
+		return errors.New("client_message_id长度超过限制")
+	}
+	return nil
+}
+
+func buildForwardMessagesStorageRequest(fromID int64, payload *pb.ForwardMessages, responseTopic string) *storage.RequestMessage {
+	request := newStorageRequest(responseTopic, fromID)
+	request.Payload = &storage.RequestMessage_ForwardMessages{ForwardMessages: &storage.ForwardMessages{
+		SourceMessageIds: payload.GetSourceMessageIds(),
+		TargetId:         payload.GetTargetId(),
+		IsGroup:          payload.GetIsGroup(),
+		Merged:           payload.GetMerged(),
+		ClientMessageId:  strings.TrimSpace(payload.GetClientMessageId()),
+	}}
+	return request
+}
+
 // handleQueryMessage 处理查询单条消息请求
 func handleQueryMessage(fromID int64, message *pb.RequestMessage) error {
 	payload, err := authenticatedPayload(fromID, message, "查询消息", "query_message", (*pb.RequestMessage).GetQueryMessage)
@@ -79,7 +279,7 @@ func handleQueryMessage(fromID int64, message *pb.RequestMessage) error {
 		},
 	}
 
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布查询请求到storage-service失败: %v", err)
 		return err
 	}
@@ -99,7 +299,7 @@ func handleQuerySyncMessages(fromID int64, message *pb.RequestMessage) error {
 		return err
 	}
 
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布同步查询请求到storage-service失败: %v", err)
 		return err
 	}
@@ -128,11 +328,14 @@ func buildSyncMessagesStorageRequest(fromID int64, payload *pb.QuerySyncMessages
 	req := newStorageRequest(currentContainerID, fromID)
 	req.Payload = &storage.RequestMessage_QuerySyncMessages{
 		QuerySyncMessages: &storage.QuerySyncMessages{
-			ToUserId:        fromID,
-			Timestamp:       payload.GetTimestamp(),
-			PageSize:        payload.GetPageSize(),
-			CursorTimestamp: payload.GetCursorTimestamp(),
-			CursorMessageId: payload.GetCursorMessageId(),
+			ToUserId:         fromID,
+			Timestamp:        payload.GetTimestamp(),
+			PageSize:         payload.GetPageSize(),
+			CursorTimestamp:  payload.GetCursorTimestamp(),
+			CursorMessageId:  payload.GetCursorMessageId(),
+			UseInboxSeq:      payload.GetUseInboxSeq(),
+			AfterSeq:         payload.GetAfterSeq(),
+			AfterReactionSeq: payload.GetAfterReactionSeq(),
 		},
 	}
 	return req
@@ -145,6 +348,171 @@ func validateSyncMessagesTarget(fromID, requestedUserID int64) error {
 	return nil
 }
 
+// handleQueryConversations 处理会话列表请求，只能查询当前登录用户自己的会话
+func handleQueryConversations(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "查询会话列表", "query_conversations", (*pb.RequestMessage).GetQueryConversations)
+	if err != nil {
+		return err
+	}
+
+	storeReq := buildConversationsStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		logger.Sugar().Errorf("发布会话列表查询请求到storage-service失败: %v", err)
+		return err
+	}
+
+	logger.Sugar().Debugf("会话列表查询请求已发送到storageService: requester_user_id=%d", fromID)
+	return nil
+}
+
+func buildConversationsStorageRequest(fromID int64, payload *pb.QueryConversations, currentContainerID string) *storage.RequestMessage {
+	req := newStorageRequest(currentContainerID, fromID)
+	req.Payload = &storage.RequestMessage_QueryConversations{
+		QueryConversations: &storage.QueryConversations{
+			PageSize:        payload.GetPageSize(),
+			CursorTimestamp: payload.GetCursorTimestamp(),
+			CursorMessageId: payload.GetCursorMessageId(),
+		},
+	}
+	return req
+}
+
+// handleQueryConversationHistory 处理单个会话的历史消息请求，群成员资格由storageService校验
+func handleQueryConversationHistory(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "查询会话历史", "query_conversation_history", (*pb.RequestMessage).GetQueryConversationHistory)
+	if err != nil {
+		return err
+	}
+	if payload.GetPeerOrGroupId() <= 0 {
+		return fmt.Errorf("会话ID无效")
+	}
+
+	storeReq := buildConversationHistoryStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		logger.Sugar().Errorf("发布会话历史查询请求到storage-service失败: %v", err)
+		return err
+	}
+
+	logger.Sugar().Debugf("会话历史查询请求已发送到storageService: requester_user_id=%d peer_or_group_id=%d", fromID, payload.GetPeerOrGroupId())
+	return nil
+}
+
+func buildConversationHistoryStorageRequest(fromID int64, payload *pb.QueryConversationHistory, currentContainerID string) *storage.RequestMessage {
+	req := newStorageRequest(currentContainerID, fromID)
+	req.Payload = &storage.RequestMessage_QueryConversationHistory{
+		QueryConversationHistory: &storage.QueryConversationHistory{
+			PeerOrGroupId:   payload.GetPeerOrGroupId(),
+			IsGroup:         payload.GetIsGroup(),
+			BeforeMessageId: payload.GetBeforeMessageId(),
+			Limit:           payload.GetLimit(),
+		},
+	}
+	return req
+}
+
+// handleQueryMentionedMessages 处理"@我的"消息列表请求，只能查询自己被@的消息
+func handleQueryMentionedMessages(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "查询@我的消息", "query_mentioned_messages", (*pb.RequestMessage).GetQueryMentionedMessages)
+	if err != nil {
+		return err
+	}
+
+	storeReq := buildMentionedMessagesStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		logger.Sugar().Errorf("发布@我的消息查询请求到storage-service失败: %v", err)
+		return err
+	}
+
+	logger.Sugar().Debugf("@我的消息查询请求已发送到storageService: requester_user_id=%d before_message_id=%d", fromID, payload.GetBeforeMessageId())
+	return nil
+}
+
+func buildMentionedMessagesStorageRequest(fromID int64, payload *pb.QueryMentionedMessages, currentContainerID string) *storage.RequestMessage {
+	req := newStorageRequest(currentContainerID, fromID)
+	req.Payload = &storage.RequestMessage_QueryMentionedMessages{
+		QueryMentionedMessages: &storage.QueryMentionedMessages{
+			BeforeMessageId: payload.GetBeforeMessageId(),
+			Limit:           payload.GetLimit(),
+		},
+	}
+	return req
+}
+
+// handleQueryPinnedMessages 处理会话置顶消息列表请求，群成员资格由storageService校验
+func handleQueryPinnedMessages(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "查询置顶消息", "query_pinned_messages", (*pb.RequestMessage).GetQueryPinnedMessages)
+	if err != nil {
+		return err
+	}
+	if payload.GetPeerOrGroupId() <= 0 {
+		return fmt.Errorf("会话ID无效")
+	}
+
+	storeReq := buildPinnedMessagesStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		logger.Sugar().Errorf("发布置顶消息查询请求到storage-service失败: %v", err)
+		return err
+	}
+
+	logger.Sugar().Debugf("置顶消息查询请求已发送到storageService: requester_user_id=%d peer_or_group_id=%d", fromID, payload.GetPeerOrGroupId())
+	return nil
+}
+
+func buildPinnedMessagesStorageRequest(fromID int64, payload *pb.QueryPinnedMessages, currentContainerID string) *storage.RequestMessage {
+	req := newStorageRequest(currentContainerID, fromID)
+	req.Payload = &storage.RequestMessage_QueryPinnedMessages{
+		QueryPinnedMessages: &storage.QueryPinnedMessages{
+			PeerOrGroupId: payload.GetPeerOrGroupId(),
+			IsGroup:       payload.GetIsGroup(),
+		},
+	}
+	return req
+}
+
+// handleSearchMessages 处理消息搜索请求，可读范围和时间范围由storageService校验
+func handleSearchMessages(fromID int64, message *pb.RequestMessage) error {
+	payload, err := authenticatedPayload(fromID, message, "搜索消息", "search_messages", (*pb.RequestMessage).GetSearchMessages)
+	if err != nil {
+		return err
+	}
+	query := strings.TrimSpace(payload.GetQuery())
+	if utf8.RuneCountInString(query) > sharedDB.MaxMessageSearchQueryRunes {
+		return fmt.Errorf("搜索词不能超过 %d 个字符", sharedDB.MaxMessageSearchQueryRunes)
+	}
+	if sharedDB.MessageSearchQuery(query) == "" {
+		return fmt.Errorf("搜索词不能为空")
+	}
+	if payload.GetPeerOrGroupId() < 0 {
+		return fmt.Errorf("会话ID无效")
+	}
+
+	storeReq := buildSearchMessagesStorageRequest(fromID, payload, currentContainerTopic())
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
+		logger.Sugar().Errorf("发布消息搜索请求到storage-service失败: %v", err)
+		return err
+	}
+
+	logger.Sugar().Debugf("消息搜索请求已发送到storageService: requester_user_id=%d peer_or_group_id=%d", fromID, payload.GetPeerOrGroupId())
+	return nil
+}
+
+func buildSearchMessagesStorageRequest(fromID int64, payload *pb.SearchMessages, currentContainerID string) *storage.RequestMessage {
+	req := newStorageRequest(currentContainerID, fromID)
+	req.Payload = &storage.RequestMessage_SearchMessages{
+		SearchMessages: &storage.SearchMessages{
+			Query:           strings.TrimSpace(payload.GetQuery()),
+			PeerOrGroupId:   payload.GetPeerOrGroupId(),
+			IsGroup:         payload.GetIsGroup(),
+			Since:           payload.GetSince(),
+			Until:           payload.GetUntil(),
+			MsgType:         payload.GetMsgType(),
+			BeforeMessageId: payload.GetBeforeMessageId(),
+			Limit:           payload.GetLimit(),
+		},
+	}
+	return req
+}
+
 // handleQueryUser 处理查询用户信息请求
 func handleQueryUser(fromID int64, message *pb.RequestMessage) error {
 	payload, err := authenticatedPayload(fromID, message, "查询用户信息", "query_user", (*pb.RequestMessage).GetQueryUser)
@@ -164,7 +532,7 @@ func handleQueryUser(fromID int64, message *pb.RequestMessage) error {
 		},
 	}
 
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布用户查询请求到storage-service失败: %v", err)
 		return err
 	}
@@ -190,7 +558,7 @@ func handleUpdateUserName(fromID int64, message *pb.RequestMessage) error {
 		},
 	}
 
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布用户名更新请求到storage-service失败: %v", err)
 		return err
 	}
@@ -216,7 +584,7 @@ func handleUpdateUserAvatar(fromID int64, message *pb.RequestMessage) error {
 		},
 	}
 
-	if err := publishStorageRequest(storeReq); err != nil {
+	if err := publishStorageRequest(storeReq, message.GetRequestId()); err != nil {
 		logger.Sugar().Errorf("发布用户头像更新请求到storage-service失败: %v", err)
 		return err
 	}
@@ -13,6 +13,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -28,6 +30,10 @@ type WebSocketHandler struct {
 	refreshLease    func(context.Context, string, redisClient.SessionData, time.Duration, time.Duration) error
 	lifecycleCtx    context.Context
 	lifecycleCancel context.CancelFunc
+	server          atomic.Pointer[http.Server]
+	draining        atomic.Bool
+	httpSessions    sync.Map // HTTP 回退传输的会话令牌 -> *httpSession
+	replayWriters   sync.WaitGroup
 }
 
 // NewWebSocketHandler 创建新的WebSocket处理器
@@ -45,10 +51,14 @@ func NewWebSocketHandler() *WebSocketHandler {
 		lifecycleCancel: lifecycleCancel,
 	}
 	handler.connManager.ConfigureSessionLeases(handler.config.sessionLeaseTTL, handler.config.routeLeaseTTL)
+	handler.connManager.SetPresenceListener(handler.onPresenceChange)
+	handler.connManager.SetFrameAdapter(adaptFrameForClient)
+	handler.connManager.SetOverflowPolicy(handler.newOverflowPolicy())
 	handler.refreshLease = func(ctx context.Context, userID string, data redisClient.SessionData, sessionTTL, routeTTL time.Duration) error {
 		return (&redisClient.DistributedSessionManager{}).RefreshOwnedSessionAndRoute(ctx, userID, data, sessionTTL, routeTTL)
 	}
 	handler.upgrader.CheckOrigin = handler.config.checkOrigin
+	handler.upgrader.Subprotocols = []string{jsonSubprotocol}
 
 	// 订阅实时踢出通知
 	handler.subscribeKickNotifications()
@@ -61,6 +71,7 @@ func (h *WebSocketHandler) Close() {
 	if h.lifecycleCancel != nil {
 		h.lifecycleCancel()
 	}
+	h.replayWriters.Wait()
 }
 
 // StartWebSocketServer 启动WebSocket服务器
@@ -82,6 +93,9 @@ func (h *WebSocketHandler) StartWebSocketServer() error {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", h.handleConnection)
+	if h.config.httpFallbackEnabled {
+		h.registerHTTPTransport(mux)
+	}
 	server := &http.Server{
 		Addr:              ":" + port,
 		Handler:           mux,
@@ -89,12 +103,19 @@ func (h *WebSocketHandler) StartWebSocketServer() error {
 		IdleTimeout:       h.config.idleTimeout,
 		MaxHeaderBytes:    h.config.maxHeaderBytes,
 	}
+	h.server.Store(server)
 	return server.ListenAndServeTLS(certFile, keyFile)
 }
 
 // handleConnection 处理WebSocket连接
 func (h *WebSocketHandler) handleConnection(w http.ResponseWriter, r *http.Request) {
 	sugar := logger.Sugar()
+	if h.draining.Load() {
+		// 实例正在下线，让客户端连接其他实例
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(h.config.drainRetryAfter/time.Second))))
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		sugar.Errorf("连接错误: %s", err)
@@ -147,6 +168,7 @@ func (h *WebSocketHandler) refreshRouteLease(conn *connection.Connection, userID
 				ConnectionID: conn.ID,
 				ContainerID:  containerID,
 				OwnerToken:   conn.OwnerToken,
+				DeviceID:     conn.DeviceID,
 			}, h.config.sessionLeaseTTL, h.config.routeLeaseTTL)
 			cancel()
 			if err == nil {
@@ -203,36 +225,42 @@ func (h *WebSocketHandler) readProcess(conn *connection.Connection) {
 			continue
 		}
 
-		requestMsg, err := HandleRequestData(p)
+		requestMsg, err := decodeRequestFrame(conn, p)
 		if err != nil {
 			sugar.Warnf("收到非标准化数据: %v", err)
 			continue
 		}
 
-		// 根据登录状态处理消息
-		if !conn.IsAuthenticated() {
-			h.handleUnauthenticatedMessage(conn, requestMsg)
-		} else {
-			h.handleAuthenticatedMessage(conn, requestMsg)
-		}
+		h.handleClientMessage(conn, requestMsg)
 
 		sugar.Debugf("收到WebSocket消息: %d bytes", len(p))
 	}
 }
 
+// handleClientMessage 根据登录状态处理客户端请求，WebSocket 与 HTTP 回退传输共用
+func (h *WebSocketHandler) handleClientMessage(conn *connection.Connection, requestMsg *pb.RequestMessage) {
+	if !conn.IsAuthenticated() {
+		h.handleUnauthenticatedMessage(conn, requestMsg)
+	} else {
+		h.handleAuthenticatedMessage(conn, requestMsg)
+	}
+}
+
 // handleUnauthenticatedMessage 处理未认证消息
 func (h *WebSocketHandler) handleUnauthenticatedMessage(conn *connection.Connection, requestMsg *pb.RequestMessage) {
 	switch requestMsg.Payload.(type) {
+	case *pb.RequestMessage_Hello:
+		h.handleHello(conn, requestMsg)
 	case *pb.RequestMessage_Login:
 		h.handleLogin(conn, requestMsg)
 	case *pb.RequestMessage_Signup:
 		h.handleSignup(conn, requestMsg)
 	case *pb.RequestMessage_Logout:
 		// 终止连接
-		conn.Conn.Close()
+		conn.Close()
 	default:
 		logger.Sugar().Errorln("未登录时不处理其他类型信息")
-		h.sendRefusedResponse(conn)
+		h.sendRefusedResponse(conn, requestMsg)
 	}
 }
 
@@ -244,33 +272,45 @@ func (h *WebSocketHandler) handleAuthenticatedMessage(conn *connection.Connectio
 		return
 	}
 
-	res, err := RequestMessageHandler(userID, requestMsg)
+	// 握手通常在登录前完成，登录后再次发送时按新声明更新能力
+	if requestMsg.GetHello() != nil {
+		h.handleHello(conn, requestMsg)
+		return
+	}
+
+	// 续传需要按连接顺序补发帧，不经过通用请求分发
+	if resume := requestMsg.GetResumeSession(); resume != nil {
+		h.handleResumeSession(conn, requestMsg.GetRequestId(), resume)
+		return
+	}
+
+	res, err := deviceRequestMessageHandler(userID, conn.DeviceID, requestMsg)
 	if err != nil {
 		logger.Sugar().Errorf("消息处理错误: %v", err)
 	}
 	if res.response != nil {
-		h.sendResponse(conn, res.response)
+		h.sendResponse(conn, replyTo(requestMsg, res.response))
 	}
 
 	if res.code == 1 {
 		// 收到logout报文，需要断开连接
-		conn.Conn.Close()
+		conn.Close()
 	}
 }
 
 // handleLogin 处理登录
 func (h *WebSocketHandler) handleLogin(conn *connection.Connection, requestMsg *pb.RequestMessage) {
-	rsp, realUserID, err := HandleLoginMessage(requestMsg)
+	rsp, realUserID, jwtSessionID, err := HandleLoginMessage(requestMsg)
 	logger.Sugar().Infof("登录响应: result=%s user_id=%d", rsp.GetLogin().GetResult(), realUserID)
 
 	if err != nil {
 		logger.Sugar().Errorf("登录出现错误: %v", err)
-		h.sendResponse(conn, rsp)
+		h.sendResponse(conn, replyTo(requestMsg, rsp))
 		return
 	}
 	if !loginResponseAllowsBinding(rsp, realUserID) {
 		logger.Sugar().Infof("认证未通过，不创建用户会话: result=%s user_id=%d", rsp.GetLogin().GetResult(), realUserID)
-		h.sendResponse(conn, rsp)
+		h.sendResponse(conn, replyTo(requestMsg, rsp))
 		return
 	}
 
@@ -278,7 +318,8 @@ func (h *WebSocketHandler) handleLogin(conn *connection.Connection, requestMsg *
 	userIDStr := strconv.FormatInt(realUserID, 10)
 	loginCtx, cancel := context.WithTimeout(context.Background(), h.config.authTimeout)
 	defer cancel()
-	err = h.connManager.Login(loginCtx, conn.ID, userIDStr)
+	login := requestMsg.GetLogin()
+	err = h.connManager.LoginDevice(loginCtx, conn.ID, userIDStr, login.GetDeviceId(), login.GetPlatform(), jwtSessionID)
 	if err != nil {
 		logger.Sugar().Errorf("绑定用户ID失败: %v", err)
 		rsp = &pb.ResponseMessage{
@@ -288,15 +329,19 @@ func (h *WebSocketHandler) handleLogin(conn *connection.Connection, requestMsg *
 				},
 			},
 		}
-		h.sendResponse(conn, rsp)
+		h.sendResponse(conn, replyTo(requestMsg, rsp))
 		return
 	}
 
 	go h.refreshRouteLease(conn, userIDStr)
-	_ = conn.Conn.SetReadDeadline(time.Now().Add(h.config.pongWait))
+	if conn.Conn != nil {
+		_ = conn.Conn.SetReadDeadline(time.Now().Add(h.config.pongWait))
+	}
+	h.enableSessionReplay(conn)
 
-	// 返回登录结果
-	h.sendResponse(conn, rsp)
+	// 返回登录结果，登录响应不进入重放缓冲区
+	h.sendControlResponse(conn, replyTo(requestMsg, rsp))
+	h.drainPendingDeliveries(conn)
 }
 
 func loginResponseAllowsBinding(response *pb.ResponseMessage, userID int64) bool {
@@ -312,7 +357,7 @@ func (h *WebSocketHandler) handleSignup(conn *connection.Connection, requestMsg
 		logger.Sugar().Errorf("注册出现错误：: %v", err)
 	}
 
-	h.sendResponse(conn, rsp)
+	h.sendResponse(conn, replyTo(requestMsg, rsp))
 }
 
 // writeToClient 监听 channel 发送消息协程
@@ -329,15 +374,16 @@ func (h *WebSocketHandler) writeToClient(conn *connection.Connection) {
 		case <-conn.Done():
 			return
 		case msg, ok := <-conn.SendChan:
-			if !ok {
+			if !ok || !h.writeFrame(conn, msg) {
 				return
 			}
-			_ = conn.Conn.SetWriteDeadline(time.Now().Add(h.config.writeTimeout))
-			if err := conn.Conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
-				sugar.Errorln("发送消息错误: ", err)
-				conn.Close()
+		case <-conn.Pending():
+			if !h.writePending(conn) {
 				return
 			}
+		case <-conn.Exhausted():
+			h.closeExhaustedConnection(conn)
+			return
 		case <-ticker.C:
 			_ = conn.Conn.SetWriteDeadline(time.Now().Add(h.config.writeTimeout))
 			if err := conn.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -349,6 +395,58 @@ func (h *WebSocketHandler) writeToClient(conn *connection.Connection) {
 	}
 }
 
+func (h *WebSocketHandler) writeFrame(conn *connection.Connection, msg []byte) bool {
+	messageType, data, err := encodeResponseFrame(conn, msg)
+	if err != nil {
+		logger.Sugar().Errorw("编码待发送帧失败，跳过该帧", "connection_id", conn.ID, "error", err)
+		return true
+	}
+	_ = conn.Conn.SetWriteDeadline(time.Now().Add(h.config.writeTimeout))
+	if err := conn.Conn.WriteMessage(messageType, data); err != nil {
+		logger.Sugar().Errorln("发送消息错误: ", err)
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// writePending 先发完发送通道中已有的帧，再发送合并帧和从 Redis 取回的暂存帧，保证持久帧的顺序。
+func (h *WebSocketHandler) writePending(conn *connection.Connection) bool {
+	for {
+		select {
+		case msg, ok := <-conn.SendChan:
+			if !ok || !h.writeFrame(conn, msg) {
+				return false
+			}
+			continue
+		default:
+		}
+		break
+	}
+	for _, msg := range conn.TakePending(pendingBatchSize) {
+		if !h.writeFrame(conn, msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// closeExhaustedConnection 在发送缓冲区耗尽后直接发送 SyncRequired 并关闭连接，通道中积压的帧不再发送。
+func (h *WebSocketHandler) closeExhaustedConnection(conn *connection.Connection) {
+	if frame := conn.SyncRequiredFrame(); frame != nil {
+		if messageType, data, err := encodeResponseFrame(conn, frame); err == nil {
+			_ = conn.Conn.SetWriteDeadline(time.Now().Add(h.config.writeTimeout))
+			_ = conn.Conn.WriteMessage(messageType, data)
+		}
+	}
+	_ = conn.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "send buffer exhausted"),
+		time.Now().Add(h.config.writeTimeout),
+	)
+	conn.Close()
+}
+
 // sendResponse 发送响应消息
 func (h *WebSocketHandler) sendResponse(conn *connection.Connection, rsp *pb.ResponseMessage) {
 	rspBytes, _ := proto.Marshal(rsp)
@@ -357,12 +455,28 @@ func (h *WebSocketHandler) sendResponse(conn *connection.Connection, rsp *pb.Res
 	}
 }
 
+// sendControlResponse 发送不分配会话序列号的响应
+func (h *WebSocketHandler) sendControlResponse(conn *connection.Connection, rsp *pb.ResponseMessage) {
+	rspBytes, _ := proto.Marshal(rsp)
+	if err := conn.EnqueueUnsequenced(rspBytes); err != nil {
+		logger.Sugar().Errorf("发送响应失败: %v", err)
+	}
+}
+
 // sendRefusedResponse 发送拒绝响应
-func (h *WebSocketHandler) sendRefusedResponse(conn *connection.Connection) {
+func (h *WebSocketHandler) sendRefusedResponse(conn *connection.Connection, requestMsg *pb.RequestMessage) {
 	rsp := &pb.ResponseMessage{
 		Payload: &pb.ResponseMessage_Refused{},
 	}
-	h.sendResponse(conn, rsp)
+	h.sendResponse(conn, replyTo(requestMsg, rsp))
+}
+
+// replyTo 把客户端请求的 request_id 回填到对该请求的直接应答中
+func replyTo(requestMsg *pb.RequestMessage, rsp *pb.ResponseMessage) *pb.ResponseMessage {
+	if rsp != nil {
+		rsp.RequestId = requestMsg.GetRequestId()
+	}
+	return rsp
 }
 
 // SendMessage 外部发送消息接口
@@ -370,6 +484,16 @@ func (h *WebSocketHandler) SendMessage(userID string, message []byte) error {
 	return h.router.RouteMessage(userID, message)
 }
 
+// DeliverLocally 只投递到用户在本容器内的设备，用于处理其他容器已拆分好的跨容器投递
+func (h *WebSocketHandler) DeliverLocally(userID string, message []byte) error {
+	return h.router.DeliverLocally(userID, message)
+}
+
+// DeliverEphemeralLocally 只投递到用户在本容器内的设备，帧不分配会话序列号，用于输入状态等临时信号
+func (h *WebSocketHandler) DeliverEphemeralLocally(userID string, message []byte) error {
+	return h.router.DeliverEphemeralLocally(userID, message)
+}
+
 // StopClient 外部关闭特定连接
 func (h *WebSocketHandler) StopClient(userID string) {
 	h.connManager.StopUserIfOwner(userID, "*")
@@ -77,6 +77,11 @@ func releasePostClaim(ctx context.Context, senderUserID int64, clientMessageID s
 	}
 }
 
+// ReleasePostIdempotency 在存储拒绝消息时释放未完成的幂等键，客户端修正后可用同一 client_message_id 重试
+func ReleasePostIdempotency(ctx context.Context, senderUserID int64, clientMessageID string) {
+	releasePostClaim(ctx, senderUserID, clientMessageID)
+}
+
 func CompletePostIdempotency(ctx context.Context, senderUserID int64, clientMessageID string, messageID int64) error {
 	if redisClient.Rdb == nil || clientMessageID == "" || messageID <= 0 {
 		return nil
@@ -102,8 +107,9 @@ func postIdempotencyKey(senderUserID int64, clientMessageID string) string {
 	return fmt.Sprintf("post:idempotency:%d:%x", senderUserID, digest[:])
 }
 
-func sendPostAck(userID, messageID int64, clientMessageID string) error {
+func sendPostAck(userID, messageID int64, clientMessageID, requestID string) error {
 	return sendMonitorResponse(userID, &pb.ResponseMessage{
+		RequestId: requestID,
 		Payload: &pb.ResponseMessage_PostAckRsp{PostAckRsp: &pb.PostAckRsp{
 			MessageId:       messageID,
 			ClientMessageId: clientMessageID,
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/shared/dispatch"
+	"Betterfly2/shared/logger"
+	"Betterfly2/shared/metrics"
+	"context"
+	redisClient "data_forwarding_service/internal/redis"
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitTimeout 限制单次令牌桶查询的耗时，超时按放行处理
+const rateLimitTimeout = 200 * time.Millisecond
+
+// defaultRateLimitType 用于没有单独配置的请求类型，每种请求类型仍使用各自的令牌桶
+const defaultRateLimitType = "default"
+
+// requestRateLimit 是一类请求的令牌桶参数：rate 为每秒补充的令牌数，burst 为桶容量。
+// rate 为 0 表示不限流。
+type requestRateLimit struct {
+	rate  float64
+	burst int
+}
+
+// defaultRequestRateLimits 以 RequestMessage.payload 的字段名为键，DF_RATE_LIMITS 中的同名配置会覆盖这里的值。
+var defaultRequestRateLimits = map[string]requestRateLimit{
+	defaultRateLimitType:    {rate: 20, burst: 40},
+	"logout":                {},
+	"post":                  {rate: 10, burst: 20},
+	"forward_messages":      {rate: 1, burst: 5},
+	"search_messages":       {rate: 1, burst: 5},
+	"insert_contact":        {rate: 10.0 / 60, burst: 5},
+	"insert_group":          {rate: 5.0 / 60, burst: 3},
+	"insert_group_user":     {rate: 30.0 / 60, burst: 10},
+	"invite_group_member":   {rate: 30.0 / 60, burst: 10},
+	"change_password":       {rate: 5.0 / 60, burst: 3},
+	"conversation_signal":   {rate: 5, burst: 10},
+	"revoke_device_session": {rate: 5.0 / 60, burst: 3},
+}
+
+var requestPayloadOneof = (&pb.RequestMessage{}).ProtoReflect().Descriptor().Oneofs().ByName("payload")
+
+type rateLimitTaker func(ctx context.Context, userID, requestType string, rate float64, burst int, now time.Time) (bool, time.Duration, error)
+
+type requestRateLimiter struct {
+	limits map[string]requestRateLimit
+	take   rateLimitTaker
+	now    func() time.Time
+}
+
+func newRequestRateLimiter(limits map[string]requestRateLimit) *requestRateLimiter {
+	return &requestRateLimiter{limits: limits, take: redisClient.TakeRateLimitToken, now: time.Now}
+}
+
+// loadRequestRateLimits 读取 DF_RATE_LIMITS，格式为逗号分隔的 "请求类型=次数/单位[:桶容量]"，
+// 单位为 s、m 或 h，例如 "post=10/s:20,insert_contact=10/m:5"；"请求类型=off" 关闭该类型的限流。
+// 格式错误的条目会被忽略并保留默认值。
+func loadRequestRateLimits() map[string]requestRateLimit {
+	limits := make(map[string]requestRateLimit, len(defaultRequestRateLimits))
+	for requestType, limit := range defaultRequestRateLimits {
+		limits[requestType] = limit
+	}
+	for _, entry := range strings.Split(os.Getenv("DF_RATE_LIMITS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		requestType, limit, err := parseRequestRateLimit(entry)
+		if err != nil {
+			logger.Sugar().Warnf("忽略无效的限流配置 %q: %v", entry, err)
+			continue
+		}
+		limits[requestType] = limit
+	}
+	return limits
+}
+
+func parseRequestRateLimit(entry string) (string, requestRateLimit, error) {
+	requestType, spec, found := strings.Cut(entry, "=")
+	requestType, spec = strings.TrimSpace(requestType), strings.TrimSpace(spec)
+	if !found || requestType == "" {
+		return "", requestRateLimit{}, fmt.Errorf("缺少请求类型")
+	}
+	if requestType != defaultRateLimitType && requestPayloadOneof.Fields().ByName(protoreflect.Name(requestType)) == nil {
+		return "", requestRateLimit{}, fmt.Errorf("未知的请求类型")
+	}
+	if spec == "off" {
+		return requestType, requestRateLimit{}, nil
+	}
+	rateSpec, burstSpec, hasBurst := strings.Cut(spec, ":")
+	countSpec, unit, found := strings.Cut(rateSpec, "/")
+	count, err := strconv.Atoi(countSpec)
+	if !found || err != nil || count <= 0 {
+		return "", requestRateLimit{}, fmt.Errorf("无效的速率")
+	}
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return "", requestRateLimit{}, fmt.Errorf("无效的时间单位 %q", unit)
+	}
+	limit := requestRateLimit{rate: float64(count) / period.Seconds(), burst: count}
+	if hasBurst {
+		if limit.burst, err = strconv.Atoi(burstSpec); err != nil || limit.burst <= 0 {
+			return "", requestRateLimit{}, fmt.Errorf("无效的桶容量")
+		}
+	}
+	return requestType, limit, nil
+}
+
+// middleware 在请求进入业务模块前按 (用户, 请求类型) 取令牌，被拒绝时返回带 retry_after_ms 的 Warn。
+// Redis 不可用时放行，限流故障不能让正常用户无法使用。
+func (l *requestRateLimiter) middleware(next dispatch.OneofHandler[dfRequestContext, dfRequestResult]) dispatch.OneofHandler[dfRequestContext, dfRequestResult] {
+	return func(ctx dfRequestContext, payload any) (dfRequestResult, error) {
+		requestType := requestPayloadName(ctx.message)
+		limit := l.limitFor(requestType)
+		if ctx.fromID <= 0 || limit.rate <= 0 || limit.burst <= 0 || redisClient.Rdb == nil {
+			return next(ctx, payload)
+		}
+		takeCtx, cancel := context.WithTimeout(context.Background(), rateLimitTimeout)
+		allowed, retryAfter, err := l.take(takeCtx, strconv.FormatInt(ctx.fromID, 10), requestType, limit.rate, limit.burst, l.now())
+		cancel()
+		if err != nil {
+			logger.Sugar().Warnw("读取请求令牌桶失败，放行请求", "user_id", ctx.fromID, "request_type", requestType, "error", err)
+			return next(ctx, payload)
+		}
+		if !allowed {
+			metrics.RecordRateLimitedRequest(requestType)
+			logger.Sugar().Infow("请求被限流", "user_id", ctx.fromID, "request_type", requestType, "retry_after", retryAfter)
+			return rateLimitedResult(requestType, retryAfter), nil
+		}
+		return next(ctx, payload)
+	}
+}
+
+func (l *requestRateLimiter) limitFor(requestType string) requestRateLimit {
+	if limit, exists := l.limits[requestType]; exists {
+		return limit
+	}
+	return l.limits[defaultRateLimitType]
+}
+
+func rateLimitedResult(requestType string, retryAfter time.Duration) dfRequestResult {
+	retryAfterMS := retryAfter.Milliseconds()
+	if retryAfterMS < 1 {
+		retryAfterMS = 1
+	}
+	return dfRequestResult{response: &pb.ResponseMessage{
+		Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{
+			WarningMessage: fmt.Sprintf("请求过于频繁，请稍后重试: %s", requestType),
+			RetryAfterMs:   retryAfterMS,
+		}},
+	}}
+}
+
+// requestPayloadName 返回请求 payload 在 RequestMessage 中的字段名，例如 "post"、"insert_contact"。
+func requestPayloadName(message *pb.RequestMessage) string {
+	field := message.ProtoReflect().WhichOneof(requestPayloadOneof)
+	if field == nil {
+		return ""
+	}
+	return string(field.Name())
+}
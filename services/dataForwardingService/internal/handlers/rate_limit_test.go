@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"testing"
+	"time"
+)
+
+func TestLoadRequestRateLimitsOverridesDefaults(t *testing.T) {
+	t.Setenv("DF_RATE_LIMITS", "post=30/s:60, insert_contact=off, unknown_type=1/s, query_presence=bad, default=100/m")
+	limits := loadRequestRateLimits()
+
+	if got := limits["post"]; got.rate != 30 || got.burst != 60 {
+		t.Fatalf("unexpected post limit: %+v", got)
+	}
+	if got := limits["insert_contact"]; got.rate != 0 {
+		t.Fatalf("insert_contact should be unlimited: %+v", got)
+	}
+	if _, exists := limits["unknown_type"]; exists {
+		t.Fatal("unknown request type should be ignored")
+	}
+	if _, exists := limits["query_presence"]; exists {
+		t.Fatal("invalid entry should be ignored")
+	}
+	if got := limits[defaultRateLimitType]; got.burst != 100 || got.rate != 100.0/60 {
+		t.Fatalf("unexpected default limit: %+v", got)
+	}
+	if got := limits["change_password"]; got != defaultRequestRateLimits["change_password"] {
+		t.Fatalf("change_password default should be kept: %+v", got)
+	}
+}
+
+func TestRequestRateLimiterRejectsWithRetryAfter(t *testing.T) {
+	useHandlerTestRedis(t)
+	now := time.Unix(1_700_000_000, 0)
+	limiter := newRequestRateLimiter(map[string]requestRateLimit{
+		defaultRateLimitType: {rate: 1, burst: 2},
+		"logout":             {},
+	})
+	limiter.now = func() time.Time { return now }
+
+	calls := 0
+	next := func(dfRequestContext, any) (dfRequestResult, error) {
+		calls++
+		return dfRequestResult{}, nil
+	}
+	handler := limiter.middleware(next)
+	post := dfRequestContext{fromID: 1001, message: &pb.RequestMessage{Payload: &pb.RequestMessage_Post{Post: &pb.Post{}}}}
+
+	for i := 0; i < 2; i++ {
+		if result, err := handler(post, nil); err != nil || result.response != nil {
+			t.Fatalf("request %d should pass: %+v %v", i, result, err)
+		}
+	}
+	result, err := handler(post, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warn := result.response.GetWarn()
+	if warn == nil || warn.GetRetryAfterMs() <= 0 || warn.GetRetryAfterMs() > 1000 {
+		t.Fatalf("expected warn with retry_after_ms, got %+v", result.response)
+	}
+	if calls != 2 {
+		t.Fatalf("rejected request should not reach the handler, calls=%d", calls)
+	}
+
+	// 其他请求类型和其他用户使用各自的令牌桶
+	presence := dfRequestContext{fromID: 1001, message: &pb.RequestMessage{Payload: &pb.RequestMessage_QueryPresence{QueryPresence: &pb.QueryPresence{}}}}
+	otherUser := dfRequestContext{fromID: 1002, message: post.message}
+	logout := dfRequestContext{fromID: 1001, message: &pb.RequestMessage{Payload: &pb.RequestMessage_Logout{Logout: &pb.LogoutReq{}}}}
+	for _, ctx := range []dfRequestContext{presence, otherUser, logout, logout, logout} {
+		if result, err := handler(ctx, nil); err != nil || result.response != nil {
+			t.Fatalf("request should pass: %+v %v", result, err)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if result, err := handler(post, nil); err != nil || result.response != nil {
+		t.Fatalf("request should pass after refill: %+v %v", result, err)
+	}
+}
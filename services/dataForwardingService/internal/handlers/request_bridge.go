@@ -41,7 +41,9 @@ func newFriendRequest(fromKafkaTopic string, targetUserID int64) *friend.Request
 	}
 }
 
-func publishStorageRequest(req *storage.RequestMessage) error {
+// publishStorageRequest 发布 storage 请求，requestID 是客户端请求的 request_id，storageService 会原样写回响应
+func publishStorageRequest(req *storage.RequestMessage, requestID string) error {
+	req.RequestId = requestID
 	_, err := mq.PublishEnvelope(publisher.PublishMessage, storageServiceTopic, envelope.MessageType_STORAGE_REQUEST, req)
 	if err != nil {
 		logger.Sugar().Errorf("发布storage请求失败: %v", err)
@@ -49,7 +51,9 @@ func publishStorageRequest(req *storage.RequestMessage) error {
 	return err
 }
 
-func publishFriendRequest(req *friend.RequestMessage) error {
+// publishFriendRequest 发布 friend 请求，requestID 含义同 publishStorageRequest
+func publishFriendRequest(req *friend.RequestMessage, requestID string) error {
+	req.RequestId = requestID
 	_, err := mq.PublishEnvelope(publisher.PublishMessage, friendServiceTopic, envelope.MessageType_FRIEND_REQUEST, req)
 	if err != nil {
 		logger.Sugar().Errorf("发布friend请求失败: %v", err)
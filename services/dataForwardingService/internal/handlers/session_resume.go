@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"Betterfly2/shared/logger"
+	"context"
+	"data_forwarding_service/internal/connection"
+	redisClient "data_forwarding_service/internal/redis"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// replayAppendTimeout 限制接管重放缓冲区和写入一批帧的耗时
+const replayAppendTimeout = time.Second
+
+// responseSeqField 对应 ResponseMessage.seq
+const responseSeqField protowire.Number = 100
+
+// withResponseSeq 在已序列化的 ResponseMessage 末尾追加 seq 字段，无需反序列化后重新编码。
+func withResponseSeq(message []byte, seq int64) []byte {
+	framed := make([]byte, 0, len(message)+protowire.SizeTag(responseSeqField)+protowire.SizeVarint(uint64(seq)))
+	framed = append(framed, message...)
+	framed = protowire.AppendTag(framed, responseSeqField, protowire.VarintType)
+	return protowire.AppendVarint(framed, uint64(seq))
+}
+
+// enableSessionReplay 为已登录连接开启推送编号，之后的每一帧都会写入该设备会话的重放缓冲区。
+// 序列号在内存中接着缓冲区已写入的最大序号分配，入队时不访问 Redis；帧由 replayWriter 在后台分批写入。
+func (h *WebSocketHandler) enableSessionReplay(conn *connection.Connection) {
+	if redisClient.Rdb == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.lifecycleCtx, replayAppendTimeout)
+	lastSeq, err := redisClient.ClaimReplayBuffer(ctx, conn.UserID, conn.DeviceID, conn.OwnerToken, h.config.replayTTL)
+	cancel()
+	if err != nil {
+		logger.Sugar().Warnw("接管会话重放缓冲区失败，本连接的推送不分配序列号", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+		return
+	}
+	writer := &replayWriter{
+		userID:     conn.UserID,
+		deviceID:   conn.DeviceID,
+		ownerToken: conn.OwnerToken,
+		maxFrames:  h.config.replayBufferSize,
+		ttl:        h.config.replayTTL,
+		wake:       make(chan struct{}, 1),
+	}
+	h.replayWriters.Add(1)
+	go func() {
+		defer h.replayWriters.Done()
+		writer.run(h.lifecycleCtx, conn.Done())
+	}()
+	// sequencer 在连接的入队锁内调用，lastSeq 不需要额外加锁
+	conn.EnableReplay(func(message []byte) (int64, []byte, error) {
+		lastSeq++
+		writer.add(redisClient.ReplayFrame{Seq: lastSeq, Payload: message})
+		return lastSeq, withResponseSeq(message, lastSeq), nil
+	})
+}
+
+// replayWriter 把一个连接已编号的帧按顺序分批写入重放缓冲区，写入期间新编号的帧并入下一批。
+type replayWriter struct {
+	userID     string
+	deviceID   string
+	ownerToken string
+	maxFrames  int
+	ttl        time.Duration
+	mu         sync.Mutex
+	pending    []redisClient.ReplayFrame
+	wake       chan struct{}
+}
+
+// add 登记一帧等待写入。Redis 持续变慢时只保留最近 maxFrames 帧，更早的帧本来也会被缓冲区裁剪。
+func (w *replayWriter) add(frame redisClient.ReplayFrame) {
+	w.mu.Lock()
+	w.pending = append(w.pending, frame)
+	if len(w.pending) > w.maxFrames {
+		w.pending = w.pending[len(w.pending)-w.maxFrames:]
+	}
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 持续写入新登记的帧，连接断开后写完剩余的帧再退出。
+func (w *replayWriter) run(ctx context.Context, done <-chan struct{}) {
+	for {
+		select {
+		case <-w.wake:
+			w.flush(ctx)
+		case <-done:
+			w.flush(ctx)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *replayWriter) flush(ctx context.Context) {
+	w.mu.Lock()
+	frames := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(frames) == 0 {
+		return
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, replayAppendTimeout)
+	defer cancel()
+	err := redisClient.AppendReplayFrames(writeCtx, w.userID, w.deviceID, w.ownerToken, frames, w.maxFrames, w.ttl)
+	if errors.Is(err, redisClient.ErrReplayOwnerChanged) {
+		logger.Sugar().Infow("设备已重新登录，丢弃旧连接未写入重放缓冲区的帧", "user_id", w.userID, "device_id", w.deviceID, "frames", len(frames))
+		return
+	}
+	if err != nil {
+		// 缓冲区中留下的序号缺口会让跨越它的续传返回 RESUME_SESSION_SYNC_REQUIRED
+		logger.Sugar().Warnw("写入会话重放缓冲区失败", "user_id", w.userID, "device_id", w.deviceID,
+			"first_seq", frames[0].Seq, "last_seq", frames[len(frames)-1].Seq, "error", err)
+	}
+}
+
+// handleResumeSession 补发 last_seq 之后客户端未收到的帧，最后发送 ResumeSessionRsp 表示补发结束。
+// 缓冲区无法覆盖 last_seq 之后的全部帧时不补发任何帧，客户端需改用 QuerySyncMessages 同步。
+func (h *WebSocketHandler) handleResumeSession(conn *connection.Connection, requestID string, request *pb.ResumeSession) {
+	err := conn.Replay(func(firstLiveSeq, lastLiveSeq int64) ([][]byte, error) {
+		response := &pb.ResumeSessionRsp{Result: pb.ResumeSessionResult_RESUME_SESSION_SERVICE_ERROR}
+		frames, err := h.loadReplayFrames(conn, request.GetLastSeq(), firstLiveSeq, lastLiveSeq, response)
+		if err != nil {
+			logger.Sugar().Warnw("读取会话重放缓冲区失败", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+		}
+		rspBytes, err := proto.Marshal(&pb.ResponseMessage{
+			RequestId: requestID,
+			Payload:   &pb.ResponseMessage_ResumeSessionRsp{ResumeSessionRsp: response},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(frames, rspBytes), nil
+	})
+	if err != nil {
+		// 补发不完整时断开连接，客户端重连后可以用同一个 last_seq 再次续传
+		logger.Sugar().Warnw("会话补发入队失败，断开连接", "user_id", conn.UserID, "device_id", conn.DeviceID, "error", err)
+		conn.Close()
+	}
+}
+
+func (h *WebSocketHandler) loadReplayFrames(conn *connection.Connection, lastSeq, firstLiveSeq, lastLiveSeq int64, response *pb.ResumeSessionRsp) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(h.lifecycleCtx, 5*time.Second)
+	defer cancel()
+	window, err := redisClient.ReadReplayFrames(ctx, conn.UserID, conn.DeviceID, lastSeq)
+	if err != nil {
+		return nil, err
+	}
+	// 本连接的帧可能还没有写入缓冲区，当前会话的最大序列号以内存中的为准
+	response.LastSeq = max(window.LastSeq, lastLiveSeq)
+	if firstLiveSeq > 0 && lastSeq >= firstLiveSeq {
+		// 客户端收到过的序号已被本连接重新分配，说明旧连接有帧没来得及写入缓冲区
+		window.Complete = false
+	}
+	if !window.Complete {
+		response.Result = pb.ResumeSessionResult_RESUME_SESSION_SYNC_REQUIRED
+		logger.Sugar().Infow("会话重放缓冲区不完整，要求客户端全量同步", "user_id", conn.UserID, "device_id", conn.DeviceID, "last_seq", lastSeq, "server_seq", window.LastSeq)
+		return nil, nil
+	}
+
+	frames := make([][]byte, 0, len(window.Frames))
+	for _, frame := range window.Frames {
+		if firstLiveSeq > 0 && frame.Seq >= firstLiveSeq {
+			break
+		}
+		frames = append(frames, withResponseSeq(frame.Payload, frame.Seq))
+	}
+	response.Result = pb.ResumeSessionResult_RESUME_SESSION_OK
+	response.Replayed = int64(len(frames))
+	return frames, nil
+}
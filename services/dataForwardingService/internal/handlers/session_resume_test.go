@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	pb "Betterfly2/proto/data_forwarding"
+	"context"
+	"data_forwarding_service/internal/connection"
+	redisClient "data_forwarding_service/internal/redis"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func newResumeTestConnection(userID, deviceID string) *connection.Connection {
+	return &connection.Connection{ID: "connection-" + deviceID, UserID: userID, OwnerToken: "owner-" + deviceID, DeviceID: deviceID, SendChan: make(chan []byte, 16)}
+}
+
+// newReplayTestHandler 返回只开启会话重放的处理器，测试结束时等待后台写入退出后才还原 Redis 客户端
+func newReplayTestHandler(t *testing.T, bufferSize int) *WebSocketHandler {
+	lifecycleCtx, lifecycleCancel := context.WithCancel(context.Background())
+	handler := &WebSocketHandler{
+		config:          websocketConfig{replayBufferSize: bufferSize, replayTTL: time.Minute},
+		lifecycleCtx:    lifecycleCtx,
+		lifecycleCancel: lifecycleCancel,
+	}
+	t.Cleanup(handler.Close)
+	return handler
+}
+
+// waitReplayWritten 等待后台写入把重放缓冲区推进到 seq
+func waitReplayWritten(t *testing.T, userID, deviceID string, seq int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		window, err := redisClient.ReadReplayFrames(context.Background(), userID, deviceID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if window.LastSeq >= seq {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replay buffer stopped at seq %d, want %d", window.LastSeq, seq)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func receiveResponse(t *testing.T, conn *connection.Connection) *pb.ResponseMessage {
+	t.Helper()
+	response := &pb.ResponseMessage{}
+	if err := proto.Unmarshal(<-conn.SendChan, response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func pushWarning(t *testing.T, conn *connection.Connection, text string) {
+	t.Helper()
+	payload, _ := proto.Marshal(&pb.ResponseMessage{Payload: &pb.ResponseMessage_Warn{Warn: &pb.Warn{WarningMessage: text}}})
+	if err := conn.EnqueueMessage(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeSessionReplaysMissedFramesOnReconnect(t *testing.T) {
+	useHandlerTestRedis(t)
+	handler := newReplayTestHandler(t, 8)
+	dropped := newResumeTestConnection("42", "phone")
+	handler.enableSessionReplay(dropped)
+	for _, text := range []string{"first", "second", "third"} {
+		pushWarning(t, dropped, text)
+	}
+	if got := receiveResponse(t, dropped); got.GetSeq() != 1 || got.GetWarn().GetWarningMessage() != "first" {
+		t.Fatalf("pushed frame was not sequenced: %+v", got)
+	}
+	waitReplayWritten(t, "42", "phone", 3)
+
+	resumed := newResumeTestConnection("42", "phone")
+	resumed.OwnerToken = "owner-phone-resumed"
+	handler.enableSessionReplay(resumed)
+	pushWarning(t, resumed, "live")
+	handler.handleResumeSession(resumed, "req-resume", &pb.ResumeSession{LastSeq: 1})
+
+	if got := receiveResponse(t, resumed); got.GetSeq() != 4 || got.GetWarn().GetWarningMessage() != "live" {
+		t.Fatalf("unexpected live frame: %+v", got)
+	}
+	for _, want := range []struct {
+		seq  int64
+		text string
+	}{{2, "second"}, {3, "third"}} {
+		if got := receiveResponse(t, resumed); got.GetSeq() != want.seq || got.GetWarn().GetWarningMessage() != want.text {
+			t.Fatalf("unexpected replayed frame: %+v", got)
+		}
+	}
+	result := receiveResponse(t, resumed)
+	if result.GetSeq() != 0 || result.GetResumeSessionRsp().GetResult() != pb.ResumeSessionResult_RESUME_SESSION_OK ||
+		result.GetResumeSessionRsp().GetReplayed() != 2 || result.GetResumeSessionRsp().GetLastSeq() != 4 || result.GetRequestId() != "req-resume" {
+		t.Fatalf("unexpected resume result: %+v", result)
+	}
+}
+
+func TestResumeSessionRequiresSyncWhenBufferWasTrimmed(t *testing.T) {
+	useHandlerTestRedis(t)
+	handler := newReplayTestHandler(t, 2)
+	dropped := newResumeTestConnection("42", "phone")
+	handler.enableSessionReplay(dropped)
+	for _, text := range []string{"first", "second", "third"} {
+		pushWarning(t, dropped, text)
+	}
+	waitReplayWritten(t, "42", "phone", 3)
+
+	resumed := newResumeTestConnection("42", "phone")
+	handler.handleResumeSession(resumed, "", &pb.ResumeSession{LastSeq: 0})
+	result := receiveResponse(t, resumed)
+	if result.GetResumeSessionRsp().GetResult() != pb.ResumeSessionResult_RESUME_SESSION_SYNC_REQUIRED || result.GetResumeSessionRsp().GetReplayed() != 0 {
+		t.Fatalf("trimmed buffer must fall back to a sync hint: %+v", result)
+	}
+	if len(resumed.SendChan) != 0 {
+		t.Fatal("partial replay must not be sent when a sync is required")
+	}
+}
+
+func TestSessionReplaySequencesFramesWithoutWaitingForRedis(t *testing.T) {
+	server := useHandlerTestRedis(t)
+	handler := newReplayTestHandler(t, 8)
+	conn := newResumeTestConnection("42", "phone")
+	handler.enableSessionReplay(conn)
+	server.Close()
+
+	for _, text := range []string{"first", "second"} {
+		pushWarning(t, conn, text)
+	}
+	for _, want := range []int64{1, 2} {
+		if got := receiveResponse(t, conn); got.GetSeq() != want {
+			t.Fatalf("frame must be sequenced in memory while Redis is unavailable: %+v", got)
+		}
+	}
+}
+
+func TestResumeSessionRequiresSyncWhenOldFramesWereNotWritten(t *testing.T) {
+	useHandlerTestRedis(t)
+	handler := newReplayTestHandler(t, 8)
+	resumed := newResumeTestConnection("42", "phone")
+	handler.enableSessionReplay(resumed)
+	pushWarning(t, resumed, "live")
+	receiveResponse(t, resumed)
+
+	// 客户端在旧连接上收到过 seq 1，但那一帧没来得及写入缓冲区，本连接又把 1 分配给了新帧
+	handler.handleResumeSession(resumed, "", &pb.ResumeSession{LastSeq: 1})
+	result := receiveResponse(t, resumed)
+	if result.GetResumeSessionRsp().GetResult() != pb.ResumeSessionResult_RESUME_SESSION_SYNC_REQUIRED || result.GetResumeSessionRsp().GetLastSeq() != 1 {
+		t.Fatalf("reused sequence numbers must fall back to a sync hint: %+v", result)
+	}
+}
@@ -26,6 +26,20 @@ type websocketConfig struct {
 	readHeaderTimeout  time.Duration
 	idleTimeout        time.Duration
 	maxHeaderBytes     int
+	replayBufferSize   int
+	replayTTL          time.Duration
+	drainTimeout       time.Duration
+	drainRetryAfter    time.Duration
+	drainBatchSize     int
+	drainBatchInterval time.Duration
+	sendSpillLimit     int
+	sendSpillTTL       time.Duration
+	// 待投递日志按用户保存，由投递消息的包级函数通过全局处理器读取
+	pendingDeliveryLimit int
+	pendingDeliveryTTL   time.Duration
+	httpFallbackEnabled  bool
+	httpPollTimeout      time.Duration
+	httpIdleTimeout      time.Duration
 }
 
 func loadWebSocketConfig() websocketConfig {
@@ -49,21 +63,34 @@ func loadWebSocketConfig() websocketConfig {
 		leaseJitter = leaseRefresh / 4
 	}
 	return websocketConfig{
-		allowedOrigins:     parseAllowedOrigins(os.Getenv("WS_ALLOWED_ORIGINS")),
-		allowMissingOrigin: envBoolValue("WS_ALLOW_MISSING_ORIGIN", true),
-		maxMessageBytes:    int64(envIntValue("WS_MAX_MESSAGE_BYTES", 4<<20)),
-		authTimeout:        envDurationValue("WS_AUTH_TIMEOUT", 15*time.Second),
-		pongWait:           pongWait,
-		pingInterval:       pingInterval,
-		writeTimeout:       envDurationValue("WS_WRITE_TIMEOUT", 10*time.Second),
-		sessionLeaseTTL:    sessionLeaseTTL,
-		routeLeaseTTL:      routeLeaseTTL,
-		leaseRefresh:       leaseRefresh,
-		leaseJitter:        leaseJitter,
-		redisFailureGrace:  envIntValue("WS_REDIS_FAILURE_GRACE", 3),
-		readHeaderTimeout:  envDurationValue("WS_READ_HEADER_TIMEOUT", 5*time.Second),
-		idleTimeout:        envDurationValue("WS_IDLE_TIMEOUT", 60*time.Second),
-		maxHeaderBytes:     envIntValue("WS_MAX_HEADER_BYTES", 1<<20),
+		allowedOrigins:       parseAllowedOrigins(os.Getenv("WS_ALLOWED_ORIGINS")),
+		allowMissingOrigin:   envBoolValue("WS_ALLOW_MISSING_ORIGIN", true),
+		maxMessageBytes:      int64(envIntValue("WS_MAX_MESSAGE_BYTES", 4<<20)),
+		authTimeout:          envDurationValue("WS_AUTH_TIMEOUT", 15*time.Second),
+		pongWait:             pongWait,
+		pingInterval:         pingInterval,
+		writeTimeout:         envDurationValue("WS_WRITE_TIMEOUT", 10*time.Second),
+		sessionLeaseTTL:      sessionLeaseTTL,
+		routeLeaseTTL:        routeLeaseTTL,
+		leaseRefresh:         leaseRefresh,
+		leaseJitter:          leaseJitter,
+		redisFailureGrace:    envIntValue("WS_REDIS_FAILURE_GRACE", 3),
+		readHeaderTimeout:    envDurationValue("WS_READ_HEADER_TIMEOUT", 5*time.Second),
+		idleTimeout:          envDurationValue("WS_IDLE_TIMEOUT", 60*time.Second),
+		maxHeaderBytes:       envIntValue("WS_MAX_HEADER_BYTES", 1<<20),
+		replayBufferSize:     envIntValue("WS_REPLAY_BUFFER_SIZE", 200),
+		replayTTL:            envDurationValue("WS_REPLAY_TTL", 10*time.Minute),
+		drainTimeout:         envDurationValue("WS_DRAIN_TIMEOUT", 25*time.Second),
+		drainRetryAfter:      envDurationValue("WS_DRAIN_RETRY_AFTER", 5*time.Second),
+		drainBatchSize:       envIntValue("WS_DRAIN_BATCH_SIZE", 100),
+		drainBatchInterval:   envDurationValue("WS_DRAIN_BATCH_INTERVAL", 200*time.Millisecond),
+		sendSpillLimit:       envIntValue("WS_SEND_SPILL_LIMIT", 1000),
+		sendSpillTTL:         envDurationValue("WS_SEND_SPILL_TTL", 5*time.Minute),
+		pendingDeliveryLimit: envIntValue("DF_PENDING_DELIVERY_LIMIT", 500),
+		pendingDeliveryTTL:   envDurationValue("DF_PENDING_DELIVERY_TTL", 72*time.Hour),
+		httpFallbackEnabled:  envBoolValue("WS_HTTP_FALLBACK_ENABLED", true),
+		httpPollTimeout:      envDurationValue("WS_HTTP_POLL_TIMEOUT", 25*time.Second),
+		httpIdleTimeout:      envDurationValue("WS_HTTP_IDLE_TIMEOUT", 60*time.Second),
 	}
 }
 
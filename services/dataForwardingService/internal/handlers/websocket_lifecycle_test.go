@@ -24,6 +24,7 @@ func testWebSocketHandler(config websocketConfig) *WebSocketHandler {
 		config:      config,
 	}
 	handler.upgrader.CheckOrigin = config.checkOrigin
+	handler.upgrader.Subprotocols = []string{jsonSubprotocol}
 	return handler
 }
 
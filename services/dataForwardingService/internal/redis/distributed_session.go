@@ -4,6 +4,8 @@ import (
 	"Betterfly2/shared/logger"
 	"context"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,10 +14,21 @@ import (
 
 type DistributedSessionManager struct{}
 
+// DefaultDeviceID 是未上报设备标识的旧客户端使用的设备会话。
+const DefaultDeviceID = "default"
+
 type SessionData struct {
 	ConnectionID string
 	ContainerID  string
 	OwnerToken   string
+	// DeviceID 不写入会话值，由 Redis 键区分；为空时视为 DefaultDeviceID。
+	DeviceID string
+	// 以下字段保存在独立的会话信息哈希中，用于设备会话列表展示；
+	// JwtSessionID 是该设备登录所用JWT的会话ID，远程结束设备时据此吊销其令牌。
+	Platform     string
+	LoggedInAt   time.Time
+	RefreshedAt  time.Time
+	JwtSessionID string
 }
 
 var releaseLockScript = redis.NewScript(`
@@ -25,15 +38,20 @@ end
 return 0
 `)
 
+// KEYS: 设备会话键、用户设备路由哈希、设备路由租约键、设备会话信息哈希
+// ARGV: 设备ID、会话值、容器ID、所有者令牌、会话TTL、路由TTL、容器集合成员、平台、当前时间(毫秒)、JWT会话ID
 var claimSessionAndRouteScript = redis.NewScript(`
 local previous_container = redis.call('HGET', KEYS[2], ARGV[1])
 if previous_container and previous_container ~= ARGV[3] then
-  redis.call('SREM', 'container_connections:' .. previous_container, ARGV[1])
+  redis.call('SREM', 'container_connections:' .. previous_container, ARGV[7])
 end
 redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[5])
 redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
-redis.call('SADD', 'container_connections:' .. ARGV[3], ARGV[1])
+redis.call('SADD', 'container_connections:' .. ARGV[3], ARGV[7])
 redis.call('SET', KEYS[3], ARGV[3] .. '|' .. ARGV[4], 'PX', ARGV[6])
+redis.call('DEL', KEYS[4])
+redis.call('HSET', KEYS[4], 'owner', ARGV[4], 'platform', ARGV[8], 'logged_in_at', ARGV[9], 'refreshed_at', ARGV[9], 'jwt_session', ARGV[10])
+redis.call('PEXPIRE', KEYS[4], ARGV[5])
 return 1
 `)
 
@@ -48,6 +66,10 @@ if session ~= ARGV[2]
 end
 redis.call('PEXPIRE', KEYS[1], ARGV[5])
 redis.call('PEXPIRE', KEYS[3], ARGV[6])
+if redis.call('HGET', KEYS[4], 'owner') == ARGV[4] then
+  redis.call('HSET', KEYS[4], 'refreshed_at', ARGV[7])
+  redis.call('PEXPIRE', KEYS[4], ARGV[5])
+end
 return 1
 `)
 
@@ -57,19 +79,40 @@ if redis.call('GET', KEYS[1]) == ARGV[2] then
   redis.call('DEL', KEYS[1])
   removed = 1
 end
+if redis.call('HGET', KEYS[4], 'owner') == ARGV[4] then
+  redis.call('DEL', KEYS[4])
+end
 if redis.call('GET', KEYS[3]) == ARGV[3] .. '|' .. ARGV[4]
   and redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[3] then
   redis.call('DEL', KEYS[3])
   redis.call('HDEL', KEYS[2], ARGV[1])
-  redis.call('SREM', 'container_connections:' .. ARGV[3], ARGV[1])
+  redis.call('SREM', 'container_connections:' .. ARGV[3], ARGV[5])
   removed = 1
 end
 return removed
 `)
 
-func sessionKey(userID string) string  { return "user_session:" + userID }
+func sessionKey(userID, deviceID string) string { return "user_session:" + userID + ":" + deviceID }
+func sessionInfoKey(userID, deviceID string) string {
+	return "user_session_info:" + userID + ":" + deviceID
+}
 func userLockKey(userID string) string { return "user_lock:" + userID }
 
+// sessionMember 是 container_connections 集合中标识单个设备会话的成员。
+func sessionMember(userID, deviceID string) string { return userID + ":" + deviceID }
+
+func (data SessionData) deviceID() string {
+	if data.DeviceID == "" {
+		return DefaultDeviceID
+	}
+	return data.DeviceID
+}
+
+func sessionKeys(userID string, data SessionData) []string {
+	deviceID := data.deviceID()
+	return []string{sessionKey(userID, deviceID), userRoutesKey(userID), routeLeaseKey(userID, deviceID), sessionInfoKey(userID, deviceID)}
+}
+
 func encodeSession(data SessionData) string {
 	return data.ConnectionID + "|" + data.ContainerID + "|" + data.OwnerToken
 }
@@ -82,22 +125,65 @@ func (dsm *DistributedSessionManager) ReleaseUserLock(ctx context.Context, userI
 	return releaseLockScript.Run(ctx, Rdb, []string{userLockKey(userID)}, ownerToken).Err()
 }
 
-func (dsm *DistributedSessionManager) GetUserSession(ctx context.Context, userID string) (SessionData, bool, error) {
-	value, err := Rdb.Get(ctx, sessionKey(userID)).Result()
+func (dsm *DistributedSessionManager) GetUserSession(ctx context.Context, userID, deviceID string) (SessionData, bool, error) {
+	if deviceID == "" {
+		deviceID = DefaultDeviceID
+	}
+	value, err := Rdb.Get(ctx, sessionKey(userID, deviceID)).Result()
 	if errors.Is(err, redis.Nil) {
 		return SessionData{}, false, nil
 	}
 	if err != nil {
 		return SessionData{}, false, err
 	}
-	return ParseSessionData(value), true, nil
+	data := ParseSessionData(value)
+	data.DeviceID = deviceID
+	info, err := Rdb.HGetAll(ctx, sessionInfoKey(userID, deviceID)).Result()
+	if err != nil {
+		return SessionData{}, false, err
+	}
+	if info["owner"] == data.OwnerToken {
+		data.Platform = info["platform"]
+		data.LoggedInAt = parseUnixMilli(info["logged_in_at"])
+		data.RefreshedAt = parseUnixMilli(info["refreshed_at"])
+		data.JwtSessionID = info["jwt_session"]
+	}
+	return data, true, nil
+}
+
+func parseUnixMilli(value string) time.Time {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || millis <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// ListUserSessions 返回用户在各设备上仍存在的会话记录，不校验路由租约。
+func (dsm *DistributedSessionManager) ListUserSessions(ctx context.Context, userID string) ([]SessionData, error) {
+	devices, err := Rdb.HKeys(ctx, userRoutesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(devices)
+	sessions := make([]SessionData, 0, len(devices))
+	for _, deviceID := range devices {
+		session, exists, err := dsm.GetUserSession(ctx, userID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
 }
 
 func (dsm *DistributedSessionManager) ClaimSessionAndRoute(ctx context.Context, userID string, data SessionData, sessionTTL, routeTTL time.Duration) error {
-	return claimSessionAndRouteScript.Run(ctx, Rdb,
-		[]string{sessionKey(userID), "ws_connection_mapping", routeLeaseKey(userID)},
-		userID, encodeSession(data), data.ContainerID, data.OwnerToken,
-		sessionTTL.Milliseconds(), routeTTL.Milliseconds(),
+	return claimSessionAndRouteScript.Run(ctx, Rdb, sessionKeys(userID, data),
+		data.deviceID(), encodeSession(data), data.ContainerID, data.OwnerToken,
+		sessionTTL.Milliseconds(), routeTTL.Milliseconds(), sessionMember(userID, data.deviceID()),
+		data.Platform, time.Now().UnixMilli(), data.JwtSessionID,
 	).Err()
 }
 
@@ -107,10 +193,9 @@ func (dsm *DistributedSessionManager) RefreshOwnedSessionAndRoute(
 	data SessionData,
 	sessionTTL, routeTTL time.Duration,
 ) error {
-	updated, err := refreshOwnedSessionAndRouteScript.Run(ctx, Rdb,
-		[]string{sessionKey(userID), "ws_connection_mapping", routeLeaseKey(userID)},
-		userID, encodeSession(data), data.ContainerID, data.OwnerToken,
-		sessionTTL.Milliseconds(), routeTTL.Milliseconds(),
+	updated, err := refreshOwnedSessionAndRouteScript.Run(ctx, Rdb, sessionKeys(userID, data),
+		data.deviceID(), encodeSession(data), data.ContainerID, data.OwnerToken,
+		sessionTTL.Milliseconds(), routeTTL.Milliseconds(), time.Now().UnixMilli(),
 	).Int()
 	if err != nil {
 		return err
@@ -122,9 +207,9 @@ func (dsm *DistributedSessionManager) RefreshOwnedSessionAndRoute(
 }
 
 func (dsm *DistributedSessionManager) RemoveOwnedSessionAndRoute(ctx context.Context, userID string, data SessionData) error {
-	return removeOwnedSessionAndRouteScript.Run(ctx, Rdb,
-		[]string{sessionKey(userID), "ws_connection_mapping", routeLeaseKey(userID)},
-		userID, encodeSession(data), data.ContainerID, data.OwnerToken,
+	return removeOwnedSessionAndRouteScript.Run(ctx, Rdb, sessionKeys(userID, data),
+		data.deviceID(), encodeSession(data), data.ContainerID, data.OwnerToken,
+		sessionMember(userID, data.deviceID()),
 	).Err()
 }
 
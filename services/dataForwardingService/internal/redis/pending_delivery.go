@@ -0,0 +1,170 @@
+package redisClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pendingDeliveryKey 是用户的待投递日志：score 为 message_id，member 为完整的 ResponseMessage 帧，
+// 同一消息重复写入不会产生重复条目。
+func pendingDeliveryKey(userID string) string {
+	return "df_pending_delivery:" + userID
+}
+
+// deliveryAckCapableKey 标记用户最近登录过声明 delivery_ack 的客户端，只有这样的用户才会写入待投递日志
+func deliveryAckCapableKey(userID string) string {
+	return "df_delivery_ack:" + userID
+}
+
+// MarkDeliveryAckCapable 记录用户有会确认投递的客户端，标记在最后一次刷新后 ttl 过期。
+func MarkDeliveryAckCapable(ctx context.Context, userID string, ttl time.Duration) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	return Rdb.Set(ctx, deliveryAckCapableKey(userID), "1", ttl).Err()
+}
+
+// AppendPendingDelivery 把已存储消息的投递帧写入有确认能力的接收者的待投递日志，返回实际写入的用户。
+// 只使用旧客户端的用户不会确认，不写入日志。日志最多保留 maxEntries 条，
+// 超出时丢弃 message_id 最小的条目，客户端仍可通过消息同步取回。
+func AppendPendingDelivery(ctx context.Context, userIDs []string, messageID int64, frame []byte, maxEntries int, ttl time.Duration) ([]string, error) {
+	if Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	if maxEntries <= 0 || ttl <= 0 {
+		return nil, fmt.Errorf("无效的待投递日志配置: max_entries=%d ttl=%s", maxEntries, ttl)
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	capable := make([]*redis.IntCmd, len(userIDs))
+	_, err := Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			capable[i] = pipe.Exists(ctx, deliveryAckCapableKey(userID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	queued := make([]string, 0, len(userIDs))
+	for i, userID := range userIDs {
+		if capable[i].Val() > 0 {
+			queued = append(queued, userID)
+		}
+	}
+	if len(queued) == 0 {
+		return nil, nil
+	}
+	_, err = Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range queued {
+			key := pendingDeliveryKey(userID)
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(messageID), Member: frame})
+			pipe.ZRemRangeByRank(ctx, key, 0, int64(-maxEntries-1))
+			pipe.PExpire(ctx, key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return queued, nil
+}
+
+// ReadPendingDelivery 返回每个用户日志中 messageID 对应的投递帧，没有该消息的用户不出现在结果中。
+func ReadPendingDelivery(ctx context.Context, userIDs []string, messageID int64) (map[string][]byte, error) {
+	if Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	score := strconv.FormatInt(messageID, 10)
+	entries := make([]*redis.StringSliceCmd, len(userIDs))
+	_, err := Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			entries[i] = pipe.ZRangeByScore(ctx, pendingDeliveryKey(userID), &redis.ZRangeBy{Min: score, Max: score, Count: 1})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	frames := make(map[string][]byte)
+	for i, userID := range userIDs {
+		if values := entries[i].Val(); len(values) > 0 {
+			frames[userID] = []byte(values[0])
+		}
+	}
+	return frames, nil
+}
+
+// replacePendingDeliveryScript 只在条目仍未确认时替换 message_id 对应的投递帧，避免复活已确认的消息。
+// KEYS: [pending log]；ARGV: [message id, frame]
+var replacePendingDeliveryScript = redis.NewScript(`
+if redis.call('ZREMRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// ReplacePendingDelivery 把用户日志中 messageID 对应的投递帧替换为 frame，条目已确认或不存在时不写入。
+func ReplacePendingDelivery(ctx context.Context, userID string, messageID int64, frame []byte) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	return replacePendingDeliveryScript.Run(ctx, Rdb, []string{pendingDeliveryKey(userID)}, messageID, frame).Err()
+}
+
+// RemovePendingDelivery 从每个用户的日志中删除 messageID 对应的条目。
+func RemovePendingDelivery(ctx context.Context, userIDs []string, messageID int64) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	score := strconv.FormatInt(messageID, 10)
+	_, err := Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			pipe.ZRemRangeByScore(ctx, pendingDeliveryKey(userID), score, score)
+		}
+		return nil
+	})
+	return err
+}
+
+// ReadPendingDeliveries 按 message_id 升序返回最多 limit 条未确认的投递帧。
+func ReadPendingDeliveries(ctx context.Context, userID string, limit int) ([][]byte, error) {
+	if Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	values, err := Rdb.ZRange(ctx, pendingDeliveryKey(userID), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, len(values))
+	for i, value := range values {
+		frames[i] = []byte(value)
+	}
+	return frames, nil
+}
+
+// AckPendingDeliveries 删除客户端已确认的条目：messageIDs 中的消息，以及 message_id 不大于 upTo 的所有消息。
+func AckPendingDeliveries(ctx context.Context, userID string, messageIDs []int64, upTo int64) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	key := pendingDeliveryKey(userID)
+	_, err := Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if upTo > 0 {
+			pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(upTo, 10))
+		}
+		for _, messageID := range messageIDs {
+			score := strconv.FormatInt(messageID, 10)
+			pipe.ZRemRangeByScore(ctx, key, score, score)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,47 @@
+package redisClient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// lastSeenTTL 是最后在线时间的保留时长，超过后视为未知
+const lastSeenTTL = 30 * 24 * time.Hour
+
+func lastSeenKey(userID string) string { return "presence_last_seen:" + userID }
+
+// RecordLastSeen 记录用户最近一次断开设备会话的时间（RFC3339，UTC）。
+func RecordLastSeen(ctx context.Context, userID string, at time.Time) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	return Rdb.Set(ctx, lastSeenKey(userID), at.UTC().Format(time.RFC3339), lastSeenTTL).Err()
+}
+
+// GetLastSeen 批量读取用户的最后在线时间，没有记录的用户不出现在结果中。
+func GetLastSeen(ctx context.Context, userIDs []string) (map[string]string, error) {
+	result := make(map[string]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	if Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = lastSeenKey(userID)
+	}
+	values, err := Rdb.MGet(ctx, keys...).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for i, value := range values {
+		if lastSeen, ok := value.(string); ok && lastSeen != "" {
+			result[userIDs[i]] = lastSeen
+		}
+	}
+	return result, nil
+}
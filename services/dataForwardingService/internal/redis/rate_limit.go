@@ -0,0 +1,66 @@
+package redisClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func rateLimitKey(userID, requestType string) string {
+	return "df_rate_limit:" + userID + ":" + requestType
+}
+
+// takeTokenScript 实现令牌桶：按经过的时间补充令牌，足够时消耗一个，不足时返回需要等待的毫秒数。
+// 桶在补满所需时间后过期，空闲用户不会长期占用Redis。
+// KEYS: [bucket]；ARGV: [每毫秒补充的令牌数, 桶容量, 当前毫秒时间戳]
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate)
+  ts = now
+end
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry_after = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, retry_after}
+`)
+
+// TakeRateLimitToken 从用户某类请求的令牌桶中取一个令牌。rate 为每秒补充的令牌数，burst 为桶容量。
+// 被拒绝时返回建议的等待时间。
+func TakeRateLimitToken(ctx context.Context, userID, requestType string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	if Rdb == nil {
+		return false, 0, errors.New("Redis未初始化")
+	}
+	if rate <= 0 || burst <= 0 {
+		return false, 0, fmt.Errorf("无效的限流配置: rate=%v burst=%d", rate, burst)
+	}
+	values, err := takeTokenScript.Run(ctx, Rdb, []string{rateLimitKey(userID, requestType)},
+		rate/1000, burst, now.UnixMilli(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(values) != 2 {
+		return false, 0, fmt.Errorf("令牌桶脚本返回值异常: %v", values)
+	}
+	return values[0] == 1, time.Duration(values[1]) * time.Millisecond, nil
+}
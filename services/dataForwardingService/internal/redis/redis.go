@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -16,36 +17,29 @@ var ctx = context.Background()
 var ErrRouteNotFound = errors.New("无有效WebSocket路由")
 var ErrSessionOwnershipLost = errors.New("WebSocket会话所有权已失效")
 
-func routeLeaseKey(userID string) string { return "ws_route_lease:" + userID }
-
-var getValidRouteScript = redis.NewScript(`
-local mapped = redis.call('HGET', KEYS[1], ARGV[1])
-if not mapped then
-  return nil
-end
-local leased = redis.call('GET', KEYS[2])
-if leased and string.sub(leased, 1, string.len(mapped) + 1) == mapped .. '|' then
-  return mapped
-end
-if redis.call('HGET', KEYS[1], ARGV[1]) == mapped then
-  redis.call('HDEL', KEYS[1], ARGV[1])
-  redis.call('SREM', 'container_connections:' .. mapped, ARGV[1])
-end
-return nil
-`)
+func userRoutesKey(userID string) string { return "ws_user_routes:" + userID }
+func routeLeaseKey(userID, deviceID string) string {
+	return "ws_route_lease:" + userID + ":" + deviceID
+}
 
+// getValidRoutesScript 返回每个仍持有有效租约的设备路由（按用户ID、设备ID、容器ID三元组展开），
+// 并清理租约缺失或已迁移的设备路由。
 var getValidRoutesScript = redis.NewScript(`
 local result = {}
 for i, user_id in ipairs(ARGV) do
-  local mapped = redis.call('HGET', KEYS[1], user_id)
-  if mapped then
-    local leased = redis.call('GET', 'ws_route_lease:' .. user_id)
+  local routes_key = 'ws_user_routes:' .. user_id
+  local routes = redis.call('HGETALL', routes_key)
+  for j = 1, #routes, 2 do
+    local device_id = routes[j]
+    local mapped = routes[j + 1]
+    local leased = redis.call('GET', 'ws_route_lease:' .. user_id .. ':' .. device_id)
     if leased and string.sub(leased, 1, string.len(mapped) + 1) == mapped .. '|' then
       table.insert(result, user_id)
+      table.insert(result, device_id)
       table.insert(result, mapped)
-    elseif redis.call('HGET', KEYS[1], user_id) == mapped then
-      redis.call('HDEL', KEYS[1], user_id)
-      redis.call('SREM', 'container_connections:' .. mapped, user_id)
+    elseif redis.call('HGET', routes_key, device_id) == mapped then
+      redis.call('HDEL', routes_key, device_id)
+      redis.call('SREM', 'container_connections:' .. mapped, user_id .. ':' .. device_id)
     end
   end
 end
@@ -67,24 +61,51 @@ func InitRedis() error {
 	return nil
 }
 
-func GetContainerByConnection(id string) (string, error) {
-	if Rdb == nil {
-		return "", errors.New("Redis客户端未初始化")
+// GetContainersByConnection 返回用户所有在线设备所在的容器（去重、有序）。
+func GetContainersByConnection(id string) ([]string, error) {
+	routes, err := GetContainersByConnections([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	containers := routes[id]
+	if len(containers) == 0 {
+		return nil, ErrRouteNotFound
+	}
+	return containers, nil
+}
+
+// GetDeviceRoutesByConnection 返回用户每个在线设备所在的容器（设备ID -> 容器ID）。
+func GetDeviceRoutesByConnection(id string) (map[string]string, error) {
+	routes, err := getValidDeviceRoutes([]string{id})
+	if err != nil {
+		return nil, err
 	}
-	result, err := getValidRouteScript.Run(ctx, Rdb,
-		[]string{"ws_connection_mapping", routeLeaseKey(id)}, id,
-	).Text()
-	if errors.Is(err, redis.Nil) {
-		return "", ErrRouteNotFound
+	if len(routes[id]) == 0 {
+		return nil, ErrRouteNotFound
 	}
+	return routes[id], nil
+}
+
+// GetContainersByConnections 批量返回用户到其在线设备所在容器的映射，离线用户不出现在结果中。
+func GetContainersByConnections(ids []string) (map[string][]string, error) {
+	routes, err := getValidDeviceRoutes(ids)
 	if err != nil {
-		return "", fmt.Errorf("读取WebSocket路由失败: %w", err)
+		return nil, err
+	}
+	result := make(map[string][]string, len(routes))
+	for userID, devices := range routes {
+		for _, containerID := range devices {
+			if !containsString(result[userID], containerID) {
+				result[userID] = append(result[userID], containerID)
+			}
+		}
+		sort.Strings(result[userID])
 	}
 	return result, nil
 }
 
-func GetContainersByConnections(ids []string) (map[string]string, error) {
-	result := make(map[string]string, len(ids))
+func getValidDeviceRoutes(ids []string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string, len(ids))
 	if len(ids) == 0 {
 		return result, nil
 	}
@@ -96,12 +117,37 @@ func GetContainersByConnections(ids []string) (map[string]string, error) {
 	for i, id := range ids {
 		args[i] = id
 	}
-	values, err := getValidRoutesScript.Run(ctx, Rdb, []string{"ws_connection_mapping"}, args...).StringSlice()
+	values, err := getValidRoutesScript.Run(ctx, Rdb, nil, args...).StringSlice()
 	if err != nil {
 		return nil, fmt.Errorf("批量读取WebSocket路由失败: %w", err)
 	}
-	for i := 0; i+1 < len(values); i += 2 {
-		result[values[i]] = values[i+1]
+	for i := 0; i+2 < len(values); i += 3 {
+		if result[values[i]] == nil {
+			result[values[i]] = make(map[string]string)
+		}
+		result[values[i]][values[i+1]] = values[i+2]
 	}
 	return result, nil
 }
+
+// CountUserRoutes 统计存在设备路由记录的用户数，仅供运维查询使用。
+func CountUserRoutes(ctx context.Context) (int64, error) {
+	if Rdb == nil {
+		return 0, errors.New("Redis客户端未初始化")
+	}
+	var count int64
+	iter := Rdb.Scan(ctx, 0, userRoutesKey("*"), 200).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -31,28 +31,40 @@ func claimTestRoute(t *testing.T, userID, containerID, ownerToken string, ttl ti
 	return data
 }
 
+func sameRoutes(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func TestGetContainerByConnectionRequiresMatchingLease(t *testing.T) {
 	server := useTestRedis(t)
 	claimTestRoute(t, "1", "pod-a", "owner-a", 90*time.Second)
-	if got, err := GetContainerByConnection("1"); err != nil || got != "pod-a" {
+	if got, err := GetContainersByConnection("1"); err != nil || !sameRoutes(got, "pod-a") {
 		t.Fatalf("expected valid route, got route=%q err=%v", got, err)
 	}
 
-	server.Del(routeLeaseKey("1"))
-	if got, err := GetContainerByConnection("1"); !errors.Is(err, ErrRouteNotFound) || got != "" {
+	server.Del(routeLeaseKey("1", DefaultDeviceID))
+	if got, err := GetContainersByConnection("1"); !errors.Is(err, ErrRouteNotFound) || len(got) != 0 {
 		t.Fatalf("missing lease returned stale route: route=%q err=%v", got, err)
 	}
-	if server.HGet("ws_connection_mapping", "1") != "" {
+	if server.HGet(userRoutesKey("1"), DefaultDeviceID) != "" {
 		t.Fatal("stale hash mapping was not cleaned")
 	}
 }
 
 func TestGetContainerByConnectionRejectsMismatchedLeaseWithoutDeletingMigratedRoute(t *testing.T) {
 	server := useTestRedis(t)
-	server.HSet("ws_connection_mapping", "1", "pod-old")
-	server.SAdd("container_connections:pod-old", "1")
-	server.Set(routeLeaseKey("1"), "pod-new|owner-new")
-	if _, err := GetContainerByConnection("1"); !errors.Is(err, ErrRouteNotFound) {
+	server.HSet(userRoutesKey("1"), DefaultDeviceID, "pod-old")
+	server.SAdd("container_connections:pod-old", sessionMember("1", DefaultDeviceID))
+	server.Set(routeLeaseKey("1", DefaultDeviceID), "pod-new|owner-new")
+	if _, err := GetContainersByConnection("1"); !errors.Is(err, ErrRouteNotFound) {
 		t.Fatalf("expected mismatched route rejection, got %v", err)
 	}
 
@@ -61,7 +73,7 @@ func TestGetContainerByConnectionRejectsMismatchedLeaseWithoutDeletingMigratedRo
 	if err := (&DistributedSessionManager{}).RemoveOwnedSessionAndRoute(context.Background(), "1", old); err != nil {
 		t.Fatal(err)
 	}
-	if got, err := GetContainerByConnection("1"); err != nil || got != "pod-new" {
+	if got, err := GetContainersByConnection("1"); err != nil || !sameRoutes(got, "pod-new") {
 		t.Fatalf("old cleanup deleted migrated route: route=%q err=%v", got, err)
 	}
 }
@@ -71,7 +83,7 @@ func TestExpiredLeaseIsNotRoutable(t *testing.T) {
 	const leaseTTL = 90 * time.Second
 	claimTestRoute(t, "1", "pod-a", "owner-a", leaseTTL)
 	server.FastForward(leaseTTL + time.Second)
-	if _, err := GetContainerByConnection("1"); !errors.Is(err, ErrRouteNotFound) {
+	if _, err := GetContainersByConnection("1"); !errors.Is(err, ErrRouteNotFound) {
 		t.Fatalf("expired lease remained routable: %v", err)
 	}
 }
@@ -84,7 +96,7 @@ func TestOldOwnerCannotUnregisterNewRouteInSameContainer(t *testing.T) {
 	if err := (&DistributedSessionManager{}).RemoveOwnedSessionAndRoute(ctx, "1", old); err != nil {
 		t.Fatal(err)
 	}
-	if route, err := GetContainerByConnection("1"); err != nil || route != "pod-a" {
+	if route, err := GetContainersByConnection("1"); err != nil || !sameRoutes(route, "pod-a") {
 		t.Fatalf("old owner removed new route: route=%q err=%v", route, err)
 	}
 }
@@ -92,19 +104,54 @@ func TestOldOwnerCannotUnregisterNewRouteInSameContainer(t *testing.T) {
 func TestGetContainersByConnectionsReturnsOnlyValidRoutes(t *testing.T) {
 	server := useTestRedis(t)
 	claimTestRoute(t, "1", "pod-a", "owner-a", 90*time.Second)
-	server.HSet("ws_connection_mapping", "2", "pod-b")
-	server.HSet("ws_connection_mapping", "3", "pod-old")
-	server.Set(routeLeaseKey("3"), "pod-new|owner-new")
+	server.HSet(userRoutesKey("2"), DefaultDeviceID, "pod-b")
+	server.HSet(userRoutesKey("3"), DefaultDeviceID, "pod-old")
+	server.Set(routeLeaseKey("3", DefaultDeviceID), "pod-new|owner-new")
 
 	routes, err := GetContainersByConnections([]string{"1", "2", "3", "4"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(routes) != 1 || routes["1"] != "pod-a" {
+	if len(routes) != 1 || !sameRoutes(routes["1"], "pod-a") {
 		t.Fatalf("batch returned invalid routes: %+v", routes)
 	}
 }
 
+func TestDeviceSessionsRouteIndependently(t *testing.T) {
+	server := useTestRedis(t)
+	ctx := context.Background()
+	dsm := &DistributedSessionManager{}
+	phone := SessionData{ConnectionID: "phone", ContainerID: "pod-b", OwnerToken: "owner-phone", DeviceID: "phone"}
+	desktop := SessionData{ConnectionID: "desktop", ContainerID: "pod-a", OwnerToken: "owner-desktop", DeviceID: "desktop"}
+	tablet := SessionData{ConnectionID: "tablet", ContainerID: "pod-a", OwnerToken: "owner-tablet", DeviceID: "tablet"}
+	for _, data := range []SessionData{phone, desktop, tablet} {
+		if err := dsm.ClaimSessionAndRoute(ctx, "5", data, time.Minute, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if routes, err := GetContainersByConnection("5"); err != nil || !sameRoutes(routes, "pod-a", "pod-b") {
+		t.Fatalf("expected both device containers, got %v err=%v", routes, err)
+	}
+	sessions, err := dsm.ListUserSessions(ctx, "5")
+	if err != nil || len(sessions) != 3 || sessions[0].DeviceID != "desktop" || sessions[1].OwnerToken != "owner-phone" {
+		t.Fatalf("unexpected device sessions: %+v err=%v", sessions, err)
+	}
+
+	if err := dsm.RemoveOwnedSessionAndRoute(ctx, "5", phone); err != nil {
+		t.Fatal(err)
+	}
+	if routes, err := GetContainersByConnection("5"); err != nil || !sameRoutes(routes, "pod-a") {
+		t.Fatalf("removing one device changed other routes: %v err=%v", routes, err)
+	}
+	server.Del(routeLeaseKey("5", "desktop"))
+	if routes, err := GetContainersByConnection("5"); err != nil || !sameRoutes(routes, "pod-a") {
+		t.Fatalf("tablet route lost with desktop lease: %v err=%v", routes, err)
+	}
+	if server.HGet(userRoutesKey("5"), "desktop") != "" {
+		t.Fatal("stale device route was not cleaned")
+	}
+}
+
 func TestRedisFailureIsDistinctFromOfflineRoute(t *testing.T) {
 	previous := Rdb
 	Rdb = redis.NewClient(&redis.Options{
@@ -117,7 +164,7 @@ func TestRedisFailureIsDistinctFromOfflineRoute(t *testing.T) {
 		Rdb = previous
 	})
 
-	_, err := GetContainerByConnection("1")
+	_, err := GetContainersByConnection("1")
 	if err == nil || errors.Is(err, ErrRouteNotFound) {
 		t.Fatalf("Redis failure was mistaken for offline route: %v", err)
 	}
@@ -149,7 +196,7 @@ func TestSessionAndLockCleanupRequireMatchingOwner(t *testing.T) {
 	if err := dsm.RemoveOwnedSessionAndRoute(ctx, "9", old); err != nil {
 		t.Fatal(err)
 	}
-	current, exists, err := dsm.GetUserSession(ctx, "9")
+	current, exists, err := dsm.GetUserSession(ctx, "9", "")
 	if err != nil || !exists || current.OwnerToken != "owner-b" {
 		t.Fatalf("foreign cleanup removed current session: %+v exists=%v err=%v", current, exists, err)
 	}
@@ -170,10 +217,10 @@ func TestRefreshOwnedSessionAndRouteSurvivesOriginal24HourBoundary(t *testing.T)
 		}
 	}
 	server.FastForward(90 * time.Minute)
-	if _, exists, err := dsm.GetUserSession(ctx, "10"); err != nil || !exists {
+	if _, exists, err := dsm.GetUserSession(ctx, "10", ""); err != nil || !exists {
 		t.Fatalf("renewed session expired: exists=%v err=%v", exists, err)
 	}
-	if route, err := GetContainerByConnection("10"); err != nil || route != "pod-a" {
+	if route, err := GetContainersByConnection("10"); err != nil || !sameRoutes(route, "pod-a") {
 		t.Fatalf("renewed route expired: route=%q err=%v", route, err)
 	}
 }
@@ -193,7 +240,7 @@ func TestRefreshOwnedSessionAndRouteFencesOldOwner(t *testing.T) {
 	if err := dsm.RefreshOwnedSessionAndRoute(ctx, "11", oldOwner, time.Minute, time.Minute); !errors.Is(err, ErrSessionOwnershipLost) {
 		t.Fatalf("old owner renewed migrated session: %v", err)
 	}
-	current, exists, err := dsm.GetUserSession(ctx, "11")
+	current, exists, err := dsm.GetUserSession(ctx, "11", "")
 	if err != nil || !exists || current.OwnerToken != newOwner.OwnerToken {
 		t.Fatalf("old refresh changed current owner: %+v exists=%v err=%v", current, exists, err)
 	}
@@ -262,3 +309,193 @@ func TestDisconnectedKickSubscriptionCancelsDuringBackoff(t *testing.T) {
 		t.Fatal("disconnected subscription ignored lifecycle cancellation")
 	}
 }
+
+func TestReplayFramesResumeOrRequireSyncAfterTrim(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	if lastSeq, err := ClaimReplayBuffer(ctx, "42", "phone", "owner-a", time.Minute); err != nil || lastSeq != 0 {
+		t.Fatalf("claim empty buffer: last_seq=%d err=%v", lastSeq, err)
+	}
+	for _, batch := range [][]int64{{1, 2}, {3, 4, 5}} {
+		frames := make([]ReplayFrame, 0, len(batch))
+		for _, seq := range batch {
+			frames = append(frames, ReplayFrame{Seq: seq, Payload: []byte{byte(seq), ':', 0}})
+		}
+		if err := AppendReplayFrames(ctx, "42", "phone", "owner-a", frames, 3, time.Minute); err != nil {
+			t.Fatalf("append frames %v: %v", batch, err)
+		}
+	}
+
+	window, err := ReadReplayFrames(ctx, "42", "phone", 2)
+	if err != nil || !window.Complete || window.LastSeq != 5 || len(window.Frames) != 3 {
+		t.Fatalf("expected frames 3-5 to be replayable: %+v err=%v", window, err)
+	}
+	if window.Frames[0].Seq != 3 || string(window.Frames[0].Payload) != string([]byte{3, ':', 0}) {
+		t.Fatalf("binary payload was not preserved: %+v", window.Frames[0])
+	}
+	for _, lastSeq := range []int64{1, 6} {
+		window, err = ReadReplayFrames(ctx, "42", "phone", lastSeq)
+		if err != nil || window.Complete || len(window.Frames) != 0 {
+			t.Fatalf("last_seq %d must require a full sync: %+v err=%v", lastSeq, window, err)
+		}
+	}
+	window, err = ReadReplayFrames(ctx, "42", "tablet", 0)
+	if err != nil || !window.Complete || window.LastSeq != 0 {
+		t.Fatalf("a device without pushes has nothing to replay: %+v err=%v", window, err)
+	}
+}
+
+func TestReplayBufferRejectsFramesFromReplacedLogin(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	if _, err := ClaimReplayBuffer(ctx, "42", "phone", "owner-old", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendReplayFrames(ctx, "42", "phone", "owner-old", []ReplayFrame{{Seq: 1, Payload: []byte("a")}}, 8, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	lastSeq, err := ClaimReplayBuffer(ctx, "42", "phone", "owner-new", time.Minute)
+	if err != nil || lastSeq != 1 {
+		t.Fatalf("new login must continue after the written frames: last_seq=%d err=%v", lastSeq, err)
+	}
+	err = AppendReplayFrames(ctx, "42", "phone", "owner-old", []ReplayFrame{{Seq: 2, Payload: []byte("late")}}, 8, time.Minute)
+	if !errors.Is(err, ErrReplayOwnerChanged) {
+		t.Fatalf("late frames from the replaced login must be rejected: %v", err)
+	}
+	if err := AppendReplayFrames(ctx, "42", "phone", "owner-new", []ReplayFrame{{Seq: 2, Payload: []byte("b")}, {Seq: 4, Payload: []byte("d")}}, 8, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	window, err := ReadReplayFrames(ctx, "42", "phone", 1)
+	if err != nil || window.Complete || window.LastSeq != 4 {
+		t.Fatalf("a gap left by a failed batch must require a full sync: %+v err=%v", window, err)
+	}
+	window, err = ReadReplayFrames(ctx, "42", "phone", 3)
+	if err != nil || !window.Complete || len(window.Frames) != 1 || string(window.Frames[0].Payload) != "d" {
+		t.Fatalf("frames after the gap are still replayable: %+v err=%v", window, err)
+	}
+}
+
+func TestLastSeenRoundTripAndExpiry(t *testing.T) {
+	server := useTestRedis(t)
+	at := time.Date(2026, 7, 21, 12, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	if err := RecordLastSeen(context.Background(), "7", at); err != nil {
+		t.Fatal(err)
+	}
+	lastSeen, err := GetLastSeen(context.Background(), []string{"7", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lastSeen) != 1 || lastSeen["7"] != "2026-07-21T04:00:00Z" {
+		t.Fatalf("unexpected last seen: %+v", lastSeen)
+	}
+	server.FastForward(lastSeenTTL + time.Second)
+	if lastSeen, err := GetLastSeen(context.Background(), []string{"7"}); err != nil || len(lastSeen) != 0 {
+		t.Fatalf("expired last seen was returned: %+v err=%v", lastSeen, err)
+	}
+}
+
+func TestRateLimitTokenBucketRefillsOverTime(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	now := time.UnixMilli(1_700_000_000_000)
+	for i := 0; i < 2; i++ {
+		allowed, _, err := TakeRateLimitToken(ctx, "7", "post", 1, 2, now)
+		if err != nil || !allowed {
+			t.Fatalf("burst token %d rejected: allowed=%t err=%v", i, allowed, err)
+		}
+	}
+	allowed, retryAfter, err := TakeRateLimitToken(ctx, "7", "post", 1, 2, now.Add(250*time.Millisecond))
+	if err != nil || allowed || retryAfter != 750*time.Millisecond {
+		t.Fatalf("empty bucket: allowed=%t retry_after=%s err=%v", allowed, retryAfter, err)
+	}
+	if allowed, _, err := TakeRateLimitToken(ctx, "7", "insert_contact", 1, 2, now); err != nil || !allowed {
+		t.Fatalf("request types must use separate buckets: allowed=%t err=%v", allowed, err)
+	}
+	if allowed, _, err := TakeRateLimitToken(ctx, "7", "post", 1, 2, now.Add(time.Second)); err != nil || !allowed {
+		t.Fatalf("refilled token rejected: allowed=%t err=%v", allowed, err)
+	}
+	if _, _, err := TakeRateLimitToken(ctx, "7", "post", 0, 2, now); err == nil {
+		t.Fatal("invalid rate was accepted")
+	}
+}
+
+func TestSpilledFramesKeepOrderUpToLimitPerConnection(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	if err := PushSpilledFrames(ctx, "7", "phone", "owner", [][]byte{[]byte("a")}, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushSpilledFrames(ctx, "7", "phone", "owner", [][]byte{[]byte("b"), []byte("c")}, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushSpilledFrames(ctx, "7", "phone", "owner", [][]byte{[]byte("d")}, 3, time.Minute); !errors.Is(err, ErrSpillQueueFull) {
+		t.Fatalf("expected full spill queue, got %v", err)
+	}
+	if frames, err := PopSpilledFrames(ctx, "7", "phone", "new-owner", 8); err != nil || len(frames) != 0 {
+		t.Fatalf("a new connection must not see frames of the old one: %q err=%v", frames, err)
+	}
+	frames, err := PopSpilledFrames(ctx, "7", "phone", "owner", 2)
+	if err != nil || len(frames) != 2 || string(frames[0]) != "a" || string(frames[1]) != "b" {
+		t.Fatalf("unexpected first frames: %q err=%v", frames, err)
+	}
+	if err := DeleteSpilledFrames(ctx, "7", "phone", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := PopSpilledFrames(ctx, "7", "phone", "owner", 8); err != nil || len(frames) != 0 {
+		t.Fatalf("deleted spill queue still returned frames: %q err=%v", frames, err)
+	}
+}
+
+func TestPendingDeliveryLogIsOrderedDedupedAndCapped(t *testing.T) {
+	useTestRedis(t)
+	ctx := context.Background()
+	for _, userID := range []string{"7", "8"} {
+		if err := MarkDeliveryAckCapable(ctx, userID, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, messageID := range []int64{3, 1, 2, 3} {
+		frame := []byte{byte('a' + messageID)}
+		queued, err := AppendPendingDelivery(ctx, []string{"7", "8", "9"}, messageID, frame, 2, time.Minute)
+		if err != nil || len(queued) != 2 {
+			t.Fatalf("only users with an ack-capable client are queued: %v err=%v", queued, err)
+		}
+	}
+	if frames, err := ReadPendingDeliveries(ctx, "9", 10); err != nil || len(frames) != 0 {
+		t.Fatalf("legacy-only user must not accumulate pending frames: %q err=%v", frames, err)
+	}
+	for _, userID := range []string{"7", "8"} {
+		frames, err := ReadPendingDeliveries(ctx, userID, 10)
+		if err != nil || len(frames) != 2 || string(frames[0]) != "c" || string(frames[1]) != "d" {
+			t.Fatalf("user %s: unexpected pending frames %q err=%v", userID, frames, err)
+		}
+	}
+	if err := AckPendingDeliveries(ctx, "7", []int64{3}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := ReadPendingDeliveries(ctx, "7", 10); err != nil || len(frames) != 1 || string(frames[0]) != "c" {
+		t.Fatalf("ack removed the wrong entry: %q err=%v", frames, err)
+	}
+	if frames, err := ReadPendingDeliveries(ctx, "8", 10); err != nil || len(frames) != 2 {
+		t.Fatalf("ack must not affect other users: %q err=%v", frames, err)
+	}
+
+	if err := ReplacePendingDelivery(ctx, "7", 3, []byte("edited")); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := ReadPendingDeliveries(ctx, "7", 10); err != nil || len(frames) != 1 || string(frames[0]) != "c" {
+		t.Fatalf("replacing an acked entry must not bring it back: %q err=%v", frames, err)
+	}
+	if err := ReplacePendingDelivery(ctx, "8", 3, []byte("edited")); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := ReadPendingDelivery(ctx, []string{"7", "8"}, 3); err != nil || len(frames) != 1 || string(frames["8"]) != "edited" {
+		t.Fatalf("unexpected replaced frames: %q err=%v", frames, err)
+	}
+	if err := RemovePendingDelivery(ctx, []string{"7", "8"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := ReadPendingDeliveries(ctx, "8", 10); err != nil || len(frames) != 1 || string(frames[0]) != "edited" {
+		t.Fatalf("recalled entry was not removed: %q err=%v", frames, err)
+	}
+}
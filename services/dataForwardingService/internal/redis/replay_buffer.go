@@ -0,0 +1,186 @@
+package redisClient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func sessionSeqKey(userID, deviceID string) string {
+	return "ws_session_seq:" + userID + ":" + deviceID
+}
+func replayBufferKey(userID, deviceID string) string {
+	return "ws_replay:" + userID + ":" + deviceID
+}
+
+// replayOwnerKey 记录当前写入重放缓冲区的登录，同一设备重新登录后旧连接迟到的帧不再写入
+func replayOwnerKey(userID, deviceID string) string {
+	return "ws_replay_owner:" + userID + ":" + deviceID
+}
+
+// claimReplayBufferScript 把重放缓冲区的写入权交给新的登录，并返回已写入的最大序列号。
+// KEYS: [owner, seq counter, replay list]；ARGV: [owner token, ttl ms]
+var claimReplayBufferScript = redis.NewScript(`
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+redis.call('PEXPIRE', KEYS[3], ARGV[2])
+return tonumber(redis.call('GET', KEYS[2]) or '0')
+`)
+
+// appendReplayFramesScript 在写入权仍属于 ARGV[1] 时按顺序追加一批 "seq:payload" 条目，
+// 把序列号计数器推进到最后一帧，并裁剪为定长重放缓冲区；写入权已转移时返回 0。
+// KEYS: [owner, seq counter, replay list]；ARGV: [owner token, max frames, ttl ms, last seq, entries...]
+var appendReplayFramesScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+  return 0
+end
+redis.call('RPUSH', KEYS[3], unpack(ARGV, 5))
+redis.call('LTRIM', KEYS[3], -tonumber(ARGV[2]), -1)
+redis.call('SET', KEYS[2], ARGV[4], 'PX', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[3])
+return 1
+`)
+
+// ErrReplayOwnerChanged 表示同一设备已经重新登录，旧连接的帧不再写入重放缓冲区
+var ErrReplayOwnerChanged = errors.New("重放缓冲区已由新的登录接管")
+
+// ReplayFrame 重放缓冲区中的一帧，Payload 为未带序列号的 ResponseMessage 字节
+type ReplayFrame struct {
+	Seq     int64
+	Payload []byte
+}
+
+// ReplayWindow 是 afterSeq 之后仍可补发的帧。Complete 为 false 表示缓冲区已被裁剪或过期，
+// 无法保证补发连续，客户端需要全量同步。
+type ReplayWindow struct {
+	Frames   []ReplayFrame
+	LastSeq  int64
+	Complete bool
+}
+
+// ClaimReplayBuffer 在登录后接管设备会话的重放缓冲区，返回已写入的最大序列号，本次登录从它的下一个序号开始编号。
+// 序列号计数器与缓冲区共享 TTL，会话长时间没有推送时两者一起过期，重连后序列号从 1 重新开始。
+func ClaimReplayBuffer(ctx context.Context, userID, deviceID, ownerToken string, ttl time.Duration) (int64, error) {
+	if Rdb == nil {
+		return 0, errors.New("Redis未初始化")
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("无效的重放缓冲区配置: ttl=%s", ttl)
+	}
+	return claimReplayBufferScript.Run(ctx, Rdb,
+		[]string{replayOwnerKey(userID, deviceID), sessionSeqKey(userID, deviceID), replayBufferKey(userID, deviceID)},
+		ownerToken, ttl.Milliseconds(),
+	).Int64()
+}
+
+// AppendReplayFrames 把一批序号递增的帧写入重放缓冲区，缓冲区最多保留 maxFrames 帧。
+// ownerToken 不再持有写入权时返回 ErrReplayOwnerChanged，整批帧都不写入。
+func AppendReplayFrames(ctx context.Context, userID, deviceID, ownerToken string, frames []ReplayFrame, maxFrames int, ttl time.Duration) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	if maxFrames <= 0 || ttl <= 0 {
+		return fmt.Errorf("无效的重放缓冲区配置: max_frames=%d ttl=%s", maxFrames, ttl)
+	}
+	if len(frames) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(frames)+4)
+	args = append(args, ownerToken, maxFrames, ttl.Milliseconds(), frames[len(frames)-1].Seq)
+	for _, frame := range frames {
+		entry := strconv.AppendInt(make([]byte, 0, len(frame.Payload)+21), frame.Seq, 10)
+		entry = append(entry, ':')
+		args = append(args, append(entry, frame.Payload...))
+	}
+	appended, err := appendReplayFramesScript.Run(ctx, Rdb,
+		[]string{replayOwnerKey(userID, deviceID), sessionSeqKey(userID, deviceID), replayBufferKey(userID, deviceID)},
+		args...,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if appended == 0 {
+		return ErrReplayOwnerChanged
+	}
+	return nil
+}
+
+// ReadReplayFrames 读取设备会话中 seq 大于 afterSeq 的帧。
+func ReadReplayFrames(ctx context.Context, userID, deviceID string, afterSeq int64) (ReplayWindow, error) {
+	if Rdb == nil {
+		return ReplayWindow{}, errors.New("Redis未初始化")
+	}
+	var lastSeqCmd *redis.StringCmd
+	var framesCmd *redis.StringSliceCmd
+	_, err := Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		lastSeqCmd = pipe.Get(ctx, sessionSeqKey(userID, deviceID))
+		framesCmd = pipe.LRange(ctx, replayBufferKey(userID, deviceID), 0, -1)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return ReplayWindow{}, err
+	}
+
+	window := ReplayWindow{}
+	if raw, err := lastSeqCmd.Result(); err == nil {
+		if window.LastSeq, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return ReplayWindow{}, fmt.Errorf("解析会话序列号失败: %w", err)
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		return ReplayWindow{}, err
+	}
+	if afterSeq < 0 || afterSeq > window.LastSeq {
+		// 客户端序列号超前说明计数器已过期重置，之前的帧无从判断是否遗漏
+		return window, nil
+	}
+	if afterSeq == window.LastSeq {
+		window.Complete = true
+		return window, nil
+	}
+
+	entries, err := framesCmd.Result()
+	if err != nil {
+		return ReplayWindow{}, err
+	}
+	expected := afterSeq + 1
+	for _, entry := range entries {
+		frame, err := parseReplayEntry(entry)
+		if err != nil {
+			return ReplayWindow{}, err
+		}
+		if frame.Seq <= afterSeq {
+			continue
+		}
+		if frame.Seq != expected {
+			// 缓冲区被裁剪，或者某一批帧没有写入成功
+			window.Frames = nil
+			return window, nil
+		}
+		window.Frames = append(window.Frames, frame)
+		expected++
+	}
+	window.Complete = expected == window.LastSeq+1
+	if !window.Complete {
+		window.Frames = nil
+	}
+	return window, nil
+}
+
+func parseReplayEntry(entry string) (ReplayFrame, error) {
+	raw := []byte(entry)
+	separator := bytes.IndexByte(raw, ':')
+	if separator <= 0 {
+		return ReplayFrame{}, errors.New("重放缓冲区条目格式错误")
+	}
+	seq, err := strconv.ParseInt(string(raw[:separator]), 10, 64)
+	if err != nil {
+		return ReplayFrame{}, fmt.Errorf("解析重放帧序列号失败: %w", err)
+	}
+	return ReplayFrame{Seq: seq, Payload: raw[separator+1:]}, nil
+}
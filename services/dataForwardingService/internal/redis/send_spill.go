@@ -0,0 +1,82 @@
+package redisClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSpillQueueFull 表示连接的待发送队列已达到上限
+var ErrSpillQueueFull = errors.New("待发送队列已满")
+
+// sendSpillKey 按登录的 ownerToken 区分同一设备的不同连接，新连接不会收到旧连接遗留的帧
+func sendSpillKey(userID, deviceID, ownerToken string) string {
+	return "ws_spill:" + userID + ":" + deviceID + ":" + ownerToken
+}
+
+// pushSpilledFramesScript 在队列放得下时按顺序追加一批帧并刷新过期时间，放不下时不写入并返回 0。
+// KEYS: [spill list]；ARGV: [max frames, ttl ms, payload...]
+var pushSpilledFramesScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[1]) + #ARGV - 2 > tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('RPUSH', KEYS[1], unpack(ARGV, 3))
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// PushSpilledFrames 把发送通道放不下的帧按顺序追加到连接的待发送队列，队列最多保留 maxFrames 帧。
+func PushSpilledFrames(ctx context.Context, userID, deviceID, ownerToken string, payloads [][]byte, maxFrames int, ttl time.Duration) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	if maxFrames <= 0 || ttl <= 0 {
+		return fmt.Errorf("无效的待发送队列配置: max_frames=%d ttl=%s", maxFrames, ttl)
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(payloads)+2)
+	args = append(args, maxFrames, ttl.Milliseconds())
+	for _, payload := range payloads {
+		args = append(args, payload)
+	}
+	pushed, err := pushSpilledFramesScript.Run(ctx, Rdb, []string{sendSpillKey(userID, deviceID, ownerToken)}, args...).Int()
+	if err != nil {
+		return err
+	}
+	if pushed == 0 {
+		return ErrSpillQueueFull
+	}
+	return nil
+}
+
+// PopSpilledFrames 按写入顺序取出最多 count 帧，队列为空时返回空切片。
+func PopSpilledFrames(ctx context.Context, userID, deviceID, ownerToken string, count int) ([][]byte, error) {
+	if Rdb == nil {
+		return nil, errors.New("Redis未初始化")
+	}
+	values, err := Rdb.LPopCount(ctx, sendSpillKey(userID, deviceID, ownerToken), count).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, len(values))
+	for i, value := range values {
+		frames[i] = []byte(value)
+	}
+	return frames, nil
+}
+
+// DeleteSpilledFrames 在连接关闭时丢弃未发送的帧，带序列号的帧仍可通过重放缓冲区续传。
+func DeleteSpilledFrames(ctx context.Context, userID, deviceID, ownerToken string) error {
+	if Rdb == nil {
+		return errors.New("Redis未初始化")
+	}
+	return Rdb.Del(ctx, sendSpillKey(userID, deviceID, ownerToken)).Err()
+}
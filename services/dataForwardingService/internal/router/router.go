@@ -1,6 +1,7 @@
 package router
 
 import (
+	pb "Betterfly2/proto/data_forwarding"
 	envelope "Betterfly2/proto/envelope"
 	"Betterfly2/shared/logger"
 	"Betterfly2/shared/metrics"
@@ -11,7 +12,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
 // Router 负责消息路由
@@ -28,34 +32,60 @@ func NewRouter(connManager *connection.ConnectionManager) *Router {
 	}
 }
 
-// RouteMessage 路由消息到指定用户
+// RouteMessage 将 ResponseMessage 字节投递到用户所有在线设备：
+// 本地设备直接入队，其他容器各发送一条 ClientResponseDelivery。
 func (r *Router) RouteMessage(toUserID string, message []byte) error {
 	start := time.Now()
 	sugar := logger.Sugar()
 
-	// 1. 先尝试本地路由
-	if r.routeLocally(toUserID, message) {
+	// 1. 投递到本容器内的所有设备
+	var firstErr error
+	localErr := r.DeliverLocally(toUserID, message)
+	deliveredLocally := localErr == nil
+	if localErr != nil && !errors.Is(localErr, ErrUserOffline) {
+		sugar.Errorf("本地消息发送失败: %v", localErr)
+		firstErr = localErr
+	}
+	if deliveredLocally {
 		sugar.Debugf("消息本地路由成功: %s", toUserID)
 		metrics.RecordMessageRouted("local", start)
-		return nil
 	}
 
-	// 2. 检查用户是否在其他容器在线
-	targetContainerID, routeErr := redisClient.GetContainerByConnection(toUserID)
-	if routeErr == nil {
-		// 用户在其他容器在线，进行跨容器路由
-		err := r.routeCrossContainer(toUserID, targetContainerID, message)
-		if err != nil {
-			metrics.RecordRoutingError()
-		} else {
-			metrics.RecordMessageRouted("cross_container", start)
+	// 2. 查找该用户其他设备所在的容器
+	containers, routeErr := redisClient.GetContainersByConnection(toUserID)
+	if routeErr != nil && !errors.Is(routeErr, redisClient.ErrRouteNotFound) {
+		metrics.RecordRoutingError()
+		return routeErr
+	}
+
+	currentContainerID := currentContainerID()
+	routedRemotely := false
+	for _, targetContainerID := range containers {
+		if targetContainerID == currentContainerID {
+			if localErr != nil && errors.Is(localErr, ErrUserOffline) {
+				sugar.Warnf("Redis连接映射异常：用户 %s 应该在本地容器但本地没有设备连接", toUserID)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("本地路由失败且Redis映射异常")
+				}
+			}
+			continue
 		}
-		return err
+		if err := r.routeCrossContainer(toUserID, targetContainerID, message); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		routedRemotely = true
+		metrics.RecordMessageRouted("cross_container", start)
 	}
 
-	if !errors.Is(routeErr, redisClient.ErrRouteNotFound) {
+	if firstErr != nil {
 		metrics.RecordRoutingError()
-		return routeErr
+		return firstErr
+	}
+	if deliveredLocally || routedRemotely {
+		return nil
 	}
 
 	// 离线消息已经由 storageService 持久化；实时投递失败必须显式返回，不能伪装成功。
@@ -64,44 +94,43 @@ func (r *Router) RouteMessage(toUserID string, message []byte) error {
 	return ErrUserOffline
 }
 
-// routeLocally 尝试本地路由
-func (r *Router) routeLocally(toUserID string, message []byte) bool {
-	// 检查用户是否在本地连接
-	if _, exists := r.connManager.GetConnectionByUserID(toUserID); exists {
-		// 发送消息到本地连接
-		err := r.connManager.SendMessageToUser(toUserID, message)
-		if err != nil {
-			logger.Sugar().Errorf("本地消息发送失败: %v", err)
-			return false
-		}
-		return true
+// DeliverLocally 只投递到用户在本容器内的设备连接，不做跨容器转发。
+// 用于处理其他容器已按容器拆分好的投递，避免再次扇出。
+func (r *Router) DeliverLocally(toUserID string, message []byte) error {
+	if len(r.connManager.GetConnectionsByUserID(toUserID)) == 0 {
+		return ErrUserOffline
+	}
+	return r.connManager.SendMessageToUser(toUserID, message)
+}
+
+// DeliverEphemeralLocally 投递到用户在本容器内的设备，帧不进入会话序列和重放缓冲区。
+func (r *Router) DeliverEphemeralLocally(toUserID string, message []byte) error {
+	if len(r.connManager.GetConnectionsByUserID(toUserID)) == 0 {
+		return ErrUserOffline
 	}
-	return false
+	return r.connManager.SendEphemeralToUser(toUserID, message)
 }
 
 // routeCrossContainer 跨容器路由
 func (r *Router) routeCrossContainer(toUserID string, targetContainerID string, message []byte) error {
 	sugar := logger.Sugar()
 
-	// 获取当前容器ID
-	currentContainerID := os.Getenv("HOSTNAME")
-	if currentContainerID == "" {
-		currentContainerID = "local"
+	userID, err := strconv.ParseInt(toUserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("无效的目标用户ID %q: %w", toUserID, err)
 	}
-
-	// 检查目标容器是否与当前容器相同
-	if targetContainerID == currentContainerID {
-		sugar.Warnf("Redis连接映射异常：用户 %s 应该在本地容器但本地路由失败，尝试重试本地路由", toUserID)
-		// Redis数据可能过期，重试本地路由
-		if r.routeLocally(toUserID, message) {
-			sugar.Debugf("重试本地路由成功: %s", toUserID)
-			return nil
-		}
-		sugar.Errorf("重试本地路由仍然失败: %s", toUserID)
-		return fmt.Errorf("本地路由失败且Redis映射异常")
+	payload, err := proto.Marshal(&pb.DFInternalDelivery{
+		Payload: &pb.DFInternalDelivery_ClientResponseDelivery{
+			ClientResponseDelivery: &pb.ClientResponseDelivery{
+				TargetUserIds:   []int64{userID},
+				ResponseMessage: message,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("序列化跨容器投递失败: %w", err)
 	}
-
-	envBytes, err := mq.MarshalEnvelopeBytes(envelope.MessageType_DF_REQUEST, message)
+	envBytes, err := mq.MarshalEnvelopeBytes(envelope.MessageType_DF_RESPONSE, payload)
 	if err != nil {
 		sugar.Errorf("序列化Envelope失败: %v", err)
 		return err
@@ -119,3 +148,10 @@ func (r *Router) routeCrossContainer(toUserID string, targetContainerID string,
 	metrics.RecordKafkaMessageProduced(targetContainerID)
 	return nil
 }
+
+func currentContainerID() string {
+	if value := os.Getenv("HOSTNAME"); value != "" {
+		return value
+	}
+	return "local"
+}
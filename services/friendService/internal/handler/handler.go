@@ -10,6 +10,7 @@ import (
 	"Betterfly2/shared/mq"
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"google.golang.org/protobuf/proto"
@@ -20,6 +21,8 @@ type friendRequestContext struct {
 	handler  *FriendHandler
 	request  *friend.RequestMessage
 	database *gorm.DB
+	// events 在事务提交后随应答一同经 Outbox 发布到请求来源的 DF 主题
+	events *[]*friend.ResponseMessage
 }
 
 type friendRequestModule func(*dispatch.OneofRouter[friendRequestContext, *friend.ResponseMessage])
@@ -82,8 +85,9 @@ func (h *FriendHandler) HandleMessage(ctx context.Context, message []byte) error
 	}
 
 	_, err := db.ExecuteInboxOutbox(ctx, h.requestDatabase(), "friend", operationKey, func(tx *gorm.DB) ([]byte, []db.PendingOutboxEvent, error) {
+		var events []*friend.ResponseMessage
 		resp, dispatchErr := getFriendRequestRouter().Dispatch(friendRequestContext{
-			handler: h, request: req, database: tx,
+			handler: h, request: req, database: tx, events: &events,
 		}, req.Payload)
 		if dispatchErr != nil {
 			logger.Sugar().Errorw("处理friend请求暂时失败", "operation_key", operationKey, "error", dispatchErr)
@@ -92,6 +96,7 @@ func (h *FriendHandler) HandleMessage(ctx context.Context, message []byte) error
 		if resp == nil {
 			return nil, nil, errors.New("friend dispatch returned nil response")
 		}
+		resp.RequestId = req.GetRequestId()
 		encoded, marshalErr := proto.Marshal(resp)
 		if marshalErr != nil {
 			return nil, nil, marshalErr
@@ -100,10 +105,21 @@ func (h *FriendHandler) HandleMessage(ctx context.Context, message []byte) error
 		if marshalErr != nil {
 			return nil, nil, marshalErr
 		}
-		return encoded, []db.PendingOutboxEvent{{
+		outboxEvents := []db.PendingOutboxEvent{{
 			EventID: db.StableEventID("friend", operationKey, "response"),
 			Topic:   req.GetFromKafkaTopic(), Payload: envelopePayload,
-		}}, nil
+		}}
+		for index, event := range events {
+			eventPayload, marshalErr := mq.MarshalEnvelope(envelope.MessageType_FRIEND_RESPONSE, event)
+			if marshalErr != nil {
+				return nil, nil, marshalErr
+			}
+			outboxEvents = append(outboxEvents, db.PendingOutboxEvent{
+				EventID: db.StableEventID("friend", operationKey, fmt.Sprintf("event-%d", index)),
+				Topic:   req.GetFromKafkaTopic(), Payload: eventPayload,
+			})
+		}
+		return encoded, outboxEvents, nil
 	})
 	return err
 }
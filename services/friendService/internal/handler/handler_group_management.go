@@ -42,6 +42,29 @@ func (h *FriendHandler) handleTransferGroupOwnerWithDB(database *gorm.DB, req *f
 	return groupManagementOperation(req, "transfer_group_owner", friend.FriendResult_FRIEND_OK, payload.GetGroupId(), payload.GetUserId(), db.GroupRoleOwner, updatedAt, groupName, previousOwnerID), nil
 }
 
+// withGroupChanged 在群资料修改成功后读取最新群资料，生成推送给群成员的变更事件
+func withGroupChanged(ctx friendRequestContext, groupID, operatorID int64, resp *friend.ResponseMessage, err error) (*friend.ResponseMessage, error) {
+	if err != nil || resp.GetResult() != friend.FriendResult_FRIEND_OK || ctx.events == nil {
+		return resp, err
+	}
+	group, err := db.GetGroupByIDWithDB(ctx.handler.resolveDatabase(ctx.database), groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return resp, nil
+	}
+	*ctx.events = append(*ctx.events, &friend.ResponseMessage{
+		Result:       friend.FriendResult_FRIEND_OK,
+		TargetUserId: operatorID,
+		Payload: &friend.ResponseMessage_GroupChanged{GroupChanged: &friend.GroupChanged{
+			GroupId: group.GroupID, GroupName: group.Name, Avatar: group.Avatar,
+			OperatorUserId: operatorID, UpdateTime: group.UpdateTime,
+		}},
+	})
+	return resp, nil
+}
+
 func groupManagementOperation(req *friend.RequestMessage, operation string, result friend.FriendResult, groupID, userID int64, role, updateTime, groupName string, previousOwnerID int64) *friend.ResponseMessage {
 	return &friend.ResponseMessage{
 		Result:       result,
@@ -68,6 +68,49 @@ func TestUpdateGroupNamePermissionsAndValidation(t *testing.T) {
 	}
 }
 
+func TestUpdateGroupNameEmitsGroupChangedEvent(t *testing.T) {
+	mock := useMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM \"groups\" WHERE group_id = \\$1 AND is_delete = \\$2 .*FOR UPDATE").
+		WithArgs(int64(3001), false, 1).
+		WillReturnRows(groupRows().AddRow(3001, "Team", "", 1001, false, "2026-07-18T00:00:00Z"))
+	mock.ExpectQuery("SELECT \\* FROM \"group_members\" WHERE group_id = \\$1 AND user_id = \\$2 .*FOR UPDATE").
+		WithArgs(int64(3001), int64(1001), 1).
+		WillReturnRows(groupMemberRows().AddRow(3001, 1001, "owner", "2026-07-18T00:00:00Z"))
+	mock.ExpectExec("UPDATE \"groups\" SET \"name\"=\\$1,\"update_time\"=\\$2 WHERE group_id = \\$3 AND is_delete = \\$4").
+		WithArgs("新群名称", sqlmock.AnyArg(), int64(3001), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT \\* FROM \"groups\" WHERE group_id = \\$1 AND is_delete = \\$2").
+		WithArgs(int64(3001), false, 1).
+		WillReturnRows(groupRows().AddRow(3001, "新群名称", "group-avatar", 1001, false, "2026-07-19T00:00:00Z"))
+
+	req := &friend.RequestMessage{
+		TargetUserId: 1001,
+		Payload: &friend.RequestMessage_UpdateGroupName{UpdateGroupName: &friend.UpdateGroupName{
+			RequestUserId: 1001, GroupId: 3001, GroupName: "新群名称",
+		}},
+	}
+	var events []*friend.ResponseMessage
+	response, err := getFriendRequestRouter().Dispatch(friendRequestContext{
+		handler: &FriendHandler{}, request: req, events: &events,
+	}, req.Payload)
+	if err != nil || response.GetResult() != friend.FriendResult_FRIEND_OK {
+		t.Fatalf("rename failed: response=%+v err=%v", response, err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one group changed event, got %d", len(events))
+	}
+	changed := events[0].GetGroupChanged()
+	if events[0].GetTargetUserId() != 1001 || changed.GetGroupId() != 3001 || changed.GetGroupName() != "新群名称" ||
+		changed.GetAvatar() != "group-avatar" || changed.GetOperatorUserId() != 1001 || changed.GetUpdateTime() != "2026-07-19T00:00:00Z" {
+		t.Fatalf("unexpected group changed event: %+v", events[0])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestTransferGroupOwnerPreservesUniqueOwnerInvariant(t *testing.T) {
 	mock := useMockDB(t)
 	expectSuccessfulOwnerTransfer(mock)
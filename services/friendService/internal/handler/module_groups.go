@@ -20,7 +20,8 @@ func registerFriendGroupModule(router *dispatch.OneofRouter[friendRequestContext
 		return ctx.handler.handleAddGroupMemberWithDB(ctx.database, ctx.request, payload.AddGroupMember)
 	})
 	dispatch.Register(router, func(ctx friendRequestContext, payload *friend.RequestMessage_UpdateGroupAvatar) (*friend.ResponseMessage, error) {
-		return ctx.handler.handleUpdateGroupAvatarWithDB(ctx.database, ctx.request, payload.UpdateGroupAvatar)
+		resp, err := ctx.handler.handleUpdateGroupAvatarWithDB(ctx.database, ctx.request, payload.UpdateGroupAvatar)
+		return withGroupChanged(ctx, payload.UpdateGroupAvatar.GetGroupId(), payload.UpdateGroupAvatar.GetRequestUserId(), resp, err)
 	})
 	dispatch.Register(router, func(ctx friendRequestContext, payload *friend.RequestMessage_QueryGroupMembers) (*friend.ResponseMessage, error) {
 		return ctx.handler.handleQueryGroupMembersWithDB(ctx.database, ctx.request, payload.QueryGroupMembers)
@@ -32,7 +33,8 @@ func registerFriendGroupModule(router *dispatch.OneofRouter[friendRequestContext
 		return ctx.handler.handleQueryJoinedGroupsWithDB(ctx.database, ctx.request, payload.QueryJoinedGroups)
 	})
 	dispatch.Register(router, func(ctx friendRequestContext, payload *friend.RequestMessage_UpdateGroupName) (*friend.ResponseMessage, error) {
-		return ctx.handler.handleUpdateGroupNameWithDB(ctx.database, ctx.request, payload.UpdateGroupName)
+		resp, err := ctx.handler.handleUpdateGroupNameWithDB(ctx.database, ctx.request, payload.UpdateGroupName)
+		return withGroupChanged(ctx, payload.UpdateGroupName.GetGroupId(), payload.UpdateGroupName.GetRequestUserId(), resp, err)
 	})
 	dispatch.Register(router, func(ctx friendRequestContext, payload *friend.RequestMessage_TransferGroupOwner) (*friend.ResponseMessage, error) {
 		return ctx.handler.handleTransferGroupOwnerWithDB(ctx.database, ctx.request, payload.TransferGroupOwner)
@@ -382,6 +382,7 @@ func marshalMessagePayload(notification pushservice.Notification) ([]byte, error
 		"conversation_name":          strings.TrimSpace(notification.ConversationName),
 		"conversation_avatar":        strings.TrimSpace(notification.ConversationAvatar),
 		"communication_notification": true,
+		"mentioned":                  notification.Mentioned,
 	}
 	if len(notification.CustomData) > 0 {
 		payload["debug_data"] = notification.CustomData
@@ -94,6 +94,9 @@ func TestClientSendsAlertHeadersAndMessageMetadata(t *testing.T) {
 		if payload["communication_notification"] != true {
 			t.Errorf("group message must remain a communication notification: %+v", payload)
 		}
+		if payload["mentioned"] != true {
+			t.Errorf("mention flag missing: %+v", payload)
+		}
 		w.WriteHeader(http.StatusOK)
 	}))
 	server.EnableHTTP2 = true
@@ -110,7 +113,7 @@ func TestClientSendsAlertHeadersAndMessageMetadata(t *testing.T) {
 		MessageType: "image", SentAt: now, ExpiresAt: now.Add(24 * time.Hour),
 		Title: "调试标题", Body: "调试正文", CustomData: map[string]any{"scenario": "smoke"},
 		SenderName: "Alice", SenderAvatar: "alice-avatar-hash", GroupName: "开发群", Avatar: "group-avatar-hash", AvatarIsGroup: true,
-		ConversationName: "开发群", ConversationAvatar: "group-avatar-hash",
+		ConversationName: "开发群", ConversationAvatar: "group-avatar-hash", Mentioned: true,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -19,17 +19,22 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// messageFanoutSQL 按接收者的会话免打扰过滤设备，被@的接收者不受免打扰限制
 const messageFanoutSQL = `WITH targets AS (
   SELECT DISTINCT value::bigint AS user_id
   FROM jsonb_array_elements_text(CAST(? AS jsonb))
+), mentioned AS (
+  SELECT DISTINCT value::bigint AS user_id
+  FROM jsonb_array_elements_text(CAST(? AS jsonb))
 ), eligible AS (
   SELECT token.id
   FROM targets
   JOIN push_device_tokens AS token ON token.user_id = targets.user_id
+  LEFT JOIN mentioned ON mentioned.user_id = token.user_id
   LEFT JOIN friends ON friends.user_id = token.user_id
     AND friends.friend_id = ? AND friends.is_delete = FALSE
   WHERE token.push_type = ? AND token.is_active = TRUE
-    AND (? = TRUE OR friends.user_id IS NULL OR friends.is_notify = TRUE)
+    AND (mentioned.user_id IS NOT NULL OR ? = TRUE OR friends.user_id IS NULL OR friends.is_notify = TRUE)
 )
 INSERT INTO push_message_deliveries
   (message_id, token_id, job_id, status, attempt, claim_token, lease_until, next_retry_at, created_at, updated_at)
@@ -70,6 +75,8 @@ func (s *GormStore) EnqueueRequest(ctx context.Context, operationKey string, req
 			return s.persistMessageJob(tx, operationKey, request, payload.MessagePush)
 		case *pushpb.RequestMessage_MessageRecall:
 			return s.persistMessageRecallJob(tx, operationKey, request, payload.MessageRecall)
+		case *pushpb.RequestMessage_MessageEdit:
+			return s.persistMessageEditJob(tx, operationKey, request, payload.MessageEdit)
 		case *pushpb.RequestMessage_VoipCall:
 			return s.persistVoIPJob(tx, operationKey, request, payload.VoipCall)
 		default:
@@ -105,7 +112,7 @@ func (s *GormStore) persistClientCommand(tx *gorm.DB, operationKey string, comma
 		return nil, nil, errors.New("persist push client command")
 	}
 	response := &pushpb.ResponseMessage{Payload: &pushpb.ResponseMessage_ClientDelivery{ClientDelivery: &pushpb.ClientDelivery{
-		TargetUserId: command.GetUserId(), Event: event,
+		TargetUserId: command.GetUserId(), Event: event, RequestId: command.GetRequestId(),
 	}}}
 	responsePayload, err := proto.Marshal(response)
 	if err != nil {
@@ -204,6 +211,10 @@ func (s *GormStore) persistMessageJob(tx *gorm.DB, operationKey string, request
 	if err != nil {
 		return nil, nil, err
 	}
+	mentionedJSON, err := json.Marshal(uniquePushTargets(message.GetMentionedUserIds(), message.GetSenderUserId()))
+	if err != nil {
+		return nil, nil, err
+	}
 	payload, err := proto.Marshal(request)
 	if err != nil {
 		return nil, nil, err
@@ -213,7 +224,7 @@ func (s *GormStore) persistMessageJob(tx *gorm.DB, operationKey string, request
 	if err := tx.Create(&job).Error; err != nil {
 		return nil, nil, err
 	}
-	result := tx.Exec(messageFanoutSQL, string(targetJSON), message.GetSenderUserId(), PushTypeAPNs, message.GetIsGroup(), message.GetMessageId(), job.JobID, DeliveryPending, now, now, now)
+	result := tx.Exec(messageFanoutSQL, string(targetJSON), string(mentionedJSON), message.GetSenderUserId(), PushTypeAPNs, message.GetIsGroup(), message.GetMessageId(), job.JobID, DeliveryPending, now, now, now)
 	if result.Error != nil {
 		return nil, nil, result.Error
 	}
@@ -275,7 +286,7 @@ func (s *GormStore) persistMessageRecallJob(tx *gorm.DB, operationKey string, re
 		return nil, nil, err
 	}
 	if err := tx.Exec(`UPDATE push_jobs SET status = ?, completed_at = ?, updated_at = ?
-WHERE kind = 'message' AND job_id IN (SELECT job_id FROM push_message_deliveries WHERE message_id = ?)`,
+WHERE kind IN ('message', 'message_edit') AND job_id IN (SELECT job_id FROM push_message_deliveries WHERE message_id = ?)`,
 		PushJobCompleted, now, now, recall.GetMessageId()).Error; err != nil {
 		return nil, nil, err
 	}
@@ -295,6 +306,71 @@ WHERE kind = 'message' AND job_id IN (SELECT job_id FROM push_message_deliveries
 	return nil, nil, nil
 }
 
+// persistMessageEditJob re-points the message's queued and already sent
+// deliveries at a job carrying the edited preview. Resending with the same
+// collapse ID replaces the banner on devices instead of adding a new one.
+func (s *GormStore) persistMessageEditJob(tx *gorm.DB, operationKey string, request *pushpb.RequestMessage, edit *pushpb.MessageEditPushRequest) ([]byte, []db.PendingOutboxEvent, error) {
+	message := edit.GetMessage()
+	if message == nil || message.GetMessageId() <= 0 || message.GetSenderUserId() <= 0 || message.GetConversationId() <= 0 || strings.TrimSpace(message.GetMessageType()) == "" {
+		return nil, nil, ErrInvalidRequest
+	}
+	editedAt, err := time.Parse(time.RFC3339Nano, edit.GetEditedAt())
+	if err != nil {
+		return nil, nil, ErrInvalidRequest
+	}
+	messageState, err := lockMessageForPush(tx, message.GetMessageId())
+	if err != nil {
+		return nil, nil, err
+	}
+	if messageState.FromUserID != message.GetSenderUserId() || messageState.IsGroup != message.GetIsGroup() ||
+		message.GetIsGroup() && messageState.ToUserID != message.GetConversationId() ||
+		!message.GetIsGroup() && message.GetConversationId() != message.GetSenderUserId() {
+		return nil, nil, ErrInvalidRequest
+	}
+	// 已撤回或已被更晚的编辑覆盖时，不再更新通知
+	if messageState.IsRecalled || messageState.EditedAt != editedAt.UTC().Format(time.RFC3339) {
+		return nil, nil, nil
+	}
+
+	payload, err := proto.Marshal(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := db.FormatReliabilityTime(time.Now())
+	job := db.PushJob{JobID: stablePushJobID(operationKey), OperationKey: operationKey, Kind: "message_edit", RequestPayload: payload, Status: PushJobPending, CreatedAt: now, UpdatedAt: now}
+	if err := tx.Create(&job).Error; err != nil {
+		return nil, nil, err
+	}
+
+	statuses := []string{DeliveryPending, DeliveryClaimed, DeliveryRetryable, DeliverySent}
+	var previousJobIDs []string
+	if err := tx.Model(&db.PushMessageDelivery{}).
+		Where("message_id = ? AND status IN ?", message.GetMessageId(), statuses).
+		Distinct().Order("job_id").Pluck("job_id", &previousJobIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	result := tx.Model(&db.PushMessageDelivery{}).
+		Where("message_id = ? AND status IN ?", message.GetMessageId(), statuses).
+		Updates(map[string]any{
+			"job_id": job.JobID, "status": DeliveryPending, "attempt": 0, "claim_token": "", "lease_until": "",
+			"next_retry_at": now, "last_error": "", "apns_id": "", "updated_at": now,
+		})
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	for _, jobID := range previousJobIDs {
+		if err := completeMessageJobIfTerminal(tx, jobID); err != nil && !errors.Is(err, ErrDeliveryFenced) {
+			return nil, nil, err
+		}
+	}
+	if result.RowsAffected == 0 {
+		if err := tx.Model(&db.PushJob{}).Where("job_id = ?", job.JobID).Updates(map[string]any{"status": PushJobCompleted, "completed_at": now, "updated_at": now}).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, nil
+}
+
 func lockMessageForPush(tx *gorm.DB, messageID int64) (*db.Message, error) {
 	var message db.Message
 	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "message_id = ?", messageID).Error; err != nil {
@@ -28,8 +28,8 @@ func TestMessageFanoutUsesFixedPlaceholderCountForTwentyThousandTargets(t *testi
 	operationKey := "push-service/0/20000"
 	jobID := stablePushJobID(operationKey)
 
-	if placeholders := strings.Count(messageFanoutSQL, "?"); placeholders != 10 {
-		t.Fatalf("fanout SQL placeholders scale with audience: got=%d want=10", placeholders)
+	if placeholders := strings.Count(messageFanoutSQL, "?"); placeholders != 11 {
+		t.Fatalf("fanout SQL placeholders scale with audience: got=%d want=11", placeholders)
 	}
 	mock.ExpectBegin()
 	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1`).
@@ -37,7 +37,7 @@ func TestMessageFanoutUsesFixedPlaceholderCountForTwentyThousandTargets(t *testi
 		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "is_group", "is_recalled"}).AddRow(20000, 1, 2, false, false))
 	mock.ExpectExec(`INSERT INTO "push_jobs"`).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectExec(`WITH targets AS`).WithArgs(
-		sqlmock.AnyArg(), int64(1), PushTypeAPNs, false, int64(20000), jobID,
+		sqlmock.AnyArg(), "[]", int64(1), PushTypeAPNs, false, int64(20000), jobID,
 		DeliveryPending, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
 	).WillReturnResult(sqlmock.NewResult(0, 20000))
 	mock.ExpectCommit()
@@ -90,6 +90,50 @@ func TestPersistMessageRecallCancelsPendingOriginalAndFansOutDurably(t *testing.
 	}
 }
 
+func TestPersistMessageEditRepointsNotifiedDeliveries(t *testing.T) {
+	store, mock := newStoreMock(t)
+	operationKey := "push-service/1/80"
+	editedAt := "2026-07-21T05:03:00Z"
+	request := &pushpb.RequestMessage{Payload: &pushpb.RequestMessage_MessageEdit{MessageEdit: &pushpb.MessageEditPushRequest{
+		Message: &pushpb.MessagePushRequest{
+			TargetUserIds: []int64{2}, SenderUserId: 1, ConversationId: 1, MessageType: "text", MessageId: 80, Preview: "fixed",
+		},
+		EditedAt: editedAt,
+	}}}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1`).
+		WithArgs(int64(80), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "from_user_id", "to_user_id", "is_group", "is_recalled", "edited_at",
+		}).AddRow(80, 1, 2, false, false, editedAt))
+	mock.ExpectExec(`INSERT INTO "push_jobs"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT DISTINCT "job_id" FROM "push_message_deliveries" WHERE message_id = \$1 AND status IN \(\$2,\$3,\$4,\$5\) ORDER BY job_id`).
+		WithArgs(int64(80), DeliveryPending, DeliveryClaimed, DeliveryRetryable, DeliverySent).
+		WillReturnRows(sqlmock.NewRows([]string{"job_id"}).AddRow("push-original"))
+	mock.ExpectExec(`UPDATE "push_message_deliveries" SET .*"job_id"=\$[0-9]+.* WHERE message_id = \$[0-9]+ AND status IN`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT \* FROM "push_jobs" WHERE job_id = \$1`).
+		WithArgs("push-original", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"job_id", "status"}).AddRow("push-original", PushJobPending))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "push_message_deliveries"`).
+		WithArgs("push-original", DeliveryPending, DeliveryClaimed, DeliveryRetryable).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`UPDATE "push_jobs" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		_, _, persistErr := store.persistMessageEditJob(tx, operationKey, request, request.GetMessageEdit())
+		return persistErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestOriginalMessagePushIsSuppressedAfterRecall(t *testing.T) {
 	store, mock := newStoreMock(t)
 	request := &pushpb.RequestMessage{Payload: &pushpb.RequestMessage_MessagePush{MessagePush: &pushpb.MessagePushRequest{
@@ -139,6 +183,34 @@ func TestPrepareMessageRecallDelivery(t *testing.T) {
 	}
 }
 
+func TestPrepareMessageDeliveryMarksMentionedRecipient(t *testing.T) {
+	request := &pushpb.RequestMessage{Payload: &pushpb.RequestMessage_MessagePush{MessagePush: &pushpb.MessagePushRequest{
+		TargetUserIds: []int64{2, 3}, SenderUserId: 1, ConversationId: 88, IsGroup: true, MessageType: "text",
+		SentAt: "2026-07-24T01:00:00Z", Preview: "开会了", MessageId: 80, MentionedUserIds: []int64{2},
+	}}}
+	payload, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewService(&memoryStore{}, &memorySender{}, "com.Voltline.Betterfly2")
+	claim := func(tokenID, userID int64) DurableDeliveryClaim {
+		return DurableDeliveryClaim{
+			JobID: "job-mention", MessageID: 80, RequestPayload: payload,
+			Token: db.PushDeviceToken{ID: tokenID, UserID: userID, Token: "token", Environment: "production", PushType: PushTypeAPNs, IsActive: true},
+		}
+	}
+	prepared := service.prepareDeliveries(context.Background(), deliveryKindMessage, []DurableDeliveryClaim{claim(9, 2), claim(10, 3)})
+	if len(prepared) != 2 || prepared[0].prepareErr != nil || prepared[1].prepareErr != nil {
+		t.Fatalf("unexpected prepared deliveries: %+v", prepared)
+	}
+	if mentioned := prepared[0].notification; !mentioned.Mentioned || mentioned.Body != "[有人@你] 测试用户：开会了" {
+		t.Fatalf("unexpected mentioned notification: %+v", mentioned)
+	}
+	if other := prepared[1].notification; other.Mentioned || other.Body != "测试用户：开会了" {
+		t.Fatalf("unexpected ordinary notification: %+v", other)
+	}
+}
+
 func TestDurableFinalizeRejectsExpiredWorkerClaim(t *testing.T) {
 	store, mock := newStoreMock(t)
 	mock.ExpectBegin()
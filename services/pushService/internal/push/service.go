@@ -96,6 +96,9 @@ func envPositiveDuration(key string, fallback time.Duration) time.Duration {
 	return value
 }
 
+// mentionedMessagePrefix 标记被@的通知，客户端即使对会话开启了免打扰也应展示
+const mentionedMessagePrefix = "[有人@你] "
+
 func defaultMessagePreview(messageType string) string {
 	switch strings.ToLower(strings.TrimSpace(messageType)) {
 	case "image":
@@ -108,6 +111,8 @@ func defaultMessagePreview(messageType string) string {
 		return "发送了一条语音"
 	case "video":
 		return "发送了一段视频"
+	case "merged":
+		return "发送了一段聊天记录"
 	default:
 		return "发来一条消息"
 	}
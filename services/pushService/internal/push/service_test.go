@@ -309,7 +309,7 @@ func TestAdminBroadcastPaginatesAndBoundsDetailedResults(t *testing.T) {
 }
 
 func TestDefaultMessagePreviewCoversSupportedMediaTypes(t *testing.T) {
-	tests := map[string]string{"image": "发送了一张图片", "gif": "发送了一个 GIF", "file": "发送了一个文件", "audio": "发送了一条语音", "video": "发送了一段视频", "link": "发来一条消息"}
+	tests := map[string]string{"image": "发送了一张图片", "gif": "发送了一个 GIF", "file": "发送了一个文件", "audio": "发送了一条语音", "video": "发送了一段视频", "merged": "发送了一段聊天记录", "link": "发来一条消息"}
 	for messageType, want := range tests {
 		if got := defaultMessagePreview(messageType); got != want {
 			t.Errorf("defaultMessagePreview(%q)=%q want %q", messageType, got, want)
@@ -66,6 +66,7 @@ type Notification struct {
 	AvatarIsGroup      bool
 	ConversationName   string
 	ConversationAvatar string
+	Mentioned          bool
 	CampaignID         string
 	DeepLink           string
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -138,6 +139,9 @@ func (s *Service) prepareDeliveries(ctx context.Context, kind deliveryKind, clai
 		cached, exists := messageCache[claim.JobID]
 		if !exists {
 			message := request.GetMessagePush()
+			if edit := request.GetMessageEdit(); edit != nil {
+				message = edit.GetMessage()
+			}
 			if message == nil {
 				cached.err = ErrInvalidRequest
 			} else {
@@ -163,6 +167,10 @@ func (s *Service) prepareDeliveries(ctx context.Context, kind deliveryKind, clai
 		if message.GetIsGroup() && strings.TrimSpace(cached.presentation.SenderName) != "" {
 			body = cached.presentation.SenderName + "：" + preview
 		}
+		mentioned := slices.Contains(message.GetMentionedUserIds(), claim.Token.UserID)
+		if mentioned {
+			body = mentionedMessagePrefix + body
+		}
 		prepared = append(prepared, preparedDelivery{claim: claim, notification: Notification{
 			Kind: NotificationMessage, Token: claim.Token.Token, Environment: parseEnvironment(claim.Token.Environment),
 			SenderUserID: message.GetSenderUserId(), TargetUserID: claim.Token.UserID,
@@ -171,6 +179,7 @@ func (s *Service) prepareDeliveries(ctx context.Context, kind deliveryKind, clai
 			Title: cached.presentation.Title, Body: body, SenderName: cached.presentation.SenderName, SenderAvatar: cached.presentation.SenderAvatar,
 			GroupName: cached.presentation.GroupName, Avatar: cached.presentation.Avatar, AvatarIsGroup: cached.presentation.AvatarIsGroup,
 			ConversationName: cached.presentation.ConversationName, ConversationAvatar: cached.presentation.ConversationAvatar,
+			Mentioned: mentioned,
 		}})
 	}
 	return prepared
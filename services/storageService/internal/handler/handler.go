@@ -12,9 +12,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"storageService/internal/cache"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
@@ -34,6 +38,8 @@ type storageRequestContext struct {
 	request   *storage.RequestMessage
 	database  *gorm.DB
 	cacheKeys *[]string
+	// events 在事务提交后随应答一同经 Outbox 发布到请求来源的 DF 主题
+	events *[]*storage.ResponseMessage
 }
 
 type storageRequestModule func(*dispatch.OneofRouter[storageRequestContext, *storage.ResponseMessage])
@@ -65,9 +71,11 @@ func newStorageRequestRouter() *dispatch.OneofRouter[storageRequestContext, *sto
 
 // StorageHandler 存储服务处理器
 type StorageHandler struct {
-	l1Cache  cache.Cache
-	l2Cache  cache.Cache // L2 Redis缓存，可能为nil
-	database *gorm.DB
+	l1Cache    cache.Cache
+	l2Cache    cache.Cache // L2 Redis缓存，可能为nil
+	database   *gorm.DB
+	editWindow time.Duration // 为零时使用 db.DefaultMessageEditWindow
+	pinLimit   int           // 为零时使用 db.DefaultMessagePinLimit
 }
 
 type fileExistsCacheEntry struct {
@@ -95,12 +103,42 @@ func NewStorageHandler() *StorageHandler {
 	}
 
 	return &StorageHandler{
-		l1Cache:  l1Cache,
-		l2Cache:  l2Cache,
-		database: db.DB(),
+		l1Cache:    l1Cache,
+		l2Cache:    l2Cache,
+		database:   db.DB(),
+		editWindow: messageEditWindowFromEnv(),
+		pinLimit:   messagePinLimitFromEnv(),
 	}
 }
 
+// messageEditWindowFromEnv 读取 MESSAGE_EDIT_WINDOW（如 15m），未设置或无效时使用默认窗口
+func messageEditWindowFromEnv() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("MESSAGE_EDIT_WINDOW"))
+	if raw == "" {
+		return db.DefaultMessageEditWindow
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil || window <= 0 {
+		logger.Sugar().Warnf("MESSAGE_EDIT_WINDOW无效，使用默认值%s: %q", db.DefaultMessageEditWindow, raw)
+		return db.DefaultMessageEditWindow
+	}
+	return window
+}
+
+// messagePinLimitFromEnv 读取 MESSAGE_PIN_LIMIT（每个会话的置顶上限），未设置或无效时使用默认值
+func messagePinLimitFromEnv() int {
+	raw := strings.TrimSpace(os.Getenv("MESSAGE_PIN_LIMIT"))
+	if raw == "" {
+		return db.DefaultMessagePinLimit
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		logger.Sugar().Warnf("MESSAGE_PIN_LIMIT无效，使用默认值%d: %q", db.DefaultMessagePinLimit, raw)
+		return db.DefaultMessagePinLimit
+	}
+	return limit
+}
+
 func (h *StorageHandler) requestDatabase() *gorm.DB {
 	if h.database != nil {
 		return h.database
@@ -128,8 +166,9 @@ func (h *StorageHandler) HandleMessage(ctx context.Context, message []byte) erro
 
 	cacheKeys := make([]string, 0, 1)
 	execution, err := db.ExecuteInboxOutbox(ctx, h.requestDatabase(), "storage", operationKey, func(tx *gorm.DB) ([]byte, []db.PendingOutboxEvent, error) {
+		var events []*storage.ResponseMessage
 		resp, dispatchErr := getStorageRequestRouter().Dispatch(storageRequestContext{
-			handler: h, request: req, database: tx, cacheKeys: &cacheKeys,
+			handler: h, request: req, database: tx, cacheKeys: &cacheKeys, events: &events,
 		}, req.Payload)
 		if dispatchErr != nil {
 			sugar.Errorw("处理存储请求暂时失败", "operation_key", operationKey, "error", dispatchErr)
@@ -138,6 +177,7 @@ func (h *StorageHandler) HandleMessage(ctx context.Context, message []byte) erro
 		if resp == nil {
 			return nil, nil, errors.New("storage dispatch returned nil response")
 		}
+		resp.RequestId = req.GetRequestId()
 		encoded, marshalErr := proto.Marshal(resp)
 		if marshalErr != nil {
 			return nil, nil, marshalErr
@@ -146,10 +186,21 @@ func (h *StorageHandler) HandleMessage(ctx context.Context, message []byte) erro
 		if marshalErr != nil {
 			return nil, nil, marshalErr
 		}
-		return encoded, []db.PendingOutboxEvent{{
+		outboxEvents := []db.PendingOutboxEvent{{
 			EventID: db.StableEventID("storage", operationKey, "response"),
 			Topic:   req.GetFromKafkaTopic(), Payload: envelopePayload,
-		}}, nil
+		}}
+		for index, event := range events {
+			eventPayload, marshalErr := mq.MarshalEnvelope(envelope.MessageType_STORAGE_RESPONSE, event)
+			if marshalErr != nil {
+				return nil, nil, marshalErr
+			}
+			outboxEvents = append(outboxEvents, db.PendingOutboxEvent{
+				EventID: db.StableEventID("storage", operationKey, fmt.Sprintf("event-%d", index)),
+				Topic:   req.GetFromKafkaTopic(), Payload: eventPayload,
+			})
+		}
+		return encoded, outboxEvents, nil
 	})
 	if err == nil {
 		if execution.Replayed && len(cacheKeys) == 0 {
@@ -170,6 +221,10 @@ func mutationCacheKeys(req *storage.RequestMessage) []string {
 		return []string{fmt.Sprintf("user:%d", payload.UpdateUserAvatar.GetUserId())}
 	case *storage.RequestMessage_RecallMessage:
 		return []string{fmt.Sprintf("message:%d", payload.RecallMessage.GetMessageId())}
+	case *storage.RequestMessage_EditMessage:
+		return []string{fmt.Sprintf("message:%d", payload.EditMessage.GetMessageId())}
+	case *storage.RequestMessage_ForwardMessages:
+		return []string{fmt.Sprintf("user_messages:%d", payload.ForwardMessages.GetTargetId())}
 	default:
 		return nil
 	}
@@ -179,26 +234,79 @@ func mutationCacheKeys(req *storage.RequestMessage) []string {
 func (h *StorageHandler) handleStoreNewMessageWithDB(database *gorm.DB, req *storage.RequestMessage, msg *storage.StoreNewMessage, cacheKeys *[]string) (*storage.ResponseMessage, error) {
 	sugar := logger.Sugar()
 
-	// 保存到数据库
-	start := time.Now()
-	storedMessage, created, err := db.StoreNewMessageWithDB(database,
-		msg.FromUserId,
-		msg.ToUserId,
-		msg.Content,
-		msg.MessageType,
-		msg.GetRealFileName(),
-		msg.IsGroup,
-		msg.GetClientMessageId(),
-	)
-	metrics.RecordDatabaseQuery("insert", start)
+	// 重试的消息已经入库时直接返回首次写入的结果：引用和@对象只在写入新消息时校验，
+	// 否则被引用消息在两次请求之间变得不可引用会让重试收到拒绝，而不是已存储的消息
+	var storedMessage *db.Message
+	created := false
+	if msg.GetReplyToMessageId() != 0 || len(msg.GetMentionedUserIds()) > 0 || msg.GetMentionAll() {
+		lookupStart := time.Now()
+		existing, err := db.GetMessageByClientMessageIDWithDB(database, msg.GetFromUserId(), msg.GetClientMessageId())
+		metrics.RecordDatabaseQuery("select", lookupStart)
+		if err != nil {
+			sugar.Errorf("查询已存储的消息失败: %v", err)
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+		if existing == nil {
+			rejected, err := validateNewMessageWithDB(database, req, msg)
+			if err != nil || rejected != nil {
+				return rejected, err
+			}
+		}
+		storedMessage = existing
+	}
+
+	if storedMessage == nil {
+		// 保存到数据库
+		start := time.Now()
+		var err error
+		storedMessage, created, err = db.StoreNewMessageWithDB(database,
+			msg.FromUserId,
+			msg.ToUserId,
+			msg.Content,
+			msg.MessageType,
+			msg.GetRealFileName(),
+			msg.IsGroup,
+			msg.GetClientMessageId(),
+			msg.GetReplyToMessageId(),
+		)
+		metrics.RecordDatabaseQuery("insert", start)
+		if err != nil {
+			sugar.Errorf("保存消息到数据库失败: %v", err)
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+	}
+
+	sugar.Debugf("消息保存成功: message_id=%d client_message_id=%s created=%t", storedMessage.MessageID, msg.GetClientMessageId(), created)
+
+	if created {
+		seqStart := time.Now()
+		_, err := db.AssignInboxSequencesWithDB(database, storedMessage)
+		metrics.RecordDatabaseQuery("upsert", seqStart)
+		if err != nil {
+			sugar.Errorf("分配收件序号失败: %v", err)
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+
+		summaryStart := time.Now()
+		err = db.RecordConversationMessageWithDB(database, storedMessage)
+		metrics.RecordDatabaseQuery("upsert", summaryStart)
+		if err != nil {
+			sugar.Errorf("更新会话摘要失败: %v", err)
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+	}
+
+	mentions, err := storeMessageMentionsWithDB(database, storedMessage, created, msg)
 	if err != nil {
-		sugar.Errorf("保存消息到数据库失败: %v", err)
+		sugar.Errorf("保存消息@对象失败: %v", err)
 		metrics.RecordDatabaseError()
 		return nil, err
 	}
 
-	sugar.Debugf("消息保存成功: message_id=%d client_message_id=%s created=%t", storedMessage.MessageID, msg.GetClientMessageId(), created)
-
 	cacheKey := fmt.Sprintf("user_messages:%d", msg.ToUserId)
 	if cacheKeys != nil {
 		*cacheKeys = append(*cacheKeys, cacheKey)
@@ -212,16 +320,19 @@ func (h *StorageHandler) handleStoreNewMessageWithDB(database *gorm.DB, req *sto
 		TargetUserId: req.TargetUserId,
 		Payload: &storage.ResponseMessage_StoreMsgRsp{
 			StoreMsgRsp: &storage.StoreMsgRsp{
-				MessageId:       storedMessage.MessageID,
-				ClientMessageId: msg.GetClientMessageId(),
-				Created:         created,
-				FromUserId:      msg.GetFromUserId(),
-				ToUserId:        msg.GetToUserId(),
-				Content:         msg.GetContent(),
-				MessageType:     msg.GetMessageType(),
-				IsGroup:         msg.GetIsGroup(),
-				RealFileName:    msg.GetRealFileName(),
-				ClientTimestamp: msg.GetClientTimestamp(),
+				MessageId:        storedMessage.MessageID,
+				ClientMessageId:  msg.GetClientMessageId(),
+				Created:          created,
+				FromUserId:       msg.GetFromUserId(),
+				ToUserId:         msg.GetToUserId(),
+				Content:          msg.GetContent(),
+				MessageType:      msg.GetMessageType(),
+				IsGroup:          msg.GetIsGroup(),
+				RealFileName:     msg.GetRealFileName(),
+				ClientTimestamp:  msg.GetClientTimestamp(),
+				ReplyToMessageId: msg.GetReplyToMessageId(),
+				MentionedUserIds: mentions.UserIDs,
+				MentionAll:       mentions.All,
 			},
 		},
 	}
@@ -229,6 +340,78 @@ func (h *StorageHandler) handleStoreNewMessageWithDB(database *gorm.DB, req *sto
 	return resp, nil
 }
 
+// validateNewMessageWithDB 校验新消息的引用和@对象，校验失败时返回拒绝应答
+func validateNewMessageWithDB(database *gorm.DB, req *storage.RequestMessage, msg *storage.StoreNewMessage) (*storage.ResponseMessage, error) {
+	sugar := logger.Sugar()
+
+	if msg.GetReplyToMessageId() != 0 {
+		replyStart := time.Now()
+		canReply, err := db.CanReplyToMessageWithDB(database, msg.GetFromUserId(), msg.GetToUserId(), msg.GetIsGroup(), msg.GetReplyToMessageId())
+		metrics.RecordDatabaseQuery("select", replyStart)
+		if err != nil {
+			sugar.Errorf("校验引用消息失败: %v", err)
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+		if !canReply {
+			sugar.Warnf("拒绝引用不可回复的消息: from_user_id=%d reply_to_message_id=%d", msg.GetFromUserId(), msg.GetReplyToMessageId())
+			return rejectedStoreMsgResponse(req, msg, storage.StorageResult_INVALID_REPLY), nil
+		}
+	}
+
+	mentionStart := time.Now()
+	mentionStatus, err := db.CheckMessageMentionsWithDB(database, msg.GetFromUserId(), msg.GetToUserId(), msg.GetIsGroup(), msg.GetMentionedUserIds(), msg.GetMentionAll())
+	metrics.RecordDatabaseQuery("select", mentionStart)
+	if err != nil {
+		sugar.Errorf("校验消息@对象失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	switch mentionStatus {
+	case db.MessageMentionInvalid:
+		sugar.Warnf("拒绝无效的@对象: from_user_id=%d to_user_id=%d is_group=%t mentions=%d", msg.GetFromUserId(), msg.GetToUserId(), msg.GetIsGroup(), len(msg.GetMentionedUserIds()))
+		return rejectedStoreMsgResponse(req, msg, storage.StorageResult_INVALID_MENTION), nil
+	case db.MessageMentionForbidden:
+		sugar.Warnf("安全拒绝非管理员@所有人: from_user_id=%d group_id=%d", msg.GetFromUserId(), msg.GetToUserId())
+		return rejectedStoreMsgResponse(req, msg, storage.StorageResult_FORBIDDEN), nil
+	}
+	return nil, nil
+}
+
+// rejectedStoreMsgResponse 回显客户端消息ID，DF 据此释放 Post 幂等占位
+func rejectedStoreMsgResponse(req *storage.RequestMessage, msg *storage.StoreNewMessage, result storage.StorageResult) *storage.ResponseMessage {
+	return &storage.ResponseMessage{
+		Result:       result,
+		TargetUserId: req.TargetUserId,
+		Payload: &storage.ResponseMessage_StoreMsgRsp{StoreMsgRsp: &storage.StoreMsgRsp{
+			ClientMessageId:  msg.GetClientMessageId(),
+			FromUserId:       msg.GetFromUserId(),
+			ToUserId:         msg.GetToUserId(),
+			IsGroup:          msg.GetIsGroup(),
+			ReplyToMessageId: msg.GetReplyToMessageId(),
+		}},
+	}
+}
+
+// storeMessageMentionsWithDB 为新消息记录@对象；幂等重放时返回首次写入的结果
+func storeMessageMentionsWithDB(database *gorm.DB, message *db.Message, created bool, msg *storage.StoreNewMessage) (db.MessageMentions, error) {
+	if len(msg.GetMentionedUserIds()) == 0 && !msg.GetMentionAll() {
+		return db.MessageMentions{}, nil
+	}
+	start := time.Now()
+	if created {
+		mentions, err := db.StoreMessageMentionsWithDB(database, message, msg.GetMentionedUserIds(), msg.GetMentionAll())
+		metrics.RecordDatabaseQuery("insert", start)
+		return mentions, err
+	}
+	existing, err := db.GetMessageMentionsWithDB(database, []int64{message.MessageID})
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		return db.MessageMentions{}, err
+	}
+	return existing[message.MessageID], nil
+}
+
 func (h *StorageHandler) handleRecallMessageWithDB(database *gorm.DB, req *storage.RequestMessage, recall *storage.RecallMessage, cacheKeys *[]string) (*storage.ResponseMessage, error) {
 	messageID := recall.GetMessageId()
 	operatorUserID := req.GetTargetUserId()
@@ -258,6 +441,63 @@ func (h *StorageHandler) handleRecallMessageWithDB(database *gorm.DB, req *stora
 	if outcome.Status != db.MessageRecallOK {
 		return response, nil
 	}
+	if err := db.RecallConversationMessageWithDB(database, outcome.Message); err != nil {
+		return nil, err
+	}
+
+	keys := []string{
+		fmt.Sprintf("message:%d", messageID),
+		fmt.Sprintf("user_messages:%d", outcome.Message.ToUserID),
+	}
+	if cacheKeys != nil {
+		*cacheKeys = append(*cacheKeys, keys...)
+	} else {
+		h.clearCacheKeys(keys)
+	}
+	return response, nil
+}
+
+// handleEditMessageWithDB 在编辑窗口内替换消息内容，响应经编辑人所在的DF容器扇出给会话其他成员。
+func (h *StorageHandler) handleEditMessageWithDB(database *gorm.DB, req *storage.RequestMessage, edit *storage.EditMessage, cacheKeys *[]string) (*storage.ResponseMessage, error) {
+	messageID := edit.GetMessageId()
+	operatorUserID := req.GetTargetUserId()
+	response := &storage.ResponseMessage{
+		Result:       storage.StorageResult_RECORD_NOT_EXIST,
+		TargetUserId: operatorUserID,
+		Payload: &storage.ResponseMessage_EditMessageRsp{EditMessageRsp: &storage.EditMessageRsp{
+			MessageId:      messageID,
+			OperatorUserId: operatorUserID,
+		}},
+	}
+	if messageID <= 0 || operatorUserID <= 0 {
+		return response, nil
+	}
+
+	start := time.Now()
+	outcome, err := db.EditMessageWithDB(database, operatorUserID, messageID, edit.GetNewContent(), h.editWindow, time.Now())
+	metrics.RecordDatabaseQuery("update", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	response.Result = storageResultForEditStatus(outcome.Status)
+	if outcome.Message != nil && outcome.Status != db.MessageEditNotFound {
+		rsp := response.GetEditMessageRsp()
+		rsp.FromUserId = outcome.Message.FromUserID
+		rsp.ToUserId = outcome.Message.ToUserID
+		rsp.IsGroup = outcome.Message.IsGroup
+		rsp.MessageType = outcome.Message.MessageType
+		if !outcome.Message.IsRecalled {
+			rsp.EditedAt = outcome.Message.EditedAt
+			rsp.Content = outcome.Message.Content
+		}
+	}
+	if outcome.Status != db.MessageEditOK {
+		return response, nil
+	}
+	if err := db.EditConversationMessageWithDB(database, outcome.Message); err != nil {
+		return nil, err
+	}
 
 	keys := []string{
 		fmt.Sprintf("message:%d", messageID),
@@ -271,6 +511,307 @@ func (h *StorageHandler) handleRecallMessageWithDB(database *gorm.DB, req *stora
 	return response, nil
 }
 
+func storageResultForEditStatus(status db.MessageEditStatus) storage.StorageResult {
+	switch status {
+	case db.MessageEditOK:
+		return storage.StorageResult_OK
+	case db.MessageEditForbidden:
+		return storage.StorageResult_FORBIDDEN
+	case db.MessageEditRecalled:
+		return storage.StorageResult_ALREADY_RECALLED
+	case db.MessageEditExpired:
+		return storage.StorageResult_EDIT_EXPIRED
+	case db.MessageEditNotEditable:
+		return storage.StorageResult_NOT_EDITABLE
+	default:
+		return storage.StorageResult_RECORD_NOT_EXIST
+	}
+}
+
+// handleReactToMessageWithDB 添加或撤销表情回应，变化后的聚合结果经回应人所在的DF容器扇出给会话成员。
+func (h *StorageHandler) handleReactToMessageWithDB(database *gorm.DB, req *storage.RequestMessage, react *storage.ReactToMessage) (*storage.ResponseMessage, error) {
+	messageID := react.GetMessageId()
+	operatorUserID := req.GetTargetUserId()
+	response := &storage.ResponseMessage{
+		Result:       storage.StorageResult_RECORD_NOT_EXIST,
+		TargetUserId: operatorUserID,
+		Payload: &storage.ResponseMessage_ReactToMessageRsp{ReactToMessageRsp: &storage.ReactToMessageRsp{
+			MessageId:      messageID,
+			OperatorUserId: operatorUserID,
+			Emoji:          react.GetEmoji(),
+			Removed:        react.GetRemove(),
+		}},
+	}
+	if messageID <= 0 || operatorUserID <= 0 {
+		return response, nil
+	}
+
+	start := time.Now()
+	outcome, err := db.ReactToMessageWithDB(database, operatorUserID, messageID, react.GetEmoji(), react.GetRemove(), time.Now())
+	metrics.RecordDatabaseQuery("upsert", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	response.Result = storageResultForReactionStatus(outcome.Status)
+	if outcome.Status == db.MessageReactionNotFound {
+		return response, nil
+	}
+	rsp := response.GetReactToMessageRsp()
+	rsp.FromUserId = outcome.Message.FromUserID
+	rsp.ToUserId = outcome.Message.ToUserID
+	rsp.IsGroup = outcome.Message.IsGroup
+	if outcome.Status != db.MessageReactionOK {
+		return response, nil
+	}
+	rsp.Changed = outcome.Changed
+	rsp.ReactedAt = outcome.ReactedAt
+	rsp.Reactions = newStorageMessageReactions(outcome.Reactions)
+	rsp.ReaderUserIds = outcome.ReaderIDs
+	return response, nil
+}
+
+func storageResultForReactionStatus(status db.MessageReactionStatus) storage.StorageResult {
+	switch status {
+	case db.MessageReactionOK:
+		return storage.StorageResult_OK
+	case db.MessageReactionRecalled:
+		return storage.StorageResult_ALREADY_RECALLED
+	case db.MessageReactionInvalid:
+		return storage.StorageResult_INVALID_REACTION
+	default:
+		return storage.StorageResult_RECORD_NOT_EXIST
+	}
+}
+
+// handlePinMessageWithDB 置顶或取消置顶消息，变化经操作者所在的DF容器扇出给会话成员。
+func (h *StorageHandler) handlePinMessageWithDB(database *gorm.DB, req *storage.RequestMessage, messageID int64, unpin bool) (*storage.ResponseMessage, error) {
+	operatorUserID := req.GetTargetUserId()
+	response := &storage.ResponseMessage{
+		Result:       storage.StorageResult_RECORD_NOT_EXIST,
+		TargetUserId: operatorUserID,
+		Payload: &storage.ResponseMessage_PinMessageRsp{PinMessageRsp: &storage.PinMessageRsp{
+			MessageId:      messageID,
+			OperatorUserId: operatorUserID,
+			Unpinned:       unpin,
+		}},
+	}
+	if messageID <= 0 || operatorUserID <= 0 {
+		return response, nil
+	}
+
+	start := time.Now()
+	var outcome *db.MessagePinOutcome
+	var err error
+	if unpin {
+		outcome, err = db.UnpinMessageWithDB(database, operatorUserID, messageID, time.Now())
+	} else {
+		outcome, err = db.PinMessageWithDB(database, operatorUserID, messageID, h.pinLimit, time.Now())
+	}
+	metrics.RecordDatabaseQuery("upsert", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	response.Result = storageResultForPinStatus(outcome.Status)
+	if outcome.Message == nil {
+		return response, nil
+	}
+	rsp := response.GetPinMessageRsp()
+	rsp.FromUserId = outcome.Message.FromUserID
+	rsp.ToUserId = outcome.Message.ToUserID
+	rsp.IsGroup = outcome.Message.IsGroup
+	rsp.Changed = outcome.Changed
+	rsp.PinnedAt = outcome.PinnedAt
+	return response, nil
+}
+
+func storageResultForPinStatus(status db.MessagePinStatus) storage.StorageResult {
+	switch status {
+	case db.MessagePinOK:
+		return storage.StorageResult_OK
+	case db.MessagePinForbidden:
+		return storage.StorageResult_FORBIDDEN
+	case db.MessagePinRecalled:
+		return storage.StorageResult_ALREADY_RECALLED
+	case db.MessagePinLimitReached:
+		return storage.StorageResult_PIN_LIMIT_REACHED
+	default:
+		return storage.StorageResult_RECORD_NOT_EXIST
+	}
+}
+
+// handleForwardMessagesWithDB 把可读的源消息逐条或合并写入目标会话，新消息与普通消息一样分配收件序号并更新会话摘要。
+// 响应经转发人所在的DF容器逐条投递给目标会话。
+func (h *StorageHandler) handleForwardMessagesWithDB(database *gorm.DB, req *storage.RequestMessage, forward *storage.ForwardMessages, cacheKeys *[]string) (*storage.ResponseMessage, error) {
+	sugar := logger.Sugar()
+	fromUserID := req.GetTargetUserId()
+	rsp := &storage.ForwardMessagesRsp{
+		FromUserId:      fromUserID,
+		ToUserId:        forward.GetTargetId(),
+		IsGroup:         forward.GetIsGroup(),
+		Merged:          forward.GetMerged(),
+		ClientMessageId: forward.GetClientMessageId(),
+	}
+	response := &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: fromUserID,
+		Payload:      &storage.ResponseMessage_ForwardMessagesRsp{ForwardMessagesRsp: rsp},
+	}
+
+	start := time.Now()
+	outcome, err := db.ForwardMessagesWithDB(database, fromUserID, forward.GetSourceMessageIds(), forward.GetTargetId(),
+		forward.GetIsGroup(), forward.GetMerged(), forward.GetClientMessageId())
+	metrics.RecordDatabaseQuery("insert", start)
+	if err != nil {
+		sugar.Errorf("转发消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	response.Result = storageResultForForwardStatus(outcome.Status)
+	if outcome.Status != db.MessageForwardOK {
+		sugar.Warnf("拒绝转发消息: from_user_id=%d sources=%v status=%d", fromUserID, forward.GetSourceMessageIds(), outcome.Status)
+		return response, nil
+	}
+
+	for _, forwarded := range outcome.Messages {
+		message := forwarded.Message
+		if forwarded.Created {
+			seqStart := time.Now()
+			_, err = db.AssignInboxSequencesWithDB(database, message)
+			metrics.RecordDatabaseQuery("upsert", seqStart)
+			if err != nil {
+				sugar.Errorf("分配收件序号失败: %v", err)
+				metrics.RecordDatabaseError()
+				return nil, err
+			}
+
+			summaryStart := time.Now()
+			err = db.RecordConversationMessageWithDB(database, message)
+			metrics.RecordDatabaseQuery("upsert", summaryStart)
+			if err != nil {
+				sugar.Errorf("更新会话摘要失败: %v", err)
+				metrics.RecordDatabaseError()
+				return nil, err
+			}
+		}
+		clientMessageID := ""
+		if message.ClientMessageID != nil {
+			clientMessageID = *message.ClientMessageID
+		}
+		rsp.Messages = append(rsp.Messages, &storage.StoreMsgRsp{
+			MessageId:       message.MessageID,
+			ClientMessageId: clientMessageID,
+			Created:         forwarded.Created,
+			FromUserId:      message.FromUserID,
+			ToUserId:        message.ToUserID,
+			Content:         message.Content,
+			MessageType:     message.MessageType,
+			IsGroup:         message.IsGroup,
+			RealFileName:    message.RealFileName,
+			ClientTimestamp: message.Timestamp,
+			MergedItems:     newStorageMergedForwardItems(forwarded.Items),
+		})
+	}
+
+	cacheKey := fmt.Sprintf("user_messages:%d", forward.GetTargetId())
+	if cacheKeys != nil {
+		*cacheKeys = append(*cacheKeys, cacheKey)
+	} else {
+		h.clearCacheKeys([]string{cacheKey})
+	}
+	return response, nil
+}
+
+func storageResultForForwardStatus(status db.MessageForwardStatus) storage.StorageResult {
+	switch status {
+	case db.MessageForwardOK:
+		return storage.StorageResult_OK
+	case db.MessageForwardRecalled:
+		return storage.StorageResult_ALREADY_RECALLED
+	case db.MessageForwardInvalid:
+		return storage.StorageResult_INVALID_FORWARD
+	default:
+		return storage.StorageResult_RECORD_NOT_EXIST
+	}
+}
+
+// handleMarkMessageReceiptsWithDB 持久化回执，响应经回执人所在的DF容器转发给各消息发送者。
+func (h *StorageHandler) handleMarkMessageReceiptsWithDB(database *gorm.DB, req *storage.RequestMessage, mark *storage.MarkMessageReceipts) (*storage.ResponseMessage, error) {
+	readerUserID := req.GetTargetUserId()
+	now := time.Now().UTC()
+	rsp := &storage.MessageReceiptsRsp{
+		ReaderUserId: readerUserID,
+		Read:         mark.GetRead(),
+		ReceiptedAt:  now.Format(time.RFC3339),
+	}
+	response := &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: readerUserID,
+		Payload:      &storage.ResponseMessage_MessageReceiptsRsp{MessageReceiptsRsp: rsp},
+	}
+
+	start := time.Now()
+	updates, err := db.MarkMessageReceiptsWithDB(database, db.MessageReceiptRequest{
+		UserID:         readerUserID,
+		Read:           mark.GetRead(),
+		MessageIDs:     mark.GetMessageIds(),
+		ConversationID: mark.GetConversationId(),
+		IsGroup:        mark.GetIsGroup(),
+		UpToMessageID:  mark.GetUpToMessageId(),
+	}, now)
+	metrics.RecordDatabaseQuery("insert", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	if mark.GetRead() && len(updates) > 0 {
+		// 已读回执返回的都是本次新变为已读的消息，据此扣减会话未读数
+		readMessages := make([]db.Message, 0, len(updates))
+		for _, update := range updates {
+			readMessages = append(readMessages, update.Message)
+		}
+		if err := db.MarkConversationMessagesReadWithDB(database, readerUserID, readMessages); err != nil {
+			metrics.RecordDatabaseError()
+			return nil, err
+		}
+	}
+	for _, update := range updates {
+		rsp.Updates = append(rsp.Updates, &storage.MessageReceiptUpdate{
+			MessageId:      update.Message.MessageID,
+			FromUserId:     update.Message.FromUserID,
+			ToUserId:       update.Message.ToUserID,
+			IsGroup:        update.Message.IsGroup,
+			DeliveredCount: update.DeliveredCount,
+			ReadCount:      update.ReadCount,
+		})
+	}
+	return response, nil
+}
+
+// handleUpdatePresenceSettingsWithDB 保存用户的在线状态隐私设置，用户为请求的 target_user_id。
+func (h *StorageHandler) handleUpdatePresenceSettingsWithDB(database *gorm.DB, req *storage.RequestMessage, update *storage.UpdatePresenceSettings) (*storage.ResponseMessage, error) {
+	if req.GetTargetUserId() <= 0 {
+		return &storage.ResponseMessage{Result: storage.StorageResult_FORBIDDEN, TargetUserId: req.GetTargetUserId()}, nil
+	}
+	start := time.Now()
+	setting, err := db.SetHideLastSeenWithDB(database, req.GetTargetUserId(), update.GetHideLastSeen(), time.Now())
+	metrics.RecordDatabaseQuery("upsert", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: req.GetTargetUserId(),
+		Payload: &storage.ResponseMessage_PresenceSettingsRsp{PresenceSettingsRsp: &storage.PresenceSettingsRsp{
+			UserId:       setting.UserID,
+			HideLastSeen: setting.HideLastSeen,
+			UpdateTime:   setting.UpdateTime,
+		}},
+	}, nil
+}
+
 func storageResultForRecallStatus(status db.MessageRecallStatus) storage.StorageResult {
 	switch status {
 	case db.MessageRecallOK:
@@ -340,7 +881,15 @@ func (h *StorageHandler) authorizedMessageResponseWithDB(database *gorm.DB, req
 		)
 		return messageNotFoundResponse(req), nil
 	}
-	return h.buildMessageResponse(req, message), nil
+	// 回应与引用预览随查询者或被引用消息变化，不进入消息缓存
+	response := h.buildMessageResponse(req, message)
+	if database == nil {
+		database = h.requestDatabase()
+	}
+	if err := attachMessageDetailsWithDB(database, req.GetTargetUserId(), []*storage.MessageRsp{response.GetMsgRsp()}); err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
 func messageNotFoundResponse(req *storage.RequestMessage) *storage.ResponseMessage {
@@ -365,6 +914,10 @@ func (h *StorageHandler) handleQuerySyncMessagesWithDB(database *gorm.DB, req *s
 		}, nil
 	}
 
+	if query.GetUseInboxSeq() {
+		return h.handleQueryInboxSyncWithDB(database, req, query)
+	}
+
 	// 新客户端优先使用复合游标；旧客户端继续使用 timestamp 作为初始下界。
 	cursorTimestamp := query.GetCursorTimestamp()
 	if cursorTimestamp == "" {
@@ -409,21 +962,12 @@ func (h *StorageHandler) handleQuerySyncMessagesWithDB(database *gorm.DB, req *s
 
 	// 转换为Protobuf格式
 	var msgResponses []*storage.MessageRsp
-	for _, msg := range page.Messages {
-		msgResponses = append(msgResponses, &storage.MessageRsp{
-			MessageId:    msg.MessageID,
-			FromUserId:   msg.FromUserID,
-			ToUserId:     msg.ToUserID,
-			Content:      msg.Content,
-			Timestamp:    msg.Timestamp,
-			MsgType:      msg.MessageType,
-			IsGroup:      msg.IsGroup,
-			RealFileName: msg.RealFileName,
-			IsRecalled:   msg.IsRecalled,
-			RecalledAt:   msg.RecalledAt,
-			RecalledBy:   msg.RecalledBy,
-		})
-		maskRecalledStorageMessage(msgResponses[len(msgResponses)-1])
+	for i := range page.Messages {
+		msgResponses = append(msgResponses, newStorageMessageRsp(&page.Messages[i]))
+	}
+	if err := attachMessageDetailsWithDB(database, req.GetTargetUserId(), msgResponses); err != nil {
+		sugar.Errorf("查询同步消息的回应与引用失败: %v", err)
+		return nil, err
 	}
 
 	resp := &storage.ResponseMessage{
@@ -442,6 +986,63 @@ func (h *StorageHandler) handleQuerySyncMessagesWithDB(database *gorm.DB, req *s
 	return resp, nil
 }
 
+// handleQueryInboxSyncWithDB 按请求者的收件序号同步消息，不受消息时间戳和时钟偏差影响
+func (h *StorageHandler) handleQueryInboxSyncWithDB(database *gorm.DB, req *storage.RequestMessage, query *storage.QuerySyncMessages) (*storage.ResponseMessage, error) {
+	sugar := logger.Sugar()
+	userID := req.GetTargetUserId()
+
+	start := time.Now()
+	pageSize := normalizeSyncPageSize(query.GetPageSize())
+	page, err := db.GetInboxSyncPageWithDB(database, userID, query.GetAfterSeq(), pageSize)
+	var latestSeq, latestReactionSeq int64
+	var reactionPage *db.ReactionUpdatesPage
+	if err == nil {
+		// 在读取页面之后查询，保证 latest_seq 不小于 next_after_seq
+		latestSeq, err = db.GetLatestInboxSeqWithDB(database, userID)
+	}
+	if err == nil {
+		reactionPage, err = db.GetReactionUpdatesPageWithDB(database, userID, query.GetAfterReactionSeq(), pageSize)
+	}
+	if err == nil {
+		latestReactionSeq, err = db.GetLatestReactionSeqWithDB(database, userID)
+	}
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		sugar.Errorf("按收件序号同步消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+
+	sugar.Debugf("按收件序号查询到 %d 条消息与 %d 条回应变化: after_seq=%d next_after_seq=%d latest_seq=%d next_after_reaction_seq=%d",
+		len(page.Messages), len(reactionPage.Messages), query.GetAfterSeq(), page.NextAfterSeq, latestSeq, reactionPage.NextAfterSeq)
+
+	rsp := &storage.SyncMessagesRsp{
+		HasMore:                page.HasMore,
+		NextAfterSeq:           page.NextAfterSeq,
+		LatestSeq:              latestSeq,
+		ReactionUpdatesHasMore: reactionPage.HasMore,
+		NextAfterReactionSeq:   reactionPage.NextAfterSeq,
+		LatestReactionSeq:      latestReactionSeq,
+	}
+	for i := range page.Messages {
+		message := newStorageMessageRsp(&page.Messages[i])
+		message.InboxSeq = page.InboxSeqs[message.GetMessageId()]
+		rsp.Msgs = append(rsp.Msgs, message)
+	}
+	for i := range reactionPage.Messages {
+		rsp.ReactionUpdates = append(rsp.ReactionUpdates, newStorageMessageRsp(&reactionPage.Messages[i]))
+	}
+	if err := attachMessageDetailsWithDB(database, userID, append(rsp.Msgs, rsp.ReactionUpdates...)); err != nil {
+		sugar.Errorf("查询同步消息的回应与引用失败: %v", err)
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_SyncMsgsRsp{SyncMsgsRsp: rsp},
+	}, nil
+}
+
 func normalizeSyncPageSize(requested int32) int {
 	if requested <= 0 {
 		return db.DefaultSyncPageSize
@@ -452,6 +1053,261 @@ func normalizeSyncPageSize(requested int32) int {
 	return int(requested)
 }
 
+// handleQueryConversationsWithDB 按最近活动时间倒序返回请求者自己的会话列表
+func (h *StorageHandler) handleQueryConversationsWithDB(database *gorm.DB, req *storage.RequestMessage, query *storage.QueryConversations) (*storage.ResponseMessage, error) {
+	userID := req.GetTargetUserId()
+	if userID <= 0 {
+		return &storage.ResponseMessage{
+			Result:       storage.StorageResult_FORBIDDEN,
+			TargetUserId: userID,
+		}, nil
+	}
+
+	cursorMessageID := query.GetCursorMessageId()
+	if cursorMessageID < 0 {
+		cursorMessageID = 0
+	}
+	start := time.Now()
+	page, err := db.GetConversationSummariesPageWithDB(database, userID, query.GetCursorTimestamp(), cursorMessageID, int(query.GetPageSize()))
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		logger.Sugar().Errorf("查询会话列表失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+
+	rsp := &storage.ConversationsRsp{
+		HasMore:             page.HasMore,
+		NextCursorTimestamp: page.NextCursorTimestamp,
+		NextCursorMessageId: page.NextCursorMessageID,
+	}
+	for _, conversation := range page.Conversations {
+		rsp.Conversations = append(rsp.Conversations, &storage.ConversationSummary{
+			ConversationId:      conversation.ConversationID,
+			IsGroup:             conversation.IsGroup,
+			LastMessageId:       conversation.LastMessageID,
+			LastSenderId:        conversation.LastSenderID,
+			LastMessageType:     conversation.LastMessageType,
+			Snippet:             conversation.Snippet,
+			LastMessageAt:       conversation.LastMessageAt,
+			LastMessageRecalled: conversation.LastMessageRecalled,
+			UnreadCount:         conversation.UnreadCount,
+		})
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_ConversationsRsp{ConversationsRsp: rsp},
+	}, nil
+}
+
+// handleQueryConversationHistoryWithDB 在请求者参与的单个会话内向前翻页查询历史消息
+func (h *StorageHandler) handleQueryConversationHistoryWithDB(database *gorm.DB, req *storage.RequestMessage, query *storage.QueryConversationHistory) (*storage.ResponseMessage, error) {
+	userID := req.GetTargetUserId()
+	forbidden := &storage.ResponseMessage{
+		Result:       storage.StorageResult_FORBIDDEN,
+		TargetUserId: userID,
+	}
+	if userID <= 0 || query.GetPeerOrGroupId() <= 0 {
+		return forbidden, nil
+	}
+
+	start := time.Now()
+	page, err := db.GetConversationHistoryPageWithDB(database, userID, query.GetPeerOrGroupId(), query.GetIsGroup(), query.GetBeforeMessageId(), int(query.GetLimit()))
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		logger.Sugar().Errorf("查询会话历史消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	if page == nil {
+		logger.Sugar().Warnf("安全拒绝非群成员查询群历史消息: requester_user_id=%d group_id=%d", userID, query.GetPeerOrGroupId())
+		return forbidden, nil
+	}
+
+	rsp := &storage.ConversationHistoryRsp{
+		PeerOrGroupId:       query.GetPeerOrGroupId(),
+		IsGroup:             query.GetIsGroup(),
+		HasMore:             page.HasMore,
+		NextBeforeMessageId: page.NextBeforeMessageID,
+	}
+	for i := range page.Messages {
+		rsp.Msgs = append(rsp.Msgs, newStorageMessageRsp(&page.Messages[i]))
+	}
+	if err := attachMessageDetailsWithDB(database, userID, rsp.Msgs); err != nil {
+		logger.Sugar().Errorf("查询历史消息的回应与引用失败: %v", err)
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_ConversationHistoryRsp{ConversationHistoryRsp: rsp},
+	}, nil
+}
+
+func (h *StorageHandler) handleQueryMentionedMessagesWithDB(database *gorm.DB, req *storage.RequestMessage, query *storage.QueryMentionedMessages) (*storage.ResponseMessage, error) {
+	userID := req.GetTargetUserId()
+	if userID <= 0 {
+		return &storage.ResponseMessage{
+			Result:       storage.StorageResult_FORBIDDEN,
+			TargetUserId: userID,
+		}, nil
+	}
+
+	start := time.Now()
+	page, err := db.GetMentionedMessagesPageWithDB(database, userID, query.GetBeforeMessageId(), int(query.GetLimit()))
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		logger.Sugar().Errorf("查询@我的消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+
+	rsp := &storage.MentionedMessagesRsp{
+		HasMore:             page.HasMore,
+		NextBeforeMessageId: page.NextBeforeMessageID,
+	}
+	for i := range page.Messages {
+		rsp.Msgs = append(rsp.Msgs, newStorageMessageRsp(&page.Messages[i]))
+	}
+	if err := attachMessageDetailsWithDB(database, userID, rsp.Msgs); err != nil {
+		logger.Sugar().Errorf("查询@我的消息的回应与引用失败: %v", err)
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_MentionedMessagesRsp{MentionedMessagesRsp: rsp},
+	}, nil
+}
+
+// handleQueryPinnedMessagesWithDB 列出请求者参与的会话中的置顶消息
+func (h *StorageHandler) handleQueryPinnedMessagesWithDB(database *gorm.DB, req *storage.RequestMessage, query *storage.QueryPinnedMessages) (*storage.ResponseMessage, error) {
+	userID := req.GetTargetUserId()
+	forbidden := &storage.ResponseMessage{
+		Result:       storage.StorageResult_FORBIDDEN,
+		TargetUserId: userID,
+	}
+	if userID <= 0 || query.GetPeerOrGroupId() <= 0 {
+		return forbidden, nil
+	}
+
+	start := time.Now()
+	pinned, err := db.GetPinnedMessagesWithDB(database, userID, query.GetPeerOrGroupId(), query.GetIsGroup())
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		logger.Sugar().Errorf("查询置顶消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+	if pinned == nil {
+		logger.Sugar().Warnf("安全拒绝非群成员查询群置顶消息: requester_user_id=%d group_id=%d", userID, query.GetPeerOrGroupId())
+		return forbidden, nil
+	}
+
+	rsp := &storage.PinnedMessagesRsp{
+		PeerOrGroupId: query.GetPeerOrGroupId(),
+		IsGroup:       query.GetIsGroup(),
+	}
+	messages := make([]*storage.MessageRsp, 0, len(pinned))
+	for i := range pinned {
+		message := newStorageMessageRsp(&pinned[i].Message)
+		messages = append(messages, message)
+		rsp.Pins = append(rsp.Pins, &storage.PinnedMessage{
+			Msg:      message,
+			PinnedBy: pinned[i].PinnedBy,
+			PinnedAt: pinned[i].PinnedAt,
+		})
+	}
+	if err := attachMessageDetailsWithDB(database, userID, messages); err != nil {
+		logger.Sugar().Errorf("查询置顶消息的回应与引用失败: %v", err)
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_PinnedMessagesRsp{PinnedMessagesRsp: rsp},
+	}, nil
+}
+
+// handleSearchMessagesWithDB 在请求者可读的消息中全文检索
+func (h *StorageHandler) handleSearchMessagesWithDB(database *gorm.DB, req *storage.RequestMessage, search *storage.SearchMessages) (*storage.ResponseMessage, error) {
+	userID := req.GetTargetUserId()
+	if userID <= 0 {
+		return &storage.ResponseMessage{
+			Result:       storage.StorageResult_FORBIDDEN,
+			TargetUserId: userID,
+		}, nil
+	}
+	filter, ok := messageSearchFilterFromRequest(search)
+	if !ok {
+		return &storage.ResponseMessage{
+			Result:       storage.StorageResult_INVALID_SEARCH,
+			TargetUserId: userID,
+		}, nil
+	}
+
+	start := time.Now()
+	page, err := db.SearchMessagesPageWithDB(database, userID, search.GetQuery(), filter, search.GetBeforeMessageId(), int(search.GetLimit()))
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		logger.Sugar().Errorf("搜索消息失败: %v", err)
+		metrics.RecordDatabaseError()
+		return nil, err
+	}
+
+	rsp := &storage.SearchMessagesRsp{
+		HasMore:             page.HasMore,
+		NextBeforeMessageId: page.NextBeforeMessageID,
+	}
+	for i := range page.Messages {
+		rsp.Msgs = append(rsp.Msgs, newStorageMessageRsp(&page.Messages[i]))
+	}
+	if err := attachMessageDetailsWithDB(database, userID, rsp.Msgs); err != nil {
+		logger.Sugar().Errorf("查询搜索结果的回应与引用失败: %v", err)
+		return nil, err
+	}
+	return &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: userID,
+		Payload:      &storage.ResponseMessage_SearchMessagesRsp{SearchMessagesRsp: rsp},
+	}, nil
+}
+
+// messageSearchFilterFromRequest 校验搜索词和时间范围，时间统一转换为与消息时间戳相同的 UTC RFC3339 格式
+func messageSearchFilterFromRequest(search *storage.SearchMessages) (db.MessageSearchFilter, bool) {
+	query := strings.TrimSpace(search.GetQuery())
+	if utf8.RuneCountInString(query) > db.MaxMessageSearchQueryRunes || db.MessageSearchQuery(query) == "" {
+		return db.MessageSearchFilter{}, false
+	}
+	if search.GetPeerOrGroupId() < 0 {
+		return db.MessageSearchFilter{}, false
+	}
+	filter := db.MessageSearchFilter{
+		PeerOrGroupID: search.GetPeerOrGroupId(),
+		IsGroup:       search.GetIsGroup(),
+		MessageType:   strings.ToLower(strings.TrimSpace(search.GetMsgType())),
+	}
+	var since, until time.Time
+	var err error
+	if raw := search.GetSince(); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			return db.MessageSearchFilter{}, false
+		}
+		filter.Since = since.UTC().Format(time.RFC3339)
+	}
+	if raw := search.GetUntil(); raw != "" {
+		if until, err = time.Parse(time.RFC3339, raw); err != nil {
+			return db.MessageSearchFilter{}, false
+		}
+		filter.Until = until.UTC().Format(time.RFC3339)
+	}
+	if filter.Since != "" && filter.Until != "" && !since.Before(until) {
+		return db.MessageSearchFilter{}, false
+	}
+	return filter, true
+}
+
 // handleUpdateUserName 处理更新用户名请求
 func (h *StorageHandler) handleUpdateUserNameWithDB(database *gorm.DB, req *storage.RequestMessage, update *storage.UpdateUserName, cacheKeys *[]string) (*storage.ResponseMessage, error) {
 	sugar := logger.Sugar()
@@ -549,29 +1405,220 @@ func (h *StorageHandler) handleUpdateUserAvatarWithDB(database *gorm.DB, req *st
 	return resp, nil
 }
 
+// appendUserProfileChanged 在资料更新成功后读取最新资料，生成推送给好友的变更事件
+func appendUserProfileChanged(database *gorm.DB, userID int64, events *[]*storage.ResponseMessage) error {
+	if events == nil {
+		return nil
+	}
+	start := time.Now()
+	user, err := db.GetUserByIDWithDB(database, userID)
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	*events = append(*events, &storage.ResponseMessage{
+		Result:       storage.StorageResult_OK,
+		TargetUserId: user.ID,
+		Payload: &storage.ResponseMessage_UserProfileChanged{
+			UserProfileChanged: &storage.UserProfileChanged{
+				UserId:     user.ID,
+				Name:       user.Name,
+				Avatar:     user.Avatar,
+				UpdateTime: user.UpdateTime,
+			},
+		},
+	})
+	return nil
+}
+
 // buildMessageResponse 构建消息查询响应
 func (h *StorageHandler) buildMessageResponse(req *storage.RequestMessage, msg *db.Message) *storage.ResponseMessage {
-	response := &storage.ResponseMessage{
+	return &storage.ResponseMessage{
 		Result:       storage.StorageResult_OK,
 		TargetUserId: req.TargetUserId,
 		Payload: &storage.ResponseMessage_MsgRsp{
-			MsgRsp: &storage.MessageRsp{
-				MessageId:    msg.MessageID,
-				FromUserId:   msg.FromUserID,
-				ToUserId:     msg.ToUserID,
-				Content:      msg.Content,
-				Timestamp:    msg.Timestamp,
-				MsgType:      msg.MessageType,
-				IsGroup:      msg.IsGroup,
-				RealFileName: msg.RealFileName,
-				IsRecalled:   msg.IsRecalled,
-				RecalledAt:   msg.RecalledAt,
-				RecalledBy:   msg.RecalledBy,
-			},
+			MsgRsp: newStorageMessageRsp(msg),
 		},
 	}
-	maskRecalledStorageMessage(response.GetMsgRsp())
-	return response
+}
+
+// newStorageMessageRsp 转换为 Protobuf 消息，已撤回消息不返回原内容
+func newStorageMessageRsp(msg *db.Message) *storage.MessageRsp {
+	message := &storage.MessageRsp{
+		MessageId:        msg.MessageID,
+		FromUserId:       msg.FromUserID,
+		ToUserId:         msg.ToUserID,
+		Content:          msg.Content,
+		Timestamp:        msg.Timestamp,
+		MsgType:          msg.MessageType,
+		IsGroup:          msg.IsGroup,
+		RealFileName:     msg.RealFileName,
+		IsRecalled:       msg.IsRecalled,
+		RecalledAt:       msg.RecalledAt,
+		RecalledBy:       msg.RecalledBy,
+		EditedAt:         msg.EditedAt,
+		ReplyToMessageId: msg.ReplyToMessageID,
+	}
+	maskRecalledStorageMessage(message)
+	return message
+}
+
+// attachMessageDetailsWithDB 为一页消息填充表情回应、引用预览与合并转发快照
+func attachMessageDetailsWithDB(database *gorm.DB, viewerUserID int64, messages []*storage.MessageRsp) error {
+	if err := attachMessageReactionsWithDB(database, viewerUserID, messages); err != nil {
+		return err
+	}
+	if err := attachQuotedMessagesWithDB(database, viewerUserID, messages); err != nil {
+		return err
+	}
+	if err := attachMergedForwardItemsWithDB(database, messages); err != nil {
+		return err
+	}
+	return attachMessageMentionsWithDB(database, messages)
+}
+
+// attachMessageMentionsWithDB 填充群消息的@对象，单聊消息不会有@记录，无需查询
+func attachMessageMentionsWithDB(database *gorm.DB, messages []*storage.MessageRsp) error {
+	groupMessageIDs := make([]int64, 0)
+	for _, message := range messages {
+		if message.GetIsGroup() && !message.GetIsRecalled() {
+			groupMessageIDs = append(groupMessageIDs, message.GetMessageId())
+		}
+	}
+	if len(groupMessageIDs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	mentions, err := db.GetMessageMentionsWithDB(database, groupMessageIDs)
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return err
+	}
+	for _, message := range messages {
+		if mention, ok := mentions[message.GetMessageId()]; ok && !message.GetIsRecalled() {
+			message.MentionedUserIds = mention.UserIDs
+			message.MentionAll = mention.All
+		}
+	}
+	return nil
+}
+
+// attachMergedForwardItemsWithDB 填充合并转发消息的源消息快照，已撤回的合并转发不返回快照
+func attachMergedForwardItemsWithDB(database *gorm.DB, messages []*storage.MessageRsp) error {
+	mergedIDs := make([]int64, 0)
+	for _, message := range messages {
+		if message.GetMsgType() == db.MergedForwardMessageType && !message.GetIsRecalled() {
+			mergedIDs = append(mergedIDs, message.GetMessageId())
+		}
+	}
+	if len(mergedIDs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	items, err := db.GetMergedForwardItemsWithDB(database, mergedIDs)
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return err
+	}
+	for _, message := range messages {
+		if message.GetMsgType() == db.MergedForwardMessageType && !message.GetIsRecalled() {
+			message.MergedItems = newStorageMergedForwardItems(items[message.GetMessageId()])
+		}
+	}
+	return nil
+}
+
+func newStorageMergedForwardItems(items []db.MergedForwardItem) []*storage.MergedForwardItem {
+	converted := make([]*storage.MergedForwardItem, 0, len(items))
+	for _, item := range items {
+		converted = append(converted, &storage.MergedForwardItem{
+			SourceMessageId: item.SourceMessageID,
+			FromUserId:      item.FromUserID,
+			Content:         item.Content,
+			Timestamp:       item.Timestamp,
+			MsgType:         item.MessageType,
+			RealFileName:    item.RealFileName,
+		})
+	}
+	return converted
+}
+
+// attachQuotedMessagesWithDB 填充被引用消息的摘要，已撤回的回复消息不返回引用，请求者不可读的被引用消息不返回摘要
+func attachQuotedMessagesWithDB(database *gorm.DB, viewerUserID int64, messages []*storage.MessageRsp) error {
+	quotedIDs := make([]int64, 0)
+	for _, message := range messages {
+		if message.GetReplyToMessageId() > 0 && !message.GetIsRecalled() {
+			quotedIDs = append(quotedIDs, message.GetReplyToMessageId())
+		}
+	}
+	if len(quotedIDs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	previews, err := db.GetQuotedMessagePreviewsWithDB(database, viewerUserID, quotedIDs)
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return err
+	}
+	for _, message := range messages {
+		preview, exists := previews[message.GetReplyToMessageId()]
+		if !exists || message.GetIsRecalled() {
+			continue
+		}
+		message.ReplyTo = &storage.QuotedMessage{
+			MessageId:  preview.MessageID,
+			FromUserId: preview.FromUserID,
+			MsgType:    preview.MessageType,
+			Snippet:    preview.Snippet,
+			IsRecalled: preview.IsRecalled,
+		}
+	}
+	return nil
+}
+
+// attachMessageReactionsWithDB 为一页消息填充表情回应聚合，已撤回消息不返回回应
+func attachMessageReactionsWithDB(database *gorm.DB, viewerUserID int64, messages []*storage.MessageRsp) error {
+	messageIDs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		if !message.GetIsRecalled() {
+			messageIDs = append(messageIDs, message.GetMessageId())
+		}
+	}
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	counts, err := db.GetMessageReactionCountsWithDB(database, viewerUserID, messageIDs)
+	metrics.RecordDatabaseQuery("select", start)
+	if err != nil {
+		metrics.RecordDatabaseError()
+		return err
+	}
+	for _, message := range messages {
+		if !message.GetIsRecalled() {
+			message.Reactions = newStorageMessageReactions(counts[message.GetMessageId()])
+		}
+	}
+	return nil
+}
+
+func newStorageMessageReactions(counts []db.ReactionCount) []*storage.MessageReaction {
+	reactions := make([]*storage.MessageReaction, 0, len(counts))
+	for _, count := range counts {
+		reactions = append(reactions, &storage.MessageReaction{
+			Emoji:       count.Emoji,
+			Count:       count.Count,
+			ReactedByMe: count.ReactedByMe,
+		})
+	}
+	return reactions
 }
 
 func maskRecalledStorageMessage(message *storage.MessageRsp) {
@@ -580,6 +1627,10 @@ func maskRecalledStorageMessage(message *storage.MessageRsp) {
 	}
 	message.Content = ""
 	message.RealFileName = ""
+	message.ReplyTo = nil
+	message.MergedItems = nil
+	message.MentionedUserIds = nil
+	message.MentionAll = false
 }
 
 // getFromCache 从缓存获取数据（先L1后L2）
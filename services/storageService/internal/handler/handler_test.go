@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -127,6 +128,26 @@ func useMockDB(t *testing.T) sqlmock.Sqlmock {
 	return mock
 }
 
+func expectMessageReactionCounts(mock sqlmock.Sqlmock, viewerUserID int64, messageID int64, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT message_id, emoji, COUNT\(\*\) AS count, BOOL_OR\(user_id = \$1\) AS reacted_by_me, MIN\(created_at\) AS first_at FROM "message_reactions" WHERE message_id IN \(\$2\) GROUP BY message_id, emoji`).
+		WithArgs(viewerUserID, messageID).
+		WillReturnRows(rows)
+}
+
+func emptyReactionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"message_id", "emoji", "count", "reacted_by_me", "first_at"})
+}
+
+func expectMessageMentions(mock sqlmock.Sqlmock, messageID int64, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT \* FROM "message_mentions" WHERE message_id IN \(\$1\)`).
+		WithArgs(messageID).
+		WillReturnRows(rows)
+}
+
+func emptyMentionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"message_id", "user_id"})
+}
+
 func TestNewStorageHandler(t *testing.T) {
 	_, _ = setupMockDB(t)
 	// 注意：我们不检查模拟数据库的期望，因为这个测试只验证handler创建
@@ -170,9 +191,27 @@ func TestHandleStoreNewMessage(t *testing.T) {
 	// 设置数据库期望
 	mock.ExpectBegin()
 	mock.ExpectQuery("INSERT INTO \"messages\"").
-		WithArgs("client-message-1", int64(1000), int64(1001), "Hello, World!", sqlmock.AnyArg(), "text", "", false, false, "", int64(0)).
+		WithArgs("client-message-1", int64(1000), int64(1001), "Hello, World!", sqlmock.AnyArg(), "text", "", false, false, "", int64(0), "", int64(0), "hello world").
 		WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow(12345))
 	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO \"user_inbox_sequences\" .* ON CONFLICT").
+		WithArgs(int64(1001), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1001, 5))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO \"inbox_entries\"").
+		WithArgs(int64(1001), int64(5), int64(12345)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO \"conversation_summaries\" .* ON CONFLICT").
+		WithArgs(
+			int64(1000), int64(1001), false, int64(12345), int64(1000), "text", "Hello, World!", sqlmock.AnyArg(), false, int64(0),
+			int64(1001), int64(1000), false, int64(12345), int64(1000), "text", "Hello, World!", sqlmock.AnyArg(), false, int64(1),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
 
 	// 调用处理函数
 	resp, err := handler.handleStoreNewMessageWithDB(handler.requestDatabase(), req, req.GetStoreNewMessage(), nil)
@@ -206,7 +245,7 @@ func TestHandleStoreNewMessageReturnsExistingMessageForDuplicateClientID(t *test
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("INSERT INTO \"messages\"").
-		WithArgs("client-message-1", int64(1000), int64(1001), "Hello, World!", sqlmock.AnyArg(), "text", "", false, false, "", int64(0)).
+		WithArgs("client-message-1", int64(1000), int64(1001), "Hello, World!", sqlmock.AnyArg(), "text", "", false, false, "", int64(0), "", int64(0), "hello world").
 		WillReturnRows(sqlmock.NewRows([]string{"message_id"}))
 	mock.ExpectCommit()
 	mock.ExpectQuery("SELECT \\* FROM \"messages\" WHERE from_user_id = \\$1 AND client_message_id = \\$2 ORDER BY \"messages\".\"message_id\" LIMIT \\$3").
@@ -223,6 +262,99 @@ func TestHandleStoreNewMessageReturnsExistingMessageForDuplicateClientID(t *test
 	}
 }
 
+func TestHandleStoreNewMessageRejectsReplyFromOtherConversation(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	msg := &storage.StoreNewMessage{
+		FromUserId:       1000,
+		ToUserId:         1001,
+		Content:          "agreed",
+		MessageType:      "text",
+		ClientMessageId:  "client-message-2",
+		ReplyToMessageId: 900,
+	}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE from_user_id = \$1 AND client_message_id = \$2 ORDER BY "messages"\."message_id" LIMIT \$3`).
+		WithArgs(int64(1000), "client-message-2", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}))
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1 ORDER BY "messages"\."message_id" LIMIT \$2`).
+		WithArgs(int64(900), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "is_group"}).AddRow(900, 1000, 1002, false))
+
+	resp, err := handler.handleStoreNewMessageWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1000, Payload: &storage.RequestMessage_StoreNewMessage{StoreNewMessage: msg}}, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := resp.GetStoreMsgRsp()
+	if resp.GetResult() != storage.StorageResult_INVALID_REPLY || store.GetMessageId() != 0 || store.GetClientMessageId() != "client-message-2" || store.GetReplyToMessageId() != 900 {
+		t.Fatalf("unexpected invalid reply response: %+v", resp)
+	}
+}
+
+func TestHandleStoreNewMessageRetryReturnsStoredReplyWithoutRecheckingQuote(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	msg := &storage.StoreNewMessage{
+		FromUserId:       1000,
+		ToUserId:         1001,
+		Content:          "agreed",
+		MessageType:      "text",
+		ClientMessageId:  "client-message-2",
+		ReplyToMessageId: 900,
+	}
+	// 首次请求已经写入，被引用消息随后不再可引用；重试只查到已存储的消息，不再校验引用
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE from_user_id = \$1 AND client_message_id = \$2 ORDER BY "messages"\."message_id" LIMIT \$3`).
+		WithArgs(int64(1000), "client-message-2", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "client_message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group", "reply_to_message_id",
+		}).AddRow(12346, "client-message-2", 1000, 1001, "agreed", "2026-07-12T09:00:01Z", "text", false, 900))
+
+	resp, err := handler.handleStoreNewMessageWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1000, Payload: &storage.RequestMessage_StoreNewMessage{StoreNewMessage: msg}}, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := resp.GetStoreMsgRsp()
+	if resp.GetResult() != storage.StorageResult_OK || store.GetMessageId() != 12346 || store.GetCreated() || store.GetReplyToMessageId() != 900 {
+		t.Fatalf("retry should return the stored reply: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleStoreNewMessageRejectsMentionAllFromMember(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	msg := &storage.StoreNewMessage{
+		FromUserId:      1000,
+		ToUserId:        7,
+		Content:         "everyone look",
+		MessageType:     "text",
+		IsGroup:         true,
+		ClientMessageId: "client-message-3",
+		MentionAll:      true,
+	}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE from_user_id = \$1 AND client_message_id = \$2 ORDER BY "messages"\."message_id" LIMIT \$3`).
+		WithArgs(int64(1000), "client-message-3", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}))
+	mock.ExpectQuery(`SELECT \* FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(1000), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "role"}).AddRow(7, 1000, db.GroupRoleMember))
+
+	resp, err := handler.handleStoreNewMessageWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1000, Payload: &storage.RequestMessage_StoreNewMessage{StoreNewMessage: msg}}, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult() != storage.StorageResult_FORBIDDEN || resp.GetStoreMsgRsp().GetMessageId() != 0 || resp.GetStoreMsgRsp().GetClientMessageId() != "client-message-3" {
+		t.Fatalf("unexpected mention all response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestHandleRecallMessagePersistsAndReturnsRoutingMetadata(t *testing.T) {
 	mock := useMockDB(t)
 	handler := &StorageHandler{l1Cache: newMockCache()}
@@ -233,10 +365,20 @@ func TestHandleRecallMessagePersistsAndReturnsRoutingMetadata(t *testing.T) {
 			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "real_file_name", "is_group", "is_recalled", "recalled_at", "recalled_by",
 		}).AddRow(77, 1001, 9001, "hello", sentAt, "text", "", true, false, "", 0))
 	mock.ExpectBegin()
-	mock.ExpectExec(`UPDATE "messages" SET "is_recalled"=\$1,"recalled_at"=\$2,"recalled_by"=\$3 WHERE message_id = \$4 AND is_recalled = \$5`).
-		WithArgs(true, sqlmock.AnyArg(), int64(1001), int64(77), false).
+	mock.ExpectExec(`UPDATE "messages" SET "is_recalled"=\$1,"recalled_at"=\$2,"recalled_by"=\$3,"search_tokens"=\$4 WHERE message_id = \$5 AND is_recalled = \$6`).
+		WithArgs(true, sqlmock.AnyArg(), int64(1001), "", int64(77), false).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "last_message_recalled"=\$1,"snippet"=\$2 WHERE \(is_group = \$3 AND conversation_id = \$4\) AND last_message_id = \$5`).
+		WithArgs(true, "", true, int64(9001), int64(77)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "unread_count"=unread_count - 1 WHERE \(is_group = \$1 AND conversation_id = \$2\)`).
+		WithArgs(true, int64(9001), int64(1001), 0, int64(77), int64(9001), sentAt).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
 
 	cacheKeys := make([]string, 0, 2)
 	resp, err := handler.handleRecallMessageWithDB(handler.requestDatabase(),
@@ -256,6 +398,551 @@ func TestHandleRecallMessagePersistsAndReturnsRoutingMetadata(t *testing.T) {
 	}
 }
 
+func TestHandleEditMessageUpdatesContentAndSummary(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache(), editWindow: 5 * time.Minute}
+	sentAt := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1 ORDER BY "messages"\."message_id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(int64(77), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "real_file_name", "is_group", "is_recalled", "recalled_at", "recalled_by", "edited_at",
+		}).AddRow(77, 1001, 1002, "helo", sentAt, "text", "", false, false, "", 0, ""))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "message_edits"`).
+		WithArgs(int64(77), int64(1001), "helo", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "messages" SET "content"=\$1,"edited_at"=\$2,"search_tokens"=\$3 WHERE message_id = \$4 AND is_recalled = \$5`).
+		WithArgs("hello", sqlmock.AnyArg(), "hello", int64(77), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "snippet"=\$1 WHERE \(is_group = \$2 AND \(\(user_id = \$3 AND conversation_id = \$4\) OR \(user_id = \$5 AND conversation_id = \$6\)\)\) AND last_message_id = \$7`).
+		WithArgs("hello", false, int64(1001), int64(1002), int64(1002), int64(1001), int64(77)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	cacheKeys := make([]string, 0, 2)
+	resp, err := handler.handleEditMessageWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1001},
+		&storage.EditMessage{MessageId: 77, NewContent: "hello"},
+		&cacheKeys,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := resp.GetEditMessageRsp()
+	if resp.GetResult() != storage.StorageResult_OK || edit.GetMessageId() != 77 || edit.GetToUserId() != 1002 || edit.GetContent() != "hello" || edit.GetEditedAt() == "" || edit.GetMessageType() != "text" {
+		t.Fatalf("unexpected edit response: %+v", resp)
+	}
+	if len(cacheKeys) != 2 || cacheKeys[0] != "message:77" || cacheKeys[1] != "user_messages:1002" {
+		t.Fatalf("unexpected cache keys: %v", cacheKeys)
+	}
+}
+
+func TestStorageEditResultMapping(t *testing.T) {
+	tests := map[db.MessageEditStatus]storage.StorageResult{
+		db.MessageEditOK:          storage.StorageResult_OK,
+		db.MessageEditNotFound:    storage.StorageResult_RECORD_NOT_EXIST,
+		db.MessageEditForbidden:   storage.StorageResult_FORBIDDEN,
+		db.MessageEditRecalled:    storage.StorageResult_ALREADY_RECALLED,
+		db.MessageEditExpired:     storage.StorageResult_EDIT_EXPIRED,
+		db.MessageEditNotEditable: storage.StorageResult_NOT_EDITABLE,
+	}
+	for input, want := range tests {
+		if got := storageResultForEditStatus(input); got != want {
+			t.Fatalf("status %v mapped to %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestHandleReactToMessageReturnsReadersAndCounts(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1 ORDER BY "messages"\."message_id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(int64(77), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group", "is_recalled",
+		}).AddRow(77, 1001, 1002, "hello", "2026-07-21T03:00:00Z", "text", false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "message_reactions" WHERE message_id = \$1 AND user_id = \$2 AND emoji = \$3`).
+		WithArgs(int64(77), int64(1002), "👍").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_reaction_sequences"`).
+		WithArgs(int64(1001), int64(1), int64(1002), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1001, 4).AddRow(1002, 9))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "reaction_updates"`).
+		WithArgs(int64(1001), int64(77), int64(4), int64(1002), int64(77), int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	expectMessageReactionCounts(mock, 1002, 77, emptyReactionRows().AddRow(77, "👍", 1, false, "2026-07-21T03:05:00Z"))
+
+	resp, err := handler.handleReactToMessageWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.ReactToMessage{MessageId: 77, Emoji: "👍", Remove: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reaction := resp.GetReactToMessageRsp()
+	if resp.GetResult() != storage.StorageResult_OK || !reaction.GetChanged() || !reaction.GetRemoved() || reaction.GetFromUserId() != 1001 || reaction.GetToUserId() != 1002 || reaction.GetReactedAt() == "" {
+		t.Fatalf("unexpected reaction response: %+v", resp)
+	}
+	if len(reaction.GetReaderUserIds()) != 2 || len(reaction.GetReactions()) != 1 || reaction.GetReactions()[0].GetCount() != 1 || reaction.GetReactions()[0].GetReactedByMe() {
+		t.Fatalf("unexpected reaction fan-out data: %+v", reaction)
+	}
+}
+
+func TestStorageReactionResultMapping(t *testing.T) {
+	tests := map[db.MessageReactionStatus]storage.StorageResult{
+		db.MessageReactionOK:       storage.StorageResult_OK,
+		db.MessageReactionNotFound: storage.StorageResult_RECORD_NOT_EXIST,
+		db.MessageReactionRecalled: storage.StorageResult_ALREADY_RECALLED,
+		db.MessageReactionInvalid:  storage.StorageResult_INVALID_REACTION,
+	}
+	for input, want := range tests {
+		if got := storageResultForReactionStatus(input); got != want {
+			t.Fatalf("status %v mapped to %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestHandleUnpinMessageReturnsConversationRouting(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1 ORDER BY "messages"\."message_id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(int64(78), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group", "is_recalled",
+		}).AddRow(78, 1001, 1002, "agenda", "2026-07-21T03:00:00Z", "text", false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "message_pins" WHERE message_id = \$1`).
+		WithArgs(int64(78)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := handler.handlePinMessageWithDB(handler.requestDatabase(), &storage.RequestMessage{TargetUserId: 1002}, 78, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := resp.GetPinMessageRsp()
+	if resp.GetResult() != storage.StorageResult_OK || !pin.GetChanged() || !pin.GetUnpinned() || pin.GetFromUserId() != 1001 || pin.GetToUserId() != 1002 || pin.GetOperatorUserId() != 1002 || pin.GetPinnedAt() == "" {
+		t.Fatalf("unexpected unpin response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoragePinResultMapping(t *testing.T) {
+	tests := map[db.MessagePinStatus]storage.StorageResult{
+		db.MessagePinOK:           storage.StorageResult_OK,
+		db.MessagePinNotFound:     storage.StorageResult_RECORD_NOT_EXIST,
+		db.MessagePinForbidden:    storage.StorageResult_FORBIDDEN,
+		db.MessagePinRecalled:     storage.StorageResult_ALREADY_RECALLED,
+		db.MessagePinLimitReached: storage.StorageResult_PIN_LIMIT_REACHED,
+	}
+	for input, want := range tests {
+		if got := storageResultForPinStatus(input); got != want {
+			t.Fatalf("status %v mapped to %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestMessagePinLimitFromEnv(t *testing.T) {
+	tests := map[string]int{"": db.DefaultMessagePinLimit, "25": 25, "0": db.DefaultMessagePinLimit, "many": db.DefaultMessagePinLimit}
+	for raw, want := range tests {
+		t.Setenv("MESSAGE_PIN_LIMIT", raw)
+		if got := messagePinLimitFromEnv(); got != want {
+			t.Fatalf("MESSAGE_PIN_LIMIT=%q gave %d, want %d", raw, got, want)
+		}
+	}
+}
+
+func TestHandleQueryPinnedMessagesRejectsNonMember(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT COALESCE\(NULLIF\(joined_at, ''\), update_time\) FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(1009)).
+		WillReturnRows(sqlmock.NewRows([]string{"cutoff"}))
+
+	resp, err := handler.handleQueryPinnedMessagesWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1009},
+		&storage.QueryPinnedMessages{PeerOrGroupId: 7, IsGroup: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult() != storage.StorageResult_FORBIDDEN || resp.GetPinnedMessagesRsp() != nil {
+		t.Fatalf("unexpected pinned response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageSearchFilterFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		search *storage.SearchMessages
+		want   db.MessageSearchFilter
+		ok     bool
+	}{
+		{
+			name:   "normalizes time range",
+			search: &storage.SearchMessages{Query: "开会", PeerOrGroupId: 1002, MsgType: " Text ", Since: "2026-08-01T08:00:00+08:00", Until: "2026-08-02T00:00:00Z"},
+			want:   db.MessageSearchFilter{PeerOrGroupID: 1002, MessageType: "text", Since: "2026-08-01T00:00:00Z", Until: "2026-08-02T00:00:00Z"},
+			ok:     true,
+		},
+		{name: "punctuation only", search: &storage.SearchMessages{Query: " ?! "}},
+		{name: "too long", search: &storage.SearchMessages{Query: strings.Repeat("a", db.MaxMessageSearchQueryRunes+1)}},
+		{name: "bad since", search: &storage.SearchMessages{Query: "report", Since: "yesterday"}},
+		{name: "empty range", search: &storage.SearchMessages{Query: "report", Since: "2026-08-02T00:00:00Z", Until: "2026-08-01T00:00:00Z"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := messageSearchFilterFromRequest(test.search)
+			if ok != test.ok || got != test.want {
+				t.Fatalf("filter=%+v ok=%v, want %+v ok=%v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestHandleSearchMessagesRejectsInvalidQuery(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+
+	resp, err := handler.handleSearchMessagesWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.SearchMessages{Query: "   "},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult() != storage.StorageResult_INVALID_SEARCH || resp.GetSearchMessagesRsp() != nil {
+		t.Fatalf("unexpected search response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleMarkMessageReceiptsReturnsSenderRoutingMetadata(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id IN \(\$1\) AND from_user_id <> \$2 AND is_recalled = \$3`).
+		WithArgs(int64(77), int64(1002), false).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group",
+		}).AddRow(77, 1001, 1002, "hello", "2026-07-21T03:00:00Z", "text", false))
+	mock.ExpectQuery(`SELECT \* FROM "message_receipts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "delivered_at", "read_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_receipts"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT message_id, COUNT`).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "delivered_count", "read_count"}).AddRow(77, 1, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "unread_count"=CASE WHEN unread_count > \$1 THEN unread_count - \$2 ELSE 0 END`).
+		WithArgs(int64(1), int64(1), int64(1002), int64(1001), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := handler.handleMarkMessageReceiptsWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.MarkMessageReceipts{Read: true, MessageIds: []int64{77}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts := resp.GetMessageReceiptsRsp()
+	if resp.GetResult() != storage.StorageResult_OK || resp.GetTargetUserId() != 1002 || receipts.GetReaderUserId() != 1002 || !receipts.GetRead() || len(receipts.GetUpdates()) != 1 {
+		t.Fatalf("unexpected receipt response: %+v", resp)
+	}
+	update := receipts.GetUpdates()[0]
+	if update.GetMessageId() != 77 || update.GetFromUserId() != 1001 || update.GetToUserId() != 1002 || update.GetReadCount() != 1 {
+		t.Fatalf("unexpected receipt update: %+v", update)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleQuerySyncMessagesByInboxSeq(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`FROM inbox_entries AS ie`).
+		WithArgs(int64(1002), int64(10), 101).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "is_recalled", "inbox_seq"}).
+			AddRow(80, 1001, 1002, "hello", false, 11).
+			AddRow(81, 1001, 1002, "secret", true, 12))
+	mock.ExpectQuery(`SELECT \* FROM "user_inbox_sequences" WHERE user_id = \$1 LIMIT \$2`).
+		WithArgs(int64(1002), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1002, 13))
+	mock.ExpectQuery(`FROM reaction_updates AS ru`).
+		WithArgs(int64(1002), int64(4), 101).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "is_recalled", "reaction_seq"}).
+			AddRow(60, 1002, 1001, "older", false, 6))
+	mock.ExpectQuery(`SELECT \* FROM "user_reaction_sequences" WHERE user_id = \$1 LIMIT \$2`).
+		WithArgs(int64(1002), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1002, 6))
+	mock.ExpectQuery(`SELECT message_id, emoji, COUNT\(\*\) AS count, BOOL_OR\(user_id = \$1\) AS reacted_by_me, MIN\(created_at\) AS first_at FROM "message_reactions" WHERE message_id IN \(\$2,\$3\)`).
+		WithArgs(int64(1002), int64(80), int64(60)).
+		WillReturnRows(emptyReactionRows().
+			AddRow(80, "👍", 2, true, "2026-07-21T03:00:00Z").
+			AddRow(60, "🎉", 1, false, "2026-07-21T03:01:00Z"))
+
+	resp, err := handler.handleQuerySyncMessagesWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.QuerySyncMessages{ToUserId: 1002, UseInboxSeq: true, AfterSeq: 10, AfterReactionSeq: 4},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync := resp.GetSyncMsgsRsp()
+	if resp.GetResult() != storage.StorageResult_OK || sync.GetNextAfterSeq() != 12 || sync.GetLatestSeq() != 13 || sync.GetHasMore() || len(sync.GetMsgs()) != 2 {
+		t.Fatalf("unexpected inbox sync response: %+v", resp)
+	}
+	if sync.GetMsgs()[0].GetInboxSeq() != 11 || sync.GetMsgs()[1].GetInboxSeq() != 12 || sync.GetMsgs()[1].GetContent() != "" {
+		t.Fatalf("unexpected inbox sync messages: %+v", sync.GetMsgs())
+	}
+	reactions := sync.GetMsgs()[0].GetReactions()
+	if len(reactions) != 1 || reactions[0].GetEmoji() != "👍" || reactions[0].GetCount() != 2 || !reactions[0].GetReactedByMe() || len(sync.GetMsgs()[1].GetReactions()) != 0 {
+		t.Fatalf("unexpected inbox sync reactions: %+v", sync.GetMsgs())
+	}
+	updates := sync.GetReactionUpdates()
+	if len(updates) != 1 || updates[0].GetMessageId() != 60 || updates[0].GetInboxSeq() != 0 || len(updates[0].GetReactions()) != 1 || updates[0].GetReactions()[0].GetEmoji() != "🎉" {
+		t.Fatalf("unexpected reaction updates: %+v", updates)
+	}
+	if sync.GetNextAfterReactionSeq() != 6 || sync.GetLatestReactionSeq() != 6 || sync.GetReactionUpdatesHasMore() {
+		t.Fatalf("unexpected reaction cursor: %+v", sync)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleQueryConversationsReturnsRequesterSummaries(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "conversation_summaries" WHERE user_id = \$1 ORDER BY last_message_at DESC, last_message_id DESC LIMIT \$2`).
+		WithArgs(int64(1002), 51).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"user_id", "conversation_id", "is_group", "last_message_id", "last_sender_id",
+			"last_message_type", "snippet", "last_message_at", "last_message_recalled", "unread_count",
+		}).
+			AddRow(1002, 7, true, 80, 1003, "text", "", "2026-07-21T03:00:00Z", true, 4).
+			AddRow(1002, 1001, false, 77, 1001, "text", "hello", "2026-07-21T02:00:00Z", false, 0))
+
+	resp, err := handler.handleQueryConversationsWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.QueryConversations{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversations := resp.GetConversationsRsp()
+	if resp.GetResult() != storage.StorageResult_OK || resp.GetTargetUserId() != 1002 || conversations.GetHasMore() || len(conversations.GetConversations()) != 2 {
+		t.Fatalf("unexpected conversations response: %+v", resp)
+	}
+	group := conversations.GetConversations()[0]
+	if group.GetConversationId() != 7 || !group.GetIsGroup() || !group.GetLastMessageRecalled() || group.GetUnreadCount() != 4 || group.GetLastSenderId() != 1003 {
+		t.Fatalf("unexpected group summary: %+v", group)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleQueryConversationHistoryMasksRecalledMessages(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE is_group = \$1 AND \(\(to_user_id = \$2 AND from_user_id = \$3\) OR \(to_user_id = \$4 AND from_user_id = \$5\)\) ORDER BY timestamp DESC, message_id DESC LIMIT \$6`).
+		WithArgs(false, int64(1002), int64(1001), int64(1001), int64(1002), 51).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "real_file_name", "is_group", "is_recalled"}).
+			AddRow(78, 1002, 1001, "secret", "2026-07-21T03:00:00Z", "file", "secret.pdf", false, true).
+			AddRow(77, 1001, 1002, "hello", "2026-07-21T02:00:00Z", "text", "", false, false))
+	expectMessageReactionCounts(mock, 1001, 77, emptyReactionRows())
+
+	resp, err := handler.handleQueryConversationHistoryWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1001},
+		&storage.QueryConversationHistory{PeerOrGroupId: 1002},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := resp.GetConversationHistoryRsp()
+	if resp.GetResult() != storage.StorageResult_OK || history.GetPeerOrGroupId() != 1002 || history.GetHasMore() || len(history.GetMsgs()) != 2 {
+		t.Fatalf("unexpected history response: %+v", resp)
+	}
+	recalled := history.GetMsgs()[0]
+	if !recalled.GetIsRecalled() || recalled.GetContent() != "" || recalled.GetRealFileName() != "" {
+		t.Fatalf("recalled message was not masked: %+v", recalled)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleForwardMessagesReplayReturnsExistingCopies(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id IN \(\$1\) ORDER BY timestamp ASC, message_id ASC`).
+		WithArgs(int64(61)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "real_file_name", "is_group"}).
+			AddRow(61, 1002, 1001, "sha512-report", "2026-07-23T08:00:00Z", "file", "report.pdf", false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "messages"`).
+		WithArgs("fwd-1#0", int64(1001), int64(1003), "sha512-report", sqlmock.AnyArg(), "file", "report.pdf", false, false, "", int64(0), "", int64(0), "report pdf").
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE from_user_id = \$1 AND client_message_id = \$2`).
+		WithArgs(int64(1001), "fwd-1#0", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "client_message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "real_file_name", "is_group"}).
+			AddRow(91, "fwd-1#0", 1001, 1003, "sha512-report", "2026-07-23T09:00:00Z", "file", "report.pdf", false))
+
+	resp, err := handler.handleForwardMessagesWithDB(handler.requestDatabase(), &storage.RequestMessage{TargetUserId: 1001},
+		&storage.ForwardMessages{SourceMessageIds: []int64{61}, TargetId: 1003, ClientMessageId: "fwd-1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarded := resp.GetForwardMessagesRsp()
+	if resp.GetResult() != storage.StorageResult_OK || len(forwarded.GetMessages()) != 1 {
+		t.Fatalf("unexpected forward response: %+v", resp)
+	}
+	copied := forwarded.GetMessages()[0]
+	if copied.GetMessageId() != 91 || copied.GetCreated() || copied.GetClientMessageId() != "fwd-1#0" || copied.GetContent() != "sha512-report" || copied.GetRealFileName() != "report.pdf" {
+		t.Fatalf("unexpected forwarded copy: %+v", copied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleForwardMessagesRejectsRecalledSource(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id IN \(\$1\) ORDER BY timestamp ASC, message_id ASC`).
+		WithArgs(int64(61)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "timestamp", "message_type", "is_group", "is_recalled"}).
+			AddRow(61, 1002, 1001, "2026-07-23T08:00:00Z", "text", false, true))
+
+	resp, err := handler.handleForwardMessagesWithDB(handler.requestDatabase(), &storage.RequestMessage{TargetUserId: 1001},
+		&storage.ForwardMessages{SourceMessageIds: []int64{61}, TargetId: 7, IsGroup: true, Merged: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult() != storage.StorageResult_ALREADY_RECALLED || len(resp.GetForwardMessagesRsp().GetMessages()) != 0 || resp.GetForwardMessagesRsp().GetToUserId() != 7 {
+		t.Fatalf("unexpected forward response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleQueryConversationHistoryAttachesQuotedPreview(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE is_group = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group", "is_recalled", "reply_to_message_id"}).
+			AddRow(79, 1002, 1001, "agreed", "2026-07-21T03:00:00Z", "text", false, false, 77))
+	expectMessageReactionCounts(mock, 1001, 79, emptyReactionRows())
+	mock.ExpectQuery(`FROM messages AS m\s+WHERE m\.message_id IN \(\$4\)`).
+		WithArgs(int64(1001), int64(1001), int64(1001), int64(77)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "content", "message_type", "real_file_name", "is_recalled", "readable"}).
+			AddRow(77, 1001, "secret", "text", "", true, true))
+
+	resp, err := handler.handleQueryConversationHistoryWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1001},
+		&storage.QueryConversationHistory{PeerOrGroupId: 1002},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := resp.GetConversationHistoryRsp().GetMsgs()[0]
+	if reply.GetReplyToMessageId() != 77 || reply.GetReplyTo().GetMessageId() != 77 || !reply.GetReplyTo().GetIsRecalled() || reply.GetReplyTo().GetSnippet() != "" {
+		t.Fatalf("quoted preview was not masked: %+v", reply)
+	}
+}
+
+func TestHandleQueryMentionedMessagesAttachesMentions(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`SELECT messages\.\* FROM "messages" JOIN message_mentions`).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group"}).
+			AddRow(88, 1002, 7, "@all standup", "2026-07-24T01:00:00Z", "text", true))
+	expectMessageReactionCounts(mock, 1001, 88, emptyReactionRows())
+	expectMessageMentions(mock, 88, sqlmock.NewRows([]string{"message_id", "user_id"}).AddRow(88, db.MentionAllUserID))
+
+	resp, err := handler.handleQueryMentionedMessagesWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1001},
+		&storage.QueryMentionedMessages{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := resp.GetMentionedMessagesRsp().GetMsgs()
+	if resp.GetResult() != storage.StorageResult_OK || len(msgs) != 1 || !msgs[0].GetMentionAll() || len(msgs[0].GetMentionedUserIds()) != 0 {
+		t.Fatalf("unexpected mentioned messages response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleQueryConversationHistoryRejectsNonMember(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectQuery(`FROM "group_members"`).
+		WithArgs(int64(7), int64(1001)).
+		WillReturnRows(sqlmock.NewRows([]string{"cutoff"}))
+
+	resp, err := handler.handleQueryConversationHistoryWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1001},
+		&storage.QueryConversationHistory{PeerOrGroupId: 7, IsGroup: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult() != storage.StorageResult_FORBIDDEN || resp.GetPayload() != nil {
+		t.Fatalf("non-member history response=%+v, want FORBIDDEN", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleUpdatePresenceSettingsUsesAuthenticatedUser(t *testing.T) {
+	mock := useMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "user_presence_settings" .* ON CONFLICT \("user_id"\) DO UPDATE`).
+		WithArgs(int64(1002), true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := handler.handleUpdatePresenceSettingsWithDB(handler.requestDatabase(),
+		&storage.RequestMessage{TargetUserId: 1002},
+		&storage.UpdatePresenceSettings{HideLastSeen: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := resp.GetPresenceSettingsRsp()
+	if resp.GetResult() != storage.StorageResult_OK || resp.GetTargetUserId() != 1002 || setting.GetUserId() != 1002 || !setting.GetHideLastSeen() || setting.GetUpdateTime() == "" {
+		t.Fatalf("unexpected presence settings response: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRecalledMessageResponsesDoNotExposeContent(t *testing.T) {
 	message := &db.Message{
 		MessageID: 78, FromUserID: 1001, ToUserID: 1002, Content: "secret",
@@ -311,6 +998,7 @@ func TestHandleQueryMessage(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{
 			"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group",
 		}).AddRow(12345, 1000, 1001, "Hello, World!", expectedTime, "text", false))
+	expectMessageReactionCounts(mock, 1001, 12345, emptyReactionRows())
 
 	// 调用处理函数
 	resp, err := handler.handleQueryMessageWithDB(handler.database, req, req.GetQueryMessage())
@@ -441,6 +1129,49 @@ func TestHandleUpdateUserNameDefersCacheInvalidationUntilCommit(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestUpdateUserNameEmitsProfileChangedEvent(t *testing.T) {
+	database, mock := setupMockDB(t)
+	handler := &StorageHandler{l1Cache: newMockCache()}
+	req := &storage.RequestMessage{
+		TargetUserId: 1000,
+		Payload: &storage.RequestMessage_UpdateUserName{
+			UpdateUserName: &storage.UpdateUserName{UserId: 1000, NewUserName: "NewUsername"},
+		},
+	}
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{
+			"id", "account", "name", "update_time", "avatar", "password_hash", "jwt_key",
+		}).AddRow(1000, "test-account", "NewUsername", "2026-07-18T00:00:00Z", "avatar-hash", "", []byte("key"))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE \"users\" SET .* WHERE id = \\$3").
+		WithArgs("NewUsername", sqlmock.AnyArg(), int64(1000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT \\* FROM \"users\" WHERE \"users\".\"id\" = \\$1").
+		WithArgs(int64(1000), 1).
+		WillReturnRows(userRows())
+	mock.ExpectQuery("SELECT \\* FROM \"users\" WHERE \"users\".\"id\" = \\$1").
+		WithArgs(int64(1000), 1).
+		WillReturnRows(userRows())
+
+	var events []*storage.ResponseMessage
+	resp, err := getStorageRequestRouter().Dispatch(storageRequestContext{
+		handler: handler, request: req, database: database, events: &events,
+	}, req.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StorageResult_OK, resp.GetResult())
+	if assert.Len(t, events, 1) {
+		changed := events[0].GetUserProfileChanged()
+		assert.Equal(t, int64(1000), events[0].GetTargetUserId())
+		assert.Equal(t, "NewUsername", changed.GetName())
+		assert.Equal(t, "avatar-hash", changed.GetAvatar())
+		assert.Equal(t, "2026-07-18T00:00:00Z", changed.GetUpdateTime())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestHandleUpdateUserNameFailureKeepsCache(t *testing.T) {
 	database, mock := setupMockDB(t)
 	cache := newMockCache()
@@ -535,6 +1266,12 @@ func TestHandleQuerySyncMessages_IncludesDirectAndGroupMessages(t *testing.T) {
 			AddRow(20001, 2002, 1001, "direct-msg", "2026-04-17T10:05:00Z", "text", "", false).
 			AddRow(20002, 1001, 9001, "own-group-msg", "2026-04-17T10:06:00Z", "text", "", true).
 			AddRow(20003, 3003, 9001, "other-group-msg", "2026-04-17T10:07:00Z", "text", "", true))
+	mock.ExpectQuery(`FROM "message_reactions" WHERE message_id IN \(\$2,\$3,\$4\)`).
+		WithArgs(int64(1001), int64(20001), int64(20002), int64(20003)).
+		WillReturnRows(emptyReactionRows())
+	mock.ExpectQuery(`SELECT \* FROM "message_mentions" WHERE message_id IN \(\$1,\$2\)`).
+		WithArgs(int64(20002), int64(20003)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id"}).AddRow(20003, 1001))
 
 	resp, err := handler.handleQuerySyncMessagesWithDB(handler.requestDatabase(), req, req.GetQuerySyncMessages())
 
@@ -556,6 +1293,7 @@ func TestHandleQuerySyncMessages_IncludesDirectAndGroupMessages(t *testing.T) {
 		assert.Equal(t, int64(3003), syncRsp.GetMsgs()[2].GetFromUserId())
 		assert.Equal(t, int64(9001), syncRsp.GetMsgs()[2].GetToUserId())
 		assert.True(t, syncRsp.GetMsgs()[2].GetIsGroup())
+		assert.Equal(t, []int64{1001}, syncRsp.GetMsgs()[2].GetMentionedUserIds())
 		assert.False(t, syncRsp.GetHasMore())
 		assert.Equal(t, "2026-04-17T10:07:00Z", syncRsp.GetNextCursorTimestamp())
 		assert.Equal(t, int64(20003), syncRsp.GetNextCursorMessageId())
@@ -600,6 +1338,7 @@ func TestHandleQuerySyncMessages_DefaultPageIsBounded(t *testing.T) {
 	mock.ExpectQuery(`(?s)SELECT \*.*ORDER BY timestamp ASC, message_id ASC\s+LIMIT \$9`).
 		WithArgs(int64(1001), "2026-04-17T10:00:00Z", "2026-04-17T10:00:00Z", int64(0), "2026-04-17T10:00:00Z", "2026-04-17T10:00:00Z", int64(0), int64(1001), 101).
 		WillReturnRows(rows)
+	mock.ExpectQuery(`FROM "message_reactions"`).WillReturnRows(emptyReactionRows())
 
 	resp, err := handler.handleQuerySyncMessagesWithDB(handler.requestDatabase(), req, req.GetQuerySyncMessages())
 
@@ -637,6 +1376,10 @@ func TestSyncCompositeCursorDoesNotRepeatEqualTimestamps(t *testing.T) {
 			AddRow(1, 2, 1001, "direct", timestamp, "text", "", false).
 			AddRow(2, 3, 9001, "group", timestamp, "text", "", true).
 			AddRow(3, 4, 1001, "next", timestamp, "text", "", false))
+	mock.ExpectQuery(`FROM "message_reactions"`).
+		WithArgs(int64(1001), int64(1), int64(2)).
+		WillReturnRows(emptyReactionRows())
+	expectMessageMentions(mock, 2, emptyMentionRows())
 	request := &storage.RequestMessage{TargetUserId: 1001, Payload: &storage.RequestMessage_QuerySyncMessages{QuerySyncMessages: &storage.QuerySyncMessages{ToUserId: 1001, CursorTimestamp: timestamp, PageSize: 2}}}
 	first, err := handler.handleQuerySyncMessagesWithDB(handler.requestDatabase(), request, request.GetQuerySyncMessages())
 	if err != nil {
@@ -649,6 +1392,7 @@ func TestSyncCompositeCursorDoesNotRepeatEqualTimestamps(t *testing.T) {
 	mock.ExpectQuery(queryPattern).
 		WithArgs(int64(1001), timestamp, timestamp, int64(2), timestamp, timestamp, int64(2), int64(1001), 3).
 		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, 4, 1001, "next", timestamp, "text", "", false))
+	expectMessageReactionCounts(mock, 1001, 3, emptyReactionRows())
 	request.GetQuerySyncMessages().CursorMessageId = 2
 	second, err := handler.handleQuerySyncMessagesWithDB(handler.requestDatabase(), request, request.GetQuerySyncMessages())
 	if err != nil {
@@ -46,6 +46,10 @@ func TestDirectMessageAuthorizationFromCache(t *testing.T) {
 		{name: "unrelated", requester: 1003, want: storage.StorageResult_RECORD_NOT_EXIST},
 	} {
 		t.Run(test.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			if test.want == storage.StorageResult_OK {
+				expectMessageReactionCounts(mock, test.requester, 41, emptyReactionRows())
+			}
 			l1 := newMockCache()
 			l1.Set("message:41", message, 0)
 			handler := &StorageHandler{l1Cache: l1}
@@ -64,6 +68,9 @@ func TestDirectMessageAuthorizationFromCache(t *testing.T) {
 }
 
 func TestGroupMessageSenderCanReadWithoutMembership(t *testing.T) {
+	mock := useMockDB(t)
+	expectMessageReactionCounts(mock, 1001, 42, emptyReactionRows())
+	expectMessageMentions(mock, 42, emptyMentionRows())
 	message := &db.Message{MessageID: 42, FromUserID: 1001, ToUserID: 9001, Timestamp: "2026-07-13T01:00:00Z", IsGroup: true}
 	l1 := newMockCache()
 	l1.Set("message:42", message, 0)
@@ -93,6 +100,10 @@ func TestGroupMessageAuthorizationUsesCurrentMembershipAndJoinedAt(t *testing.T)
 			mock.ExpectQuery(`SELECT count\(\*\) FROM "group_members" WHERE group_id = \$1 AND user_id = \$2 AND COALESCE\(NULLIF\(joined_at, ''\), update_time\) <= \$3`).
 				WithArgs(int64(9001), int64(1002), "2026-07-13T01:00:00Z").
 				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.memberCount))
+			if test.want == storage.StorageResult_OK {
+				expectMessageReactionCounts(mock, 1002, 43, emptyReactionRows())
+				expectMessageMentions(mock, 43, emptyMentionRows())
+			}
 
 			message := &db.Message{MessageID: 43, FromUserID: 1001, ToUserID: 9001, Timestamp: "2026-07-13T01:00:00Z", IsGroup: true}
 			l1 := newMockCache()
@@ -19,7 +19,40 @@ func registerStorageMessageModule(router *dispatch.OneofRouter[storageRequestCon
 	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QuerySyncMessages) (*storage.ResponseMessage, error) {
 		return ctx.handler.handleQuerySyncMessagesWithDB(ctx.database, ctx.request, payload.QuerySyncMessages)
 	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QueryConversations) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleQueryConversationsWithDB(ctx.database, ctx.request, payload.QueryConversations)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QueryConversationHistory) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleQueryConversationHistoryWithDB(ctx.database, ctx.request, payload.QueryConversationHistory)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QueryMentionedMessages) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleQueryMentionedMessagesWithDB(ctx.database, ctx.request, payload.QueryMentionedMessages)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_SearchMessages) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleSearchMessagesWithDB(ctx.database, ctx.request, payload.SearchMessages)
+	})
 	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_RecallMessage) (*storage.ResponseMessage, error) {
 		return ctx.handler.handleRecallMessageWithDB(ctx.database, ctx.request, payload.RecallMessage, ctx.cacheKeys)
 	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_EditMessage) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleEditMessageWithDB(ctx.database, ctx.request, payload.EditMessage, ctx.cacheKeys)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_ReactToMessage) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleReactToMessageWithDB(ctx.database, ctx.request, payload.ReactToMessage)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_PinMessage) (*storage.ResponseMessage, error) {
+		return ctx.handler.handlePinMessageWithDB(ctx.database, ctx.request, payload.PinMessage.GetMessageId(), false)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_UnpinMessage) (*storage.ResponseMessage, error) {
+		return ctx.handler.handlePinMessageWithDB(ctx.database, ctx.request, payload.UnpinMessage.GetMessageId(), true)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QueryPinnedMessages) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleQueryPinnedMessagesWithDB(ctx.database, ctx.request, payload.QueryPinnedMessages)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_ForwardMessages) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleForwardMessagesWithDB(ctx.database, ctx.request, payload.ForwardMessages, ctx.cacheKeys)
+	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_MarkMessageReceipts) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleMarkMessageReceiptsWithDB(ctx.database, ctx.request, payload.MarkMessageReceipts)
+	})
 }
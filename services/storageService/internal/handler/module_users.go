@@ -11,12 +11,27 @@ func init() {
 
 func registerStorageUserModule(router *dispatch.OneofRouter[storageRequestContext, *storage.ResponseMessage]) {
 	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_UpdateUserName) (*storage.ResponseMessage, error) {
-		return ctx.handler.handleUpdateUserNameWithDB(ctx.database, ctx.request, payload.UpdateUserName, ctx.cacheKeys)
+		resp, err := ctx.handler.handleUpdateUserNameWithDB(ctx.database, ctx.request, payload.UpdateUserName, ctx.cacheKeys)
+		return withUserProfileChanged(ctx, payload.UpdateUserName.GetUserId(), resp, err)
 	})
 	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_UpdateUserAvatar) (*storage.ResponseMessage, error) {
-		return ctx.handler.handleUpdateUserAvatarWithDB(ctx.database, ctx.request, payload.UpdateUserAvatar, ctx.cacheKeys)
+		resp, err := ctx.handler.handleUpdateUserAvatarWithDB(ctx.database, ctx.request, payload.UpdateUserAvatar, ctx.cacheKeys)
+		return withUserProfileChanged(ctx, payload.UpdateUserAvatar.GetUserId(), resp, err)
 	})
 	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_QueryUser) (*storage.ResponseMessage, error) {
 		return ctx.handler.handleQueryUserWithDB(ctx.database, ctx.request, payload.QueryUser)
 	})
+	dispatch.Register(router, func(ctx storageRequestContext, payload *storage.RequestMessage_UpdatePresenceSettings) (*storage.ResponseMessage, error) {
+		return ctx.handler.handleUpdatePresenceSettingsWithDB(ctx.database, ctx.request, payload.UpdatePresenceSettings)
+	})
+}
+
+func withUserProfileChanged(ctx storageRequestContext, userID int64, resp *storage.ResponseMessage, err error) (*storage.ResponseMessage, error) {
+	if err != nil || resp.GetResult() != storage.StorageResult_OK {
+		return resp, err
+	}
+	if err := appendUserProfileChanged(ctx.database, userID, ctx.events); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
@@ -18,7 +18,7 @@ import (
 	gologger "gorm.io/gorm/logger"
 )
 
-const CurrentSchemaVersion = 4
+const CurrentSchemaVersion = 19
 
 type PoolConfig struct {
 	MaxOpenConns    int
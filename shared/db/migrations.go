@@ -14,6 +14,11 @@ type Migration struct {
 	Version int
 	Name    string
 	Apply   func(*gorm.DB) error
+	// NoTransaction runs Apply directly on the migration session so every
+	// statement commits on its own. Use it for CREATE INDEX CONCURRENTLY and
+	// batched backfills on large tables; Apply must be safe to rerun after a
+	// partial failure because the version is recorded only at the end.
+	NoTransaction bool
 }
 
 var nonPostgresMigrationLock sync.Mutex
@@ -24,7 +29,7 @@ var nonPostgresMigrationLock sync.Mutex
 const legacySnapshotMigrationVersion = 3
 
 func migrationPlan() []Migration {
-	// Versions 1-5 are published history. Do not add newly introduced models to
+	// Versions 1-19 are published history. Do not add newly introduced models to
 	// these functions; the next schema change must be an explicit new version.
 	return []Migration{
 		{Version: 1, Name: "core schema", Apply: migrateCoreSchema},
@@ -32,6 +37,20 @@ func migrationPlan() []Migration {
 		{Version: 3, Name: "legacy compatibility and query indexes", Apply: migrateLegacyCompatibility},
 		{Version: 4, Name: "transactional inbox outbox and durable push", Apply: migrateReliabilitySchema},
 		{Version: 5, Name: "message recall state", Apply: migrateMessageRecallSchema},
+		{Version: 6, Name: "message receipts", Apply: migrateMessageReceiptSchema},
+		{Version: 7, Name: "presence privacy settings", Apply: migratePresenceSettingsSchema},
+		{Version: 8, Name: "conversation summaries", Apply: migrateConversationSummarySchema},
+		{Version: 9, Name: "conversation history index", Apply: migrateConversationHistorySchema},
+		{Version: 10, Name: "per-user inbox sequences", Apply: migrateInboxSequenceSchema},
+		{Version: 11, Name: "message edit history", Apply: migrateMessageEditSchema},
+		{Version: 12, Name: "message reactions", Apply: migrateMessageReactionSchema},
+		{Version: 13, Name: "message replies", Apply: migrateMessageReplySchema},
+		{Version: 14, Name: "merged message forwards", Apply: migrateMergedForwardSchema},
+		{Version: 15, Name: "message mentions", Apply: migrateMessageMentionSchema},
+		{Version: 16, Name: "message pins", Apply: migrateMessagePinSchema},
+		{Version: 17, Name: "message search index", Apply: migrateMessageSearchSchema, NoTransaction: true},
+		{Version: 18, Name: "reaction update cursor", Apply: migrateReactionUpdateSchema},
+		{Version: 19, Name: "revoked jwt sessions", Apply: migrateRevokedJwtSessionSchema},
 	}
 }
 
@@ -74,22 +93,30 @@ func runMigrationPlan(database *gorm.DB, plan []Migration) error {
 		return err
 	}
 	for _, migration := range pending {
-		migrationRunner := migrationSession(database)
-		if err := migrationRunner.Transaction(func(tx *gorm.DB) error {
-			cleanTx := migrationSession(tx)
-			if err := migration.Apply(cleanTx); err != nil {
-				return fmt.Errorf("apply migration %d (%s): %w", migration.Version, migration.Name, err)
-			}
-			return cleanTx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchemaMigration{
-				Version: migration.Version, AppliedAt: time.Now().UTC().Format(time.RFC3339Nano),
-			}).Error
-		}); err != nil {
+		if err := applyMigration(database, migration); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func applyMigration(database *gorm.DB, migration Migration) error {
+	run := func(session *gorm.DB) error {
+		if err := migration.Apply(session); err != nil {
+			return fmt.Errorf("apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		return session.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchemaMigration{
+			Version: migration.Version, AppliedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}).Error
+	}
+	if migration.NoTransaction {
+		return run(migrationSession(database))
+	}
+	return migrationSession(database).Transaction(func(tx *gorm.DB) error {
+		return run(migrationSession(tx))
+	})
+}
+
 func normalizeLegacyMigrationLedger(database *gorm.DB, applied []int) ([]int, error) {
 	missing, normalized := legacyMigrationBackfill(applied)
 	if len(missing) == 0 {
@@ -242,6 +269,136 @@ func migrateMessageRecallSchema(tx *gorm.DB) error {
 	return migrateModelsAdditive(tx, &Message{})
 }
 
+func migrateMessageReceiptSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &MessageReceipt{})
+}
+
+func migratePresenceSettingsSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &UserPresenceSetting{})
+}
+
+func migrateConversationSummarySchema(tx *gorm.DB) error {
+	if err := migrateModelsAdditive(tx, &ConversationSummary{}); err != nil {
+		return err
+	}
+	return backfillConversationSummaries(tx)
+}
+
+// backfillConversationSummaries builds one summary row per direct pair and per
+// group member from existing messages, mirroring RecordConversationMessageWithDB:
+// the sender always gets a row, group members only for messages sent after they
+// joined. Unread counts exclude recalled messages and messages with a read
+// receipt. Existing rows are kept, so rerunning the statement is harmless.
+func backfillConversationSummaries(tx *gorm.DB) error {
+	return tx.Exec(`
+WITH visible AS (
+  SELECT m.from_user_id AS user_id, m.to_user_id AS conversation_id, m.is_group, m.message_id, 0 AS unread
+  FROM messages AS m
+  UNION ALL
+  SELECT m.to_user_id, m.from_user_id, m.is_group, m.message_id, `+conversationBackfillUnread("m.to_user_id")+`
+  FROM messages AS m
+  WHERE m.is_group = ? AND m.to_user_id <> m.from_user_id
+  UNION ALL
+  SELECT gm.user_id, m.to_user_id, m.is_group, m.message_id, `+conversationBackfillUnread("gm.user_id")+`
+  FROM messages AS m
+  JOIN group_members AS gm ON gm.group_id = m.to_user_id AND gm.user_id <> m.from_user_id
+    AND COALESCE(NULLIF(gm.joined_at, ''), gm.update_time) <= m.timestamp
+  WHERE m.is_group = ?
+), ranked AS (
+  SELECT user_id, conversation_id, is_group, message_id,
+    ROW_NUMBER() OVER (PARTITION BY user_id, conversation_id, is_group ORDER BY message_id DESC) AS position,
+    SUM(unread) OVER (PARTITION BY user_id, conversation_id, is_group) AS unread_count
+  FROM visible
+)
+INSERT INTO conversation_summaries (user_id, conversation_id, is_group, last_message_id, last_sender_id,
+  last_message_type, snippet, last_message_at, last_message_recalled, unread_count)
+SELECT r.user_id, r.conversation_id, r.is_group, m.message_id, m.from_user_id, m.message_type,
+  CASE WHEN m.is_recalled THEN ''
+    WHEN m.message_type IN ('text', 'link') THEN SUBSTR(m.content, 1, ?)
+    WHEN m.message_type = 'file' THEN SUBSTR(m.real_file_name, 1, ?)
+    ELSE '' END,
+  m.timestamp, m.is_recalled, r.unread_count
+FROM ranked AS r
+JOIN messages AS m ON m.message_id = r.message_id
+WHERE r.position = 1
+ON CONFLICT DO NOTHING`, false, true, ConversationSnippetRunes, ConversationSnippetRunes).Error
+}
+
+// conversationBackfillUnread counts a received message as unread unless it was
+// recalled or the recipient has a read receipt for it.
+func conversationBackfillUnread(recipientColumn string) string {
+	return `CASE WHEN m.is_recalled OR EXISTS (SELECT 1 FROM message_receipts AS mr WHERE mr.message_id = m.message_id AND mr.user_id = ` +
+		recipientColumn + ` AND mr.read_at <> '') THEN 0 ELSE 1 END`
+}
+
+func migrateConversationHistorySchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &Message{})
+}
+
+func migrateInboxSequenceSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &UserInboxSequence{}, &InboxEntry{})
+}
+
+func migrateMessageEditSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &Message{}, &MessageEdit{})
+}
+
+func migrateMessageReactionSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &MessageReaction{})
+}
+
+func migrateMessageReplySchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &Message{})
+}
+
+func migrateMergedForwardSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &MergedForwardItem{})
+}
+
+func migrateMessageMentionSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &MessageMention{})
+}
+
+func migrateMessagePinSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &MessagePin{})
+}
+
+// migrateMessageSearchSchema runs outside a transaction: the backfill commits
+// one batch at a time and the GIN index is built CONCURRENTLY, so writes to
+// messages are never blocked for the whole run. Queries must use the exact
+// to_tsvector('simple', search_tokens) expression to hit the index.
+func migrateMessageSearchSchema(database *gorm.DB) error {
+	if err := migrateModelsAdditive(database, &Message{}); err != nil {
+		return err
+	}
+	if err := BackfillMessageSearchTokensWithDB(database); err != nil {
+		return err
+	}
+	if database.Dialector.Name() != "postgres" {
+		return nil
+	}
+	// An interrupted concurrent build leaves an INVALID index that IF NOT EXISTS would keep.
+	var invalid int64
+	if err := database.Raw(`SELECT count(*) FROM pg_index JOIN pg_class ON pg_class.oid = pg_index.indexrelid
+WHERE pg_class.relname = 'idx_messages_search_tokens' AND NOT pg_index.indisvalid`).Scan(&invalid).Error; err != nil {
+		return err
+	}
+	if invalid > 0 {
+		if err := database.Exec(`DROP INDEX CONCURRENTLY IF EXISTS idx_messages_search_tokens`).Error; err != nil {
+			return err
+		}
+	}
+	return database.Exec(`CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_messages_search_tokens ON messages USING GIN (to_tsvector('simple', search_tokens))`).Error
+}
+
+func migrateReactionUpdateSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &UserReactionSequence{}, &ReactionUpdate{})
+}
+
+func migrateRevokedJwtSessionSchema(tx *gorm.DB) error {
+	return migrateModelsAdditive(tx, &RevokedJwtSession{})
+}
+
 type additiveSchemaMigrator interface {
 	HasTable(dst interface{}) bool
 	CreateTable(dst ...interface{}) error
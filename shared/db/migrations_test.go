@@ -95,15 +95,70 @@ func TestPendingMigrationsSupportsFirstRepeatAndLegacyUpgrade(t *testing.T) {
 
 func TestMigrationPlanIncludesMessageRecallV5(t *testing.T) {
 	plan := migrationPlan()
-	if len(plan) != 5 || plan[4].Version != 5 || plan[4].Name != "message recall state" || plan[4].Apply == nil {
+	if len(plan) < 5 || plan[4].Version != 5 || plan[4].Name != "message recall state" || plan[4].Apply == nil {
 		t.Fatalf("unexpected migration plan tail: %+v", plan)
 	}
 	pending, err := pendingMigrations(plan, []int{1, 2, 3, 4})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(pending) != 1 || pending[0].Version != 5 {
-		t.Fatalf("schema v4 upgrade pending=%+v, want only v5", pending)
+	if len(pending) == 0 || pending[0].Version != 5 {
+		t.Fatalf("schema v4 upgrade pending=%+v, want v5 first", pending)
+	}
+}
+
+func TestMigrationPlanIncludesRevokedJwtSessionsV19(t *testing.T) {
+	plan := migrationPlan()
+	if len(plan) != 19 || plan[17].Name != "reaction update cursor" || plan[18].Version != 19 || plan[18].Name != "revoked jwt sessions" || plan[18].Apply == nil || plan[18].NoTransaction {
+		t.Fatalf("unexpected migration plan tail: %+v", plan)
+	}
+	if CurrentSchemaVersion != plan[len(plan)-1].Version {
+		t.Fatalf("services require schema v%d, latest migration is v%d", CurrentSchemaVersion, plan[len(plan)-1].Version)
+	}
+	pending, err := pendingMigrations(plan, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 19 {
+		t.Fatalf("schema v18 upgrade pending=%+v, want only v19", pending)
+	}
+}
+
+func TestConversationSummaryBackfillBuildsRowsFromMessages(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectExec(`WITH visible AS \(.*FROM messages AS m UNION ALL `+
+		`SELECT m\.to_user_id, m\.from_user_id, .*mr\.user_id = m\.to_user_id AND mr\.read_at <> ''.* WHERE m\.is_group = \$1 AND m\.to_user_id <> m\.from_user_id UNION ALL `+
+		`SELECT gm\.user_id, m\.to_user_id, .*mr\.user_id = gm\.user_id .*COALESCE\(NULLIF\(gm\.joined_at, ''\), gm\.update_time\) <= m\.timestamp WHERE m\.is_group = \$2 \), ranked AS .*`+
+		`ROW_NUMBER\(\) OVER \(PARTITION BY user_id, conversation_id, is_group ORDER BY message_id DESC\).*`+
+		`INSERT INTO conversation_summaries .*SUBSTR\(m\.content, 1, \$3\).*SUBSTR\(m\.real_file_name, 1, \$4\).*WHERE r\.position = 1 ON CONFLICT DO NOTHING`).
+		WithArgs(false, true, ConversationSnippetRunes, ConversationSnippetRunes).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+
+	if err := backfillConversationSummaries(database); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoTransactionMigrationRecordsVersionWithoutTransaction(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectExec(`CREATE INDEX CONCURRENTLY idx_test`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "schema_migrations" .* ON CONFLICT DO NOTHING`).
+		WithArgs(sqlmock.AnyArg(), 18).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(18))
+	mock.ExpectCommit()
+
+	err := applyMigration(database, Migration{Version: 18, Name: "concurrent index", NoTransaction: true, Apply: func(tx *gorm.DB) error {
+		return tx.Exec(`CREATE INDEX CONCURRENTLY idx_test`).Error
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
 	}
 }
 
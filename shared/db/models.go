@@ -64,6 +64,13 @@ type User struct {
 	JwtKey       []byte `gorm:"comment:jwt的key"`
 }
 
+// RevokedJwtSession 记录被远程结束的JWT会话，过期前 Auth 拒绝该会话签发的所有令牌。
+type RevokedJwtSession struct {
+	UserID    int64  `gorm:"primaryKey;autoIncrement:false;comment:用户ID"`
+	SessionID string `gorm:"primaryKey;type:varchar(64);comment:JWT会话ID"`
+	ExpiresAt string `gorm:"type:varchar(35);index;comment:吊销记录过期时间，晚于该会话最后一个令牌的过期时间"`
+}
+
 type Friend struct {
 	UserID     int64  `gorm:"primaryKey;index:idx_friends_user_active_update,priority:1;comment:当前用户ID"`
 	FriendID   int64  `gorm:"primaryKey;comment:对方用户ID"`
@@ -106,18 +113,123 @@ type RelationshipRequest struct {
 }
 
 type Message struct {
-	MessageID       int64   `gorm:"primaryKey;autoIncrement:true;index:idx_messages_sync_target_time_id,priority:4;comment:消息唯一ID"`
-	ClientMessageID *string `gorm:"type:varchar(128);uniqueIndex:uidx_messages_sender_client_id,priority:2;comment:客户端幂等消息ID，旧消息为空"`
-	FromUserID      int64   `gorm:"type:int8;uniqueIndex:uidx_messages_sender_client_id,priority:1;comment:消息来源用户ID"`
-	ToUserID        int64   `gorm:"type:int8;index:idx_messages_sync_target_time_id,priority:2;comment:消息去向用户ID"`
-	Content         string  `gorm:"type:varchar(700);comment:消息内容"`
-	Timestamp       string  `gorm:"type:varchar(25);index:idx_messages_sync_target_time_id,priority:3;comment:消息产生时间"`
-	MessageType     string  `gorm:"type:varchar(10);comment:消息类型"`
-	RealFileName    string  `gorm:"type:varchar(255);comment:文件消息的原始文件名，非文件消息为空"`
-	IsGroup         bool    `gorm:"type:bool;index:idx_messages_sync_target_time_id,priority:1;comment:消息是否来自于群聊"`
-	IsRecalled      bool    `gorm:"type:bool;default:false;comment:消息是否已撤回"`
-	RecalledAt      string  `gorm:"type:varchar(35);comment:消息撤回时间RFC3339"`
-	RecalledBy      int64   `gorm:"comment:执行撤回的用户ID"`
+	MessageID        int64   `gorm:"primaryKey;autoIncrement:true;index:idx_messages_sync_target_time_id,priority:4;index:idx_messages_conversation_history,priority:5;comment:消息唯一ID"`
+	ClientMessageID  *string `gorm:"type:varchar(128);uniqueIndex:uidx_messages_sender_client_id,priority:2;comment:客户端幂等消息ID，旧消息为空"`
+	FromUserID       int64   `gorm:"type:int8;uniqueIndex:uidx_messages_sender_client_id,priority:1;index:idx_messages_conversation_history,priority:3;comment:消息来源用户ID"`
+	ToUserID         int64   `gorm:"type:int8;index:idx_messages_sync_target_time_id,priority:2;index:idx_messages_conversation_history,priority:2;comment:消息去向用户ID"`
+	Content          string  `gorm:"type:varchar(700);comment:消息内容"`
+	Timestamp        string  `gorm:"type:varchar(25);index:idx_messages_sync_target_time_id,priority:3;index:idx_messages_conversation_history,priority:4;comment:消息产生时间"`
+	MessageType      string  `gorm:"type:varchar(10);comment:消息类型"`
+	RealFileName     string  `gorm:"type:varchar(255);comment:文件消息的原始文件名，非文件消息为空"`
+	IsGroup          bool    `gorm:"type:bool;index:idx_messages_sync_target_time_id,priority:1;index:idx_messages_conversation_history,priority:1;comment:消息是否来自于群聊"`
+	IsRecalled       bool    `gorm:"type:bool;default:false;comment:消息是否已撤回"`
+	RecalledAt       string  `gorm:"type:varchar(35);comment:消息撤回时间RFC3339"`
+	RecalledBy       int64   `gorm:"comment:执行撤回的用户ID"`
+	EditedAt         string  `gorm:"type:varchar(35);comment:最后一次编辑时间RFC3339，未编辑为空"`
+	ReplyToMessageID int64   `gorm:"default:0;comment:引用回复的消息ID，非回复消息为0"`
+	SearchTokens     string  `gorm:"type:text;comment:全文检索分词，空格分隔，由 MessageSearchTokens 生成"`
+}
+
+// MessageEdit 保存消息每次编辑前的内容，按编辑顺序追加，不随消息撤回删除。
+type MessageEdit struct {
+	ID              int64  `gorm:"primaryKey;autoIncrement:true;comment:编辑记录ID"`
+	MessageID       int64  `gorm:"index:idx_message_edits_message;comment:被编辑的消息ID"`
+	EditorUserID    int64  `gorm:"comment:执行编辑的用户ID"`
+	PreviousContent string `gorm:"type:varchar(700);comment:编辑前的消息内容"`
+	EditedAt        string `gorm:"type:varchar(35);comment:编辑时间RFC3339"`
+}
+
+// MessageReaction 记录用户对消息的表情回应，同一用户可以对一条消息使用多个不同表情。
+type MessageReaction struct {
+	MessageID int64  `gorm:"primaryKey;autoIncrement:false;comment:消息ID"`
+	UserID    int64  `gorm:"primaryKey;autoIncrement:false;comment:回应用户ID"`
+	Emoji     string `gorm:"primaryKey;type:varchar(64);comment:回应表情"`
+	CreatedAt string `gorm:"type:varchar(35);comment:回应时间RFC3339"`
+}
+
+// UserReactionSequence 保存每个用户已分配的最大回应更新序号，与收件序号相互独立。
+type UserReactionSequence struct {
+	UserID  int64 `gorm:"primaryKey;autoIncrement:false;comment:用户ID"`
+	LastSeq int64 `gorm:"default:0;comment:已分配的最大回应更新序号"`
+}
+
+// ReactionUpdate 记录用户可读消息的最近一次回应变化，每个用户每条消息只有一行，变化时原地更新序号。
+type ReactionUpdate struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false;index:idx_reaction_updates_user_seq,priority:1;comment:可读用户ID"`
+	MessageID int64 `gorm:"primaryKey;autoIncrement:false;comment:消息ID"`
+	Seq       int64 `gorm:"index:idx_reaction_updates_user_seq,priority:2;comment:回应更新序号"`
+}
+
+// MergedForwardItem 是合并转发消息中一条源消息的快照，源消息之后撤回或编辑不影响已转发的内容。
+type MergedForwardItem struct {
+	MessageID       int64  `gorm:"primaryKey;autoIncrement:false;comment:合并转发消息ID"`
+	Position        int    `gorm:"primaryKey;autoIncrement:false;comment:源消息在合并转发中的顺序，从0开始"`
+	SourceMessageID int64  `gorm:"comment:源消息ID"`
+	FromUserID      int64  `gorm:"comment:源消息发送者ID"`
+	Content         string `gorm:"type:varchar(700);comment:源消息内容，文件消息为file_hash"`
+	Timestamp       string `gorm:"type:varchar(25);comment:源消息产生时间"`
+	MessageType     string `gorm:"type:varchar(10);comment:源消息类型"`
+	RealFileName    string `gorm:"type:varchar(255);comment:文件消息的原始文件名，非文件消息为空"`
+}
+
+// MessageMention 记录群消息中被@的成员，UserID 为 MentionAllUserID 表示@所有人。
+type MessageMention struct {
+	MessageID int64 `gorm:"primaryKey;autoIncrement:false;index:idx_message_mentions_user,priority:2;comment:消息ID"`
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false;index:idx_message_mentions_user,priority:1;comment:被@的用户ID，0表示@所有人"`
+}
+
+// MessagePin 记录会话中的置顶消息，取消置顶时删除。单聊按两个参与者的用户ID从小到大定位会话，
+// 双方看到同一组置顶。
+type MessagePin struct {
+	MessageID      int64  `gorm:"primaryKey;autoIncrement:false;comment:置顶的消息ID"`
+	IsGroup        bool   `gorm:"index:idx_message_pins_conversation,priority:1;comment:是否为群聊会话"`
+	ConversationID int64  `gorm:"index:idx_message_pins_conversation,priority:2;comment:群聊为群ID，单聊为较小的参与者用户ID"`
+	PeerID         int64  `gorm:"index:idx_message_pins_conversation,priority:3;comment:单聊为较大的参与者用户ID，群聊为0"`
+	PinnedBy       int64  `gorm:"comment:执行置顶的用户ID"`
+	PinnedAt       string `gorm:"type:varchar(35);index:idx_message_pins_conversation,priority:4;comment:置顶时间RFC3339"`
+}
+
+// MessageReceipt 记录接收方对单条消息的送达与已读状态，已读隐含已送达。
+type MessageReceipt struct {
+	MessageID   int64  `gorm:"primaryKey;comment:消息ID"`
+	UserID      int64  `gorm:"primaryKey;index:idx_message_receipts_user;comment:回执用户ID"`
+	DeliveredAt string `gorm:"type:varchar(35);comment:送达时间RFC3339"`
+	ReadAt      string `gorm:"type:varchar(35);comment:已读时间RFC3339，未读为空"`
+}
+
+// UserPresenceSetting 保存用户的在线状态隐私设置，没有记录的用户使用默认值。
+type UserPresenceSetting struct {
+	UserID       int64  `gorm:"primaryKey;autoIncrement:false;comment:用户ID"`
+	HideLastSeen bool   `gorm:"comment:是否对好友隐藏最后在线时间"`
+	UpdateTime   string `gorm:"type:varchar(35);comment:上次修改时间RFC3339"`
+}
+
+// ConversationSummary 是用户视角的会话摘要，随消息写入、撤回和已读回执在同一事务内维护。
+// 单聊的 ConversationID 为对方用户ID，群聊为群ID。
+type ConversationSummary struct {
+	UserID              int64  `gorm:"primaryKey;autoIncrement:false;index:idx_conversation_summaries_activity,priority:1;comment:会话所属用户ID"`
+	ConversationID      int64  `gorm:"primaryKey;autoIncrement:false;comment:单聊为对方用户ID，群聊为群ID"`
+	IsGroup             bool   `gorm:"primaryKey;comment:是否为群聊会话"`
+	LastMessageID       int64  `gorm:"index:idx_conversation_summaries_activity,priority:3;comment:最后一条消息ID"`
+	LastSenderID        int64  `gorm:"comment:最后一条消息的发送者ID"`
+	LastMessageType     string `gorm:"type:varchar(10);comment:最后一条消息类型"`
+	Snippet             string `gorm:"type:varchar(100);comment:最后一条消息摘要，撤回后为空"`
+	LastMessageAt       string `gorm:"type:varchar(25);index:idx_conversation_summaries_activity,priority:2;comment:最后一条消息时间"`
+	LastMessageRecalled bool   `gorm:"default:false;comment:最后一条消息是否已撤回"`
+	UnreadCount         int64  `gorm:"default:0;comment:该用户在会话中的未读消息数"`
+}
+
+// UserInboxSequence 保存每个用户已分配的最大收件序号，分配时行锁保证序号严格递增。
+type UserInboxSequence struct {
+	UserID  int64 `gorm:"primaryKey;autoIncrement:false;comment:用户ID"`
+	LastSeq int64 `gorm:"default:0;comment:已分配的最大收件序号"`
+}
+
+// InboxEntry 按收件序号记录用户收到的消息，同一用户的序号从 1 开始连续分配。
+type InboxEntry struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false;index:idx_inbox_entries_user_message,priority:1;comment:收件用户ID"`
+	Seq       int64 `gorm:"primaryKey;autoIncrement:false;comment:收件序号"`
+	MessageID int64 `gorm:"index:idx_inbox_entries_user_message,priority:2;comment:消息ID"`
 }
 
 type FileMetadata struct {
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"errors"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultConversationPageSize = 50
+	MaxConversationPageSize     = 200
+
+	// ConversationSnippetRunes 是会话摘要保留的最大字符数
+	ConversationSnippetRunes = 60
+)
+
+type ConversationSummaryPage struct {
+	Conversations       []ConversationSummary
+	HasMore             bool
+	NextCursorTimestamp string
+	NextCursorMessageID int64
+}
+
+type conversationKey struct {
+	conversationID int64
+	isGroup        bool
+}
+
+// 消息可能乱序提交，摘要只在新消息更晚时覆盖，未读数始终累加。
+var conversationSummaryUpserts = clause.Set{
+	latestConversationColumn("last_message_id"),
+	latestConversationColumn("last_sender_id"),
+	latestConversationColumn("last_message_type"),
+	latestConversationColumn("snippet"),
+	latestConversationColumn("last_message_at"),
+	latestConversationColumn("last_message_recalled"),
+	{Column: clause.Column{Name: "unread_count"}, Value: gorm.Expr("conversation_summaries.unread_count + excluded.unread_count")},
+}
+
+func latestConversationColumn(column string) clause.Assignment {
+	return clause.Assignment{
+		Column: clause.Column{Name: column},
+		Value: gorm.Expr("CASE WHEN excluded.last_message_id > conversation_summaries.last_message_id THEN excluded." +
+			column + " ELSE conversation_summaries." + column + " END"),
+	}
+}
+
+// RecordConversationMessageWithDB 把新消息写入发送者与所有接收者的会话摘要，接收者的未读数加一。
+// 群聊按发送时的群成员写入。调用方只应对新创建的消息调用，幂等重放不得重复计数。
+func RecordConversationMessageWithDB(database *gorm.DB, message *Message) error {
+	if database == nil {
+		return errors.New("conversation summary database is nil")
+	}
+	if message == nil || message.MessageID <= 0 {
+		return nil
+	}
+
+	row := func(userID, conversationID int64, unread int64) ConversationSummary {
+		return ConversationSummary{
+			UserID:          userID,
+			ConversationID:  conversationID,
+			IsGroup:         message.IsGroup,
+			LastMessageID:   message.MessageID,
+			LastSenderID:    message.FromUserID,
+			LastMessageType: message.MessageType,
+			Snippet:         conversationSnippet(message),
+			LastMessageAt:   message.Timestamp,
+			UnreadCount:     unread,
+		}
+	}
+	rows := []ConversationSummary{row(message.FromUserID, message.ToUserID, 0)}
+	if !message.IsGroup {
+		if message.ToUserID != message.FromUserID {
+			rows = append(rows, row(message.ToUserID, message.FromUserID, 1))
+		}
+	} else {
+		memberIDs, err := GetActiveGroupMemberIDsWithDB(database, message.ToUserID)
+		if err != nil {
+			return err
+		}
+		for _, memberID := range memberIDs {
+			if memberID != message.FromUserID {
+				rows = append(rows, row(memberID, message.ToUserID, 1))
+			}
+		}
+	}
+	return database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}, {Name: "is_group"}},
+		DoUpdates: conversationSummaryUpserts,
+	}).Create(&rows).Error
+}
+
+// RecallConversationMessageWithDB 在消息撤回后清空仍以其为最后一条消息的摘要，
+// 并为撤回前尚未读过该消息的接收者扣减未读数。
+func RecallConversationMessageWithDB(database *gorm.DB, message *Message) error {
+	if database == nil {
+		return errors.New("conversation summary database is nil")
+	}
+	if message == nil || message.MessageID <= 0 {
+		return nil
+	}
+	err := conversationSummaryScope(database, message).
+		Where("last_message_id = ?", message.MessageID).
+		Updates(map[string]any{"snippet": "", "last_message_recalled": true}).Error
+	if err != nil {
+		return err
+	}
+
+	unread := conversationSummaryScope(database, message).
+		Where("user_id <> ? AND unread_count > ?", message.FromUserID, 0).
+		Where("NOT EXISTS (SELECT 1 FROM message_receipts WHERE message_receipts.message_id = ? AND message_receipts.user_id = conversation_summaries.user_id AND message_receipts.read_at <> '')", message.MessageID)
+	if message.IsGroup {
+		// 与回执的接收方规则一致，发送后才入群的成员没有为这条消息计数
+		unread = unread.Where("EXISTS (SELECT 1 FROM group_members WHERE group_members.group_id = ? AND group_members.user_id = conversation_summaries.user_id AND COALESCE(NULLIF(group_members.joined_at, ''), group_members.update_time) <= ?)", message.ToUserID, message.Timestamp)
+	}
+	return unread.Update("unread_count", gorm.Expr("unread_count - 1")).Error
+}
+
+// EditConversationMessageWithDB 在消息编辑后刷新仍以其为最后一条消息的摘要内容，未读数不变。
+func EditConversationMessageWithDB(database *gorm.DB, message *Message) error {
+	if database == nil {
+		return errors.New("conversation summary database is nil")
+	}
+	if message == nil || message.MessageID <= 0 {
+		return nil
+	}
+	return conversationSummaryScope(database, message).
+		Where("last_message_id = ?", message.MessageID).
+		Update("snippet", conversationSnippet(message)).Error
+}
+
+// MarkConversationMessagesReadWithDB 按会话扣减回执人本次新标记为已读的消息数，最低为零。
+func MarkConversationMessagesReadWithDB(database *gorm.DB, userID int64, messages []Message) error {
+	if database == nil {
+		return errors.New("conversation summary database is nil")
+	}
+	counts := make(map[conversationKey]int64)
+	for _, message := range messages {
+		key := conversationKey{conversationID: message.FromUserID}
+		if message.IsGroup {
+			key = conversationKey{conversationID: message.ToUserID, isGroup: true}
+		}
+		counts[key]++
+	}
+	keys := make([]conversationKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].conversationID != keys[j].conversationID {
+			return keys[i].conversationID < keys[j].conversationID
+		}
+		return !keys[i].isGroup && keys[j].isGroup
+	})
+	for _, key := range keys {
+		count := counts[key]
+		err := database.Model(&ConversationSummary{}).
+			Where("user_id = ? AND conversation_id = ? AND is_group = ?", userID, key.conversationID, key.isGroup).
+			Update("unread_count", gorm.Expr("CASE WHEN unread_count > ? THEN unread_count - ? ELSE 0 END", count, count)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetConversationSummariesPageWithDB 按最近活动时间倒序分页返回用户的会话摘要，
+// 游标为上一页最后一个会话的 (last_message_at, last_message_id)。
+func GetConversationSummariesPageWithDB(database *gorm.DB, userID int64, cursorTimestamp string, cursorMessageID int64, pageSize int) (*ConversationSummaryPage, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultConversationPageSize
+	}
+	if pageSize > MaxConversationPageSize {
+		pageSize = MaxConversationPageSize
+	}
+	query := database.Where("user_id = ?", userID)
+	if cursorTimestamp != "" {
+		query = query.Where("last_message_at < ? OR (last_message_at = ? AND last_message_id < ?)", cursorTimestamp, cursorTimestamp, cursorMessageID)
+	}
+	var conversations []ConversationSummary
+	err := query.
+		Order("last_message_at DESC, last_message_id DESC").
+		Limit(pageSize + 1).
+		Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ConversationSummaryPage{HasMore: len(conversations) > pageSize}
+	if page.HasMore {
+		conversations = conversations[:pageSize]
+	}
+	page.Conversations = conversations
+	if page.HasMore {
+		last := conversations[len(conversations)-1]
+		page.NextCursorTimestamp = last.LastMessageAt
+		page.NextCursorMessageID = last.LastMessageID
+	}
+	return page, nil
+}
+
+func conversationSummaryScope(database *gorm.DB, message *Message) *gorm.DB {
+	scope := database.Model(&ConversationSummary{})
+	if message.IsGroup {
+		return scope.Where("is_group = ? AND conversation_id = ?", true, message.ToUserID)
+	}
+	return scope.Where("is_group = ? AND ((user_id = ? AND conversation_id = ?) OR (user_id = ? AND conversation_id = ?))",
+		false, message.FromUserID, message.ToUserID, message.ToUserID, message.FromUserID)
+}
+
+// conversationSnippet 文本消息截取内容，文件消息使用原始文件名，其他类型由客户端按消息类型展示。
+func conversationSnippet(message *Message) string {
+	var snippet string
+	switch message.MessageType {
+	case "text", "link":
+		snippet = message.Content
+	case "file":
+		snippet = message.RealFileName
+	case MergedForwardMessageType:
+		snippet = "[聊天记录]"
+	}
+	runes := []rune(snippet)
+	if len(runes) > ConversationSnippetRunes {
+		runes = runes[:ConversationSnippetRunes]
+	}
+	return string(runes)
+}
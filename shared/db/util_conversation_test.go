@@ -0,0 +1,134 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var conversationSummaryColumns = []string{
+	"user_id", "conversation_id", "is_group", "last_message_id", "last_sender_id",
+	"last_message_type", "snippet", "last_message_at", "last_message_recalled", "unread_count",
+}
+
+func TestRecordConversationMessageUpsertsSenderAndRecipient(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	message := &Message{
+		MessageID: 77, FromUserID: 1001, ToUserID: 1002, Content: strings.Repeat("好", ConversationSnippetRunes+5),
+		Timestamp: "2026-07-21T03:00:00Z", MessageType: "text",
+	}
+	snippet := strings.Repeat("好", ConversationSnippetRunes)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "conversation_summaries" .* ON CONFLICT \("user_id","conversation_id","is_group"\) DO UPDATE SET "last_message_id"=CASE WHEN excluded.last_message_id > conversation_summaries.last_message_id THEN excluded.last_message_id ELSE conversation_summaries.last_message_id END,.*"unread_count"=conversation_summaries.unread_count \+ excluded.unread_count`).
+		WithArgs(
+			int64(1001), int64(1002), false, int64(77), int64(1001), "text", snippet, "2026-07-21T03:00:00Z", false, int64(0),
+			int64(1002), int64(1001), false, int64(77), int64(1001), "text", snippet, "2026-07-21T03:00:00Z", false, int64(1),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := RecordConversationMessageWithDB(database, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordConversationMessageCountsUnreadForOtherGroupMembers(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	message := &Message{
+		MessageID: 78, FromUserID: 1001, ToUserID: 7, RealFileName: "plan.pdf",
+		Timestamp: "2026-07-21T03:00:00Z", MessageType: "file", IsGroup: true,
+	}
+	mock.ExpectQuery(`SELECT "user_id" FROM "group_members" WHERE group_id = \$1 ORDER BY user_id ASC`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1001).AddRow(1002).AddRow(1003))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "conversation_summaries"`).
+		WithArgs(
+			int64(1001), int64(7), true, int64(78), int64(1001), "file", "plan.pdf", "2026-07-21T03:00:00Z", false, int64(0),
+			int64(1002), int64(7), true, int64(78), int64(1001), "file", "plan.pdf", "2026-07-21T03:00:00Z", false, int64(1),
+			int64(1003), int64(7), true, int64(78), int64(1001), "file", "plan.pdf", "2026-07-21T03:00:00Z", false, int64(1),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	if err := RecordConversationMessageWithDB(database, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecallConversationMessageClearsSnippetAndUnread(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	message := &Message{MessageID: 77, FromUserID: 1001, ToUserID: 1002, Timestamp: "2026-07-21T03:00:00Z", MessageType: "text"}
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "last_message_recalled"=\$1,"snippet"=\$2 WHERE \(is_group = \$3 AND \(\(user_id = \$4 AND conversation_id = \$5\) OR \(user_id = \$6 AND conversation_id = \$7\)\)\) AND last_message_id = \$8`).
+		WithArgs(true, "", false, int64(1001), int64(1002), int64(1002), int64(1001), int64(77)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "unread_count"=unread_count - 1 WHERE .* AND \(user_id <> \$6 AND unread_count > \$7\) AND \(NOT EXISTS \(SELECT 1 FROM message_receipts`).
+		WithArgs(false, int64(1001), int64(1002), int64(1002), int64(1001), int64(1001), 0, int64(77)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := RecallConversationMessageWithDB(database, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkConversationMessagesReadDecrementsPerConversation(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "unread_count"=CASE WHEN unread_count > \$1 THEN unread_count - \$2 ELSE 0 END WHERE user_id = \$3 AND conversation_id = \$4 AND is_group = \$5`).
+		WithArgs(int64(1), int64(1), int64(1002), int64(7), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "conversation_summaries" SET "unread_count"=CASE WHEN unread_count > \$1 THEN unread_count - \$2 ELSE 0 END WHERE user_id = \$3 AND conversation_id = \$4 AND is_group = \$5`).
+		WithArgs(int64(2), int64(2), int64(1002), int64(1001), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := MarkConversationMessagesReadWithDB(database, 1002, []Message{
+		{MessageID: 41, FromUserID: 1001, ToUserID: 1002},
+		{MessageID: 42, FromUserID: 1003, ToUserID: 7, IsGroup: true},
+		{MessageID: 43, FromUserID: 1001, ToUserID: 1002},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConversationSummariesPageUsesActivityCursor(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT \* FROM "conversation_summaries" WHERE user_id = \$1 AND \(last_message_at < \$2 OR \(last_message_at = \$3 AND last_message_id < \$4\)\) ORDER BY last_message_at DESC, last_message_id DESC LIMIT \$5`).
+		WithArgs(int64(1002), "2026-07-21T03:00:00Z", "2026-07-21T03:00:00Z", int64(77), 3).
+		WillReturnRows(sqlmock.NewRows(conversationSummaryColumns).
+			AddRow(1002, 1001, false, 76, 1001, "text", "a", "2026-07-21T03:00:00Z", false, 2).
+			AddRow(1002, 7, true, 70, 1003, "text", "b", "2026-07-21T02:00:00Z", false, 0).
+			AddRow(1002, 1004, false, 60, 1002, "text", "c", "2026-07-21T01:00:00Z", false, 0))
+
+	page, err := GetConversationSummariesPageWithDB(database, 1002, "2026-07-21T03:00:00Z", 77, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Conversations) != 2 || page.Conversations[0].UnreadCount != 2 ||
+		page.NextCursorTimestamp != "2026-07-21T02:00:00Z" || page.NextCursorMessageID != 70 {
+		t.Fatalf("unexpected conversation page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
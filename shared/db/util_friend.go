@@ -41,6 +41,22 @@ func RemoveDirectFriendPairWithDB(database *gorm.DB, userID, friendID int64) (bo
 	return affected > 0, now, nil
 }
 
+// IsActiveFriend 检查 userID 的好友列表中是否有未删除的 friendID。
+func IsActiveFriend(userID, friendID int64) (bool, error) {
+	return IsActiveFriendWithDB(DB(), userID, friendID)
+}
+
+func IsActiveFriendWithDB(database *gorm.DB, userID, friendID int64) (bool, error) {
+	var count int64
+	err := database.Model(&Friend{}).
+		Where("user_id = ? AND friend_id = ? AND is_delete = ?", userID, friendID, false).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func GetFriendList(userID int64) ([]FriendContact, error) {
 	return GetFriendListWithDB(DB(), userID)
 }
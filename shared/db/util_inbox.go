@@ -0,0 +1,149 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AssignInboxSequencesWithDB 为新消息的每个收件人分配下一个收件序号并写入收件箱。
+// 单聊的收件人是接收者，群聊是发送时的全部群成员（包括发送者，与按时间同步的范围一致）。
+// 序号计数行在事务提交前保持锁定，因此同一用户的序号按提交顺序严格递增且没有空洞。
+// 调用方只应对新创建的消息调用，幂等重放不得重复分配。
+func AssignInboxSequencesWithDB(database *gorm.DB, message *Message) ([]InboxEntry, error) {
+	if database == nil {
+		return nil, errors.New("inbox sequence database is nil")
+	}
+	if message == nil || message.MessageID <= 0 {
+		return nil, nil
+	}
+
+	recipientIDs := []int64{message.ToUserID}
+	if message.IsGroup {
+		memberIDs, err := GetActiveGroupMemberIDsWithDB(database, message.ToUserID)
+		if err != nil {
+			return nil, err
+		}
+		recipientIDs = memberIDs
+	}
+	return appendInboxEntries(database, message.MessageID, recipientIDs)
+}
+
+// GetMessageReaderIDsWithDB 返回当前可以读取该消息的用户：单聊为双方，群聊为入群时间不晚于消息的成员和仍在群内的发送者。
+func GetMessageReaderIDsWithDB(database *gorm.DB, message *Message) ([]int64, error) {
+	if !message.IsGroup {
+		if message.FromUserID == message.ToUserID {
+			return []int64{message.FromUserID}, nil
+		}
+		if message.FromUserID < message.ToUserID {
+			return []int64{message.FromUserID, message.ToUserID}, nil
+		}
+		return []int64{message.ToUserID, message.FromUserID}, nil
+	}
+	var userIDs []int64
+	err := database.Model(&GroupMember{}).
+		Where("group_id = ? AND (COALESCE(NULLIF(joined_at, ''), update_time) <= ? OR user_id = ?)", message.ToUserID, message.Timestamp, message.FromUserID).
+		Order("user_id ASC").
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// appendInboxEntries 为每个收件人分配下一个收件序号，recipientIDs 必须按 user_id 升序。
+func appendInboxEntries(database *gorm.DB, messageID int64, recipientIDs []int64) ([]InboxEntry, error) {
+	if len(recipientIDs) == 0 {
+		return nil, nil
+	}
+
+	// 群成员按 user_id 升序加锁，避免并发扇出互相死锁
+	sequences := make([]UserInboxSequence, 0, len(recipientIDs))
+	for _, userID := range recipientIDs {
+		sequences = append(sequences, UserInboxSequence{UserID: userID, LastSeq: 1})
+	}
+	err := database.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Set{{Column: clause.Column{Name: "last_seq"}, Value: gorm.Expr("user_inbox_sequences.last_seq + 1")}},
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "user_id"}, {Name: "last_seq"}}},
+	).Create(&sequences).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]InboxEntry, 0, len(sequences))
+	for _, sequence := range sequences {
+		entries = append(entries, InboxEntry{UserID: sequence.UserID, Seq: sequence.LastSeq, MessageID: messageID})
+	}
+	if err := database.Create(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetInboxSyncPageWithDB 按收件序号返回 afterSeq 之后的消息，序号连续，客户端可据此发现缺失。
+func GetInboxSyncPageWithDB(database *gorm.DB, userID, afterSeq int64, pageSize int) (*SyncMessagesPage, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultSyncPageSize
+	}
+	if pageSize > MaxSyncPageSize {
+		pageSize = MaxSyncPageSize
+	}
+	if afterSeq < 0 {
+		afterSeq = 0
+	}
+
+	var rows []struct {
+		Message
+		InboxSeq int64
+	}
+	err := database.Raw(`
+SELECT m.*, ie.seq AS inbox_seq
+FROM inbox_entries AS ie
+JOIN messages AS m ON m.message_id = ie.message_id
+WHERE ie.user_id = ? AND ie.seq > ?
+ORDER BY ie.seq ASC
+LIMIT ?
+`, userID, afterSeq, pageSize+1).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SyncMessagesPage{HasMore: len(rows) > pageSize, InboxSeqs: make(map[int64]int64, len(rows)), NextAfterSeq: afterSeq}
+	if page.HasMore {
+		rows = rows[:pageSize]
+	}
+	for _, row := range rows {
+		page.Messages = append(page.Messages, row.Message)
+		page.InboxSeqs[row.MessageID] = row.InboxSeq
+		page.NextAfterSeq = row.InboxSeq
+	}
+	return page, nil
+}
+
+// GetInboxSeqsWithDB 返回用户对指定消息的收件序号，schema v10 之前存储的消息没有序号。
+func GetInboxSeqsWithDB(database *gorm.DB, userID int64, messageIDs []int64) (map[int64]int64, error) {
+	seqs := make(map[int64]int64, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return seqs, nil
+	}
+	var entries []InboxEntry
+	err := database.Where("user_id = ? AND message_id IN ?", userID, messageIDs).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seqs[entry.MessageID] = entry.Seq
+	}
+	return seqs, nil
+}
+
+// GetLatestInboxSeqWithDB 返回用户已分配的最大收件序号，从未收到消息时为 0。
+func GetLatestInboxSeqWithDB(database *gorm.DB, userID int64) (int64, error) {
+	var sequences []UserInboxSequence
+	err := database.Where("user_id = ?", userID).Limit(1).Find(&sequences).Error
+	if err != nil || len(sequences) == 0 {
+		return 0, err
+	}
+	return sequences[0].LastSeq, nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAssignInboxSequencesFansOutToGroupMembers(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	message := &Message{MessageID: 78, FromUserID: 1001, ToUserID: 7, IsGroup: true}
+	mock.ExpectQuery(`SELECT "user_id" FROM "group_members" WHERE group_id = \$1 ORDER BY user_id ASC`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1001).AddRow(1002))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_inbox_sequences" \("user_id","last_seq"\) VALUES \(\$1,\$2\),\(\$3,\$4\) ON CONFLICT \("user_id"\) DO UPDATE SET "last_seq"=user_inbox_sequences.last_seq \+ 1 RETURNING "user_id","last_seq"`).
+		WithArgs(int64(1001), int64(1), int64(1002), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1001, 12).AddRow(1002, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "inbox_entries" \("user_id","seq","message_id"\) VALUES \(\$1,\$2,\$3\),\(\$4,\$5,\$6\)`).
+		WithArgs(int64(1001), int64(12), int64(78), int64(1002), int64(1), int64(78)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	entries, err := AssignInboxSequencesWithDB(database, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Seq != 12 || entries[1].UserID != 1002 || entries[1].Seq != 1 {
+		t.Fatalf("unexpected inbox entries: %+v", entries)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInboxSyncPageFollowsSequence(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT m\.\*, ie\.seq AS inbox_seq FROM inbox_entries AS ie JOIN messages AS m ON m\.message_id = ie\.message_id WHERE ie\.user_id = \$1 AND ie\.seq > \$2 ORDER BY ie\.seq ASC LIMIT \$3`).
+		WithArgs(int64(1002), int64(10), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "timestamp", "inbox_seq"}).
+			AddRow(80, 1001, 1002, "2026-07-21T03:00:00Z", 11).
+			AddRow(79, 1003, 1002, "2026-07-21T03:00:01Z", 12).
+			AddRow(81, 1001, 1002, "2026-07-21T02:59:59Z", 13))
+
+	page, err := GetInboxSyncPageWithDB(database, 1002, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Messages) != 2 || page.NextAfterSeq != 12 || page.InboxSeqs[79] != 12 || page.InboxSeqs[80] != 11 {
+		t.Fatalf("unexpected inbox page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInboxSyncPageWithoutNewEntriesKeepsCursor(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`FROM inbox_entries`).
+		WithArgs(int64(1002), int64(10), 101).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "inbox_seq"}))
+
+	page, err := GetInboxSyncPageWithDB(database, 1002, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasMore || len(page.Messages) != 0 || page.NextAfterSeq != 10 {
+		t.Fatalf("unexpected empty inbox page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
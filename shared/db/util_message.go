@@ -27,21 +27,23 @@ type MessageRecallOutcome struct {
 	Status  MessageRecallStatus
 }
 
-func StoreNewMessageWithDB(database *gorm.DB, fromUserID, toUserID int64, content, messageType, realFileName string, isGroup bool, clientMessageID string) (*Message, bool, error) {
+func StoreNewMessageWithDB(database *gorm.DB, fromUserID, toUserID int64, content, messageType, realFileName string, isGroup bool, clientMessageID string, replyToMessageID int64) (*Message, bool, error) {
 	clientMessageID = strings.TrimSpace(clientMessageID)
 	var clientMessageIDPtr *string
 	if clientMessageID != "" {
 		clientMessageIDPtr = &clientMessageID
 	}
 	message := &Message{
-		ClientMessageID: clientMessageIDPtr,
-		FromUserID:      fromUserID,
-		ToUserID:        toUserID,
-		Content:         content,
-		Timestamp:       utils.NowTime(),
-		MessageType:     messageType,
-		RealFileName:    realFileName,
-		IsGroup:         isGroup,
+		ClientMessageID:  clientMessageIDPtr,
+		FromUserID:       fromUserID,
+		ToUserID:         toUserID,
+		Content:          content,
+		Timestamp:        utils.NowTime(),
+		MessageType:      messageType,
+		RealFileName:     realFileName,
+		IsGroup:          isGroup,
+		ReplyToMessageID: replyToMessageID,
+		SearchTokens:     MessageSearchTokens(messageType, content, realFileName),
 	}
 
 	if clientMessageIDPtr == nil {
@@ -69,6 +71,23 @@ func StoreNewMessageWithDB(database *gorm.DB, fromUserID, toUserID int64, conten
 	return &existing, false, nil
 }
 
+// GetMessageByClientMessageIDWithDB 按发送者和客户端消息ID查找已入库的消息，不存在或未提供客户端消息ID时返回 nil
+func GetMessageByClientMessageIDWithDB(database *gorm.DB, fromUserID int64, clientMessageID string) (*Message, error) {
+	clientMessageID = strings.TrimSpace(clientMessageID)
+	if clientMessageID == "" {
+		return nil, nil
+	}
+	var message Message
+	err := database.Where("from_user_id = ? AND client_message_id = ?", fromUserID, clientMessageID).First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
 func GetMessageByIDWithDB(database *gorm.DB, messageID int64) (*Message, error) {
 	var message Message
 	err := database.First(&message, "message_id = ?", messageID).Error
@@ -128,6 +147,8 @@ func RecallMessageWithDB(database *gorm.DB, operatorUserID, messageID int64, now
 			"is_recalled": true,
 			"recalled_at": recalledAt,
 			"recalled_by": operatorUserID,
+			// 撤回后的内容不能再被搜索命中
+			"search_tokens": "",
 		})
 	if result.Error != nil {
 		return nil, result.Error
@@ -138,6 +159,7 @@ func RecallMessageWithDB(database *gorm.DB, operatorUserID, messageID int64, now
 	message.IsRecalled = true
 	message.RecalledAt = recalledAt
 	message.RecalledBy = operatorUserID
+	message.SearchTokens = ""
 	return &MessageRecallOutcome{Message: &message, Status: MessageRecallOK}, nil
 }
 
@@ -151,6 +173,9 @@ type SyncMessagesPage struct {
 	HasMore             bool
 	NextCursorTimestamp string
 	NextCursorMessageID int64
+	// InboxSeqs 按消息ID记录请求者的收件序号，只有按收件序号同步时填充
+	InboxSeqs    map[int64]int64
+	NextAfterSeq int64
 }
 
 // GetSyncMessagesPage 获取稳定分页的同步消息。
@@ -181,7 +206,9 @@ FROM (
     m.is_group,
     m.is_recalled,
     m.recalled_at,
-    m.recalled_by
+    m.recalled_by,
+    m.edited_at,
+    m.reply_to_message_id
   FROM messages AS m
   WHERE m.is_group = FALSE
     AND m.to_user_id = ?
@@ -200,7 +227,9 @@ FROM (
     m.is_group,
     m.is_recalled,
     m.recalled_at,
-    m.recalled_by
+    m.recalled_by,
+    m.edited_at,
+    m.reply_to_message_id
   FROM group_members AS gm
   JOIN messages AS m
     ON m.to_user_id = gm.group_id
@@ -234,6 +263,85 @@ func buildSyncMessagesPage(messages []Message, pageSize int) *SyncMessagesPage {
 	return page
 }
 
+const (
+	DefaultHistoryPageSize = 50
+	MaxHistoryPageSize     = 200
+)
+
+type ConversationHistoryPage struct {
+	Messages            []Message
+	HasMore             bool
+	NextBeforeMessageID int64
+}
+
+// GetConversationHistoryPageWithDB 在单个会话内按 (timestamp, message_id) 倒序向前翻页，
+// beforeMessageID 为上一页最早的一条消息，首页传 0。
+// 群聊与 CanUserReadMessageWithDB 一致，只返回入群之后的消息和请求者自己发送的消息；
+// 请求者不是群成员时返回 nil。
+func GetConversationHistoryPageWithDB(database *gorm.DB, userID, conversationID int64, isGroup bool, beforeMessageID int64, pageSize int) (*ConversationHistoryPage, error) {
+	if database == nil {
+		return nil, errors.New("conversation history database is nil")
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultHistoryPageSize
+	}
+	if pageSize > MaxHistoryPageSize {
+		pageSize = MaxHistoryPageSize
+	}
+
+	query := database.Model(&Message{})
+	switch {
+	case isGroup:
+		var cutoffs []string
+		err := database.Model(&GroupMember{}).
+			Where("group_id = ? AND user_id = ?", conversationID, userID).
+			Pluck("COALESCE(NULLIF(joined_at, ''), update_time)", &cutoffs).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(cutoffs) == 0 {
+			return nil, nil
+		}
+		query = query.Where("is_group = ? AND to_user_id = ?", true, conversationID).
+			Where("timestamp >= ? OR from_user_id = ?", cutoffs[0], userID)
+	case userID == conversationID:
+		query = query.Where("is_group = ? AND to_user_id = ? AND from_user_id = ?", false, userID, userID)
+	default:
+		// 两个方向各自命中 idx_messages_conversation_history
+		query = query.Where("is_group = ? AND ((to_user_id = ? AND from_user_id = ?) OR (to_user_id = ? AND from_user_id = ?))",
+			false, conversationID, userID, userID, conversationID)
+	}
+
+	if beforeMessageID > 0 {
+		var cursor Message
+		err := database.Select("message_id", "timestamp").Take(&cursor, "message_id = ?", beforeMessageID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &ConversationHistoryPage{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("timestamp < ? OR (timestamp = ? AND message_id < ?)", cursor.Timestamp, cursor.Timestamp, cursor.MessageID)
+	}
+
+	var messages []Message
+	err := query.
+		Order("timestamp DESC, message_id DESC").
+		Limit(pageSize + 1).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ConversationHistoryPage{HasMore: len(messages) > pageSize}
+	if page.HasMore {
+		messages = messages[:pageSize]
+		page.NextBeforeMessageID = messages[len(messages)-1].MessageID
+	}
+	page.Messages = messages
+	return page, nil
+}
+
 // CanUserReadMessage checks authorization against the current relationship
 // state. Callers must invoke it even when the message entity came from cache.
 func CanUserReadMessageWithDB(database *gorm.DB, requesterID int64, message *Message) (bool, error) {
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultMessageEditWindow 是未配置时允许编辑消息的时长
+	DefaultMessageEditWindow = 15 * time.Minute
+
+	// MaxMessageContentRunes 与 messages.content 的列宽一致
+	MaxMessageContentRunes = 700
+)
+
+type MessageEditStatus int
+
+const (
+	MessageEditOK MessageEditStatus = iota
+	MessageEditNotFound
+	MessageEditForbidden
+	MessageEditRecalled
+	MessageEditExpired
+	MessageEditNotEditable
+)
+
+type MessageEditOutcome struct {
+	Message *Message
+	Status  MessageEditStatus
+}
+
+// EditMessageWithDB 在编辑窗口内替换发送者自己的文本或链接消息，编辑前的内容追加到编辑历史。
+func EditMessageWithDB(database *gorm.DB, operatorUserID, messageID int64, newContent string, window time.Duration, now time.Time) (*MessageEditOutcome, error) {
+	if database == nil {
+		return nil, errors.New("edit message database is nil")
+	}
+	if operatorUserID <= 0 || messageID <= 0 {
+		return &MessageEditOutcome{Status: MessageEditNotFound}, nil
+	}
+	if window <= 0 {
+		window = DefaultMessageEditWindow
+	}
+
+	var message Message
+	err := database.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "message_id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &MessageEditOutcome{Status: MessageEditNotFound}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if message.FromUserID != operatorUserID {
+		canRead, authErr := CanUserReadMessageWithDB(database, operatorUserID, &message)
+		if authErr != nil {
+			return nil, authErr
+		}
+		status := MessageEditNotFound
+		if canRead {
+			status = MessageEditForbidden
+		}
+		return &MessageEditOutcome{Message: &message, Status: status}, nil
+	}
+	if message.IsRecalled {
+		return &MessageEditOutcome{Message: &message, Status: MessageEditRecalled}, nil
+	}
+	if (message.MessageType != "text" && message.MessageType != "link") || !validEditedContent(newContent) {
+		return &MessageEditOutcome{Message: &message, Status: MessageEditNotEditable}, nil
+	}
+
+	sentAt, err := time.Parse(time.RFC3339, message.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if now.UTC().Sub(sentAt.UTC()) > window {
+		return &MessageEditOutcome{Message: &message, Status: MessageEditExpired}, nil
+	}
+	editedAt := now.UTC().Format(time.RFC3339)
+	history := MessageEdit{
+		MessageID:       message.MessageID,
+		EditorUserID:    operatorUserID,
+		PreviousContent: message.Content,
+		EditedAt:        editedAt,
+	}
+	if err := database.Create(&history).Error; err != nil {
+		return nil, err
+	}
+	searchTokens := MessageSearchTokens(message.MessageType, newContent, message.RealFileName)
+	result := database.Model(&Message{}).
+		Where("message_id = ? AND is_recalled = ?", messageID, false).
+		Updates(map[string]any{"content": newContent, "edited_at": editedAt, "search_tokens": searchTokens})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, errors.New("message edit update lost locked row")
+	}
+	message.Content = newContent
+	message.EditedAt = editedAt
+	message.SearchTokens = searchTokens
+	return &MessageEditOutcome{Message: &message, Status: MessageEditOK}, nil
+}
+
+func validEditedContent(content string) bool {
+	return strings.TrimSpace(content) != "" && utf8.ValidString(content) && utf8.RuneCountInString(content) <= MaxMessageContentRunes
+}
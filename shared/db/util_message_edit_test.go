@@ -0,0 +1,72 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEditMessageRecordsHistoryAndUpdatesContent(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	now := time.Date(2026, 7, 21, 4, 10, 0, 0, time.UTC)
+	expectRecallMessage(mock, 41, 1001, 1002, now.Add(-5*time.Minute).Format(time.RFC3339), false, false, "", 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "message_edits" \("message_id","editor_user_id","previous_content","edited_at"\) VALUES \(\$1,\$2,\$3,\$4\) RETURNING "id"`).
+		WithArgs(int64(41), int64(1001), "secret", now.Format(time.RFC3339)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "messages" SET "content"=\$1,"edited_at"=\$2,"search_tokens"=\$3 WHERE message_id = \$4 AND is_recalled = \$5`).
+		WithArgs("fixed", now.Format(time.RFC3339), "fixed", int64(41), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	outcome, err := EditMessageWithDB(database, 1001, 41, "fixed", DefaultMessageEditWindow, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessageEditOK || outcome.Message.Content != "fixed" || outcome.Message.EditedAt != now.Format(time.RFC3339) {
+		t.Fatalf("unexpected edit outcome: %+v", outcome)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEditMessageRejectsExpiredRecalledInvalidAndOtherUsers(t *testing.T) {
+	now := time.Date(2026, 7, 21, 4, 30, 0, 0, time.UTC)
+	recent := now.Add(-time.Minute).Format(time.RFC3339)
+	tests := []struct {
+		name       string
+		operator   int64
+		content    string
+		sentAt     string
+		window     time.Duration
+		isRecalled bool
+		wantStatus MessageEditStatus
+	}{
+		{name: "expired", operator: 1001, content: "fixed", sentAt: now.Add(-2*time.Minute - time.Second).Format(time.RFC3339), window: 2 * time.Minute, wantStatus: MessageEditExpired},
+		{name: "default window", operator: 1001, content: "fixed", sentAt: now.Add(-DefaultMessageEditWindow - time.Second).Format(time.RFC3339), wantStatus: MessageEditExpired},
+		{name: "recalled", operator: 1001, content: "fixed", sentAt: recent, isRecalled: true, wantStatus: MessageEditRecalled},
+		{name: "blank content", operator: 1001, content: "  ", sentAt: recent, wantStatus: MessageEditNotEditable},
+		{name: "recipient cannot edit", operator: 1002, content: "fixed", sentAt: recent, wantStatus: MessageEditForbidden},
+		{name: "unrelated user sees not found", operator: 1003, content: "fixed", sentAt: recent, wantStatus: MessageEditNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectRecallMessage(mock, 42, 1001, 1002, test.sentAt, false, test.isRecalled, "", 0)
+			outcome, err := EditMessageWithDB(database, test.operator, 42, test.content, test.window, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Status != test.wantStatus {
+				t.Fatalf("status=%v want=%v", outcome.Status, test.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxForwardSourceMessages 限制一次转发的源消息数量，逐条转发与合并转发相同
+	MaxForwardSourceMessages = 100
+
+	// MergedForwardMessageType 是合并转发消息的类型，源消息快照保存在 merged_forward_items
+	MergedForwardMessageType = "merged"
+)
+
+type MessageForwardStatus int
+
+const (
+	MessageForwardOK MessageForwardStatus = iota
+	MessageForwardNotFound
+	MessageForwardRecalled
+	MessageForwardInvalid
+)
+
+// ForwardedMessage 是转发写入目标会话的一条消息，Items 只在合并转发消息上填充
+type ForwardedMessage struct {
+	Message *Message
+	Created bool
+	Items   []MergedForwardItem
+}
+
+type MessageForwardOutcome struct {
+	Status   MessageForwardStatus
+	Messages []ForwardedMessage
+}
+
+// ForwardMessagesWithDB 把转发人可读的源消息写入目标会话，源消息按发送时间排序。
+// 逐条转发为每条源消息生成一条新消息，合并转发只生成一条 merged 消息并保存源消息快照。
+// 文件消息只复制 file_hash 和文件名：FileMetadata 以内容的 SHA-512 为主键，无需重新上传。
+// clientMessageID 非空时逐条转发的第 i 条使用 "<clientMessageID>#<i>"，重试不会重复写入。
+func ForwardMessagesWithDB(database *gorm.DB, userID int64, sourceMessageIDs []int64, targetID int64, isGroup, merged bool, clientMessageID string) (*MessageForwardOutcome, error) {
+	if database == nil {
+		return nil, errors.New("message forward database is nil")
+	}
+	sourceIDs, valid := forwardSourceIDs(sourceMessageIDs)
+	if !valid || userID <= 0 || targetID <= 0 {
+		return &MessageForwardOutcome{Status: MessageForwardInvalid}, nil
+	}
+
+	var sources []Message
+	err := database.Where("message_id IN ?", sourceIDs).Order("timestamp ASC, message_id ASC").Find(&sources).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) != len(sourceIDs) {
+		return &MessageForwardOutcome{Status: MessageForwardNotFound}, nil
+	}
+	mergedSourceIDs := make([]int64, 0)
+	for i := range sources {
+		canRead, err := CanUserReadMessageWithDB(database, userID, &sources[i])
+		if err != nil {
+			return nil, err
+		}
+		if !canRead {
+			return &MessageForwardOutcome{Status: MessageForwardNotFound}, nil
+		}
+		if sources[i].IsRecalled {
+			return &MessageForwardOutcome{Status: MessageForwardRecalled}, nil
+		}
+		if sources[i].MessageType == MergedForwardMessageType {
+			mergedSourceIDs = append(mergedSourceIDs, sources[i].MessageID)
+		}
+	}
+
+	outcome := &MessageForwardOutcome{Status: MessageForwardOK}
+	if merged {
+		items := make([]MergedForwardItem, 0, len(sources))
+		for i := range sources {
+			items = append(items, MergedForwardItem{
+				Position:        i,
+				SourceMessageID: sources[i].MessageID,
+				FromUserID:      sources[i].FromUserID,
+				Content:         sources[i].Content,
+				Timestamp:       sources[i].Timestamp,
+				MessageType:     sources[i].MessageType,
+				RealFileName:    sources[i].RealFileName,
+			})
+		}
+		forwarded, err := storeForwardedMessageWithDB(database, userID, targetID, isGroup, &Message{MessageType: MergedForwardMessageType}, items, clientMessageID)
+		if err != nil {
+			return nil, err
+		}
+		outcome.Messages = []ForwardedMessage{forwarded}
+		return outcome, nil
+	}
+
+	// 逐条转发合并转发消息时连同快照一起复制，接收方同样可以展开
+	sourceItems, err := GetMergedForwardItemsWithDB(database, mergedSourceIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sources {
+		copyClientMessageID := ""
+		if clientMessageID != "" {
+			copyClientMessageID = fmt.Sprintf("%s#%d", clientMessageID, i)
+		}
+		forwarded, err := storeForwardedMessageWithDB(database, userID, targetID, isGroup, &sources[i], sourceItems[sources[i].MessageID], copyClientMessageID)
+		if err != nil {
+			return nil, err
+		}
+		outcome.Messages = append(outcome.Messages, forwarded)
+	}
+	return outcome, nil
+}
+
+// storeForwardedMessageWithDB 以转发人为发送者写入 source 的内容，幂等命中时返回已保存的消息和快照
+func storeForwardedMessageWithDB(database *gorm.DB, userID, targetID int64, isGroup bool, source *Message, items []MergedForwardItem, clientMessageID string) (ForwardedMessage, error) {
+	message, created, err := StoreNewMessageWithDB(database, userID, targetID, source.Content, source.MessageType, source.RealFileName, isGroup, clientMessageID, 0)
+	if err != nil {
+		return ForwardedMessage{}, err
+	}
+	forwarded := ForwardedMessage{Message: message, Created: created}
+	if message.MessageType != MergedForwardMessageType {
+		return forwarded, nil
+	}
+	if !created {
+		existing, err := GetMergedForwardItemsWithDB(database, []int64{message.MessageID})
+		if err != nil {
+			return ForwardedMessage{}, err
+		}
+		forwarded.Items = existing[message.MessageID]
+		return forwarded, nil
+	}
+	if len(items) == 0 {
+		return forwarded, nil
+	}
+	copies := make([]MergedForwardItem, len(items))
+	for i, item := range items {
+		item.MessageID = message.MessageID
+		copies[i] = item
+	}
+	if err := database.Create(&copies).Error; err != nil {
+		return ForwardedMessage{}, err
+	}
+	forwarded.Items = copies
+	return forwarded, nil
+}
+
+// GetMergedForwardItemsWithDB 按合并转发消息ID批量读取源消息快照，每条消息内按原顺序排列
+func GetMergedForwardItemsWithDB(database *gorm.DB, messageIDs []int64) (map[int64][]MergedForwardItem, error) {
+	items := make(map[int64][]MergedForwardItem, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return items, nil
+	}
+	if database == nil {
+		return nil, errors.New("message forward database is nil")
+	}
+
+	var rows []MergedForwardItem
+	err := database.Where("message_id IN ?", messageIDs).Order("message_id ASC, position ASC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		items[row.MessageID] = append(items[row.MessageID], row)
+	}
+	return items, nil
+}
+
+func forwardSourceIDs(messageIDs []int64) ([]int64, bool) {
+	if len(messageIDs) == 0 || len(messageIDs) > MaxForwardSourceMessages {
+		return nil, false
+	}
+	seen := make(map[int64]bool, len(messageIDs))
+	unique := make([]int64, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		if messageID <= 0 {
+			return nil, false
+		}
+		if !seen[messageID] {
+			seen[messageID] = true
+			unique = append(unique, messageID)
+		}
+	}
+	return unique, true
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectForwardSources(mock sqlmock.Sqlmock, rows *sqlmock.Rows, messageIDs ...int64) {
+	placeholders := make([]string, len(messageIDs))
+	args := make([]driver.Value, len(messageIDs))
+	for i, messageID := range messageIDs {
+		placeholders[i] = fmt.Sprintf(`\$%d`, i+1)
+		args[i] = messageID
+	}
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id IN \(` + strings.Join(placeholders, ",") + `\) ORDER BY timestamp ASC, message_id ASC`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+}
+
+func TestForwardMessagesMergedSnapshotsSourcesInTimeOrder(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	expectForwardSources(mock, sqlmock.NewRows(recallMessageColumns).
+		AddRow(61, nil, 1002, 1001, "sha512-report", "2026-07-23T08:00:00Z", "file", "report.pdf", false, false, "", 0).
+		AddRow(62, nil, 1001, 1002, "see attached", "2026-07-23T08:01:00Z", "text", "", false, false, "", 0), 62, 61)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "messages"`).
+		WithArgs("bundle-1", int64(1001), int64(7), "", sqlmock.AnyArg(), MergedForwardMessageType, "", true, false, "", int64(0), "", int64(0), "").
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow(90))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "merged_forward_items" \("message_id","position","source_message_id","from_user_id","content","timestamp","message_type","real_file_name"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8\),\(\$9,\$10,\$11,\$12,\$13,\$14,\$15,\$16\)`).
+		WithArgs(
+			int64(90), 0, int64(61), int64(1002), "sha512-report", "2026-07-23T08:00:00Z", "file", "report.pdf",
+			int64(90), 1, int64(62), int64(1001), "see attached", "2026-07-23T08:01:00Z", "text", "",
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	outcome, err := ForwardMessagesWithDB(database, 1001, []int64{62, 61, 62}, 7, true, true, "bundle-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessageForwardOK || len(outcome.Messages) != 1 || !outcome.Messages[0].Created {
+		t.Fatalf("unexpected forward outcome: %+v", outcome)
+	}
+	items := outcome.Messages[0].Items
+	if len(items) != 2 || items[0].SourceMessageID != 61 || items[0].MessageID != 90 || items[1].Position != 1 {
+		t.Fatalf("unexpected merged snapshot: %+v", items)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForwardMessagesCopiesFileReferencesOneByOne(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	expectForwardSources(mock, sqlmock.NewRows(recallMessageColumns).
+		AddRow(61, nil, 1002, 1001, "sha512-report", "2026-07-23T08:00:00Z", "file", "report.pdf", false, false, "", 0), 61)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "messages"`).
+		WithArgs("fwd-1#0", int64(1001), int64(1003), "sha512-report", sqlmock.AnyArg(), "file", "report.pdf", false, false, "", int64(0), "", int64(0), "report pdf").
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow(91))
+	mock.ExpectCommit()
+
+	outcome, err := ForwardMessagesWithDB(database, 1001, []int64{61}, 1003, false, false, "fwd-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessageForwardOK || len(outcome.Messages) != 1 || outcome.Messages[0].Message.MessageID != 91 || outcome.Messages[0].Items != nil {
+		t.Fatalf("unexpected forward outcome: %+v", outcome)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForwardMessagesRejectsInvalidUnreadableAndRecalledSources(t *testing.T) {
+	tooMany := make([]int64, MaxForwardSourceMessages+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+	for name, sourceIDs := range map[string][]int64{"empty": nil, "non-positive": {61, 0}, "too many": tooMany} {
+		t.Run(name, func(t *testing.T) {
+			database, _ := newInboxDatabase(t)
+			outcome, err := ForwardMessagesWithDB(database, 1001, sourceIDs, 1003, false, false, "")
+			if err != nil || outcome.Status != MessageForwardInvalid {
+				t.Fatalf("outcome=%+v err=%v", outcome, err)
+			}
+		})
+	}
+
+	tests := []struct {
+		name       string
+		userID     int64
+		rows       *sqlmock.Rows
+		wantStatus MessageForwardStatus
+	}{
+		{name: "missing", userID: 1001, rows: sqlmock.NewRows(recallMessageColumns), wantStatus: MessageForwardNotFound},
+		{name: "unreadable", userID: 1003, rows: sqlmock.NewRows(recallMessageColumns).
+			AddRow(61, nil, 1002, 1001, "secret", "2026-07-23T08:00:00Z", "text", "", false, false, "", 0), wantStatus: MessageForwardNotFound},
+		{name: "recalled", userID: 1001, rows: sqlmock.NewRows(recallMessageColumns).
+			AddRow(61, nil, 1002, 1001, "", "2026-07-23T08:00:00Z", "text", "", false, true, "2026-07-23T08:01:00Z", 1002), wantStatus: MessageForwardRecalled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectForwardSources(mock, test.rows, 61)
+			outcome, err := ForwardMessagesWithDB(database, test.userID, []int64{61}, 1004, false, false, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Status != test.wantStatus || len(outcome.Messages) != 0 {
+				t.Fatalf("status=%v want=%v", outcome.Status, test.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
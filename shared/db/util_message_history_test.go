@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var historyMessageColumns = []string{"message_id", "from_user_id", "to_user_id", "content", "timestamp", "message_type", "is_group"}
+
+func TestConversationHistoryPagesDirectConversationBackwards(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT "message_id","timestamp" FROM "messages" WHERE message_id = \$1 LIMIT \$2`).
+		WithArgs(int64(90), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "timestamp"}).AddRow(90, "2026-07-21T03:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE \(is_group = \$1 AND \(\(to_user_id = \$2 AND from_user_id = \$3\) OR \(to_user_id = \$4 AND from_user_id = \$5\)\)\) AND \(timestamp < \$6 OR \(timestamp = \$7 AND message_id < \$8\)\) ORDER BY timestamp DESC, message_id DESC LIMIT \$9`).
+		WithArgs(false, int64(1002), int64(1001), int64(1001), int64(1002), "2026-07-21T03:00:00Z", "2026-07-21T03:00:00Z", int64(90), 3).
+		WillReturnRows(sqlmock.NewRows(historyMessageColumns).
+			AddRow(89, 1002, 1001, "c", "2026-07-21T03:00:00Z", "text", false).
+			AddRow(80, 1001, 1002, "b", "2026-07-21T02:00:00Z", "text", false).
+			AddRow(70, 1002, 1001, "a", "2026-07-21T01:00:00Z", "text", false))
+
+	page, err := GetConversationHistoryPageWithDB(database, 1001, 1002, false, 90, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Messages) != 2 || page.Messages[0].MessageID != 89 || page.NextBeforeMessageID != 80 {
+		t.Fatalf("unexpected history page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConversationHistoryRespectsGroupJoinCutoff(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT COALESCE\(NULLIF\(joined_at, ''\), update_time\) FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(1002)).
+		WillReturnRows(sqlmock.NewRows([]string{"cutoff"}).AddRow("2026-07-20T00:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE \(is_group = \$1 AND to_user_id = \$2\) AND \(timestamp >= \$3 OR from_user_id = \$4\) ORDER BY timestamp DESC, message_id DESC LIMIT \$5`).
+		WithArgs(true, int64(7), "2026-07-20T00:00:00Z", int64(1002), 51).
+		WillReturnRows(sqlmock.NewRows(historyMessageColumns).
+			AddRow(80, 1003, 7, "hi", "2026-07-21T02:00:00Z", "text", true))
+
+	page, err := GetConversationHistoryPageWithDB(database, 1002, 7, true, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasMore || len(page.Messages) != 1 || page.NextBeforeMessageID != 0 {
+		t.Fatalf("unexpected history page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConversationHistoryRejectsNonMember(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`FROM "group_members"`).
+		WithArgs(int64(7), int64(1002)).
+		WillReturnRows(sqlmock.NewRows([]string{"cutoff"}))
+
+	page, err := GetConversationHistoryPageWithDB(database, 1002, 7, true, 0, 20)
+	if err != nil || page != nil {
+		t.Fatalf("non-member history page=%+v err=%v, want nil", page, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxMessageMentions 限制一条群消息单独@的成员数量，@所有人不计入
+	MaxMessageMentions = 50
+
+	// MentionAllUserID 是 message_mentions 中表示@所有人的 user_id
+	MentionAllUserID = 0
+)
+
+type MessageMentionStatus int
+
+const (
+	MessageMentionOK MessageMentionStatus = iota
+	MessageMentionInvalid
+	MessageMentionForbidden
+)
+
+// MessageMentions 是一条群消息实际记录的@对象，All 为 true 时不再逐个列出成员
+type MessageMentions struct {
+	UserIDs []int64
+	All     bool
+}
+
+type MentionedMessagesPage struct {
+	Messages            []Message
+	HasMore             bool
+	NextBeforeMessageID int64
+}
+
+// CheckMessageMentionsWithDB 校验发送者能否在新消息中@这些用户：只有群消息可以@成员，
+// @所有人要求发送者是群主或管理员。没有@任何人时直接通过。
+func CheckMessageMentionsWithDB(database *gorm.DB, fromUserID, toUserID int64, isGroup bool, userIDs []int64, mentionAll bool) (MessageMentionStatus, error) {
+	if len(userIDs) == 0 && !mentionAll {
+		return MessageMentionOK, nil
+	}
+	if !isGroup || len(userIDs) > MaxMessageMentions {
+		return MessageMentionInvalid, nil
+	}
+	for _, userID := range userIDs {
+		if userID <= 0 {
+			return MessageMentionInvalid, nil
+		}
+	}
+	if !mentionAll {
+		return MessageMentionOK, nil
+	}
+	if database == nil {
+		return MessageMentionInvalid, errors.New("message mention database is nil")
+	}
+	_, canManage, err := RequireGroupManagerWithDB(database, toUserID, fromUserID)
+	if err != nil {
+		return MessageMentionInvalid, err
+	}
+	if !canManage {
+		return MessageMentionForbidden, nil
+	}
+	return MessageMentionOK, nil
+}
+
+// StoreMessageMentionsWithDB 保存新群消息的@对象并返回实际记录的结果。
+// 单独@的用户只保留发送时仍在群内的其他成员；@所有人只记录一行 MentionAllUserID，
+// 查询时按成员入群时间展开，避免大群每条消息写入全部成员。
+func StoreMessageMentionsWithDB(database *gorm.DB, message *Message, userIDs []int64, mentionAll bool) (MessageMentions, error) {
+	if message == nil || !message.IsGroup || (len(userIDs) == 0 && !mentionAll) {
+		return MessageMentions{}, nil
+	}
+	if database == nil {
+		return MessageMentions{}, errors.New("message mention database is nil")
+	}
+	if mentionAll {
+		err := database.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&MessageMention{MessageID: message.MessageID, UserID: MentionAllUserID}).Error
+		if err != nil {
+			return MessageMentions{}, err
+		}
+		return MessageMentions{All: true}, nil
+	}
+
+	candidates := make([]int64, 0, len(userIDs))
+	seen := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if userID > 0 && userID != message.FromUserID && !seen[userID] {
+			seen[userID] = true
+			candidates = append(candidates, userID)
+		}
+	}
+	if len(candidates) == 0 {
+		return MessageMentions{}, nil
+	}
+	var members []int64
+	err := database.Model(&GroupMember{}).
+		Where("group_id = ? AND user_id IN ?", message.ToUserID, candidates).
+		Order("user_id ASC").
+		Pluck("user_id", &members).Error
+	if err != nil {
+		return MessageMentions{}, err
+	}
+	if len(members) == 0 {
+		return MessageMentions{}, nil
+	}
+	rows := make([]MessageMention, 0, len(members))
+	for _, userID := range members {
+		rows = append(rows, MessageMention{MessageID: message.MessageID, UserID: userID})
+	}
+	if err := database.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return MessageMentions{}, err
+	}
+	return MessageMentions{UserIDs: members}, nil
+}
+
+// GetMessageMentionsWithDB 按消息ID批量读取@对象，没有@任何人的消息不出现在结果中
+func GetMessageMentionsWithDB(database *gorm.DB, messageIDs []int64) (map[int64]MessageMentions, error) {
+	mentions := make(map[int64]MessageMentions, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return mentions, nil
+	}
+	if database == nil {
+		return nil, errors.New("message mention database is nil")
+	}
+
+	var rows []MessageMention
+	err := database.Where("message_id IN ?", messageIDs).Order("message_id ASC, user_id ASC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		mention := mentions[row.MessageID]
+		if row.UserID == MentionAllUserID {
+			mention.All = true
+		} else {
+			mention.UserIDs = append(mention.UserIDs, row.UserID)
+		}
+		mentions[row.MessageID] = mention
+	}
+	return mentions, nil
+}
+
+// GetMentionedMessagesPageWithDB 按 message_id 倒序列出其他成员发送的、@了 userID 或@所有人的群消息，
+// beforeMessageID 为上一页最后一条消息，首页传 0。
+// 与 GetConversationHistoryPageWithDB 一致，只返回用户当前所在群中入群之后的消息；已撤回的消息不再列出。
+func GetMentionedMessagesPageWithDB(database *gorm.DB, userID, beforeMessageID int64, pageSize int) (*MentionedMessagesPage, error) {
+	if database == nil {
+		return nil, errors.New("message mention database is nil")
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultHistoryPageSize
+	}
+	if pageSize > MaxHistoryPageSize {
+		pageSize = MaxHistoryPageSize
+	}
+
+	query := database.Model(&Message{}).
+		Select("messages.*").
+		Joins("JOIN message_mentions ON message_mentions.message_id = messages.message_id").
+		Joins("JOIN group_members ON group_members.group_id = messages.to_user_id AND group_members.user_id = ?", userID).
+		Where("message_mentions.user_id IN ?", []int64{userID, MentionAllUserID}).
+		Where("messages.is_group = ? AND messages.is_recalled = ? AND messages.from_user_id <> ?", true, false, userID).
+		Where("messages.timestamp >= COALESCE(NULLIF(group_members.joined_at, ''), group_members.update_time)")
+	if beforeMessageID > 0 {
+		query = query.Where("messages.message_id < ?", beforeMessageID)
+	}
+
+	var messages []Message
+	err := query.
+		Order("messages.message_id DESC").
+		Limit(pageSize + 1).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MentionedMessagesPage{HasMore: len(messages) > pageSize}
+	if page.HasMore {
+		messages = messages[:pageSize]
+		page.NextBeforeMessageID = messages[len(messages)-1].MessageID
+	}
+	page.Messages = messages
+	return page, nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckMessageMentionsRestrictsMentionAllToManagers(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		wantStatus MessageMentionStatus
+	}{
+		{name: "owner", role: GroupRoleOwner, wantStatus: MessageMentionOK},
+		{name: "admin", role: GroupRoleAdmin, wantStatus: MessageMentionOK},
+		{name: "member", role: GroupRoleMember, wantStatus: MessageMentionForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			mock.ExpectQuery(`SELECT \* FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+				WithArgs(int64(7), int64(1001), 1).
+				WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "role"}).AddRow(7, 1001, test.role))
+			status, err := CheckMessageMentionsWithDB(database, 1001, 7, true, nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != test.wantStatus {
+				t.Fatalf("status=%v want=%v", status, test.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckMessageMentionsRejectsDirectChatsAndOversizedLists(t *testing.T) {
+	tooMany := make([]int64, MaxMessageMentions+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 2000)
+	}
+	tests := []struct {
+		name    string
+		isGroup bool
+		userIDs []int64
+		all     bool
+	}{
+		{name: "direct chat", isGroup: false, userIDs: []int64{1002}},
+		{name: "direct chat mention all", isGroup: false, all: true},
+		{name: "too many", isGroup: true, userIDs: tooMany},
+		{name: "non-positive", isGroup: true, userIDs: []int64{1002, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, _ := newInboxDatabase(t)
+			status, err := CheckMessageMentionsWithDB(database, 1001, 7, test.isGroup, test.userIDs, test.all)
+			if err != nil || status != MessageMentionInvalid {
+				t.Fatalf("status=%v err=%v", status, err)
+			}
+		})
+	}
+}
+
+func TestStoreMessageMentionsKeepsOnlyOtherCurrentMembers(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT "user_id" FROM "group_members" WHERE group_id = \$1 AND user_id IN \(\$2,\$3\) ORDER BY user_id ASC`).
+		WithArgs(int64(7), int64(1003), int64(1002)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1002))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_mentions" \("message_id","user_id"\) VALUES \(\$1,\$2\) ON CONFLICT DO NOTHING`).
+		WithArgs(int64(81), int64(1002)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	message := &Message{MessageID: 81, FromUserID: 1001, ToUserID: 7, IsGroup: true}
+	mentions, err := StoreMessageMentionsWithDB(database, message, []int64{1003, 1001, 1002, 1003}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mentions.All || len(mentions.UserIDs) != 1 || mentions.UserIDs[0] != 1002 {
+		t.Fatalf("unexpected stored mentions: %+v", mentions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreMessageMentionsRecordsMentionAllOnce(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_mentions" \("message_id","user_id"\) VALUES \(\$1,\$2\) ON CONFLICT DO NOTHING`).
+		WithArgs(int64(82), int64(MentionAllUserID)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	message := &Message{MessageID: 82, FromUserID: 1001, ToUserID: 7, IsGroup: true}
+	mentions, err := StoreMessageMentionsWithDB(database, message, []int64{1002}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mentions.All || len(mentions.UserIDs) != 0 {
+		t.Fatalf("unexpected stored mentions: %+v", mentions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMentionedMessagesPageFollowsMembershipAndCursor(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT messages\.\* FROM "messages" JOIN message_mentions ON message_mentions\.message_id = messages\.message_id JOIN group_members ON group_members\.group_id = messages\.to_user_id AND group_members\.user_id = \$1 WHERE message_mentions\.user_id IN \(\$2,\$3\) AND \(messages\.is_group = \$4 AND messages\.is_recalled = \$5 AND messages\.from_user_id <> \$6\) AND messages\.timestamp >= COALESCE\(NULLIF\(group_members\.joined_at, ''\), group_members\.update_time\) AND messages\.message_id < \$7 ORDER BY messages\.message_id DESC LIMIT \$8`).
+		WithArgs(int64(1002), int64(1002), int64(MentionAllUserID), true, false, int64(1002), int64(90), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "is_group"}).
+			AddRow(88, 1001, 7, true).
+			AddRow(85, 1003, 7, true).
+			AddRow(80, 1001, 9, true))
+
+	page, err := GetMentionedMessagesPageWithDB(database, 1002, 90, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Messages) != 2 || page.NextBeforeMessageID != 85 {
+		t.Fatalf("unexpected mentioned page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
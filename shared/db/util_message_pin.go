@@ -0,0 +1,244 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultMessagePinLimit 是未配置时每个会话最多置顶的消息数
+const DefaultMessagePinLimit = 10
+
+type MessagePinStatus int
+
+const (
+	MessagePinOK MessagePinStatus = iota
+	MessagePinNotFound
+	MessagePinForbidden
+	MessagePinRecalled
+	MessagePinLimitReached
+)
+
+type MessagePinOutcome struct {
+	Message *Message
+	Status  MessagePinStatus
+	// Changed 为 false 表示重复置顶或取消未置顶的消息，无需广播
+	Changed  bool
+	PinnedAt string // 置顶时为置顶时间，取消置顶时为操作时间
+}
+
+// PinnedMessage 是会话置顶列表中的一条消息
+type PinnedMessage struct {
+	Message  Message
+	PinnedBy int64
+	PinnedAt string
+}
+
+// PinMessageWithDB 把可读消息置顶到所在会话。群聊只有群主和管理员可以置顶，单聊双方都可以。
+// 已撤回的消息不能置顶，也不计入会话的置顶上限；limit 不大于 0 时使用 DefaultMessagePinLimit。
+// 上限检查前锁住会话对应的群行或单聊较小参与者的用户行，并发置顶不会超出上限。
+func PinMessageWithDB(database *gorm.DB, operatorUserID, messageID int64, limit int, now time.Time) (*MessagePinOutcome, error) {
+	message, status, err := lockPinnableMessageWithDB(database, operatorUserID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if status != MessagePinOK {
+		return &MessagePinOutcome{Message: message, Status: status}, nil
+	}
+	if message.IsRecalled {
+		return &MessagePinOutcome{Message: message, Status: MessagePinRecalled}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultMessagePinLimit
+	}
+
+	var existing []MessagePin
+	if err := database.Where("message_id = ?", messageID).Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return &MessagePinOutcome{Message: message, Status: MessagePinOK, PinnedAt: existing[0].PinnedAt}, nil
+	}
+
+	conversationID, peerID := messagePinConversation(message)
+	if err := lockMessagePinConversationWithDB(database, message.IsGroup, conversationID); err != nil {
+		return nil, err
+	}
+	var count int64
+	err = database.Model(&MessagePin{}).
+		Joins("JOIN messages ON messages.message_id = message_pins.message_id").
+		Where("message_pins.is_group = ? AND message_pins.conversation_id = ? AND message_pins.peer_id = ? AND messages.is_recalled = ?",
+			message.IsGroup, conversationID, peerID, false).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count >= int64(limit) {
+		return &MessagePinOutcome{Message: message, Status: MessagePinLimitReached}, nil
+	}
+
+	pin := MessagePin{
+		MessageID:      messageID,
+		IsGroup:        message.IsGroup,
+		ConversationID: conversationID,
+		PeerID:         peerID,
+		PinnedBy:       operatorUserID,
+		PinnedAt:       now.UTC().Format(time.RFC3339),
+	}
+	if err := database.Create(&pin).Error; err != nil {
+		return nil, err
+	}
+	return &MessagePinOutcome{Message: message, Status: MessagePinOK, Changed: true, PinnedAt: pin.PinnedAt}, nil
+}
+
+// UnpinMessageWithDB 取消置顶，权限与置顶相同；已撤回的消息也可以取消置顶。
+func UnpinMessageWithDB(database *gorm.DB, operatorUserID, messageID int64, now time.Time) (*MessagePinOutcome, error) {
+	message, status, err := lockPinnableMessageWithDB(database, operatorUserID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if status != MessagePinOK {
+		return &MessagePinOutcome{Message: message, Status: status}, nil
+	}
+	result := database.Where("message_id = ?", messageID).Delete(&MessagePin{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &MessagePinOutcome{
+		Message:  message,
+		Status:   MessagePinOK,
+		Changed:  result.RowsAffected > 0,
+		PinnedAt: now.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// lockPinnableMessageWithDB 锁住消息行并检查操作者的置顶权限，操作者不可读时按消息不存在处理
+func lockPinnableMessageWithDB(database *gorm.DB, operatorUserID, messageID int64) (*Message, MessagePinStatus, error) {
+	if database == nil {
+		return nil, MessagePinNotFound, errors.New("message pin database is nil")
+	}
+	if operatorUserID <= 0 || messageID <= 0 {
+		return nil, MessagePinNotFound, nil
+	}
+
+	var message Message
+	err := database.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "message_id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, MessagePinNotFound, nil
+	}
+	if err != nil {
+		return nil, MessagePinNotFound, err
+	}
+	canRead, err := CanUserReadMessageWithDB(database, operatorUserID, &message)
+	if err != nil {
+		return nil, MessagePinNotFound, err
+	}
+	if !canRead {
+		return nil, MessagePinNotFound, nil
+	}
+	if !message.IsGroup {
+		return &message, MessagePinOK, nil
+	}
+	_, canManage, err := RequireGroupManagerWithDB(database, message.ToUserID, operatorUserID)
+	if err != nil {
+		return nil, MessagePinNotFound, err
+	}
+	if !canManage {
+		return &message, MessagePinForbidden, nil
+	}
+	return &message, MessagePinOK, nil
+}
+
+// lockMessagePinConversationWithDB 单聊没有会话行，锁较小参与者的用户行代替
+func lockMessagePinConversationWithDB(database *gorm.DB, isGroup bool, conversationID int64) error {
+	var err error
+	if isGroup {
+		var group Group
+		err = database.Clauses(clause.Locking{Strength: "UPDATE"}).Select("group_id").Take(&group, "group_id = ?", conversationID).Error
+	} else {
+		var user User
+		err = database.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Take(&user, "id = ?", conversationID).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+func messagePinConversation(message *Message) (int64, int64) {
+	if message.IsGroup {
+		return message.ToUserID, 0
+	}
+	return directPinConversation(message.FromUserID, message.ToUserID)
+}
+
+func directPinConversation(userID, peerID int64) (int64, int64) {
+	if userID <= peerID {
+		return userID, peerID
+	}
+	return peerID, userID
+}
+
+// GetPinnedMessagesWithDB 按置顶时间倒序列出会话中未撤回的置顶消息，userID 不在群内时返回 nil。
+// 与 GetConversationHistoryPageWithDB 一致，群成员看不到入群之前的消息。
+func GetPinnedMessagesWithDB(database *gorm.DB, userID, conversationID int64, isGroup bool) ([]PinnedMessage, error) {
+	if database == nil {
+		return nil, errors.New("message pin database is nil")
+	}
+
+	pinConversationID, peerID := conversationID, int64(0)
+	cutoff := ""
+	if isGroup {
+		var cutoffs []string
+		err := database.Model(&GroupMember{}).
+			Where("group_id = ? AND user_id = ?", conversationID, userID).
+			Pluck("COALESCE(NULLIF(joined_at, ''), update_time)", &cutoffs).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(cutoffs) == 0 {
+			return nil, nil
+		}
+		cutoff = cutoffs[0]
+	} else {
+		pinConversationID, peerID = directPinConversation(userID, conversationID)
+	}
+
+	var pins []MessagePin
+	err := database.
+		Where("is_group = ? AND conversation_id = ? AND peer_id = ?", isGroup, pinConversationID, peerID).
+		Order("pinned_at DESC, message_id DESC").
+		Find(&pins).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) == 0 {
+		return []PinnedMessage{}, nil
+	}
+	messageIDs := make([]int64, len(pins))
+	for i, pin := range pins {
+		messageIDs[i] = pin.MessageID
+	}
+
+	query := database.Where("message_id IN ? AND is_recalled = ?", messageIDs, false)
+	if isGroup {
+		query = query.Where("timestamp >= ? OR from_user_id = ?", cutoff, userID)
+	}
+	var messages []Message
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Message, len(messages))
+	for _, message := range messages {
+		byID[message.MessageID] = message
+	}
+	pinned := make([]PinnedMessage, 0, len(messages))
+	for _, pin := range pins {
+		if message, ok := byID[pin.MessageID]; ok {
+			pinned = append(pinned, PinnedMessage{Message: message, PinnedBy: pin.PinnedBy, PinnedAt: pin.PinnedAt})
+		}
+	}
+	return pinned, nil
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectPinManagerRole(mock sqlmock.Sqlmock, groupID, userID int64, role string) {
+	mock.ExpectQuery(`SELECT \* FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+		WithArgs(groupID, userID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "role"}).AddRow(groupID, userID, role))
+}
+
+func TestPinMessageEnforcesConversationLimit(t *testing.T) {
+	now := time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		pinned      int64
+		wantStatus  MessagePinStatus
+		wantChanged bool
+	}{
+		{name: "below limit", pinned: 1, wantStatus: MessagePinOK, wantChanged: true},
+		{name: "at limit", pinned: 2, wantStatus: MessagePinLimitReached},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectRecallMessage(mock, 51, 1002, 1001, "2026-08-01T08:00:00Z", false, false, "", 0)
+			mock.ExpectQuery(`SELECT \* FROM "message_pins" WHERE message_id = \$1 LIMIT \$2`).
+				WithArgs(int64(51), 1).
+				WillReturnRows(sqlmock.NewRows([]string{"message_id"}))
+			mock.ExpectQuery(`SELECT "id" FROM "users" WHERE id = \$1 LIMIT \$2 FOR UPDATE`).
+				WithArgs(int64(1001), 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1001))
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "message_pins" JOIN messages ON messages\.message_id = message_pins\.message_id WHERE message_pins\.is_group = \$1 AND message_pins\.conversation_id = \$2 AND message_pins\.peer_id = \$3 AND messages\.is_recalled = \$4`).
+				WithArgs(false, int64(1001), int64(1002), false).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.pinned))
+			if test.wantChanged {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO "message_pins" \("message_id","is_group","conversation_id","peer_id","pinned_by","pinned_at"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\)`).
+					WithArgs(int64(51), false, int64(1001), int64(1002), int64(1001), now.Format(time.RFC3339)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			outcome, err := PinMessageWithDB(database, 1001, 51, 2, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Status != test.wantStatus || outcome.Changed != test.wantChanged {
+				t.Fatalf("unexpected pin outcome: %+v", outcome)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPinMessageRepeatedPinKeepsOriginalTime(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	expectRecallMessage(mock, 51, 1002, 1001, "2026-08-01T08:00:00Z", false, false, "", 0)
+	mock.ExpectQuery(`SELECT \* FROM "message_pins" WHERE message_id = \$1 LIMIT \$2`).
+		WithArgs(int64(51), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "pinned_by", "pinned_at"}).AddRow(51, 1002, "2026-08-01T08:30:00Z"))
+
+	outcome, err := PinMessageWithDB(database, 1001, 51, 0, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessagePinOK || outcome.Changed || outcome.PinnedAt != "2026-08-01T08:30:00Z" {
+		t.Fatalf("unexpected pin outcome: %+v", outcome)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinMessageRequiresGroupManager(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		wantStatus MessagePinStatus
+	}{
+		{name: "member", role: GroupRoleMember, wantStatus: MessagePinForbidden},
+		{name: "admin", role: GroupRoleAdmin, wantStatus: MessagePinRecalled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectRecallMessage(mock, 52, 1001, 7, "2026-08-01T08:00:00Z", true, true, "2026-08-01T08:01:00Z", 1001)
+			expectPinManagerRole(mock, 7, 1001, test.role)
+
+			outcome, err := PinMessageWithDB(database, 1001, 52, 0, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Status != test.wantStatus || outcome.Changed {
+				t.Fatalf("status=%v want=%v", outcome.Status, test.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUnpinMessageHidesUnreadableMessages(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	expectRecallMessage(mock, 53, 1002, 1003, "2026-08-01T08:00:00Z", false, false, "", 0)
+
+	outcome, err := UnpinMessageWithDB(database, 1001, 53, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessagePinNotFound || outcome.Message != nil {
+		t.Fatalf("unexpected unpin outcome: %+v", outcome)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetPinnedMessagesKeepsPinOrderAndMemberCutoff(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT COALESCE\(NULLIF\(joined_at, ''\), update_time\) FROM "group_members" WHERE group_id = \$1 AND user_id = \$2`).
+		WithArgs(int64(7), int64(1002)).
+		WillReturnRows(sqlmock.NewRows([]string{"cutoff"}).AddRow("2026-07-01T00:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "message_pins" WHERE is_group = \$1 AND conversation_id = \$2 AND peer_id = \$3 ORDER BY pinned_at DESC, message_id DESC`).
+		WithArgs(true, int64(7), int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "is_group", "conversation_id", "peer_id", "pinned_by", "pinned_at"}).
+			AddRow(55, true, 7, 0, 1001, "2026-08-01T09:00:00Z").
+			AddRow(54, true, 7, 0, 1003, "2026-08-01T08:00:00Z").
+			AddRow(40, true, 7, 0, 1001, "2026-07-30T08:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE \(message_id IN \(\$1,\$2,\$3\) AND is_recalled = \$4\) AND \(timestamp >= \$5 OR from_user_id = \$6\)`).
+		WithArgs(int64(55), int64(54), int64(40), false, "2026-07-01T00:00:00Z", int64(1002)).
+		WillReturnRows(sqlmock.NewRows(recallMessageColumns).
+			AddRow(54, nil, 1003, 7, "agenda", "2026-07-20T08:00:00Z", "text", "", true, false, "", 0).
+			AddRow(55, nil, 1001, 7, "rules", "2026-07-21T08:00:00Z", "text", "", true, false, "", 0))
+
+	pinned, err := GetPinnedMessagesWithDB(database, 1002, 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 2 || pinned[0].Message.MessageID != 55 || pinned[1].Message.MessageID != 54 || pinned[1].PinnedBy != 1003 {
+		t.Fatalf("unexpected pinned messages: %+v", pinned)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"errors"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxReactionEmojiRunes 限制单个回应的长度，足以容纳带肤色和 ZWJ 组合的 emoji 序列
+const MaxReactionEmojiRunes = 16
+
+type MessageReactionStatus int
+
+const (
+	MessageReactionOK MessageReactionStatus = iota
+	MessageReactionNotFound
+	MessageReactionRecalled
+	MessageReactionInvalid
+)
+
+// ReactionCount 是一条消息上某个表情的聚合结果，ReactedByMe 相对于查询者
+type ReactionCount struct {
+	MessageID   int64
+	Emoji       string
+	Count       int64
+	ReactedByMe bool
+	FirstAt     string
+}
+
+type MessageReactionOutcome struct {
+	Message   *Message
+	Status    MessageReactionStatus
+	Changed   bool
+	ReactedAt string
+	Reactions []ReactionCount
+	// ReaderIDs 是回应变化后分配了新回应更新序号的可读者，只在 Changed 时填充
+	ReaderIDs []int64
+}
+
+// ReactToMessageWithDB 为可读消息添加或移除操作者的表情回应，重复添加或移除不存在的回应时 Changed 为 false。
+// 消息行在事务内加锁，与撤回互斥，撤回后的消息不再接受回应。
+// 回应变化时为可读者更新回应更新序号，离线设备按该序号同步即可看到最新的回应，收件箱不受影响。
+func ReactToMessageWithDB(database *gorm.DB, userID, messageID int64, emoji string, remove bool, now time.Time) (*MessageReactionOutcome, error) {
+	if database == nil {
+		return nil, errors.New("message reaction database is nil")
+	}
+	if userID <= 0 || messageID <= 0 {
+		return &MessageReactionOutcome{Status: MessageReactionNotFound}, nil
+	}
+
+	var message Message
+	err := database.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "message_id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &MessageReactionOutcome{Status: MessageReactionNotFound}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	canRead, err := CanUserReadMessageWithDB(database, userID, &message)
+	if err != nil {
+		return nil, err
+	}
+	if !canRead {
+		return &MessageReactionOutcome{Status: MessageReactionNotFound}, nil
+	}
+	if message.IsRecalled {
+		return &MessageReactionOutcome{Message: &message, Status: MessageReactionRecalled}, nil
+	}
+	if !validReactionEmoji(emoji) {
+		return &MessageReactionOutcome{Message: &message, Status: MessageReactionInvalid}, nil
+	}
+
+	reactedAt := now.UTC().Format(time.RFC3339)
+	var result *gorm.DB
+	if remove {
+		result = database.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).Delete(&MessageReaction{})
+	} else {
+		result = database.Clauses(clause.OnConflict{DoNothing: true}).Create(&MessageReaction{
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+			CreatedAt: reactedAt,
+		})
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	outcome := &MessageReactionOutcome{
+		Message:   &message,
+		Status:    MessageReactionOK,
+		Changed:   result.RowsAffected > 0,
+		ReactedAt: reactedAt,
+	}
+	if outcome.Changed {
+		if outcome.ReaderIDs, err = RecordReactionUpdateWithDB(database, &message); err != nil {
+			return nil, err
+		}
+	}
+	counts, err := GetMessageReactionCountsWithDB(database, userID, []int64{messageID})
+	if err != nil {
+		return nil, err
+	}
+	outcome.Reactions = counts[messageID]
+	return outcome, nil
+}
+
+// GetMessageReactionCountsWithDB 按消息聚合表情回应，每条消息内按首次回应时间排序。
+// ReactedByMe 按 viewerUserID 计算；调用方负责只传入查询者可读的消息。
+func GetMessageReactionCountsWithDB(database *gorm.DB, viewerUserID int64, messageIDs []int64) (map[int64][]ReactionCount, error) {
+	counts := make(map[int64][]ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+	if database == nil {
+		return nil, errors.New("message reaction database is nil")
+	}
+
+	var rows []ReactionCount
+	err := database.Model(&MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted_by_me, MIN(created_at) AS first_at", viewerUserID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id ASC, first_at ASC, emoji ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.MessageID] = append(counts[row.MessageID], row)
+	}
+	return counts, nil
+}
+
+// reactionPictographs 是可以单独作为回应的 emoji 图形字符，包括区域指示符和肤色修饰所在的 U+1F000 区段
+var reactionPictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00a9, Stride: 1},
+		{Lo: 0x00ae, Hi: 0x00ae, Stride: 1},
+		{Lo: 0x203c, Hi: 0x203c, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2122, Stride: 1},
+		{Lo: 0x2139, Hi: 0x2139, Stride: 1},
+		{Lo: 0x2194, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303d, Hi: 0x303d, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3297, Stride: 1},
+		{Lo: 0x3299, Hi: 0x3299, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+	LatinOffset: 2,
+}
+
+// validReactionEmoji 只接受由 emoji 组成的序列：图形字符、ZWJ、变体选择符、标签字符，以及键帽序列中的 0-9、# 和 *，
+// 至少包含一个图形字符或键帽符号，普通文字和空白一律拒绝。
+func validReactionEmoji(emoji string) bool {
+	if emoji == "" || !utf8.ValidString(emoji) || utf8.RuneCountInString(emoji) > MaxReactionEmojiRunes {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(reactionPictographs, r), r == 0x20e3:
+			hasSymbol = true
+		case r == 0x200d, r == 0xfe0e, r == 0xfe0f, r >= 0xe0020 && r <= 0xe007f:
+		case r >= '0' && r <= '9', r == '#', r == '*':
+		default:
+			return false
+		}
+	}
+	return hasSymbol
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestReactToMessageAddsReactionAndRecordsReactionUpdates(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	now := time.Date(2026, 7, 22, 9, 0, 0, 0, time.UTC)
+	expectRecallMessage(mock, 51, 1001, 1002, now.Add(-time.Hour).Format(time.RFC3339), false, false, "", 0)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_reactions" \("message_id","user_id","emoji","created_at"\) VALUES \(\$1,\$2,\$3,\$4\) ON CONFLICT DO NOTHING`).
+		WithArgs(int64(51), int64(1002), "👍", now.Format(time.RFC3339)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_reaction_sequences" \("user_id","last_seq"\) VALUES \(\$1,\$2\),\(\$3,\$4\) ON CONFLICT \("user_id"\) DO UPDATE SET "last_seq"=user_reaction_sequences.last_seq \+ 1 RETURNING "user_id","last_seq"`).
+		WithArgs(int64(1001), int64(1), int64(1002), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1001, 8).AddRow(1002, 5))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "reaction_updates" \("user_id","message_id","seq"\) VALUES \(\$1,\$2,\$3\),\(\$4,\$5,\$6\) ON CONFLICT \("user_id","message_id"\) DO UPDATE SET "seq"="excluded"."seq"`).
+		WithArgs(int64(1001), int64(51), int64(8), int64(1002), int64(51), int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT message_id, emoji, COUNT\(\*\) AS count, BOOL_OR\(user_id = \$1\) AS reacted_by_me, MIN\(created_at\) AS first_at FROM "message_reactions" WHERE message_id IN \(\$2\) GROUP BY message_id, emoji ORDER BY message_id ASC, first_at ASC, emoji ASC`).
+		WithArgs(int64(1002), int64(51)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "emoji", "count", "reacted_by_me", "first_at"}).
+			AddRow(51, "👍", 2, true, now.Add(-time.Minute).Format(time.RFC3339)))
+
+	outcome, err := ReactToMessageWithDB(database, 1002, 51, "👍", false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != MessageReactionOK || !outcome.Changed || len(outcome.ReaderIDs) != 2 {
+		t.Fatalf("unexpected reaction outcome: %+v", outcome)
+	}
+	if len(outcome.Reactions) != 1 || outcome.Reactions[0].Count != 2 || !outcome.Reactions[0].ReactedByMe {
+		t.Fatalf("unexpected reaction counts: %+v", outcome.Reactions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReactToMessageRejectsUnreadableRecalledAndInvalidEmoji(t *testing.T) {
+	now := time.Date(2026, 7, 22, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		userID     int64
+		emoji      string
+		isRecalled bool
+		wantStatus MessageReactionStatus
+	}{
+		{name: "unrelated user", userID: 1003, emoji: "👍", wantStatus: MessageReactionNotFound},
+		{name: "recalled", userID: 1002, emoji: "👍", isRecalled: true, wantStatus: MessageReactionRecalled},
+		{name: "blank emoji", userID: 1002, emoji: "", wantStatus: MessageReactionInvalid},
+		{name: "padded emoji", userID: 1002, emoji: " 👍", wantStatus: MessageReactionInvalid},
+		{name: "too long", userID: 1002, emoji: "👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍", wantStatus: MessageReactionInvalid},
+		{name: "plain text", userID: 1002, emoji: "lol", wantStatus: MessageReactionInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectRecallMessage(mock, 52, 1001, 1002, now.Add(-time.Hour).Format(time.RFC3339), false, test.isRecalled, "", 0)
+			outcome, err := ReactToMessageWithDB(database, test.userID, 52, test.emoji, false, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Status != test.wantStatus || outcome.Changed {
+				t.Fatalf("status=%v changed=%v want=%v", outcome.Status, outcome.Changed, test.wantStatus)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestValidReactionEmojiAcceptsOnlyEmojiSequences(t *testing.T) {
+	tests := map[string]bool{
+		"👍":       true,
+		"👍🏽":      true,
+		"❤️":      true,
+		"👨‍👩‍👧":   true,
+		"🇨🇳":      true,
+		"#️⃣":     true,
+		"🏴󠁧󠁢󠁳󠁣󠁴󠁿": true,
+		"a":       false,
+		"1":       false,
+		"好":       false,
+		"👍 ":      false,
+		"👍!":      false,
+	}
+	for emoji, want := range tests {
+		if got := validReactionEmoji(emoji); got != want {
+			t.Fatalf("validReactionEmoji(%q)=%v want %v", emoji, got, want)
+		}
+	}
+}
@@ -28,8 +28,8 @@ func TestRecallMessageOwnRecentMessageUpdatesTombstone(t *testing.T) {
 	now := time.Date(2026, 7, 21, 4, 0, 30, 0, time.UTC)
 	expectRecallMessage(mock, 41, 1001, 1002, now.Add(-30*time.Second).Format(time.RFC3339), false, false, "", 0)
 	mock.ExpectBegin()
-	mock.ExpectExec(`UPDATE "messages" SET "is_recalled"=\$1,"recalled_at"=\$2,"recalled_by"=\$3 WHERE message_id = \$4 AND is_recalled = \$5`).
-		WithArgs(true, now.Format(time.RFC3339), int64(1001), int64(41), false).
+	mock.ExpectExec(`UPDATE "messages" SET "is_recalled"=\$1,"recalled_at"=\$2,"recalled_by"=\$3,"search_tokens"=\$4 WHERE message_id = \$5 AND is_recalled = \$6`).
+		WithArgs(true, now.Format(time.RFC3339), int64(1001), "", int64(41), false).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxReceiptBatch 限制单次回执处理的消息数，会话水位线只覆盖最近的未回执消息。
+const MaxReceiptBatch = 200
+
+// MessageReceiptRequest 描述一次送达/已读回执：指定 MessageIDs，或以会话水位线标记
+// 会话中 message_id 不超过 UpToMessageID 的消息。单聊的 ConversationID 为对方用户ID，群聊为群ID。
+type MessageReceiptRequest struct {
+	UserID         int64
+	Read           bool
+	MessageIDs     []int64
+	ConversationID int64
+	IsGroup        bool
+	UpToMessageID  int64
+}
+
+// MessageReceiptUpdate 是本次回执实际改变状态的消息及其最新的回执计数。
+type MessageReceiptUpdate struct {
+	Message        Message
+	DeliveredCount int64
+	ReadCount      int64
+}
+
+type messageReceiptCount struct {
+	MessageID      int64 `gorm:"column:message_id"`
+	DeliveredCount int64 `gorm:"column:delivered_count"`
+	ReadCount      int64 `gorm:"column:read_count"`
+}
+
+// MarkMessageReceiptsWithDB 记录接收方回执并返回状态发生变化的消息。只有消息的接收方
+// （单聊收件人或发送时已在群内的成员）可以回执，自己发送或已撤回的消息会被忽略，重复回执不会再次返回。
+func MarkMessageReceiptsWithDB(database *gorm.DB, request MessageReceiptRequest, now time.Time) ([]MessageReceiptUpdate, error) {
+	if database == nil {
+		return nil, errors.New("message receipt database is nil")
+	}
+	if request.UserID <= 0 {
+		return nil, nil
+	}
+	candidates, err := receiptCandidateMessages(database, request)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+	messages, err := filterReceiptRecipientMessages(database, request.UserID, candidates)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+
+	messageIDs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	var existing []MessageReceipt
+	if err := database.Where("user_id = ? AND message_id IN ?", request.UserID, messageIDs).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	existingReceipts := make(map[int64]MessageReceipt, len(existing))
+	for _, receipt := range existing {
+		existingReceipts[receipt.MessageID] = receipt
+	}
+
+	at := now.UTC().Format(time.RFC3339)
+	var created []MessageReceipt
+	var markRead []int64
+	changed := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		receipt, exists := existingReceipts[message.MessageID]
+		switch {
+		case !exists:
+			row := MessageReceipt{MessageID: message.MessageID, UserID: request.UserID, DeliveredAt: at}
+			if request.Read {
+				row.ReadAt = at
+			}
+			created = append(created, row)
+		case request.Read && receipt.ReadAt == "":
+			markRead = append(markRead, message.MessageID)
+		default:
+			continue
+		}
+		changed = append(changed, message)
+	}
+	if len(created) > 0 {
+		if err := database.Clauses(clause.OnConflict{DoNothing: true}).Create(&created).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(markRead) > 0 {
+		if err := database.Model(&MessageReceipt{}).
+			Where("user_id = ? AND message_id IN ? AND read_at = ?", request.UserID, markRead, "").
+			Update("read_at", at).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return withReceiptCounts(database, changed)
+}
+
+func receiptCandidateMessages(database *gorm.DB, request MessageReceiptRequest) ([]Message, error) {
+	var messages []Message
+	if len(request.MessageIDs) > 0 {
+		messageIDs := uniquePositiveIDs(request.MessageIDs, MaxReceiptBatch)
+		if len(messageIDs) == 0 {
+			return nil, nil
+		}
+		err := database.
+			Where("message_id IN ? AND from_user_id <> ? AND is_recalled = ?", messageIDs, request.UserID, false).
+			Order("message_id ASC").
+			Find(&messages).Error
+		return messages, err
+	}
+	if request.ConversationID <= 0 || request.UpToMessageID <= 0 {
+		return nil, nil
+	}
+
+	query := database.Model(&Message{})
+	if request.IsGroup {
+		query = query.Where("is_group = ? AND to_user_id = ? AND from_user_id <> ?", true, request.ConversationID, request.UserID)
+	} else {
+		query = query.Where("is_group = ? AND from_user_id = ? AND to_user_id = ?", false, request.ConversationID, request.UserID)
+	}
+	unreceipted := "NOT EXISTS (SELECT 1 FROM message_receipts WHERE message_receipts.message_id = messages.message_id AND message_receipts.user_id = ?)"
+	if request.Read {
+		unreceipted = "NOT EXISTS (SELECT 1 FROM message_receipts WHERE message_receipts.message_id = messages.message_id AND message_receipts.user_id = ? AND message_receipts.read_at <> '')"
+	}
+	err := query.
+		Where("message_id <= ? AND is_recalled = ?", request.UpToMessageID, false).
+		Where(unreceipted, request.UserID).
+		Order("message_id DESC").
+		Limit(MaxReceiptBatch).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].MessageID < messages[j].MessageID })
+	return messages, nil
+}
+
+// filterReceiptRecipientMessages 与 CanUserReadMessageWithDB 的规则一致，但每个群只查询一次成员关系。
+func filterReceiptRecipientMessages(database *gorm.DB, userID int64, messages []Message) ([]Message, error) {
+	joinedAt := make(map[int64]string)
+	result := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		if message.FromUserID == userID {
+			continue
+		}
+		if !message.IsGroup {
+			if message.ToUserID == userID {
+				result = append(result, message)
+			}
+			continue
+		}
+		joined, loaded := joinedAt[message.ToUserID]
+		if !loaded {
+			var member GroupMember
+			err := database.Where("group_id = ? AND user_id = ?", message.ToUserID, userID).Limit(1).Find(&member).Error
+			if err != nil {
+				return nil, err
+			}
+			if member.GroupID != 0 {
+				joined = member.JoinedAt
+				if joined == "" {
+					joined = member.UpdateTime
+				}
+			}
+			joinedAt[message.ToUserID] = joined
+		}
+		if joined != "" && joined <= message.Timestamp {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+func withReceiptCounts(database *gorm.DB, messages []Message) ([]MessageReceiptUpdate, error) {
+	messageIDs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	var counts []messageReceiptCount
+	err := database.Model(&MessageReceipt{}).
+		Select("message_id, COUNT(*) AS delivered_count, COUNT(NULLIF(read_at, '')) AS read_count").
+		Where("message_id IN ?", messageIDs).
+		Group("message_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	countByMessage := make(map[int64]messageReceiptCount, len(counts))
+	for _, count := range counts {
+		countByMessage[count.MessageID] = count
+	}
+	updates := make([]MessageReceiptUpdate, 0, len(messages))
+	for _, message := range messages {
+		count := countByMessage[message.MessageID]
+		updates = append(updates, MessageReceiptUpdate{
+			Message:        message,
+			DeliveredCount: count.DeliveredCount,
+			ReadCount:      count.ReadCount,
+		})
+	}
+	return updates, nil
+}
+
+func uniquePositiveIDs(ids []int64, limit int) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			continue
+		}
+		if _, exists := seen[id]; exists {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+		if len(result) == limit {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMarkMessageReceiptsReadSkipsForeignAndAlreadyReadMessages(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	now := time.Date(2026, 7, 21, 4, 0, 0, 0, time.UTC)
+	at := now.Format(time.RFC3339)
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id IN \(\$1,\$2,\$3,\$4\) AND from_user_id <> \$5 AND is_recalled = \$6 ORDER BY message_id ASC`).
+		WithArgs(int64(41), int64(42), int64(43), int64(44), int64(1002), false).
+		WillReturnRows(sqlmock.NewRows(recallMessageColumns).
+			AddRow(41, nil, 1001, 1002, "direct", "2026-07-21T03:00:00Z", "text", "", false, false, "", 0).
+			AddRow(42, nil, 1001, 1003, "not for reader", "2026-07-21T03:00:00Z", "text", "", false, false, "", 0).
+			AddRow(43, nil, 1001, 7, "group", "2026-07-21T03:00:00Z", "text", "", true, false, "", 0).
+			AddRow(44, nil, 1001, 1002, "already read", "2026-07-21T03:00:00Z", "text", "", false, false, "", 0))
+	mock.ExpectQuery(`SELECT \* FROM "group_members" WHERE group_id = \$1 AND user_id = \$2 LIMIT \$3`).
+		WithArgs(int64(7), int64(1002), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "role", "joined_at", "update_time"}).
+			AddRow(7, 1002, "member", "2026-07-20T00:00:00Z", "2026-07-20T00:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "message_receipts" WHERE user_id = \$1 AND message_id IN \(\$2,\$3,\$4\)`).
+		WithArgs(int64(1002), int64(41), int64(43), int64(44)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "delivered_at", "read_at"}).
+			AddRow(43, 1002, at, "").
+			AddRow(44, 1002, at, at))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_receipts" \("message_id","user_id","delivered_at","read_at"\) VALUES \(\$1,\$2,\$3,\$4\) ON CONFLICT DO NOTHING`).
+		WithArgs(int64(41), int64(1002), at, at).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message_receipts" SET "read_at"=\$1 WHERE user_id = \$2 AND message_id IN \(\$3\) AND read_at = \$4`).
+		WithArgs(at, int64(1002), int64(43), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT message_id, COUNT\(\*\) AS delivered_count, COUNT\(NULLIF\(read_at, ''\)\) AS read_count FROM "message_receipts" WHERE message_id IN \(\$1,\$2\) GROUP BY "message_id"`).
+		WithArgs(int64(41), int64(43)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "delivered_count", "read_count"}).
+			AddRow(41, 1, 1).
+			AddRow(43, 3, 2))
+
+	updates, err := MarkMessageReceiptsWithDB(database, MessageReceiptRequest{UserID: 1002, Read: true, MessageIDs: []int64{41, 42, 41, 43, 0, 44}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[0].Message.MessageID != 41 || updates[0].ReadCount != 1 ||
+		updates[1].Message.MessageID != 43 || updates[1].DeliveredCount != 3 || updates[1].ReadCount != 2 {
+		t.Fatalf("unexpected receipt updates: %+v", updates)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkMessageReceiptsDeliveredWatermarkSkipsMessagesBeforeJoin(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	now := time.Date(2026, 7, 21, 4, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE \(is_group = \$1 AND to_user_id = \$2 AND from_user_id <> \$3\) AND \(message_id <= \$4 AND is_recalled = \$5\) AND \(NOT EXISTS \(SELECT 1 FROM message_receipts WHERE message_receipts.message_id = messages.message_id AND message_receipts.user_id = \$6\)\) ORDER BY message_id DESC LIMIT \$7`).
+		WithArgs(true, int64(7), int64(1002), int64(50), false, int64(1002), MaxReceiptBatch).
+		WillReturnRows(sqlmock.NewRows(recallMessageColumns).
+			AddRow(50, nil, 1001, 7, "after join", "2026-07-21T03:00:00Z", "text", "", true, false, "", 0).
+			AddRow(30, nil, 1001, 7, "before join", "2026-07-19T03:00:00Z", "text", "", true, false, "", 0))
+	mock.ExpectQuery(`SELECT \* FROM "group_members" WHERE group_id = \$1 AND user_id = \$2 LIMIT \$3`).
+		WithArgs(int64(7), int64(1002), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "role", "joined_at", "update_time"}).
+			AddRow(7, 1002, "member", "2026-07-20T00:00:00Z", "2026-07-20T00:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM "message_receipts" WHERE user_id = \$1 AND message_id IN \(\$2\)`).
+		WithArgs(int64(1002), int64(50)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "user_id", "delivered_at", "read_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "message_receipts"`).
+		WithArgs(int64(50), int64(1002), now.Format(time.RFC3339), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT message_id, COUNT\(\*\)`).
+		WithArgs(int64(50)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "delivered_count", "read_count"}).AddRow(50, 4, 0))
+
+	updates, err := MarkMessageReceiptsWithDB(database, MessageReceiptRequest{UserID: 1002, ConversationID: 7, IsGroup: true, UpToMessageID: 50}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Message.MessageID != 50 || updates[0].DeliveredCount != 4 {
+		t.Fatalf("unexpected receipt updates: %+v", updates)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// QuotedMessagePreview 是回复消息携带的被引用消息摘要，被引用消息撤回后只保留撤回标记。
+type QuotedMessagePreview struct {
+	MessageID   int64
+	FromUserID  int64
+	MessageType string
+	Snippet     string
+	IsRecalled  bool
+}
+
+// CanReplyToMessageWithDB 检查被引用消息与新消息属于同一会话且发送者可读，replyToMessageID 为 0 表示不是回复。
+// 已撤回的消息仍可被引用，预览显示为撤回占位。
+func CanReplyToMessageWithDB(database *gorm.DB, fromUserID, toUserID int64, isGroup bool, replyToMessageID int64) (bool, error) {
+	if replyToMessageID == 0 {
+		return true, nil
+	}
+	if replyToMessageID < 0 {
+		return false, nil
+	}
+	if database == nil {
+		return false, errors.New("message reply database is nil")
+	}
+	quoted, err := GetMessageByIDWithDB(database, replyToMessageID)
+	if err != nil || quoted == nil {
+		return false, err
+	}
+	if quoted.IsGroup != isGroup {
+		return false, nil
+	}
+	if isGroup && quoted.ToUserID != toUserID {
+		return false, nil
+	}
+	if !isGroup && !sameDirectConversation(quoted, fromUserID, toUserID) {
+		return false, nil
+	}
+	return CanUserReadMessageWithDB(database, fromUserID, quoted)
+}
+
+func sameDirectConversation(message *Message, userID, peerID int64) bool {
+	return (message.FromUserID == userID && message.ToUserID == peerID) ||
+		(message.FromUserID == peerID && message.ToUserID == userID)
+}
+
+// GetQuotedMessagePreviewsWithDB 按消息ID批量读取 viewerUserID 看到的引用预览，缺失的消息不出现在结果中。
+// 可读范围与 CanUserReadMessageWithDB 一致，被引用消息对 viewerUserID 不可读（例如在该消息之后才入群）时不返回摘要。
+func GetQuotedMessagePreviewsWithDB(database *gorm.DB, viewerUserID int64, messageIDs []int64) (map[int64]QuotedMessagePreview, error) {
+	previews := make(map[int64]QuotedMessagePreview, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return previews, nil
+	}
+	if database == nil {
+		return nil, errors.New("message reply database is nil")
+	}
+
+	var rows []struct {
+		Message
+		Readable bool
+	}
+	err := database.Raw(`
+SELECT m.message_id, m.from_user_id, m.content, m.message_type, m.real_file_name, m.is_recalled,
+	CASE
+		WHEN m.from_user_id = ? THEN TRUE
+		WHEN NOT m.is_group THEN m.to_user_id = ?
+		ELSE EXISTS (
+			SELECT 1 FROM group_members AS gm
+			WHERE gm.group_id = m.to_user_id AND gm.user_id = ? AND COALESCE(NULLIF(gm.joined_at, ''), gm.update_time) <= m.timestamp
+		)
+	END AS readable
+FROM messages AS m
+WHERE m.message_id IN ?
+`, viewerUserID, viewerUserID, viewerUserID, messageIDs).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		preview := QuotedMessagePreview{
+			MessageID:   rows[i].MessageID,
+			FromUserID:  rows[i].FromUserID,
+			MessageType: rows[i].MessageType,
+			IsRecalled:  rows[i].IsRecalled,
+		}
+		if !preview.IsRecalled && rows[i].Readable {
+			preview.Snippet = conversationSnippet(&rows[i].Message)
+		}
+		previews[preview.MessageID] = preview
+	}
+	return previews, nil
+}
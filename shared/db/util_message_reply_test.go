@@ -0,0 +1,105 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectQuotedMessage(mock sqlmock.Sqlmock, messageID, fromUserID, toUserID int64, isGroup bool) {
+	mock.ExpectQuery(`SELECT \* FROM "messages" WHERE message_id = \$1 ORDER BY "messages"\."message_id" LIMIT \$2`).
+		WithArgs(messageID, int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "timestamp", "is_group"}).
+			AddRow(messageID, fromUserID, toUserID, "2026-07-23T08:00:00Z", isGroup))
+}
+
+func TestCanReplyToMessageRequiresSameConversation(t *testing.T) {
+	tests := []struct {
+		name      string
+		fromID    int64
+		toID      int64
+		isGroup   bool
+		quotedTo  int64
+		quotedGrp bool
+		want      bool
+	}{
+		{name: "peer message", fromID: 1002, toID: 1001, quotedTo: 1002, want: true},
+		{name: "other direct conversation", fromID: 1002, toID: 1003, quotedTo: 1002, want: false},
+		{name: "group message into direct chat", fromID: 1002, toID: 1001, quotedTo: 7, quotedGrp: true, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, mock := newInboxDatabase(t)
+			expectQuotedMessage(mock, 61, 1001, test.quotedTo, test.quotedGrp)
+			ok, err := CanReplyToMessageWithDB(database, test.fromID, test.toID, test.isGroup, 61)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.want {
+				t.Fatalf("can reply=%v want=%v", ok, test.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCanReplyToGroupMessageChecksReadability(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	expectQuotedMessage(mock, 62, 1001, 7, true)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "group_members" WHERE group_id = \$1 AND user_id = \$2 AND COALESCE\(NULLIF\(joined_at, ''\), update_time\) <= \$3`).
+		WithArgs(int64(7), int64(1005), "2026-07-23T08:00:00Z").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	ok, err := CanReplyToMessageWithDB(database, 1005, 7, true, 62)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("member who joined after the quoted message must not reply to it")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotedMessagePreviewsMaskRecalledContent(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT m\.message_id, m\.from_user_id, m\.content, m\.message_type, m\.real_file_name, m\.is_recalled,.+ AS readable\s+FROM messages AS m\s+WHERE m\.message_id IN \(\$4,\$5\)`).
+		WithArgs(int64(1001), int64(1001), int64(1001), int64(61), int64(62)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "content", "message_type", "real_file_name", "is_recalled", "readable"}).
+			AddRow(61, 1001, "hello", "text", "", false, true).
+			AddRow(62, 1002, "secret", "text", "", true, true))
+
+	previews, err := GetQuotedMessagePreviewsWithDB(database, 1001, []int64{61, 62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previews[61].Snippet != "hello" || previews[61].IsRecalled || !previews[62].IsRecalled || previews[62].Snippet != "" {
+		t.Fatalf("unexpected quoted previews: %+v", previews)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotedMessagePreviewsMaskContentForLateJoiner(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`EXISTS \(\s+SELECT 1 FROM group_members AS gm\s+WHERE gm\.group_id = m\.to_user_id AND gm\.user_id = \$3 AND COALESCE\(NULLIF\(gm\.joined_at, ''\), gm\.update_time\) <= m\.timestamp`).
+		WithArgs(int64(1005), int64(1005), int64(1005), int64(62)).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "content", "message_type", "real_file_name", "is_recalled", "readable"}).
+			AddRow(62, 1002, "before you joined", "text", "", false, false))
+
+	previews, err := GetQuotedMessagePreviewsWithDB(database, 1005, []int64{62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, exists := previews[62]
+	if !exists || preview.Snippet != "" || preview.IsRecalled || preview.MessageID != 62 {
+		t.Fatalf("late joiner must not see the quoted content: %+v", previews)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxMessageSearchQueryRunes 是搜索词的最大长度
+	MaxMessageSearchQueryRunes = 64
+	// maxMessageSearchTerms 限制一次搜索展开后的检索项数量，超出部分忽略
+	maxMessageSearchTerms = 16
+	// maxMessageSearchTokenRunes 截断过长的单词，PostgreSQL 不索引超过 2047 字节的词
+	maxMessageSearchTokenRunes = 64
+	messageSearchBackfillBatch = 500
+)
+
+// MessageSearchFilter 是搜索的可选过滤条件，零值表示不限
+type MessageSearchFilter struct {
+	PeerOrGroupID int64 // 单聊为对方用户ID，群聊为群ID
+	IsGroup       bool
+	Since         string // RFC3339，包含
+	Until         string // RFC3339，不包含
+	MessageType   string
+}
+
+type MessageSearchPage struct {
+	Messages            []Message
+	HasMore             bool
+	NextBeforeMessageID int64
+}
+
+// MessageSearchTokens 把消息的可检索文本转换为空格分隔的词，写入 messages.search_tokens。
+// 中日韩文字没有空格分词，按单字和相邻两字切分；其他文字按字母数字连续段切分并转为小写。
+// 文本和链接消息检索内容，文件消息检索原始文件名，其他类型不参与检索。
+func MessageSearchTokens(messageType, content, realFileName string) string {
+	var text string
+	switch messageType {
+	case "text", "link":
+		text = content
+	case "file":
+		text = realFileName
+	default:
+		return ""
+	}
+
+	seen := make(map[string]struct{})
+	var tokens []string
+	add := func(token string) {
+		if _, ok := seen[token]; ok {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	forEachMessageSearchRun(text, func(run []rune, cjk bool) {
+		if !cjk {
+			add(string(run))
+			return
+		}
+		for i := range run {
+			add(string(run[i]))
+			if i+1 < len(run) {
+				add(string(run[i : i+2]))
+			}
+		}
+	})
+	return strings.Join(tokens, " ")
+}
+
+// MessageSearchQuery 把用户输入转换为 to_tsquery 表达式，所有检索项都要命中。
+// 中日韩连续段按相邻两字匹配，单字时按单字匹配；其他单词按前缀匹配。没有可检索内容时返回空串。
+func MessageSearchQuery(query string) string {
+	seen := make(map[string]struct{})
+	var terms []string
+	add := func(term string) {
+		if _, ok := seen[term]; ok || len(terms) >= maxMessageSearchTerms {
+			return
+		}
+		seen[term] = struct{}{}
+		terms = append(terms, term)
+	}
+	forEachMessageSearchRun(query, func(run []rune, cjk bool) {
+		switch {
+		case !cjk:
+			add(string(run) + ":*")
+		case len(run) == 1:
+			add(string(run))
+		default:
+			for i := 0; i+1 < len(run); i++ {
+				add(string(run[i : i+2]))
+			}
+		}
+	})
+	return strings.Join(terms, " & ")
+}
+
+// forEachMessageSearchRun 按字符类别切分文本，标点、空白和符号都视为分隔符
+func forEachMessageSearchRun(text string, visit func(run []rune, cjk bool)) {
+	var run []rune
+	runCJK := false
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if !runCJK && len(run) > maxMessageSearchTokenRunes {
+			run = run[:maxMessageSearchTokenRunes]
+		}
+		visit(run, runCJK)
+		run = nil
+	}
+	for _, r := range strings.ToLower(text) {
+		cjk := isMessageSearchCJK(r)
+		if !cjk && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if len(run) > 0 && cjk != runCJK {
+			flush()
+		}
+		runCJK = cjk
+		run = append(run, r)
+	}
+	flush()
+}
+
+func isMessageSearchCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// SearchMessagesPageWithDB 在 userID 可读的消息中全文检索，按 message_id 倒序分页。
+// 可读规则与 CanUserReadMessageWithDB 一致：单聊为收发双方，群聊为发送者或入群时间不晚于消息时间的当前成员；
+// 已撤回的消息不会命中。query 没有可检索内容时返回空页。
+func SearchMessagesPageWithDB(database *gorm.DB, userID int64, query string, filter MessageSearchFilter, beforeMessageID int64, pageSize int) (*MessageSearchPage, error) {
+	if database == nil {
+		return nil, errors.New("message search database is nil")
+	}
+	tsQuery := MessageSearchQuery(query)
+	if userID <= 0 || tsQuery == "" {
+		return &MessageSearchPage{}, nil
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultHistoryPageSize
+	}
+	if pageSize > MaxHistoryPageSize {
+		pageSize = MaxHistoryPageSize
+	}
+
+	search := database.Model(&Message{}).
+		Select("messages.*").
+		Joins("LEFT JOIN group_members ON messages.is_group = ? AND group_members.group_id = messages.to_user_id AND group_members.user_id = ?", true, userID).
+		Where("to_tsvector('simple', messages.search_tokens) @@ to_tsquery('simple', ?)", tsQuery).
+		Where("messages.is_recalled = ?", false).
+		Where("(messages.is_group = ? AND (messages.from_user_id = ? OR messages.to_user_id = ?)) OR "+
+			"(messages.is_group = ? AND (messages.from_user_id = ? OR messages.timestamp >= COALESCE(NULLIF(group_members.joined_at, ''), group_members.update_time)))",
+			false, userID, userID, true, userID)
+	if filter.PeerOrGroupID > 0 {
+		if filter.IsGroup {
+			search = search.Where("messages.is_group = ? AND messages.to_user_id = ?", true, filter.PeerOrGroupID)
+		} else {
+			search = search.Where("messages.is_group = ? AND ((messages.to_user_id = ? AND messages.from_user_id = ?) OR (messages.to_user_id = ? AND messages.from_user_id = ?))",
+				false, filter.PeerOrGroupID, userID, userID, filter.PeerOrGroupID)
+		}
+	}
+	if filter.Since != "" {
+		search = search.Where("messages.timestamp >= ?", filter.Since)
+	}
+	if filter.Until != "" {
+		search = search.Where("messages.timestamp < ?", filter.Until)
+	}
+	if filter.MessageType != "" {
+		search = search.Where("messages.message_type = ?", filter.MessageType)
+	}
+	if beforeMessageID > 0 {
+		search = search.Where("messages.message_id < ?", beforeMessageID)
+	}
+
+	var messages []Message
+	err := search.
+		Order("messages.message_id DESC").
+		Limit(pageSize + 1).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessageSearchPage{HasMore: len(messages) > pageSize}
+	if page.HasMore {
+		messages = messages[:pageSize]
+		page.NextBeforeMessageID = messages[len(messages)-1].MessageID
+	}
+	page.Messages = messages
+	return page, nil
+}
+
+// BackfillMessageSearchTokensWithDB 为升级前的消息补齐检索分词，已撤回的消息写入空串。
+// 每批 messageSearchBackfillBatch 行用一条 UPDATE ... FROM (VALUES ...) 写入，在事务外调用时每批单独提交。
+func BackfillMessageSearchTokensWithDB(database *gorm.DB) error {
+	var lastMessageID int64
+	for {
+		var messages []Message
+		err := database.
+			Select("message_id", "content", "message_type", "real_file_name", "is_recalled").
+			Where("search_tokens IS NULL AND message_id > ?", lastMessageID).
+			Order("message_id ASC").
+			Limit(messageSearchBackfillBatch).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		rows := make([]string, 0, len(messages))
+		args := make([]any, 0, 2*len(messages))
+		for _, message := range messages {
+			tokens := ""
+			if !message.IsRecalled {
+				tokens = MessageSearchTokens(message.MessageType, message.Content, message.RealFileName)
+			}
+			rows = append(rows, "(CAST(? AS bigint), CAST(? AS text))")
+			args = append(args, message.MessageID, tokens)
+		}
+		err = database.Exec(`UPDATE messages SET search_tokens = batch.tokens FROM (VALUES `+strings.Join(rows, ", ")+
+			`) AS batch(message_id, tokens) WHERE messages.message_id = batch.message_id`, args...).Error
+		if err != nil {
+			return err
+		}
+		if len(messages) < messageSearchBackfillBatch {
+			return nil
+		}
+		lastMessageID = messages[len(messages)-1].MessageID
+	}
+}
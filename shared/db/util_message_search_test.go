@@ -0,0 +1,114 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMessageSearchTokensSplitsCJKIntoGrams(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType string
+		content     string
+		fileName    string
+		want        string
+	}{
+		{name: "mixed text", messageType: "text", content: "明天开会, Meeting at 3PM 明天", want: "明 明天 天 天开 开 开会 会 meeting at 3pm"},
+		{name: "kana and hangul", messageType: "link", content: "カメラ 사진", want: "カ カメ メ メラ ラ 사 사진 진"},
+		{name: "file name", messageType: "file", content: "sha512-report", fileName: "Q3_report.pdf", want: "q3 report pdf"},
+		{name: "image", messageType: "image", content: "https://cdn.example.com/cat.png", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MessageSearchTokens(test.messageType, test.content, test.fileName); got != test.want {
+				t.Fatalf("tokens=%q want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageSearchQueryMatchesIndexedGrams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "开会", want: "开会"},
+		{query: "明天开会", want: "明天 & 天开 & 开会"},
+		{query: "会 Meet", want: "会 & meet:*"},
+		{query: "  !!  ", want: ""},
+	}
+	for _, test := range tests {
+		if got := MessageSearchQuery(test.query); got != test.want {
+			t.Fatalf("query %q=%q want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSearchMessagesPageAppliesReadabilityAndFilters(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT messages\.\* FROM "messages" LEFT JOIN group_members ON messages\.is_group = \$1 AND group_members\.group_id = messages\.to_user_id AND group_members\.user_id = \$2 `+
+		`WHERE to_tsvector\('simple', messages\.search_tokens\) @@ to_tsquery\('simple', \$3\) AND messages\.is_recalled = \$4 `+
+		`AND \(\(messages\.is_group = \$5 AND \(messages\.from_user_id = \$6 OR messages\.to_user_id = \$7\)\) OR \(messages\.is_group = \$8 AND \(messages\.from_user_id = \$9 OR messages\.timestamp >= COALESCE\(NULLIF\(group_members\.joined_at, ''\), group_members\.update_time\)\)\)\) `+
+		`AND \(messages\.is_group = \$10 AND messages\.to_user_id = \$11\) AND messages\.timestamp >= \$12 AND messages\.timestamp < \$13 AND messages\.message_type = \$14 AND messages\.message_id < \$15 `+
+		`ORDER BY messages\.message_id DESC LIMIT \$16`).
+		WithArgs(true, int64(1002), "开会", false, false, int64(1002), int64(1002), true, int64(1002), true, int64(7),
+			"2026-08-01T00:00:00Z", "2026-09-01T00:00:00Z", "text", int64(90), 3).
+		WillReturnRows(sqlmock.NewRows(recallMessageColumns).
+			AddRow(88, nil, 1001, 7, "明天开会", "2026-08-02T08:00:00Z", "text", "", true, false, "", 0).
+			AddRow(80, nil, 1003, 7, "开会改到周五", "2026-08-01T08:00:00Z", "text", "", true, false, "", 0).
+			AddRow(75, nil, 1002, 7, "谁来开会", "2026-08-01T07:00:00Z", "text", "", true, false, "", 0))
+
+	page, err := SearchMessagesPageWithDB(database, 1002, "开会", MessageSearchFilter{
+		PeerOrGroupID: 7,
+		IsGroup:       true,
+		Since:         "2026-08-01T00:00:00Z",
+		Until:         "2026-09-01T00:00:00Z",
+		MessageType:   "text",
+	}, 90, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Messages) != 2 || page.NextBeforeMessageID != 80 {
+		t.Fatalf("unexpected search page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchMessagesPageSkipsQueriesWithoutTerms(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+
+	page, err := SearchMessagesPageWithDB(database, 1002, "?!", MessageSearchFilter{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.HasMore || len(page.Messages) != 0 {
+		t.Fatalf("unexpected search page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackfillMessageSearchTokensWritesOneUpdatePerPage(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT "message_id","content","message_type","real_file_name","is_recalled" FROM "messages" WHERE search_tokens IS NULL AND message_id > \$1 ORDER BY message_id ASC LIMIT \$2`).
+		WithArgs(int64(0), messageSearchBackfillBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "content", "message_type", "real_file_name", "is_recalled"}).
+			AddRow(3, "明天开会", "text", "", false).
+			AddRow(5, "secret", "text", "", true).
+			AddRow(8, "sha512", "file", "Q3.pdf", false))
+	mock.ExpectExec(`UPDATE messages SET search_tokens = batch\.tokens FROM \(VALUES \(CAST\(\$1 AS bigint\), CAST\(\$2 AS text\)\), \(CAST\(\$3 AS bigint\), CAST\(\$4 AS text\)\), \(CAST\(\$5 AS bigint\), CAST\(\$6 AS text\)\)\) `+
+		`AS batch\(message_id, tokens\) WHERE messages\.message_id = batch\.message_id`).
+		WithArgs(int64(3), "明 明天 天 天开 开 开会 会", int64(5), "", int64(8), "q3 pdf").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := BackfillMessageSearchTokensWithDB(database); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
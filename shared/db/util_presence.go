@@ -0,0 +1,45 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetHiddenLastSeenUsers 返回 userIDs 中开启了“隐藏最后在线时间”的用户集合。
+func GetHiddenLastSeenUsers(userIDs []int64) (map[int64]bool, error) {
+	return GetHiddenLastSeenUsersWithDB(DB(), userIDs)
+}
+
+func GetHiddenLastSeenUsersWithDB(database *gorm.DB, userIDs []int64) (map[int64]bool, error) {
+	hidden := make(map[int64]bool)
+	if len(userIDs) == 0 {
+		return hidden, nil
+	}
+	var hiddenIDs []int64
+	err := database.Model(&UserPresenceSetting{}).
+		Where("user_id IN ? AND hide_last_seen = ?", userIDs, true).
+		Pluck("user_id", &hiddenIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range hiddenIDs {
+		hidden[userID] = true
+	}
+	return hidden, nil
+}
+
+// SetHideLastSeenWithDB 写入用户的最后在线时间隐私设置，不存在时创建。
+func SetHideLastSeenWithDB(database *gorm.DB, userID int64, hide bool, now time.Time) (UserPresenceSetting, error) {
+	if database == nil {
+		return UserPresenceSetting{}, errors.New("presence setting database is nil")
+	}
+	setting := UserPresenceSetting{UserID: userID, HideLastSeen: hide, UpdateTime: now.UTC().Format(time.RFC3339)}
+	err := database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hide_last_seen", "update_time"}),
+	}).Create(&setting).Error
+	return setting, err
+}
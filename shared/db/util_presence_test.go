@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetHiddenLastSeenUsersReturnsOnlyHiddenUsers(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT "user_id" FROM "user_presence_settings" WHERE user_id IN \(\$1,\$2,\$3\) AND hide_last_seen = \$4`).
+		WithArgs(int64(1001), int64(1002), int64(1003), true).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1002))
+
+	hidden, err := GetHiddenLastSeenUsersWithDB(database, []int64{1001, 1002, 1003})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hidden) != 1 || !hidden[1002] {
+		t.Fatalf("unexpected hidden users: %+v", hidden)
+	}
+	if empty, err := GetHiddenLastSeenUsersWithDB(database, nil); err != nil || len(empty) != 0 {
+		t.Fatalf("empty lookup must not query: hidden=%+v err=%v", empty, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetHideLastSeenUpsertsSetting(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	now := time.Date(2026, 7, 21, 4, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "user_presence_settings" \("user_id","hide_last_seen","update_time"\) VALUES \(\$1,\$2,\$3\) ON CONFLICT \("user_id"\) DO UPDATE SET "hide_last_seen"="excluded"."hide_last_seen","update_time"="excluded"."update_time"`).
+		WithArgs(int64(1002), true, "2026-07-21T04:00:00Z").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	setting, err := SetHideLastSeenWithDB(database, 1002, true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setting.UserID != 1002 || !setting.HideLastSeen || setting.UpdateTime != "2026-07-21T04:00:00Z" {
+		t.Fatalf("unexpected setting: %+v", setting)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReactionUpdatesPage 是按回应更新序号同步的一页消息，每条消息只出现一次。
+type ReactionUpdatesPage struct {
+	Messages     []Message
+	HasMore      bool
+	NextAfterSeq int64
+}
+
+// RecordReactionUpdateWithDB 在消息的回应变化后为所有可读者分配新的回应更新序号，
+// 每个可读者在 reaction_updates 中只保留该消息的一行并原地更新序号，收件箱不受影响。
+// 可读者与 CanUserReadMessageWithDB 一致，按 user_id 升序返回。
+func RecordReactionUpdateWithDB(database *gorm.DB, message *Message) ([]int64, error) {
+	if database == nil {
+		return nil, errors.New("reaction update database is nil")
+	}
+	if message == nil || message.MessageID <= 0 {
+		return nil, nil
+	}
+	readerIDs, err := GetMessageReaderIDsWithDB(database, message)
+	if err != nil || len(readerIDs) == 0 {
+		return nil, err
+	}
+
+	// 与收件序号使用不同的计数行，回应频繁变化时不会与新消息的序号分配互相等待；按 user_id 升序加锁避免死锁
+	sequences := make([]UserReactionSequence, 0, len(readerIDs))
+	for _, userID := range readerIDs {
+		sequences = append(sequences, UserReactionSequence{UserID: userID, LastSeq: 1})
+	}
+	err = database.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Set{{Column: clause.Column{Name: "last_seq"}, Value: gorm.Expr("user_reaction_sequences.last_seq + 1")}},
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "user_id"}, {Name: "last_seq"}}},
+	).Create(&sequences).Error
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]ReactionUpdate, 0, len(sequences))
+	for _, sequence := range sequences {
+		updates = append(updates, ReactionUpdate{UserID: sequence.UserID, MessageID: message.MessageID, Seq: sequence.LastSeq})
+	}
+	err = database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"seq"}),
+	}).Create(&updates).Error
+	if err != nil {
+		return nil, err
+	}
+	return readerIDs, nil
+}
+
+// GetReactionUpdatesPageWithDB 按回应更新序号返回 afterSeq 之后回应发生过变化的消息。
+// 同一消息多次变化只保留最新序号，因此序号不连续；客户端按 message_id 覆盖本地回应即可。
+func GetReactionUpdatesPageWithDB(database *gorm.DB, userID, afterSeq int64, pageSize int) (*ReactionUpdatesPage, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultSyncPageSize
+	}
+	if pageSize > MaxSyncPageSize {
+		pageSize = MaxSyncPageSize
+	}
+	if afterSeq < 0 {
+		afterSeq = 0
+	}
+
+	var rows []struct {
+		Message
+		ReactionSeq int64
+	}
+	err := database.Raw(`
+SELECT m.*, ru.seq AS reaction_seq
+FROM reaction_updates AS ru
+JOIN messages AS m ON m.message_id = ru.message_id
+WHERE ru.user_id = ? AND ru.seq > ?
+ORDER BY ru.seq ASC
+LIMIT ?
+`, userID, afterSeq, pageSize+1).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ReactionUpdatesPage{HasMore: len(rows) > pageSize, NextAfterSeq: afterSeq}
+	if page.HasMore {
+		rows = rows[:pageSize]
+	}
+	for _, row := range rows {
+		page.Messages = append(page.Messages, row.Message)
+		page.NextAfterSeq = row.ReactionSeq
+	}
+	return page, nil
+}
+
+// GetLatestReactionSeqWithDB 返回用户已分配的最大回应更新序号，从未有过回应变化时为 0。
+func GetLatestReactionSeqWithDB(database *gorm.DB, userID int64) (int64, error) {
+	var sequences []UserReactionSequence
+	err := database.Where("user_id = ?", userID).Limit(1).Find(&sequences).Error
+	if err != nil || len(sequences) == 0 {
+		return 0, err
+	}
+	return sequences[0].LastSeq, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRecordReactionUpdateKeepsOneRowPerReaderAndMessage(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	message := &Message{MessageID: 78, FromUserID: 1001, ToUserID: 7, IsGroup: true, Timestamp: "2026-07-21T03:00:00Z"}
+	mock.ExpectQuery(`SELECT "user_id" FROM "group_members" WHERE group_id = \$1 AND \(COALESCE\(NULLIF\(joined_at, ''\), update_time\) <= \$2 OR user_id = \$3\) ORDER BY user_id ASC`).
+		WithArgs(int64(7), "2026-07-21T03:00:00Z", int64(1001)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1001).AddRow(1002))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_reaction_sequences" .* ON CONFLICT \("user_id"\) DO UPDATE SET "last_seq"=user_reaction_sequences.last_seq \+ 1 RETURNING "user_id","last_seq"`).
+		WithArgs(int64(1001), int64(1), int64(1002), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "last_seq"}).AddRow(1001, 3).AddRow(1002, 9))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "reaction_updates" .* ON CONFLICT \("user_id","message_id"\) DO UPDATE SET "seq"="excluded"."seq"`).
+		WithArgs(int64(1001), int64(78), int64(3), int64(1002), int64(78), int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	readerIDs, err := RecordReactionUpdateWithDB(database, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readerIDs) != 2 || readerIDs[0] != 1001 || readerIDs[1] != 1002 {
+		t.Fatalf("unexpected reaction readers: %v", readerIDs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReactionUpdatesPageFollowsSequence(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	mock.ExpectQuery(`SELECT m\.\*, ru\.seq AS reaction_seq FROM reaction_updates AS ru JOIN messages AS m ON m\.message_id = ru\.message_id WHERE ru\.user_id = \$1 AND ru\.seq > \$2 ORDER BY ru\.seq ASC LIMIT \$3`).
+		WithArgs(int64(1002), int64(4), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "from_user_id", "to_user_id", "reaction_seq"}).
+			AddRow(80, 1001, 1002, 6).
+			AddRow(75, 1002, 1001, 9).
+			AddRow(81, 1001, 1002, 12))
+
+	page, err := GetReactionUpdatesPageWithDB(database, 1002, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.HasMore || len(page.Messages) != 2 || page.Messages[1].MessageID != 75 || page.NextAfterSeq != 9 {
+		t.Fatalf("unexpected reaction update page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
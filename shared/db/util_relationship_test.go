@@ -3,6 +3,8 @@ package db
 import (
 	"testing"
 	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
 )
 
 func TestRelationshipRequestWindowIsExactlySevenDays(t *testing.T) {
@@ -89,3 +91,25 @@ func TestGroupPermissionMatrix(t *testing.T) {
 		})
 	}
 }
+
+func TestIsActiveFriendIgnoresDeletedFriendship(t *testing.T) {
+	database, mock := newInboxDatabase(t)
+	for _, count := range []int{1, 0} {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "friends" WHERE user_id = \$1 AND friend_id = \$2 AND is_delete = \$3`).
+			WithArgs(int64(1002), int64(1001), false).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	}
+
+	for _, want := range []bool{true, false} {
+		isFriend, err := IsActiveFriendWithDB(database, 1002, 1001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isFriend != want {
+			t.Fatalf("unexpected friendship: got %t want %t", isFriend, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"Betterfly2/shared/utils"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -57,6 +59,27 @@ func RotateUserJwtKeyCAS(id int64, oldJwtKey, newJwtKey []byte) (bool, error) {
 	return result.RowsAffected == 1, result.Error
 }
 
+// RevokeJwtSession 记录被吊销的JWT会话，重复吊销时刷新过期时间。
+func RevokeJwtSession(userID int64, sessionID string, expiresAt time.Time) error {
+	return DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "session_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(&RevokedJwtSession{
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: FormatReliabilityTime(expiresAt),
+	}).Error
+}
+
+// IsJwtSessionRevoked 判断JWT会话在 now 时是否仍处于吊销状态，过期的吊销记录视为不存在。
+func IsJwtSessionRevoked(userID int64, sessionID string, now time.Time) (bool, error) {
+	var count int64
+	err := DB().Model(&RevokedJwtSession{}).
+		Where("user_id = ? AND session_id = ? AND expires_at > ?", userID, sessionID, FormatReliabilityTime(now)).
+		Count(&count).Error
+	return count > 0, err
+}
+
 func AddUser(user *User) error {
 	user.UpdateTime = utils.NowTime()
 	database := DB()
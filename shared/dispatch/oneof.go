@@ -14,8 +14,13 @@ var (
 
 type OneofHandler[Ctx any, Resp any] func(Ctx, any) (Resp, error)
 
+// Middleware wraps the handler selected for a payload. It only runs for
+// registered payload types.
+type Middleware[Ctx any, Resp any] func(next OneofHandler[Ctx, Resp]) OneofHandler[Ctx, Resp]
+
 type OneofRouter[Ctx any, Resp any] struct {
-	handlers map[reflect.Type]OneofHandler[Ctx, Resp]
+	handlers    map[reflect.Type]OneofHandler[Ctx, Resp]
+	middlewares []Middleware[Ctx, Resp]
 }
 
 func NewOneofRouter[Ctx any, Resp any]() *OneofRouter[Ctx, Resp] {
@@ -44,6 +49,11 @@ func Register[Ctx any, Resp any, Payload any](router *OneofRouter[Ctx, Resp], ha
 	}
 }
 
+// Use appends middlewares; the first one registered is the outermost.
+func (r *OneofRouter[Ctx, Resp]) Use(middlewares ...Middleware[Ctx, Resp]) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
 func (r *OneofRouter[Ctx, Resp]) Dispatch(ctx Ctx, payload any) (Resp, error) {
 	if payload == nil {
 		var zero Resp
@@ -56,6 +66,9 @@ func (r *OneofRouter[Ctx, Resp]) Dispatch(ctx Ctx, payload any) (Resp, error) {
 		var zero Resp
 		return zero, fmt.Errorf("%w: %s", ErrUnregisteredPayload, payloadType)
 	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
 
 	return handler(ctx, payload)
 }
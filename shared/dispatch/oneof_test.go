@@ -52,3 +52,44 @@ func TestOneofRouterRejectsDuplicateRegistration(t *testing.T) {
 		return prefix + payload.Value, nil
 	})
 }
+
+func TestOneofRouterMiddlewaresWrapRegisteredHandlersInOrder(t *testing.T) {
+	router := NewOneofRouter[string, string]()
+	Register(router, func(prefix string, payload *testPayload) (string, error) {
+		return prefix + payload.Value, nil
+	})
+	var calls []string
+	tag := func(name string) Middleware[string, string] {
+		return func(next OneofHandler[string, string]) OneofHandler[string, string] {
+			return func(ctx string, payload any) (string, error) {
+				calls = append(calls, name)
+				return next(ctx, payload)
+			}
+		}
+	}
+	reject := func(next OneofHandler[string, string]) OneofHandler[string, string] {
+		return func(ctx string, payload any) (string, error) {
+			if payload.(*testPayload).Value == "blocked" {
+				return "rejected", nil
+			}
+			return next(ctx, payload)
+		}
+	}
+	router.Use(tag("outer"), tag("inner"), reject)
+
+	got, err := router.Dispatch("hello ", &testPayload{Value: "betterfly"})
+	if err != nil || got != "hello betterfly" {
+		t.Fatalf("unexpected dispatch result: %q err=%v", got, err)
+	}
+	if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+		t.Fatalf("unexpected middleware order: %v", calls)
+	}
+	if got, _ := router.Dispatch("hello ", &testPayload{Value: "blocked"}); got != "rejected" {
+		t.Fatalf("middleware did not short-circuit: %q", got)
+	}
+
+	calls = nil
+	if _, err := router.Dispatch("hello ", &unknownPayload{}); !errors.Is(err, ErrUnregisteredPayload) || len(calls) != 0 {
+		t.Fatalf("middleware ran for unregistered payload: calls=%v err=%v", calls, err)
+	}
+}
@@ -164,6 +164,22 @@ var (
 		Help: "Total number of WebSocket connections closed",
 	})
 
+	WebSocketSendQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "betterfly_websocket_send_queue_depth",
+		Help:    "Per-connection send queue depth observed after each enqueued frame",
+		Buckets: []float64{1, 4, 16, 64, 128, 192, 256},
+	})
+
+	WebSocketSendOverflowTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "betterfly_websocket_send_overflow_total",
+		Help: "Total number of frames handled by the send queue overflow policy",
+	}, []string{"action"})
+
+	RateLimitedRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "betterfly_rate_limited_requests_total",
+		Help: "Total number of client requests rejected by rate limiting",
+	}, []string{"request_type"})
+
 	// 用户在线状态
 	OnlineUsersTotal = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "betterfly_online_users_total",
@@ -149,7 +149,22 @@ func RecordWebSocketConnectionClosed() {
 	WebSocketConnectionsTotal.Dec()
 }
 
+// RecordWebSocketSendQueueDepth 记录帧入队后连接发送队列的长度
+func RecordWebSocketSendQueueDepth(depth int) {
+	WebSocketSendQueueDepth.Observe(float64(depth))
+}
+
+// RecordWebSocketSendOverflow 记录发送队列已满时的处理方式：coalesced、spilled 或 sync_required
+func RecordWebSocketSendOverflow(action string) {
+	WebSocketSendOverflowTotal.WithLabelValues(action).Inc()
+}
+
 // UpdateOnlineUsers 更新在线用户数
 func UpdateOnlineUsers(count int) {
 	OnlineUsersTotal.Set(float64(count))
 }
+
+// RecordRateLimitedRequest 记录被限流拒绝的客户端请求
+func RecordRateLimitedRequest(requestType string) {
+	RateLimitedRequestsTotal.WithLabelValues(requestType).Inc()
+}